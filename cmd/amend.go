@@ -0,0 +1,81 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(NewAmendCommand())
+}
+
+type amendFlags struct {
+	includedFilepaths []string
+	force             bool
+}
+
+func (flags amendFlags) validate() errors.GitGhostError {
+	if !flags.force {
+		return errors.New("amend deletes the original ghost, so --force is required")
+	}
+	return nil
+}
+
+func NewAmendCommand() *cobra.Command {
+	var (
+		flags amendFlags
+	)
+	command := &cobra.Command{
+		Use:         "amend <tag-or-diff-hash> --force",
+		Annotations: writesGhostRepoAnnotations,
+		Short:       "replace a diff ghost with local modifications on its base",
+		Long:        "push local modifications as a new diff ghost on the base of the diff ghost of <tag-or-diff-hash>, which must be HEAD, move its tags to the new one and delete it.  the diff hash changes since ghosts are named by their contents, so refer to the ghost by a tag to keep the reference working.",
+		Args:        cobra.ExactArgs(1),
+		Run:         runAmendCommand(&flags),
+	}
+	command.Flags().StringSliceVarP(&flags.includedFilepaths, "include", "I", []string{}, "include a non-indexed file, this flag can be repeated to specify multiple files.")
+	command.Flags().BoolVar(&globalOpts.noSecretScan, "no-secret-scan", false, "push the amended ghost without scanning it for secrets")
+	command.Flags().BoolVarP(&flags.force, "force", "f", false, "required since the original ghost is deleted")
+	return command
+}
+
+func runAmendCommand(flags *amendFlags) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if err := flags.validate(); err != nil {
+			exitWithConfigError(err)
+		}
+		if err := nonEmpty("tag-or-diff-hash", args[0]); err != nil {
+			exitWithConfigError(err)
+		}
+		options := ghost.AmendOptions{
+			WorkingEnvSpec:    globalOpts.WorkingEnvSpec(),
+			Prefix:            globalOpts.ghostPrefix,
+			Target:            args[0],
+			IncludedFilepaths: flags.includedFilepaths,
+		}
+
+		result, err := ghost.Amend(options)
+		if err != nil {
+			exitWithError(err)
+		}
+		fmt.Printf("%s %s\n", result.Amended.CommitHashFrom, result.Amended.DiffHash)
+	}
+}
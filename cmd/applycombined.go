@@ -0,0 +1,64 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"io"
+	"os"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(NewApplyCombinedCommand())
+}
+
+func NewApplyCombinedCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "apply-combined [file]",
+		Short: "apply a combined patch written by 'show --combined'",
+		Long:  "apply sections of a combined patch written by 'show --combined' to working dir in the order of its manifest: commits by 'git am' and then diffs by 'git apply'.  [file] is read from stdin if it is '-'.  the whole patch is checked before applying anything, and applying stops at the first section failing.",
+		Args:  cobra.ExactArgs(1),
+		Run:   runApplyCombinedCommand,
+	}
+	return command
+}
+
+func runApplyCombinedCommand(cmd *cobra.Command, args []string) {
+	var reader io.Reader = os.Stdin
+	if args[0] != "-" {
+		if err := util.ValidateReadableFile(args[0]); err != nil {
+			exitWithConfigError(err)
+		}
+		f, err := os.Open(args[0])
+		if err != nil {
+			exitWithError(errors.WithStack(err))
+		}
+		defer util.LogDeferredError(f.Close)
+		reader = f
+	}
+	options := ghost.ApplyCombinedOptions{
+		SrcDir: globalOpts.srcDir,
+		Reader: reader,
+	}
+	err := ghost.ApplyCombined(options)
+	if err != nil {
+		exitWithError(err)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(NewCheckoutCommand())
+}
+
+type checkoutFlags struct {
+	dir        string
+	sourceRepo string
+}
+
+func (flags checkoutFlags) validate() errors.GitGhostError {
+	if flags.dir == "" {
+		return errors.New("dir must be specified")
+	}
+	return nil
+}
+
+func NewCheckoutCommand() *cobra.Command {
+	var (
+		flags checkoutFlags
+	)
+	command := &cobra.Command{
+		Use:         "checkout [hash]",
+		Annotations: map[string]string{annotationCreatesSrcDir: "true"},
+		Short:       "clone the source repo of a ghost at its base and apply the ghost",
+		Long:        "clone --source-repo (default to the source repo recorded in the ghost) into --dir, which must be missing or empty, check out the base commit of a ghost branch whose diff hash (or local base commit hash, or branch name) is [hash] detaching HEAD, and apply the ghost on it.  --dir is removed if cloning, checking out or applying fails.",
+		Args:        cobra.ExactArgs(1),
+		Run:         runCheckoutCommand(&flags),
+	}
+	command.Flags().StringVar(&flags.dir, "dir", "", "directory which the source repo is cloned into, which must be missing or empty")
+	command.Flags().StringVar(&flags.sourceRepo, "source-repo", "", "repo to clone (default to the source repo recorded in the ghost)")
+	return command
+}
+
+func runCheckoutCommand(flags *checkoutFlags) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if err := flags.validate(); err != nil {
+			exitWithConfigError(err)
+		}
+		if err := nonEmpty("hash", args[0]); err != nil {
+			exitWithConfigError(err)
+		}
+		sourceRepo := flags.sourceRepo
+		if sourceRepo != "" {
+			// the repo is cloned into --dir, which is not the current directory
+			if _, err := os.Stat(sourceRepo); err == nil {
+				abs, err := filepath.Abs(sourceRepo)
+				if err != nil {
+					exitWithError(errors.WithStack(err))
+				}
+				sourceRepo = abs
+			}
+		}
+		options := ghost.CheckoutOptions{
+			WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
+			Prefix:         globalOpts.ghostPrefix,
+			SourceRepo:     sourceRepo,
+		}
+		options.SrcDir = flags.dir
+		result, err := ghost.Checkout(options, args[0])
+		if err != nil {
+			exitWithError(err)
+		}
+		fmt.Printf("checked out %s of %s into %s and applied %s (HEAD: %s)\n", result.Base, util.RedactURLPassword(result.SourceRepo), result.Dir, result.Branch.BranchName(), result.Head)
+	}
+}
@@ -86,7 +86,7 @@ var completionCmd = &cobra.Command{
 		completion, ok := availableCompletions[shell]
 		if !ok {
 			fmt.Printf("Invalid shell '%s'. The supported shells are bash and zsh.\n", shell)
-			os.Exit(1)
+			os.Exit(exitCodeConfig)
 		}
 		if err := completion(os.Stdout); err != nil {
 			log.Fatal(err)
@@ -0,0 +1,307 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(NewConfigCommand())
+}
+
+// setting is a global option which can be set by a flag, an env, git config of the source repo or global git config, in this order of precedence
+type setting struct {
+	// name is the name of the flag
+	name         string
+	configKey    string
+	env          string
+	defaultValue string
+	// secret values are redacted in 'config list'
+	secret bool
+	value  func(flags *globalFlags) *string
+}
+
+const (
+	sourceFlag    = "flag"
+	sourceEnv     = "env"
+	sourceFile    = "file"
+	sourceGlobal  = "global"
+	sourceDefault = "default"
+)
+
+var settings = []setting{
+	{
+		name:      "ghost-repo",
+		configKey: "ghost.repo",
+		env:       "GIT_GHOST_REPO",
+		value:     func(flags *globalFlags) *string { return &flags.ghostRepo },
+	},
+	{
+		name:         "ghost-prefix",
+		configKey:    "ghost.prefix",
+		env:          "GIT_GHOST_PREFIX",
+		defaultValue: "ghost",
+		value:        func(flags *globalFlags) *string { return &flags.ghostPrefix },
+	},
+	{
+		name:      "branch-name-scheme",
+		configKey: "ghost.branchNameScheme",
+		env:       "GIT_GHOST_BRANCH_NAME_SCHEME",
+		value:     func(flags *globalFlags) *string { return &flags.branchNameScheme },
+	},
+	{
+		name:      "tmpdir",
+		configKey: "ghost.tmpdir",
+		env:       "GIT_GHOST_TMPDIR",
+		value:     func(flags *globalFlags) *string { return &flags.tmpDir },
+	},
+	{
+		name:      "ssh-command",
+		configKey: "ghost.sshCommand",
+		env:       "GIT_GHOST_SSH_COMMAND",
+		secret:    true,
+		value:     func(flags *globalFlags) *string { return &flags.sshCommand },
+	},
+	{
+		name:      "identity-file",
+		configKey: "ghost.identityFile",
+		env:       "GIT_GHOST_IDENTITY_FILE",
+		secret:    true,
+		value:     func(flags *globalFlags) *string { return &flags.identityFile },
+	},
+	{
+		name:      "proxy",
+		configKey: "ghost.proxy",
+		env:       "GIT_GHOST_PROXY",
+		value:     func(flags *globalFlags) *string { return &flags.proxy },
+	},
+	{
+		name:      "ca-bundle",
+		configKey: "ghost.caBundle",
+		env:       "GIT_GHOST_CA_BUNDLE",
+		value:     func(flags *globalFlags) *string { return &flags.caBundle },
+	},
+	{
+		name:      "pipe-through",
+		configKey: "ghost.pipeThrough",
+		env:       "GIT_GHOST_PIPE_THROUGH",
+		value:     func(flags *globalFlags) *string { return &flags.pipeThrough },
+	},
+	{
+		name:      "pipe-through-on-pull",
+		configKey: "ghost.pipeThroughOnPull",
+		env:       "GIT_GHOST_PIPE_THROUGH_ON_PULL",
+		value:     func(flags *globalFlags) *string { return &flags.pipeThroughOnPull },
+	},
+	{
+		name:      "concurrency",
+		configKey: "ghost.concurrency",
+		env:       "GIT_GHOST_CONCURRENCY",
+		value:     func(flags *globalFlags) *string { return &flags.concurrency },
+	},
+	{
+		name:      "min-git-version",
+		configKey: "ghost.minGitVersion",
+		env:       "GIT_GHOST_MIN_GIT_VERSION",
+		value:     func(flags *globalFlags) *string { return &flags.minGitVersion },
+	},
+	{
+		name:      "ghost-user",
+		configKey: "ghost.user",
+		env:       "GIT_GHOST_USER",
+		value:     func(flags *globalFlags) *string { return &flags.ghostUser },
+	},
+	{
+		name:      "audit-log",
+		configKey: "ghost.auditLog",
+		env:       "GIT_GHOST_AUDIT_LOG",
+		value:     func(flags *globalFlags) *string { return &flags.auditLog },
+	},
+	{
+		name:         "max-commits",
+		configKey:    "ghost.maxCommits",
+		env:          "GIT_GHOST_MAX_COMMITS",
+		defaultValue: "1000",
+		value:        func(flags *globalFlags) *string { return &flags.maxCommits },
+	},
+	{
+		name:      "secret-patterns",
+		configKey: "ghost.secretPatterns",
+		env:       "GIT_GHOST_SECRET_PATTERNS",
+		value:     func(flags *globalFlags) *string { return &flags.secretPatterns },
+	},
+	{
+		name:      "check-eol",
+		configKey: "ghost.checkEol",
+		env:       "GIT_GHOST_CHECK_EOL",
+		value:     func(flags *globalFlags) *string { return &flags.checkEOL },
+	},
+	{
+		name:      "post-apply-hook",
+		configKey: "ghost.postApplyHook",
+		env:       "GIT_GHOST_POST_APPLY_HOOK",
+		value:     func(flags *globalFlags) *string { return &flags.postApplyHook },
+	},
+}
+
+func findSetting(name string) (*setting, errors.GitGhostError) {
+	names := []string{}
+	for i := range settings {
+		if settings[i].name == name {
+			return &settings[i], nil
+		}
+		names = append(names, settings[i].name)
+	}
+	return nil, errors.Errorf("unknown config name %s. it must be one of %v", name, names)
+}
+
+// resolveSettings fills empty settings in flags and records where their values come from
+func (flags *globalFlags) resolveSettings() {
+	flags.sources = map[string]string{}
+	for _, s := range settings {
+		value := s.value(flags)
+		if *value != "" {
+			flags.sources[s.name] = sourceFlag
+			continue
+		}
+		if env := os.Getenv(s.env); env != "" {
+			*value = env
+			flags.sources[s.name] = sourceEnv
+			continue
+		}
+		// git config of the source repo (including files it includes) takes precedence over global and system ones
+		configValue, scope, ok, err := git.GetConfigWithScope(flags.srcDir, s.configKey)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"key": s.configKey,
+			}).Debugf("failed to get git config: %s", err)
+		}
+		if ok && configValue != "" {
+			*value = configValue
+			flags.sources[s.name] = sourceFile
+			if scope == "global" || scope == "system" {
+				flags.sources[s.name] = sourceGlobal
+			}
+			continue
+		}
+		*value = s.defaultValue
+		flags.sources[s.name] = sourceDefault
+	}
+}
+
+// redact hides a secret value, or a password in a URL
+func (s setting) redact(value string) string {
+	if value == "" {
+		return value
+	}
+	if s.secret {
+		return "<redacted>"
+	}
+	return util.RedactURLPassword(value)
+}
+
+type configFlags struct {
+	global bool
+}
+
+func NewConfigCommand() *cobra.Command {
+	var (
+		flags configFlags
+	)
+	names := []string{}
+	for _, s := range settings {
+		names = append(names, s.name)
+	}
+	command := &cobra.Command{
+		Use:   "config",
+		Short: "view and set configuration of git-ghost.",
+		Long: fmt.Sprintf(
+			"view and set configuration of git-ghost, which is stored in git config as ghost.* keys.  flags take precedence over envs, envs over git config of the source repo, it over global git config, and global git config over defaults.  available names: %s",
+			strings.Join(names, ", "),
+		),
+	}
+	command.AddCommand(&cobra.Command{
+		Use:   "get [name]",
+		Short: "print an effective value of a setting",
+		Long:  "print an effective value of a setting [name].",
+		Args:  cobra.ExactArgs(1),
+		Run:   runConfigGetCommand,
+	})
+	setCommand := &cobra.Command{
+		Use:   "set [name] [value]",
+		Short: "set a value of a setting in git config",
+		Long:  "set a value of a setting [name] in git config of the source directory, or the global one with --global.",
+		Args:  cobra.ExactArgs(2),
+		Run:   runConfigSetCommand(&flags),
+	}
+	setCommand.Flags().BoolVar(&flags.global, "global", false, "set the value in the global git config (~/.gitconfig) instead of the one of the source directory.")
+	command.AddCommand(setCommand)
+	command.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "list effective values of all settings with their sources",
+		Long:  "list effective values of all settings with their sources (flag, env, file for git config of the source repo, global for global or system git config, or default).  secrets are redacted.",
+		Args:  cobra.NoArgs,
+		Run:   runConfigListCommand,
+	})
+	return command
+}
+
+func runConfigGetCommand(cmd *cobra.Command, args []string) {
+	s, err := findSetting(args[0])
+	if err != nil {
+		exitWithConfigError(err)
+	}
+	fmt.Println(*s.value(&globalOpts))
+}
+
+func runConfigSetCommand(flags *configFlags) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		s, err := findSetting(args[0])
+		if err != nil {
+			exitWithConfigError(err)
+		}
+		err = git.SetConfig(globalOpts.srcDir, s.configKey, args[1], flags.global)
+		if err != nil {
+			exitWithError(err)
+		}
+	}
+}
+
+func runConfigListCommand(cmd *cobra.Command, args []string) {
+	for _, s := range settings {
+		fmt.Printf("%s=%s (%s)\n", s.name, s.redact(*s.value(&globalOpts)), globalOpts.sources[s.name])
+	}
+}
+
+// isConfigCommand checks cmd is 'config' or its subcommand, which works without valid settings
+func isConfigCommand(cmd *cobra.Command) bool {
+	for c := cmd; c.HasParent(); c = c.Parent() {
+		// a child of the root command
+		if !c.Parent().HasParent() {
+			return c.Name() == "config"
+		}
+	}
+	return false
+}
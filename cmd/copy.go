@@ -0,0 +1,118 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(NewCopyCommand())
+}
+
+type copyFlags struct {
+	toPrefix string
+	toRepo   string
+	tag      string
+}
+
+func (flags copyFlags) validate() errors.GitGhostError {
+	if flags.toPrefix == "" && flags.toRepo == "" && flags.tag == "" {
+		return errors.New("at least one of to-prefix, to-repo and tag must be specified")
+	}
+	return nil
+}
+
+func NewCopyCommand() *cobra.Command {
+	var (
+		flags copyFlags
+	)
+	command := &cobra.Command{
+		Use:         "copy [hash]",
+		Aliases:     []string{"cp"},
+		Annotations: writesGhostRepoAnnotations,
+		Short:       "copy a ghost under another prefix or into another ghost repo",
+		Long:        "copy a ghost branch whose diff hash (or local base commit hash, or branch name) is [hash] under --to-prefix, into --to-repo, or both, pointing to the same commit without re-creating it.  the copy is verified to point to the source commit after pushing.",
+		Args:        cobra.ExactArgs(1),
+		Run:         runCopyCommand(&flags),
+	}
+	command.Flags().StringVar(&flags.toPrefix, "to-prefix", "", "prefix of the copied ghost branch (default to ghost-prefix)")
+	command.Flags().StringVar(&flags.toRepo, "to-repo", "", "ghost repo to copy the ghost branch into (default to ghost-repo)")
+	command.Flags().StringVar(&flags.tag, "tag", "", "add a tag to the copied ghost branch under to-prefix")
+	return command
+}
+
+func runCopyCommand(flags *copyFlags) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if err := flags.validate(); err != nil {
+			exitWithConfigError(err)
+		}
+		if err := nonEmpty("hash", args[0]); err != nil {
+			exitWithConfigError(err)
+		}
+		toRepo := flags.toRepo
+		if toRepo != "" {
+			// the repo is pushed to from a temporary directory
+			if _, err := os.Stat(toRepo); err == nil {
+				abs, err := filepath.Abs(toRepo)
+				if err != nil {
+					exitWithError(errors.WithStack(err))
+				}
+				toRepo = abs
+			}
+			if !globalOpts.allowSameRepo {
+				same, err := git.IsSameRepo(globalOpts.srcDir, toRepo)
+				if err != nil {
+					exitWithError(err)
+				}
+				if same {
+					exitWithConfigError(errors.Errorf("to-repo %s is the source repo itself or one of its remotes, whose branches would be polluted by ghosts. please specify another to-repo (or --allow-same-repo if it is intended)", util.RedactURLPassword(toRepo)))
+				}
+			}
+		}
+		// the destination is checked with its default branch here, since only main and master are checked before running commands
+		repo, prefix := toRepo, flags.toPrefix
+		if repo == "" {
+			repo = globalOpts.ghostRepo
+		}
+		if prefix == "" {
+			prefix = globalOpts.ghostPrefix
+		}
+		if err := validateNotProtected(repo, prefix); err != nil {
+			exitWithError(err)
+		}
+		options := ghost.CopyOptions{
+			WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
+			Prefix:         globalOpts.ghostPrefix,
+			ToPrefix:       flags.toPrefix,
+			ToRepo:         toRepo,
+			Tag:            flags.tag,
+		}
+		result, err := ghost.Copy(options, args[0])
+		if err != nil {
+			exitWithError(err)
+		}
+		fmt.Println(result.Copy.BranchName())
+	}
+}
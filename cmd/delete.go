@@ -15,13 +15,16 @@
 package cmd
 
 import (
+	"bufio"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/pfnet-research/git-ghost/pkg/ghost"
 	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
 	"github.com/pfnet-research/git-ghost/pkg/util/errors"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
@@ -30,10 +33,13 @@ func init() {
 }
 
 type deleteFlags struct {
-	hashFrom string
-	hashTo   string
-	all      bool
-	dryrun   bool
+	hashFrom    string
+	hashTo      string
+	all         bool
+	dryrun      bool
+	allMatching string
+	yes         bool
+	ifExists    bool
 }
 
 func NewDeleteCommand() *cobra.Command {
@@ -42,11 +48,12 @@ func NewDeleteCommand() *cobra.Command {
 	)
 
 	var command = &cobra.Command{
-		Use:   "delete",
-		Short: "delete ghost branches of diffs.",
-		Long:  "delete ghost branches of diffs.",
-		Args:  cobra.NoArgs,
-		Run:   runDeleteDiffCommand(&deleteFlags),
+		Use:         "delete",
+		Annotations: writesGhostRepoAnnotations,
+		Short:       "delete ghost branches of diffs.",
+		Long:        "delete ghost branches of diffs.",
+		Args:        cobra.NoArgs,
+		Run:         runDeleteDiffCommand(&deleteFlags),
 	}
 	command.AddCommand(&cobra.Command{
 		Use:   "commits",
@@ -69,9 +76,12 @@ func NewDeleteCommand() *cobra.Command {
 		Args:  cobra.NoArgs,
 		Run:   runDeleteAllCommand(&deleteFlags),
 	})
+	command.Flags().StringVar(&deleteFlags.allMatching, "all-matching", "", "delete ghost branches of all the tags matching the glob pattern (e.g. 'ci/job-123/*') together with the tags, after confirmation.")
+	command.Flags().BoolVarP(&deleteFlags.yes, "yes", "y", false, "delete ghost branches by --all-matching without confirmation.")
 	command.PersistentFlags().StringVar(&deleteFlags.hashFrom, "from", "", "commit or diff hash to which ghost branches are deleted.")
 	command.PersistentFlags().StringVar(&deleteFlags.hashTo, "to", "", "commit or diff hash from which ghost branches are deleted.")
 	command.PersistentFlags().BoolVar(&deleteFlags.all, "all", false, "flag to ensure multiple ghost branches.")
+	command.PersistentFlags().BoolVar(&deleteFlags.ifExists, "if-exists", false, "succeed without deleting anything if no ghost branch of the hash specified by --to is found.")
 	command.PersistentFlags().BoolVar(&deleteFlags.dryrun, "dry-run", false, "If true, only print the branch names that would be deleted, without deleting them.")
 	return command
 }
@@ -80,14 +90,14 @@ func runDeleteCommitsCommand(flags *deleteFlags) func(cmd *cobra.Command, args [
 	return func(cmd *cobra.Command, args []string) {
 		err := flags.validate()
 		if err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithConfigError(err)
 		}
 		opts := ghost.DeleteOptions{
 			WorkingEnvSpec: types.WorkingEnvSpec{
 				SrcDir:          globalOpts.srcDir,
 				GhostWorkingDir: globalOpts.ghostWorkDir,
 				GhostRepo:       globalOpts.ghostRepo,
+				FullFetch:       globalOpts.fullFetch,
 			},
 			ListCommitsBranchSpec: &types.ListCommitsBranchSpec{
 				Prefix:   globalOpts.ghostPrefix,
@@ -97,27 +107,26 @@ func runDeleteCommitsCommand(flags *deleteFlags) func(cmd *cobra.Command, args [
 			Dryrun: flags.dryrun,
 		}
 
-		res, err := ghost.Delete(opts)
-		if err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
-		}
-		fmt.Print(res.PrettyString())
+		runDelete(flags, opts)
 	}
 }
 
 func runDeleteDiffCommand(flags *deleteFlags) func(cmd *cobra.Command, args []string) {
 	return func(cmd *cobra.Command, args []string) {
+		if flags.allMatching != "" {
+			runDeleteMatching(flags)
+			return
+		}
 		err := flags.validate()
 		if err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithConfigError(err)
 		}
 		opts := ghost.DeleteOptions{
 			WorkingEnvSpec: types.WorkingEnvSpec{
 				SrcDir:          globalOpts.srcDir,
 				GhostWorkingDir: globalOpts.ghostWorkDir,
 				GhostRepo:       globalOpts.ghostRepo,
+				FullFetch:       globalOpts.fullFetch,
 			},
 			ListDiffBranchSpec: &types.ListDiffBranchSpec{
 				Prefix:   globalOpts.ghostPrefix,
@@ -127,12 +136,7 @@ func runDeleteDiffCommand(flags *deleteFlags) func(cmd *cobra.Command, args []st
 			Dryrun: flags.dryrun,
 		}
 
-		res, err := ghost.Delete(opts)
-		if err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
-		}
-		fmt.Print(res.PrettyString())
+		runDelete(flags, opts)
 	}
 }
 
@@ -140,14 +144,14 @@ func runDeleteAllCommand(flags *deleteFlags) func(cmd *cobra.Command, args []str
 	return func(cmd *cobra.Command, args []string) {
 		err := flags.validate()
 		if err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithConfigError(err)
 		}
 		opts := ghost.DeleteOptions{
 			WorkingEnvSpec: types.WorkingEnvSpec{
 				SrcDir:          globalOpts.srcDir,
 				GhostWorkingDir: globalOpts.ghostWorkDir,
 				GhostRepo:       globalOpts.ghostRepo,
+				FullFetch:       globalOpts.fullFetch,
 			},
 			ListCommitsBranchSpec: &types.ListCommitsBranchSpec{
 				Prefix:   globalOpts.ghostPrefix,
@@ -162,13 +166,82 @@ func runDeleteAllCommand(flags *deleteFlags) func(cmd *cobra.Command, args []str
 			Dryrun: flags.dryrun,
 		}
 
-		res, err := ghost.Delete(opts)
-		if err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+		runDelete(flags, opts)
+	}
+}
+
+// runDelete deletes ghost branches by opts and prints them, where a missing ghost is not an error with --if-exists
+func runDelete(flags *deleteFlags, opts ghost.DeleteOptions) {
+	res, err := ghost.Delete(opts)
+	if err != nil && flags.ifExists && errors.CategoryOf(err) == errors.CategoryNotFound {
+		log.WithField("error", err.Error()).Info("nothing is deleted since the ghost doesn't exist")
+		return
+	}
+	if err != nil {
+		exitWithError(err)
+	}
+	fmt.Print(res.PrettyString())
+}
+
+// runDeleteMatching deletes ghost branches of tags matching --all-matching, which are listed beforehand
+func runDeleteMatching(flags *deleteFlags) {
+	if flags.hashFrom != "" || flags.hashTo != "" || flags.all {
+		exitWithConfigError(errors.New("all-matching is not available with --from, --to or --all"))
+	}
+	options := newTagOptions()
+	ghosts, err := ghost.FindTaggedGhosts(options, flags.allMatching)
+	if err != nil {
+		exitWithError(err)
+	}
+	if len(ghosts) == 0 {
+		fmt.Fprintf(os.Stderr, "no tags match %s\n", flags.allMatching)
+		return
+	}
+	if flags.dryrun {
+		fmt.Printf("Ghost branches to be deleted (%d):\n", len(ghosts))
+		for _, g := range ghosts {
+			fmt.Println(g.String())
 		}
-		fmt.Print(res.PrettyString())
+		return
+	}
+	if !flags.yes {
+		fmt.Fprintf(os.Stderr, "Ghost branches to be deleted (%d):\n", len(ghosts))
+		for _, g := range ghosts {
+			fmt.Fprintln(os.Stderr, g.String())
+		}
+		if !confirm("delete them?") {
+			exitWithConfigError(errors.New("deletion is not confirmed. specify --yes to delete without confirmation"))
+		}
+	}
+
+	deleted, err := ghost.DeleteTaggedGhosts(options, ghosts)
+	if deleted == nil {
+		exitWithError(err)
+	}
+	failed := 0
+	fmt.Println("Deleted Ghost Branches:")
+	for _, g := range deleted {
+		if g.Err != nil {
+			failed++
+			continue
+		}
+		fmt.Println(g.String())
+	}
+	fmt.Printf("\n%d deleted, %d failed\n", len(deleted)-failed, failed)
+	if err != nil {
+		exitWithError(err)
+	}
+}
+
+// confirm asks a yes/no question on stderr and reads the answer from stdin, which is no unless it starts with "y"
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && answer == "" {
+		fmt.Fprintln(os.Stderr)
+		return false
 	}
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(answer)), "y")
 }
 
 func (flags deleteFlags) validate() errors.GitGhostError {
@@ -0,0 +1,72 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+
+	"github.com/spf13/cobra"
+)
+
+type diffLocalFlags struct {
+	includedFilepaths []string
+}
+
+func init() {
+	RootCmd.AddCommand(NewDiffLocalCommand())
+}
+
+func NewDiffLocalCommand() *cobra.Command {
+	var (
+		flags diffLocalFlags
+	)
+	command := &cobra.Command{
+		Use:   "diff-local [diff-from-hash(default=HEAD)] [diff-hash]",
+		Short: "show what pulling a diff in ghost repo would change in your working dir",
+		Long:  "show a diff from current state of your working dir to the state which pulling the diff from [diff-from-hash] to [diff-hash] in ghost repo results in.  neither your index nor your working dir is modified.",
+		Args:  cobra.RangeArgs(1, 2),
+		Run:   runDiffLocalCommand(&flags),
+	}
+	command.Flags().StringSliceVarP(&flags.includedFilepaths, "include", "I", []string{}, "include a non-indexed file in current state, this flag can be repeated to specify multiple files.")
+	return command
+}
+
+func runDiffLocalCommand(flags *diffLocalFlags) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		arg := newShowDiffArg(args)
+		if err := arg.validate(); err != nil {
+			exitWithConfigError(err)
+		}
+
+		options := ghost.DiffLocalOptions{
+			WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
+			PullableDiffBranchSpec: &types.PullableDiffBranchSpec{
+				Prefix:         globalOpts.ghostPrefix,
+				CommittishFrom: arg.diffFrom,
+				DiffHash:       arg.diffHash,
+			},
+			IncludedFilepaths: flags.includedFilepaths,
+			Writer:            os.Stdout,
+		}
+
+		err := ghost.DiffLocal(options)
+		if err != nil {
+			exitWithError(err)
+		}
+	}
+}
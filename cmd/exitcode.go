@@ -0,0 +1,78 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	gherrors "github.com/pkg/errors"
+)
+
+// Exit codes which tell categories of failures
+const (
+	exitCodeGeneric = 1
+	// exitCodeInvalidGhost is for a ghost which is found invalid before applying it
+	exitCodeInvalidGhost = 2
+	exitCodeNotFound     = 3
+	exitCodeRemote       = 4
+	exitCodeConfig       = 5
+	exitCodeConflict     = 6
+	exitCodeExists       = 7
+)
+
+var categoryExitCodes = map[errors.Category]int{
+	errors.CategoryConflict: exitCodeConflict,
+	errors.CategoryNotFound: exitCodeNotFound,
+	errors.CategoryRemote:   exitCodeRemote,
+	errors.CategoryConfig:   exitCodeConfig,
+	errors.CategoryExists:   exitCodeExists,
+}
+
+func exitCode(err error) int {
+	switch gherrors.Cause(err).(type) {
+	case *git.InvalidBundleError, *types.UnsupportedFormatError:
+		return exitCodeInvalidGhost
+	}
+	if code, ok := categoryExitCodes[errors.CategoryOf(err)]; ok {
+		return code
+	}
+	return exitCodeGeneric
+}
+
+// ExitCode returns an exit code for err returned by RootCmd.Execute
+//
+// Since commands exit by themselves on their failures, err is a failure on parsing flags or arguments,
+// or on loading settings, which is regarded as a config error unless it is classified otherwise.
+func ExitCode(err error) int {
+	if errors.CategoryOf(err) == errors.CategoryGeneric {
+		return exitCodeConfig
+	}
+	return exitCode(err)
+}
+
+// exitWithError logs err and exits with an exit code for its category
+func exitWithError(err errors.GitGhostError) {
+	errors.LogErrorWithStack(err)
+	os.Exit(exitCode(err))
+}
+
+// exitWithConfigError logs err on validating settings, flags or arguments and exits as a config error
+func exitWithConfigError(err errors.GitGhostError) {
+	exitWithError(errors.WithCategory(err, errors.CategoryConfig))
+}
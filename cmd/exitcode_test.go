@@ -0,0 +1,43 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestExitCode pins each category of failures to the exit code documented in SPEC.md, which scripts depend on
+func TestExitCode(t *testing.T) {
+	assert.Equal(t, 1, exitCode(errors.New("generic")))
+	assert.Equal(t, 2, exitCode(errors.WithStack(&git.InvalidBundleError{})))
+	assert.Equal(t, 2, exitCode(errors.Annotatef(&types.UnsupportedFormatError{Branch: "ghost/a/b", Version: 99}, "failed to pull")))
+	assert.Equal(t, 3, exitCode(errors.WithCategory(errors.New("not found"), errors.CategoryNotFound)))
+	assert.Equal(t, 4, exitCode(errors.WithCategory(errors.New("remote"), errors.CategoryRemote)))
+	assert.Equal(t, 5, exitCode(errors.WithCategory(errors.New("config"), errors.CategoryConfig)))
+	assert.Equal(t, 6, exitCode(errors.WithCategory(errors.New("conflict"), errors.CategoryConflict)))
+	assert.Equal(t, 7, exitCode(errors.WithCategory(errors.New("exists"), errors.CategoryExists)))
+}
+
+func TestExitCodeOfExecute(t *testing.T) {
+	// failures on parsing flags or arguments are config errors unless classified otherwise
+	assert.Equal(t, 5, ExitCode(errors.New("unknown flag")))
+	assert.Equal(t, 3, ExitCode(errors.WithCategory(errors.New("not found"), errors.CategoryNotFound)))
+}
@@ -0,0 +1,74 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(NewExportPatchesCommand())
+}
+
+func NewExportPatchesCommand() *cobra.Command {
+	var (
+		outputDir string
+	)
+	command := &cobra.Command{
+		Use:   "export-patches [from-hash(default=HEAD)] [to-hash] --dir <dir>",
+		Short: "write commits from ghost repo into a directory as a numbered series of patches like 'git format-patch'",
+		Long:  "write commits from [from-hash] to [to-hash] from your ghost repo into <dir> as NNNN-subject.patch files by 'git format-patch', e.g. to review them with standard patch tools or send them by 'git send-email'.  paths of the written patches are printed.",
+		Args:  cobra.RangeArgs(0, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := nonEmpty("dir", outputDir); err != nil {
+				exitWithConfigError(err)
+			}
+			arg := newPullCommitsArg(args)
+			if err := arg.validate(); err != nil {
+				exitWithConfigError(err)
+			}
+			dir, absErr := filepath.Abs(outputDir)
+			if absErr != nil {
+				exitWithConfigError(errors.WithStack(absErr))
+			}
+			options := ghost.ExportPatchesOptions{
+				WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
+				CommitsBranchSpec: &types.CommitsBranchSpec{
+					Prefix:         globalOpts.ghostPrefix,
+					CommittishFrom: arg.commitsFrom,
+					CommittishTo:   arg.commitsTo,
+				},
+				OutputDir: dir,
+			}
+
+			paths, err := ghost.ExportPatches(options)
+			if err != nil {
+				exitWithError(err)
+			}
+			for _, p := range paths {
+				fmt.Println(p)
+			}
+		},
+	}
+	command.Flags().StringVar(&outputDir, "dir", "", "directory to write the patches into, which must be empty or not exist")
+	return command
+}
@@ -0,0 +1,130 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(NewFsckCommand())
+}
+
+type fsckFlags struct {
+	repair    bool
+	noHeaders bool
+	output    string
+}
+
+func NewFsckCommand() *cobra.Command {
+	var (
+		flags fsckFlags
+	)
+	command := &cobra.Command{
+		Use:   "fsck",
+		Short: "check consistency of ghost branches and tags in ghost repo.",
+		Long:  "check consistency of ghost branches and tags in ghost repo: refs not of ghosts, tags of deleted ghost branches, ghost branches whose objects are missing or corrupt and ones whose ghost files are missing or corrupt, and objects by 'git fsck' if ghost repo is local.  it exits with 1 if any problem is left unrepaired.",
+		Args:  cobra.NoArgs,
+		Run:   runFsckCommand(&flags),
+	}
+	command.Flags().BoolVar(&flags.repair, "repair", false, "delete refs of problems found (but refs not of ghosts), and prune unreachable objects older than 2 weeks if ghost repo is local.")
+	command.Flags().BoolVar(&flags.noHeaders, "no-headers", false, "don't print headers and the summary.")
+	command.Flags().StringVarP(&flags.output, "output", "o", "", "Output format. One of: json")
+	return command
+}
+
+func (flags fsckFlags) validate() errors.GitGhostError {
+	if flags.output != "" && flags.output != "json" {
+		return errors.New("output must be json if specified")
+	}
+	if flags.repair && globalOpts.offline {
+		return errors.New("fsck --repair is not available in offline mode because it writes to ghost repo")
+	}
+	return nil
+}
+
+func runFsckCommand(flags *fsckFlags) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if err := flags.validate(); err != nil {
+			exitWithConfigError(err)
+		}
+		if flags.repair && !globalOpts.allowSameRepo {
+			if err := validateNotSameRepo(); err != nil {
+				exitWithConfigError(err)
+			}
+		}
+		if flags.repair {
+			if err := validateNotProtected(globalOpts.ghostRepo, globalOpts.ghostPrefix); err != nil {
+				exitWithError(err)
+			}
+		}
+		options := ghost.FsckOptions{
+			WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
+			Prefix:         globalOpts.ghostPrefix,
+			Repair:         flags.repair,
+		}
+		res, err := ghost.Fsck(options)
+		if err != nil {
+			exitWithError(err)
+		}
+		if flags.output == "json" {
+			printFsckResultJSON(res)
+		} else {
+			fmt.Print(res.PrettyString(!flags.noHeaders))
+		}
+		if n := res.Unrepaired(); n > 0 {
+			exitWithError(errors.Errorf("%d problems are found in ghost repo", n))
+		}
+	}
+}
+
+type fsckProblemJSON struct {
+	Kind     string `json:"kind"`
+	Ref      string `json:"ref,omitempty"`
+	Detail   string `json:"detail"`
+	Repaired bool   `json:"repaired"`
+}
+
+type fsckResultJSON struct {
+	Branches       int               `json:"branches"`
+	Tags           int               `json:"tags"`
+	ObjectsChecked bool              `json:"objectsChecked"`
+	Pruned         bool              `json:"pruned"`
+	Problems       []fsckProblemJSON `json:"problems"`
+}
+
+func printFsckResultJSON(res *ghost.FsckResult) {
+	out := fsckResultJSON{
+		Branches:       res.Branches,
+		Tags:           res.Tags,
+		ObjectsChecked: res.ObjectsChecked,
+		Pruned:         res.Pruned,
+		Problems:       []fsckProblemJSON{},
+	}
+	for _, p := range res.Problems {
+		out.Problems = append(out.Problems, fsckProblemJSON{Kind: p.Kind, Ref: p.Ref, Detail: p.Detail, Repaired: p.Repaired})
+	}
+	bytes, err := json.Marshal(out)
+	if err != nil {
+		exitWithError(errors.WithStack(err))
+	}
+	fmt.Println(string(bytes))
+}
@@ -0,0 +1,174 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(NewGroupCommand())
+}
+
+type groupFlags struct {
+	base          string
+	bundle        bool
+	force         bool
+	autoStash     bool
+	allowSiblings bool
+}
+
+func NewGroupCommand() *cobra.Command {
+	var (
+		flags groupFlags
+	)
+	command := &cobra.Command{
+		Use:   "group",
+		Short: "push and pull ghosts of several repos as a unit.",
+		Long:  "push and pull ghosts of several repos (e.g. siblings orchestrated by a meta-repo) as a unit.  a group is stored as a group branch listing ghost branches of the repos by their directories relative to src-dir.",
+	}
+	pushCommand := &cobra.Command{
+		Use:         "push [repo-dir...]",
+		Annotations: writesGhostRepoAnnotations,
+		Short:       "push ghosts of repos and a group of them",
+		Long:        "push a diff from HEAD to the current state (and commits from --base to HEAD if specified) of every [repo-dir], and then a group branch listing them.  the group is not pushed if any of the repos fails.",
+		Args:        cobra.MinimumNArgs(1),
+		Run:         runGroupPushCommand(&flags),
+	}
+	pushCommand.Flags().StringVar(&flags.base, "base", "", "also push commits from this commit to HEAD of every repo, e.g. a remote branch like origin/master")
+	pushCommand.Flags().BoolVar(&flags.bundle, "bundle", false, "push commits as git bundles, used with --base")
+	pushCommand.Flags().BoolVarP(&flags.force, "force", "f", false, "push diffs even if they are the same as the ones pushed last time, and ignore --max-commits")
+	pushCommand.Flags().BoolVar(&globalOpts.noSecretScan, "no-secret-scan", false, "push ghosts without scanning them for secrets")
+	command.AddCommand(pushCommand)
+	pullCommand := &cobra.Command{
+		Use:   "pull [group-hash]",
+		Short: "pull a group and apply ghosts of its repos",
+		Long:  "pull a group and apply ghosts of its repos into the directories relative to src-dir which they were pushed from.  a failure on a repo doesn't stop applying the others.",
+		Args:  cobra.ExactArgs(1),
+		Run:   runGroupPullCommand(&flags),
+	}
+	pullCommand.Flags().BoolVar(&flags.autoStash, "autostash", false, "stash local changes of every repo before applying and restore them after that")
+	pullCommand.Flags().BoolVar(&flags.allowSiblings, "allow-siblings", false, "allow repos in siblings of src-dir, e.g. ../lib, which are rejected by default")
+	command.AddCommand(pullCommand)
+	return command
+}
+
+func runGroupPushCommand(flags *groupFlags) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if flags.bundle && flags.base == "" {
+			exitWithConfigError(errors.New("bundle is only available with --base"))
+		}
+		components := make([]ghost.PushOptions, 0, len(args))
+		for _, dir := range args {
+			dir, err := filepath.Abs(dir)
+			if err != nil {
+				exitWithConfigError(errors.WithStack(err))
+			}
+			if err := git.ValidateWorkTree(dir); err != nil {
+				exitWithConfigError(errors.Errorf("repo-dir is invalid: %s", err))
+			}
+			spec := globalOpts.WorkingEnvSpec()
+			spec.SrcDir = dir
+			component := ghost.PushOptions{
+				WorkingEnvSpec: spec,
+				DiffBranchSpec: &types.DiffBranchSpec{
+					Prefix:         globalOpts.ghostPrefix,
+					CommittishFrom: "HEAD",
+				},
+				Force: flags.force,
+			}
+			if flags.base != "" {
+				component.CommitsBranchSpec = &types.CommitsBranchSpec{
+					Prefix:         globalOpts.ghostPrefix,
+					CommittishFrom: flags.base,
+					CommittishTo:   "HEAD",
+					Bundle:         flags.bundle,
+					MaxCommits:     globalOpts.maxCommitsLimit(flags.force),
+				}
+			}
+			components = append(components, component)
+		}
+		options := ghost.PushGroupOptions{
+			WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
+			Prefix:         globalOpts.ghostPrefix,
+			Components:     components,
+		}
+
+		result, err := ghost.PushGroup(options)
+		if result == nil {
+			exitWithError(err)
+		}
+		printGroupResult(os.Stderr, result, "pushed")
+		if err != nil {
+			exitWithError(err)
+		}
+		fmt.Println(result.GroupHash)
+	}
+}
+
+func runGroupPullCommand(flags *groupFlags) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if err := nonEmpty("group-hash", args[0]); err != nil {
+			exitWithConfigError(err)
+		}
+		options := ghost.PullGroupOptions{
+			WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
+			Prefix:         globalOpts.ghostPrefix,
+			GroupHash:      args[0],
+			AutoStash:      flags.autoStash,
+			AllowSiblings:  flags.allowSiblings,
+		}
+
+		result, err := ghost.PullGroup(options)
+		if result == nil {
+			exitWithError(err)
+		}
+		printGroupResult(os.Stdout, result, "applied")
+		if err != nil {
+			exitWithError(err)
+		}
+	}
+}
+
+// printGroupResult prints the status of every repo in a group followed by a summary
+func printGroupResult(w *os.File, result *ghost.GroupResult, done string) {
+	failed := 0
+	for _, c := range result.Components {
+		names := make([]string, 0, len(c.Branches))
+		for _, branch := range c.Branches {
+			names = append(names, branch.BranchName())
+		}
+		switch {
+		case c.Err != nil:
+			failed++
+			fmt.Fprintf(w, "%s: failed: %s\n", c.Path, strings.SplitN(c.Err.Error(), "\n", 2)[0])
+		case len(names) == 0:
+			fmt.Fprintf(w, "%s: nothing to do\n", c.Path)
+		default:
+			fmt.Fprintf(w, "%s: %s %s\n", c.Path, done, strings.Join(names, " "))
+		}
+	}
+	fmt.Fprintf(w, "\n%d %s, %d failed\n", len(result.Components)-failed, done, failed)
+}
@@ -0,0 +1,85 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(NewHashCommand())
+}
+
+func NewHashCommand() *cobra.Command {
+	var (
+		flags pushFlags
+	)
+	command := &cobra.Command{
+		Use:   "hash [from-hash(default=HEAD)]",
+		Short: "print the diff hash which 'push diff' would assign to current state of your working dir without pushing",
+		Long:  "print the diff hash of the diff from [from-hash] to current state of your working dir, which is the same as the one 'push diff' with the same flags would assign.  nothing is pushed, and ghost repo is not accessed, so 'git-ghost list diff --to <hash>' can check if the state is already pushed.",
+		Args:  cobra.RangeArgs(0, 1),
+		Run:   runHashCommand(&flags),
+	}
+	addDiffFlags(command, &flags)
+	return command
+}
+
+func runHashCommand(flags *pushFlags) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if err := flags.validate(); err != nil {
+			exitWithConfigError(err)
+		}
+		if err := flags.validateBaseRefs(args); err != nil {
+			exitWithConfigError(err)
+		}
+		arg := newPushDiffArg(args)
+		if err := flags.resolveBaseRefs(&arg); err != nil {
+			exitWithError(err)
+		}
+		if err := arg.validate(); err != nil {
+			exitWithConfigError(err)
+		}
+		options := ghost.HashOptions{
+			WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
+			DiffBranchSpec: &types.DiffBranchSpec{
+				Prefix:                 globalOpts.ghostPrefix,
+				CommittishFrom:         arg.diffFrom,
+				IncludedFilepaths:      flags.includedFilepaths,
+				FollowSymlinks:         flags.followSymlinks,
+				SkipGenerated:          flags.skipGenerated,
+				IgnoreModeChanges:      flags.ignoreModeChanges,
+				Unified:                flags.unifiedContext(),
+				IgnoreLines:            flags.ignoreLines,
+				KeepEmptyDirs:          flags.keepEmptyDirs,
+				NoUntracked:            flags.noUntracked,
+				SkipNonIndexedBinaries: !flags.includeBinaries,
+				SkipNonIndexedText:     !flags.includeText,
+				BinaryAttachments:      flags.binaryAttachments,
+			},
+		}
+
+		branch, err := ghost.Hash(options)
+		if err != nil {
+			exitWithError(err)
+		}
+		fmt.Println(branch.DiffHash)
+	}
+}
@@ -15,6 +15,7 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"regexp"
@@ -31,7 +32,7 @@ func init() {
 	RootCmd.AddCommand(NewListCommand())
 }
 
-var outputTypes = []string{"only-from", "only-to"}
+var outputTypes = []string{"only-from", "only-to", "json"}
 var regexpOutputPattern = regexp.MustCompile("^(|" + strings.Join(outputTypes, "|") + ")$")
 
 type listFlags struct {
@@ -39,6 +40,12 @@ type listFlags struct {
 	hashTo    string
 	noHeaders bool
 	output    string
+	maxCount  int
+	after     string
+	size      bool
+	stream    bool
+	meta      []string
+	jobs      int
 }
 
 func NewListCommand() *cobra.Command {
@@ -77,7 +84,13 @@ func NewListCommand() *cobra.Command {
 	command.PersistentFlags().StringVar(&listFlags.hashFrom, "from", "", "commit or diff hash to which ghost branches are listed.")
 	command.PersistentFlags().StringVar(&listFlags.hashTo, "to", "", "commit or diff hash from which ghost branches are listed.")
 	command.PersistentFlags().BoolVar(&listFlags.noHeaders, "no-headers", false, "When using the default, only-from or only-to output format, don't print headers (default print headers).")
-	command.PersistentFlags().StringVarP(&listFlags.output, "output", "o", "", "Output format. One of: only-from|only-to")
+	command.PersistentFlags().StringVarP(&listFlags.output, "output", "o", "", "Output format. One of: only-from|only-to|json")
+	command.PersistentFlags().IntVar(&listFlags.maxCount, "max-count", 0, "Limit the number of ghost branches to list, counting all types (default no limit).")
+	command.PersistentFlags().StringVar(&listFlags.after, "after", "", "List ghost branches after the one with this branch name (the branch of JSON output) to page through results of all types.")
+	command.PersistentFlags().BoolVar(&listFlags.size, "size", false, "Show stored sizes of ghost branches and their total, which requires fetching them.")
+	command.PersistentFlags().StringArrayVar(&listFlags.meta, "meta", []string{}, "List only ghost branches pushed with this metadata key=value by 'push --meta', or having key with any value, which requires fetching them. this flag can be repeated to require all of them.")
+	command.PersistentFlags().IntVarP(&listFlags.jobs, "jobs", "j", 1, "maximum number of ghost branches whose metadata is read in parallel for --meta, bounded by --concurrency.")
+	command.PersistentFlags().BoolVar(&listFlags.stream, "stream", false, "Print ghost branches as soon as the ghost repo lists them. JSON output is printed as one object per line.")
 	return command
 }
 
@@ -85,28 +98,23 @@ func runListCommitsCommand(flags *listFlags) func(cmd *cobra.Command, args []str
 	return func(cmd *cobra.Command, args []string) {
 		err := flags.validate()
 		if err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithConfigError(err)
 		}
 		opts := ghost.ListOptions{
-			WorkingEnvSpec: types.WorkingEnvSpec{
-				SrcDir:          globalOpts.srcDir,
-				GhostWorkingDir: globalOpts.ghostWorkDir,
-				GhostRepo:       globalOpts.ghostRepo,
-			},
+			WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
 			ListCommitsBranchSpec: &types.ListCommitsBranchSpec{
 				Prefix:   globalOpts.ghostPrefix,
 				HashFrom: flags.hashFrom,
 				HashTo:   flags.hashTo,
 			},
+			MaxCount: flags.maxCount,
+			After:    flags.after,
+			Size:     flags.size,
+			Metadata: flags.metadata(),
+			Jobs:     flags.jobs,
 		}
 
-		res, err := ghost.List(opts)
-		if err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
-		}
-		fmt.Print(res.PrettyString(!flags.noHeaders, flags.output))
+		flags.list(opts)
 	}
 }
 
@@ -114,28 +122,23 @@ func runListDiffCommand(flags *listFlags) func(cmd *cobra.Command, args []string
 	return func(cmd *cobra.Command, args []string) {
 		err := flags.validate()
 		if err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithConfigError(err)
 		}
 		opts := ghost.ListOptions{
-			WorkingEnvSpec: types.WorkingEnvSpec{
-				SrcDir:          globalOpts.srcDir,
-				GhostWorkingDir: globalOpts.ghostWorkDir,
-				GhostRepo:       globalOpts.ghostRepo,
-			},
+			WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
 			ListDiffBranchSpec: &types.ListDiffBranchSpec{
 				Prefix:   globalOpts.ghostPrefix,
 				HashFrom: flags.hashFrom,
 				HashTo:   flags.hashTo,
 			},
+			MaxCount: flags.maxCount,
+			After:    flags.after,
+			Size:     flags.size,
+			Metadata: flags.metadata(),
+			Jobs:     flags.jobs,
 		}
 
-		res, err := ghost.List(opts)
-		if err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
-		}
-		fmt.Print(res.PrettyString(!flags.noHeaders, flags.output))
+		flags.list(opts)
 	}
 }
 
@@ -143,15 +146,10 @@ func runListAllCommand(flags *listFlags) func(cmd *cobra.Command, args []string)
 	return func(cmd *cobra.Command, args []string) {
 		err := flags.validate()
 		if err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithConfigError(err)
 		}
 		opts := ghost.ListOptions{
-			WorkingEnvSpec: types.WorkingEnvSpec{
-				SrcDir:          globalOpts.srcDir,
-				GhostWorkingDir: globalOpts.ghostWorkDir,
-				GhostRepo:       globalOpts.ghostRepo,
-			},
+			WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
 			ListCommitsBranchSpec: &types.ListCommitsBranchSpec{
 				Prefix:   globalOpts.ghostPrefix,
 				HashFrom: flags.hashFrom,
@@ -162,14 +160,14 @@ func runListAllCommand(flags *listFlags) func(cmd *cobra.Command, args []string)
 				HashFrom: flags.hashFrom,
 				HashTo:   flags.hashTo,
 			},
+			MaxCount: flags.maxCount,
+			After:    flags.after,
+			Size:     flags.size,
+			Metadata: flags.metadata(),
+			Jobs:     flags.jobs,
 		}
 
-		res, err := ghost.List(opts)
-		if err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
-		}
-		fmt.Print(res.PrettyString(!flags.noHeaders, flags.output))
+		flags.list(opts)
 	}
 }
 
@@ -177,5 +175,161 @@ func (flags listFlags) validate() errors.GitGhostError {
 	if !regexpOutputPattern.MatchString(flags.output) {
 		return errors.Errorf("output must be one of %v", outputTypes)
 	}
+	if flags.maxCount < 0 {
+		return errors.New("max-count must not be negative")
+	}
+	if flags.stream && flags.size {
+		return errors.New("can't specify --size with --stream")
+	}
+	if flags.stream && len(flags.meta) > 0 {
+		return errors.New("can't specify --meta with --stream")
+	}
+	if _, err := types.ParseMetadataFilter(flags.meta); err != nil {
+		return errors.Errorf("meta is invalid: %s", err)
+	}
+	if flags.jobs < 1 {
+		return errors.Errorf("jobs must be a positive integer (value: %d)", flags.jobs)
+	}
 	return nil
 }
+
+// metadata returns conditions which metadata of listed ghost branches must meet, which are validated beforehand
+func (flags listFlags) metadata() types.MetadataFilter {
+	filter, _ := types.ParseMetadataFilter(flags.meta)
+	return filter
+}
+
+func (flags listFlags) list(opts ghost.ListOptions) {
+	if flags.stream {
+		flags.printStream(opts)
+		return
+	}
+	res, err := ghost.List(opts)
+	if err != nil {
+		exitWithError(err)
+	}
+	flags.print(res)
+}
+
+func (flags listFlags) print(res *ghost.ListResult) {
+	if flags.output == "json" {
+		printListResultJSON(res)
+		return
+	}
+	fmt.Print(res.PrettyString(!flags.noHeaders, flags.output))
+}
+
+// printStream prints ghost branches as ghost.ListStream passes them
+func (flags listFlags) printStream(opts ghost.ListOptions) {
+	if flags.output == "json" {
+		err := ghost.ListStream(opts, printListedBranchJSON)
+		if err != nil {
+			exitWithError(err)
+		}
+		return
+	}
+	printer := ghost.NewListStreamPrinter(os.Stdout, opts, !flags.noHeaders, flags.output)
+	err := ghost.ListStream(opts, printer.Print)
+	if err != nil {
+		exitWithError(err)
+	}
+	printer.Close()
+}
+
+// printListedBranchJSON prints a ghost branch as a line of JSON object with its type
+func printListedBranchJSON(branch types.GhostBranch) errors.GitGhostError {
+	var out interface{}
+	switch br := branch.(type) {
+	case types.CommitsBranch:
+		out = listedCommitsJSON{
+			Type:   "commits",
+			Branch: br.BranchName(),
+			From:   br.CommitHashFrom,
+			To:     br.CommitHashTo,
+		}
+	case types.DiffBranch:
+		out = listedDiffJSON{
+			Type:   "diff",
+			Branch: br.BranchName(),
+			From:   br.CommitHashFrom,
+			Hash:   br.DiffHash,
+		}
+	default:
+		return errors.Errorf("unknown ghost branch type %T", branch)
+	}
+	bytes, err := json.Marshal(out)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	fmt.Println(string(bytes))
+	return nil
+}
+
+type listedCommitsJSON struct {
+	Type   string `json:"type,omitempty"`
+	Branch string `json:"branch"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Size   *int64 `json:"size,omitempty"`
+}
+
+type listedDiffJSON struct {
+	Type   string `json:"type,omitempty"`
+	Branch string `json:"branch"`
+	From   string `json:"from"`
+	Hash   string `json:"hash"`
+	Size   *int64 `json:"size,omitempty"`
+}
+
+type listResultJSON struct {
+	Commits   []listedCommitsJSON `json:"commits,omitempty"`
+	Diffs     []listedDiffJSON    `json:"diffs,omitempty"`
+	TotalSize *int64              `json:"totalSize,omitempty"`
+}
+
+func printListResultJSON(res *ghost.ListResult) {
+	size := func(name string) *int64 {
+		if res.Sizes == nil {
+			return nil
+		}
+		s := res.Sizes[name]
+		return &s
+	}
+
+	out := listResultJSON{}
+	if res.CommitsBranches != nil {
+		branches := *res.CommitsBranches
+		branches.Sort()
+		out.Commits = []listedCommitsJSON{}
+		for _, branch := range branches {
+			out.Commits = append(out.Commits, listedCommitsJSON{
+				Branch: branch.BranchName(),
+				From:   branch.CommitHashFrom,
+				To:     branch.CommitHashTo,
+				Size:   size(branch.BranchName()),
+			})
+		}
+	}
+	if res.DiffBranches != nil {
+		branches := *res.DiffBranches
+		branches.Sort()
+		out.Diffs = []listedDiffJSON{}
+		for _, branch := range branches {
+			out.Diffs = append(out.Diffs, listedDiffJSON{
+				Branch: branch.BranchName(),
+				From:   branch.CommitHashFrom,
+				Hash:   branch.DiffHash,
+				Size:   size(branch.BranchName()),
+			})
+		}
+	}
+	if res.Sizes != nil {
+		total := res.TotalSize()
+		out.TotalSize = &total
+	}
+	bytes, err := json.Marshal(out)
+	if err != nil {
+		exitWithError(errors.WithStack(err))
+	}
+	fmt.Println(string(bytes))
+}
@@ -0,0 +1,64 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(NewLogCommand())
+}
+
+func NewLogCommand() *cobra.Command {
+	var (
+		format types.LogFormat
+	)
+	command := &cobra.Command{
+		Use:   "log [from-hash(default=HEAD)] [to-hash]",
+		Short: "show commits in ghost repo like 'git log' without applying them",
+		Long:  "show commits from [from-hash] to [to-hash] in your ghost repo with their subjects, authors and dates like 'git log', e.g. to review what pulling them adds.  commits pushed as patches are recreated in a temporary worktree, so their hashes may differ from the original ones.",
+		Args:  cobra.RangeArgs(0, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			arg := newPullCommitsArg(args)
+			if err := arg.validate(); err != nil {
+				exitWithConfigError(err)
+			}
+			options := ghost.LogOptions{
+				WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
+				CommitsBranchSpec: &types.CommitsBranchSpec{
+					Prefix:         globalOpts.ghostPrefix,
+					CommittishFrom: arg.commitsFrom,
+					CommittishTo:   arg.commitsTo,
+				},
+				LogFormat: format,
+				Writer:    os.Stdout,
+			}
+
+			err := ghost.Log(options)
+			if err != nil {
+				exitWithError(err)
+			}
+		},
+	}
+	command.Flags().BoolVar(&format.Oneline, "oneline", false, "show each commit in a line like 'git log --oneline'")
+	command.Flags().BoolVar(&format.Stat, "stat", false, "show files changed by each commit like 'git log --stat'")
+	return command
+}
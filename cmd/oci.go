@@ -0,0 +1,95 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/oci"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(NewOCICommand())
+}
+
+type ociFlags struct {
+	noHeaders bool
+}
+
+func NewOCICommand() *cobra.Command {
+	var (
+		flags ociFlags
+	)
+	command := &cobra.Command{
+		Use:   "oci",
+		Short: "share ghost branches as OCI artifacts.",
+		Long:  "share ghost branches as OCI artifacts in container registries, which are pulled by 'pull --oci'.  credentials are taken from 'docker login'.",
+	}
+	command.AddCommand(&cobra.Command{
+		Use:   "push [hash] [reference]",
+		Short: "push a ghost branch to a registry",
+		Long:  "push a ghost branch whose diff hash (or the last hash of its line in 'list' output) is [hash] to [reference] (e.g. registry.example.com/team/ghosts:tag) as an OCI artifact.  the tag defaults to one derived from the ghost branch.",
+		Args:  cobra.ExactArgs(2),
+		Run:   runOCIPushCommand,
+	})
+	listCommand := &cobra.Command{
+		Use:   "list [repository]",
+		Short: "list ghost branches in a registry",
+		Long:  "list tags of [repository] (e.g. registry.example.com/team/ghosts) with ghost branches which they point to.",
+		Args:  cobra.ExactArgs(1),
+		Run:   runOCIListCommand(&flags),
+	}
+	listCommand.Flags().BoolVar(&flags.noHeaders, "no-headers", false, "don't print headers (default print headers).")
+	command.AddCommand(listCommand)
+	return command
+}
+
+func newOCIOptions() ghost.OCIOptions {
+	return ghost.OCIOptions{
+		WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
+		Prefix:         globalOpts.ghostPrefix,
+		Client:         globalOpts.ociClientOptions(),
+	}
+}
+
+func runOCIPushCommand(cmd *cobra.Command, args []string) {
+	if err := nonEmpty("hash", args[0]); err != nil {
+		exitWithConfigError(err)
+	}
+	if _, err := oci.ParseReference(args[1]); err != nil {
+		exitWithConfigError(err)
+	}
+	artifact, err := ghost.PushOCI(newOCIOptions(), args[0], args[1])
+	if err != nil {
+		exitWithError(err)
+	}
+	fmt.Printf("%s@%s %s\n", artifact.Reference, artifact.Digest, artifact.Branch)
+}
+
+func runOCIListCommand(flags *ociFlags) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if _, err := oci.ParseReference(args[0]); err != nil {
+			exitWithConfigError(err)
+		}
+		artifacts, err := ghost.ListOCI(newOCIOptions(), args[0])
+		if err != nil {
+			exitWithError(err)
+		}
+		fmt.Print(artifacts.PrettyString(!flags.noHeaders))
+	}
+}
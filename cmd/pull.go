@@ -15,10 +15,16 @@
 package cmd
 
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
 
 	"github.com/pfnet-research/git-ghost/pkg/ghost"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/oci"
 	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util"
 	"github.com/pfnet-research/git-ghost/pkg/util/errors"
 
 	log "github.com/sirupsen/logrus"
@@ -30,7 +36,319 @@ func init() {
 }
 
 type pullFlags struct {
-	// forceApply bool
+	force           bool
+	autoStash       bool
+	atomic          bool
+	commit          bool
+	commitMessage   string
+	commitAuthor    string
+	backup          bool
+	keepBackup      bool
+	directory       string
+	strip           int
+	reject          bool
+	allowFuzz       int
+	onCollision     string
+	recover         bool
+	rerere          bool
+	resume          bool
+	strategyOption  string
+	strict          bool
+	trailers        bool
+	ffOnly          bool
+	report          string
+	onlyConflicts   bool
+	failOnHookError bool
+	quiet           bool
+	progressFormat  string
+	noFetchBase     bool
+	check           bool
+	latest          string
+	oci             string
+	// paths are given after "--" instead of by a flag
+	paths []string
+}
+
+func (flags pullFlags) validate() errors.GitGhostError {
+	if flags.commit && flags.commitMessage == "" {
+		return errors.New("message must be specified with --commit")
+	}
+	if !flags.commit && (flags.commitMessage != "" || flags.commitAuthor != "") {
+		return errors.New("message and author are only available with --commit")
+	}
+	if flags.keepBackup && !flags.backup {
+		return errors.New("keep-backup is only available with --backup")
+	}
+	if flags.reject && flags.commit {
+		return errors.New("reject is not available with --commit, which requires a diff to be applied entirely")
+	}
+	if flags.allowFuzz < 0 {
+		return errors.New("allow-fuzz must not be negative")
+	}
+	if flags.allowFuzz > 0 && (flags.commit || flags.reject || flags.recover) {
+		return errors.New("allow-fuzz is not available with --commit, --reject or --recover, which apply a diff in other ways")
+	}
+	if flags.onCollision != "" && len(util.SubtractStringSlice([]string{flags.onCollision}, types.CollisionPolicies)) > 0 {
+		return errors.Errorf("on-untracked-collision must be one of %v but got '%s'", types.CollisionPolicies, flags.onCollision)
+	}
+	if flags.recover && flags.commit {
+		return errors.New("recover is not available with --commit, which requires a diff to be applied entirely")
+	}
+	if flags.recover && flags.strategyOption != "" {
+		return errors.New("recover is not available with --strategy-option, which is another way to resolve conflicts")
+	}
+	if flags.resume && flags.commit {
+		return errors.New("resume is not available with --commit, which requires a diff to be applied entirely")
+	}
+	if flags.resume && flags.strategyOption != "" {
+		return errors.New("resume is not available with --strategy-option, which recreates all the commits on their base")
+	}
+	if flags.resume && flags.ffOnly {
+		return errors.New("resume is not available with --ff-only, which requires HEAD not to have any commits beyond the base")
+	}
+	if flags.onlyConflicts && globalOpts.verbose > 0 {
+		return errors.New("only-conflicts is not available with --verbose, which logs clean applies as well")
+	}
+	if flags.atomic && (flags.autoStash || flags.reject || flags.resume || flags.check) {
+		return errors.New("atomic is not available with --autostash, --reject, --resume or --check, which leave what they apply partially by design")
+	}
+	if flags.check && (flags.autoStash || flags.commit || flags.backup || flags.reject || flags.allowFuzz > 0 || flags.onCollision != "" || flags.recover || flags.rerere || flags.resume || flags.strategyOption != "" || flags.ffOnly || flags.report != "" || flags.onlyConflicts) {
+		return errors.New("check is not available with --autostash, --commit, --backup, --reject, --allow-fuzz, --on-untracked-collision, --recover, --rerere, --resume, --strategy-option, --ff-only, --report or --only-conflicts, which change how ghosts are applied")
+	}
+	if flags.latest != "" && flags.oci != "" {
+		return errors.New("latest is not available with --oci")
+	}
+	if flags.oci != "" {
+		if _, err := oci.ParseReference(flags.oci); err != nil {
+			return errors.Errorf("oci is invalid: %s", err)
+		}
+	}
+	if flags.strip < 1 {
+		return errors.New("strip must be at least 1")
+	}
+	if flags.strategyOption != "" && flags.strategyOption != "ours" && flags.strategyOption != "theirs" {
+		return errors.Errorf("strategy-option must be 'ours' or 'theirs' but got '%s'", flags.strategyOption)
+	}
+	if flags.rerere && flags.strategyOption != "" {
+		return errors.New("rerere is not available with --strategy-option, which is another way to resolve conflicts")
+	}
+	if flags.strategyOption != "" && (flags.directory != "" || flags.strip > 1) {
+		return errors.New("strategy-option is not available with --directory or --strip")
+	}
+	return validateProgressFormat(flags.progressFormat)
+}
+
+func (flags pullFlags) applyOptions() types.ApplyOptions {
+	opts := types.ApplyOptions{
+		Force:              flags.force,
+		Backup:             flags.backup,
+		KeepBackup:         flags.keepBackup,
+		Directory:          flags.directory,
+		Strip:              flags.strip,
+		Reject:             flags.reject,
+		Fuzz:               flags.allowFuzz,
+		UntrackedCollision: flags.onCollision,
+		Recover:            flags.recover,
+		Rerere:             flags.rerere,
+		Resume:             flags.resume,
+		StrategyOption:     flags.strategyOption,
+		StrictSourceRepo:   flags.strict,
+		Trailers:           flags.trailers,
+		FFOnly:             flags.ffOnly,
+		Paths:              flags.paths,
+	}
+	if flags.commit {
+		opts.Commit = &types.CommitOptions{
+			Message: flags.commitMessage,
+			Author:  flags.commitAuthor,
+		}
+	}
+	return opts
+}
+
+// pull pulls and applies ghosts, writes a report of applying them if required by flags and exits on an error
+func (flags pullFlags) pull(options ghost.PullOptions) {
+	if flags.check {
+		flags.checkPull(options)
+		return
+	}
+	if flags.report != "" || flags.onlyConflicts || flags.allowFuzz > 0 || flags.onCollision != "" || flags.rerere {
+		options.ApplyOptions.Report = &types.ApplyReport{Ghosts: []types.GhostApplyReport{}}
+	}
+	options.PostApplyHook = globalOpts.postApplyHook
+	options.FailOnHookError = flags.failOnHookError
+	setProgressFormat(flags.progressFormat)
+	types.SetFetchMissingBase(!flags.noFetchBase)
+	if !flags.quiet && !flags.onlyConflicts {
+		options.OnPulled = func(m types.TransferMetrics) { printTransferMetrics("pull", []types.TransferMetrics{m}) }
+	}
+	err := ghost.Pull(options)
+	if flags.allowFuzz > 0 {
+		printFuzzedHunks(options.ApplyOptions.Report)
+	}
+	if flags.onCollision != "" {
+		printCollisions(options.ApplyOptions.Report)
+	}
+	if flags.rerere {
+		printRerere(options.ApplyOptions.Report)
+	}
+	if flags.onlyConflicts && err != nil && errors.CategoryOf(err) == errors.CategoryConflict {
+		printConflicts(options.ApplyOptions.Report, err)
+	}
+	if flags.report != "" {
+		// the report is written even on an error so that what was applied partially is recorded
+		report := options.ApplyOptions.Report
+		if err != nil {
+			report.Error = err.Error()
+		}
+		writeErr := writeApplyReport(flags.report, report)
+		if writeErr != nil {
+			if err == nil {
+				exitWithError(writeErr)
+			}
+			errors.LogErrorWithStack(writeErr)
+		}
+	}
+	if err != nil {
+		exitWithError(err)
+	}
+}
+
+// checkPull prints whether ghosts apply to working dir as it is without applying them, and exits with the conflict code if they don't
+func (flags pullFlags) checkPull(options ghost.PullOptions) {
+	types.SetFetchMissingBase(!flags.noFetchBase)
+	result, err := ghost.CheckPull(options)
+	if err != nil {
+		exitWithError(err)
+	}
+	branches := strings.Join(result.Branches, ", ")
+	if result.Clean {
+		fmt.Printf("%s applies cleanly to %s\n", branches, globalOpts.srcDir)
+		return
+	}
+	if len(result.Conflicts) > 0 {
+		fmt.Printf("%s conflicts with %s in %d files: %s\n", branches, globalOpts.srcDir, len(result.Conflicts), strings.Join(result.Conflicts, ", "))
+	} else {
+		fmt.Printf("%s does not apply to %s\n", branches, globalOpts.srcDir)
+	}
+	exitWithError(errors.WithCategory(errors.New(result.Error), errors.CategoryConflict))
+}
+
+// pullLatest pulls the ghost branch of the latest tag matching --latest, which must be of ghostType unless it is empty
+func (flags pullFlags) pullLatest(args []string, ghostType string) {
+	if len(args) > 0 {
+		exitWithConfigError(errors.New("hashes are not available with --latest"))
+	}
+	tag, err := ghost.LatestTag(newTagOptions(), flags.latest)
+	if err != nil {
+		exitWithError(err)
+	}
+	log.Infof("pulling %s tagged as %s", tag.Branch.BranchName(), tag.Name)
+	flags.pull(flags.branchPullOptions(tag.Branch, "tag "+tag.Name, ghostType))
+}
+
+// pullOCI pulls the ghost branch stored as the OCI artifact of --oci, which must be of ghostType unless it is empty
+func (flags pullFlags) pullOCI(args []string, ghostType string) {
+	if len(args) > 0 {
+		exitWithConfigError(errors.New("hashes are not available with --oci"))
+	}
+	branch, ref, err := ghost.ResolveOCI(globalOpts.ociClientOptions(), flags.oci)
+	if err != nil {
+		exitWithError(err)
+	}
+	log.Infof("pulling %s from %s", branch.BranchName(), ref)
+	options := flags.branchPullOptions(branch, flags.oci, ghostType)
+	// the artifact is pinned by its digest so that the tag moved meanwhile doesn't mix up ghosts
+	options.OCIReference = ref.String()
+	options.OCIClient = globalOpts.ociClientOptions()
+	flags.pull(options)
+}
+
+// branchPullOptions returns options to pull branch found by source, which must be of ghostType unless it is empty
+func (flags pullFlags) branchPullOptions(branch types.GhostBranch, source, ghostType string) ghost.PullOptions {
+	options := ghost.PullOptions{
+		WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
+		ApplyOptions:   flags.applyOptions(),
+		AutoStash:      flags.autoStash,
+		Atomic:         flags.atomic,
+	}
+	switch b := branch.(type) {
+	case *types.CommitsBranch:
+		options.CommitsBranchSpec = &types.CommitsBranchSpec{
+			Prefix:         globalOpts.ghostPrefix,
+			CommittishFrom: b.CommitHashFrom,
+			CommittishTo:   b.CommitHashTo,
+		}
+	case *types.DiffBranch:
+		options.PullableDiffBranchSpec = &types.PullableDiffBranchSpec{
+			Prefix:         globalOpts.ghostPrefix,
+			CommittishFrom: b.CommitHashFrom,
+			DiffHash:       b.DiffHash,
+		}
+	}
+	if (ghostType == "commits" && options.CommitsBranchSpec == nil) || (ghostType == "diff" && options.PullableDiffBranchSpec == nil) {
+		exitWithConfigError(errors.Errorf("%s points to %s, which can't be pulled by 'pull %s'", source, branch.BranchName(), ghostType))
+	}
+	return options
+}
+
+// printFuzzedHunks prints hunks applied with fuzz to stderr, which should be reviewed
+func printFuzzedHunks(report *types.ApplyReport) {
+	for _, g := range report.Ghosts {
+		for _, h := range g.Fuzzed {
+			fmt.Fprintf(os.Stderr, "fuzz: %s: %s\n", g.Branch, h)
+		}
+	}
+}
+
+// printRerere prints paths of conflicts resolved by rerere to stderr
+func printRerere(report *types.ApplyReport) {
+	for _, g := range report.Ghosts {
+		for _, path := range g.Rerere {
+			fmt.Fprintf(os.Stderr, "rerere: %s: %s: resolved by a recorded resolution\n", g.Branch, path)
+		}
+	}
+}
+
+// printCollisions prints untracked files which were in the way of files created by ghosts to stderr with how they are resolved
+func printCollisions(report *types.ApplyReport) {
+	for _, g := range report.Ghosts {
+		for _, c := range g.Collisions {
+			resolved := map[string]string{
+				types.CollisionSkip:      "skipped, keeping the untracked file",
+				types.CollisionOverwrite: "overwritten",
+				types.CollisionBackup:    "overwritten after backed up into " + c.BackupDir,
+			}[c.Policy]
+			fmt.Fprintf(os.Stderr, "collision: %s: %s: %s\n", g.Branch, c.Path, resolved)
+		}
+	}
+}
+
+// printConflicts prints ghosts which failed to be applied by a conflict in the format of the apply report
+func printConflicts(report *types.ApplyReport, err errors.GitGhostError) {
+	conflicts := types.ApplyReport{
+		Ghosts: []types.GhostApplyReport{},
+		Error:  err.Error(),
+	}
+	for _, g := range report.Ghosts {
+		if !g.Applied {
+			conflicts.Ghosts = append(conflicts.Ghosts, g)
+		}
+	}
+	data, jsonErr := json.MarshalIndent(conflicts, "", "  ")
+	if jsonErr != nil {
+		errors.LogErrorWithStack(errors.WithStack(jsonErr))
+		return
+	}
+	fmt.Println(string(data))
+}
+
+func writeApplyReport(path string, report *types.ApplyReport) errors.GitGhostError {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(ioutil.WriteFile(path, append(data, '\n'), 0644))
 }
 
 func NewPullCommand() *cobra.Command {
@@ -38,38 +356,84 @@ func NewPullCommand() *cobra.Command {
 		flags pullFlags
 	)
 	command := &cobra.Command{
-		Use:   "pull [from-hash(default=HEAD)] [diff-hash]",
+		Use:   "pull [from-hash(default=HEAD)] [diff-hash] [-- path...]",
 		Short: "pull commits(hash1...hash2), diff(hash...current state) from ghost repo and apply them to working dir",
-		Long:  "pull commits or diff or all from ghost repo and apply them to working dir.  If you didn't specify any subcommand, this commands works as an alias for 'pull diff' command.",
-		Args:  cobra.RangeArgs(1, 2),
+		Long:  "pull commits or diff or all from ghost repo and apply them to working dir.  If you didn't specify any subcommand, this commands works as an alias for 'pull diff' command.  Paths after '--' limit applying a diff to files at or under them.",
+		Args:  hashArgs(0, 2),
 		Run:   runPullDiffCommand(&flags),
 	}
-	// command.PersistentFlags().BoolVarP(&flags.forceApply, "force", "f", true, "force apply pulled ghost branches to working dir")
-
 	command.AddCommand(&cobra.Command{
-		Use:   "diff [diff-from-hash(default=HEAD)] [diff-hash]",
+		Use:   "diff [diff-from-hash(default=HEAD)] [diff-hash] [-- path...]",
 		Short: "pull diff from ghost repo and apply it to working dir",
-		Long:  "pull diff from [diff-from-hash] to [diff-hash] from your ghost repo and apply it to working dir",
-		Args:  cobra.RangeArgs(1, 2),
+		Long:  "pull diff from [diff-from-hash] to [diff-hash] from your ghost repo and apply it to working dir.  Paths after '--' (relative to the top of working dir as in the ghost) limit applying to files at or under them, where a renamed file is applied entirely if either of its old and new paths matches.",
+		Args:  hashArgs(0, 2),
 		Run:   runPullDiffCommand(&flags),
 	})
 	command.AddCommand(&cobra.Command{
 		Use:   "commits [from-hash(default=HEAD)] [to-hash]",
 		Short: "pull commits from ghost repo and apply it to working dir",
 		Long:  "pull commits from [from-hash] to [to-hash] from your ghost repo and apply it to working dir",
-		Args:  cobra.RangeArgs(1, 2),
+		Args:  hashArgs(0, 2),
 		Run:   runPullCommitsCommand(&flags),
 	})
 	command.AddCommand(&cobra.Command{
 		Use:   "all [from-hash(default=HEAD)] [to-hash] [diff-hash]",
 		Short: "pull both commits and diff from ghost repo and apply them to working dir sequentially",
 		Long:  "pull commits([from-hash]...[to-hash]) and diff([to-hash]...[diff-hash]) and apply them to working dir sequentially",
-		Args:  cobra.RangeArgs(2, 3),
+		Args:  hashArgs(2, 3),
 		Run:   runPullAllCommand(&flags),
 	})
+	command.PersistentFlags().BoolVar(&flags.autoStash, "autostash", false, "stash local changes before applying and restore them after that")
+	command.PersistentFlags().BoolVar(&flags.atomic, "atomic", false, "restore HEAD, the index and working dir including untracked files as they were before pulling if applying any of the ghosts (or the post-apply hook with --fail-on-hook-error) fails or is interrupted")
+	command.PersistentFlags().BoolVarP(&flags.force, "force", "f", false, "abort 'git am' or 'git rebase' left in progress in working dir before applying, and apply ghosts refused by --strict")
+	command.PersistentFlags().BoolVar(&flags.strict, "strict", false, "refuse ghosts created in a repo which is neither working dir nor one of its remotes, which are only warned about by default")
+	command.PersistentFlags().BoolVar(&flags.trailers, "trailers", false, "append trailers of where ghosts come from (Git-Ghost-Branch, Git-Ghost-Base, Git-Ghost-Source-Repo, Git-Ghost-Pushed-By, Git-Ghost-Pushed-At and Git-Ghost-Version) to messages of applied commits (no effect on diffs and bundles)")
+	command.PersistentFlags().BoolVar(&flags.ffOnly, "ff-only", false, "refuse commits which would not fast-forward HEAD like 'git merge --ff-only', i.e. unless HEAD is their base or an ancestor of it, which is fast-forwarded to the base before applying (no effect on diffs)")
+	command.PersistentFlags().BoolVar(&flags.backup, "backup", false, "back up files touched by applying into .git/git-ghost-backup/<timestamp> beforehand, which are kept if applying fails")
+	command.PersistentFlags().BoolVar(&flags.keepBackup, "keep-backup", false, "keep the backup even if applying succeeds, used with --backup")
+	command.PersistentFlags().StringVar(&flags.directory, "directory", "", "apply into the directory relative to the top of working dir by prepending it to paths in ghosts, like 'git apply --directory' (not supported for bundles)")
+	command.PersistentFlags().IntVar(&flags.strip, "strip", 1, "number of leading components removed from paths in ghosts, like 'git apply -p' (not supported for bundles)")
+	command.PersistentFlags().BoolVar(&flags.reject, "reject", false, "apply hunks of a diff which can be applied and leave the others in *.rej files like 'git apply --reject' (no effect on commits)")
+	command.PersistentFlags().StringVar(&flags.onCollision, "on-untracked-collision", "", "resolve untracked files in the way of files which a diff creates, which make applying fail by default: 'skip' keeps the untracked files without applying the diff to them, 'overwrite' replaces them, and 'backup' replaces them after copying them into .git/git-ghost-backup. they are printed to stderr (no effect on commits)")
+	command.PersistentFlags().IntVar(&flags.allowFuzz, "allow-fuzz", 0, "fall back on 'patch' ignoring up to this number of lines of context of each hunk (2 if the number is omitted) when 'git apply' refuses a diff, which may apply hunks to wrong places. hunks applied with fuzz are printed to stderr (no effect on commits)")
+	command.PersistentFlags().Lookup("allow-fuzz").NoOptDefVal = "2"
+	command.PersistentFlags().BoolVar(&flags.recover, "recover", false, "escalate applying which fails: retry commits by 'git am --3way', and salvage hunks which can be applied by 'git apply --reject' at last, leaving the others in *.rej files (no effect on bundles)")
+	command.PersistentFlags().BoolVar(&flags.rerere, "rerere", false, "retry commits which 'git am' refuses by 'git am --3way' with rerere enabled, and continue it while conflicts are all resolved by resolutions recorded by rerere. resolved conflicts are printed to stderr (no effect on diffs and bundles)")
+	command.PersistentFlags().BoolVar(&flags.resume, "resume", false, "skip what is already applied, e.g. by a pull interrupted partway: commits whose patch ids are in HEAD and files of a diff already changed, quitting 'git am' left in progress (no effect on bundles)")
+	command.PersistentFlags().StringVarP(&flags.strategyOption, "strategy-option", "X", "", "cherry-pick commits with the merge strategy option ('ours' or 'theirs') if they conflict, which gives them new hashes (no effect on diffs and bundles)")
+	command.PersistentFlags().StringVar(&globalOpts.postApplyHook, "post-apply-hook", "", "shell command run in working dir after ghosts are applied successfully, which gets the last ghost by GIT_GHOST_TYPE, GIT_GHOST_FROM, GIT_GHOST_HASH and GIT_GHOST_BRANCHES envs (default to GIT_GHOST_POST_APPLY_HOOK env, or ghost.postApplyHook git config)")
+	command.PersistentFlags().BoolVar(&flags.failOnHookError, "fail-on-hook-error", false, "fail if the post-apply hook fails, which is only reported by default")
+	command.PersistentFlags().StringVar(&flags.latest, "latest", "", "pull the ghost branch of the latest tag matching the glob pattern (e.g. 'ci/*') instead of hashes, whose type is taken from the ghost branch by 'pull' (not available with 'pull all')")
+	command.PersistentFlags().StringVar(&flags.oci, "oci", "", "pull the ghost branch stored as an OCI artifact by 'oci push' at the reference (e.g. 'registry.example.com/team/ghosts:tag') from the registry instead of ghost repo, whose type is taken from the ghost branch by 'pull' (not available with 'pull all')")
+	command.PersistentFlags().BoolVar(&flags.onlyConflicts, "only-conflicts", false, "print nothing on a clean apply, and print ghosts which conflict in JSON in the format of --report only on a conflict, which exits with code 6 (not available with --verbose)")
+	command.PersistentFlags().BoolVarP(&flags.quiet, "quiet", "q", false, "don't print the size and timings of pulled ghosts to stderr (always quiet with --only-conflicts).")
+	command.PersistentFlags().StringVar(&flags.progressFormat, "progress-format", "", "report progress of fetching and applying ghosts to stderr in this format, e.g. for a frontend to render a progress bar. One of: json")
+	command.PersistentFlags().BoolVar(&flags.noFetchBase, "no-fetch-base", false, "fail instead of fetching a base commit of ghosts missing in working dir which is a partial clone from its promisor remote")
+	command.PersistentFlags().BoolVar(&flags.check, "check", false, "only check whether ghosts apply to working dir as it is (including local changes) like 'git apply --check', printing files which conflict, which exits with code 6. nothing is applied, and the post-apply hook is not run")
+	command.PersistentFlags().StringVar(&flags.report, "report", "", "write a report of applying in JSON to the file, even if applying fails")
+	command.PersistentFlags().BoolVar(&flags.commit, "commit", false, "create a commit of an applied diff (no effect on commits, which are applied as they are)")
+	command.PersistentFlags().StringVarP(&flags.commitMessage, "message", "m", "", "commit message used with --commit")
+	command.PersistentFlags().StringVar(&flags.commitAuthor, "author", "", "author of the commit in the form of 'Name <email>' used with --commit (default to the user of the source directory)")
 	return command
 }
 
+// splitPathArgs splits args of cmd into hashes and paths given after "--"
+func splitPathArgs(cmd *cobra.Command, args []string) ([]string, []string) {
+	dash := cmd.ArgsLenAtDash()
+	if dash < 0 {
+		return args, []string{}
+	}
+	return args[:dash], args[dash:]
+}
+
+// hashArgs validates the number of hashes before "--" like cobra.RangeArgs
+func hashArgs(min, max int) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		hashes, _ := splitPathArgs(cmd, args)
+		return cobra.RangeArgs(min, max)(cmd, hashes)
+	}
+}
+
 type pullCommitsArg struct {
 	commitsFrom string
 	commitsTo   string
@@ -107,10 +471,24 @@ func (arg pullCommitsArg) validate() errors.GitGhostError {
 
 func runPullCommitsCommand(flags *pullFlags) func(cmd *cobra.Command, args []string) {
 	return func(cmd *cobra.Command, args []string) {
+		args, paths := splitPathArgs(cmd, args)
+		if len(paths) > 0 {
+			exitWithConfigError(errors.New("paths are not available for commits, which are applied entirely"))
+		}
+		if err := flags.validate(); err != nil {
+			exitWithConfigError(err)
+		}
+		if flags.latest != "" {
+			flags.pullLatest(args, "commits")
+			return
+		}
+		if flags.oci != "" {
+			flags.pullOCI(args, "commits")
+			return
+		}
 		arg := newPullCommitsArg(args)
 		if err := arg.validate(); err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithConfigError(err)
 		}
 
 		options := ghost.PullOptions{
@@ -120,14 +498,12 @@ func runPullCommitsCommand(flags *pullFlags) func(cmd *cobra.Command, args []str
 				CommittishFrom: arg.commitsFrom,
 				CommittishTo:   arg.commitsTo,
 			},
-			// ForceApply: flags.forceApply,
+			ApplyOptions: flags.applyOptions(),
+			AutoStash:    flags.autoStash,
+			Atomic:       flags.atomic,
 		}
 
-		err := ghost.Pull(options)
-		if err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
-		}
+		flags.pull(options)
 	}
 }
 
@@ -168,10 +544,25 @@ func (arg pullDiffArg) validate() errors.GitGhostError {
 
 func runPullDiffCommand(flags *pullFlags) func(cmd *cobra.Command, args []string) {
 	return func(cmd *cobra.Command, args []string) {
+		args, flags.paths = splitPathArgs(cmd, args)
+		if err := flags.validate(); err != nil {
+			exitWithConfigError(err)
+		}
+		if flags.latest != "" || flags.oci != "" {
+			ghostType := ""
+			if cmd.Name() == "diff" {
+				ghostType = "diff"
+			}
+			if flags.oci != "" {
+				flags.pullOCI(args, ghostType)
+			} else {
+				flags.pullLatest(args, ghostType)
+			}
+			return
+		}
 		arg := newPullDiffArg(args)
 		if err := arg.validate(); err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithConfigError(err)
 		}
 
 		options := ghost.PullOptions{
@@ -181,19 +572,30 @@ func runPullDiffCommand(flags *pullFlags) func(cmd *cobra.Command, args []string
 				CommittishFrom: arg.diffFrom,
 				DiffHash:       arg.diffHash,
 			},
-			// ForceApply: flags.forceApply,
+			ApplyOptions: flags.applyOptions(),
+			AutoStash:    flags.autoStash,
+			Atomic:       flags.atomic,
 		}
 
-		err := ghost.Pull(options)
-		if err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
-		}
+		flags.pull(options)
 	}
 }
 
 func runPullAllCommand(flags *pullFlags) func(cmd *cobra.Command, args []string) {
 	return func(cmd *cobra.Command, args []string) {
+		args, paths := splitPathArgs(cmd, args)
+		if len(paths) > 0 {
+			exitWithConfigError(errors.New("paths are not available with 'pull all', which applies commits entirely"))
+		}
+		if err := flags.validate(); err != nil {
+			exitWithConfigError(err)
+		}
+		if flags.latest != "" {
+			exitWithConfigError(errors.New("latest is not available with 'pull all'"))
+		}
+		if flags.oci != "" {
+			exitWithConfigError(errors.New("oci is not available with 'pull all'"))
+		}
 		var pullCommitsArg pullCommitsArg
 		var pullDiffArg pullDiffArg
 
@@ -206,16 +608,14 @@ func runPullAllCommand(flags *pullFlags) func(cmd *cobra.Command, args []string)
 			pullDiffArg = newPullDiffArg(args)
 		default:
 			log.Error(cmd.Args(cmd, args))
-			os.Exit(1)
+			os.Exit(exitCodeConfig)
 		}
 
 		if err := pullCommitsArg.validate(); err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithConfigError(err)
 		}
 		if err := pullDiffArg.validate(); err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithConfigError(err)
 		}
 
 		options := ghost.PullOptions{
@@ -230,13 +630,11 @@ func runPullAllCommand(flags *pullFlags) func(cmd *cobra.Command, args []string)
 				CommittishFrom: pullDiffArg.diffFrom,
 				DiffHash:       pullDiffArg.diffHash,
 			},
-			// ForceApply: flags.forceApply,
+			ApplyOptions: flags.applyOptions(),
+			AutoStash:    flags.autoStash,
+			Atomic:       flags.atomic,
 		}
 
-		err := ghost.Pull(options)
-		if err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
-		}
+		flags.pull(options)
 	}
 }
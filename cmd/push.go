@@ -15,19 +15,308 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/pfnet-research/git-ghost/pkg/ghost"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
 	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util"
 	"github.com/pfnet-research/git-ghost/pkg/util/errors"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
 type pushFlags struct {
 	includedFilepaths []string
 	followSymlinks    bool
+	skipGenerated     bool
+	ignoreModeChanges bool
+	unified           int
+	ignoreLines       []string
+	meta              []string
+	keepEmptyDirs     bool
+	noUntracked       bool
+	includeBinaries   bool
+	includeText       bool
+	incrementalFrom   string
+	incrementalLast   bool
+	anonymize         bool
+	anonymizeDates    bool
+	patchID           bool
+	force             bool
+	createOnly        bool
+	splitSize         string
+	compressMinSize   string
+	compression       string
+	output            string
+	stat              bool
+	sizeReport        int
+	pathspecs         []string
+	bundle            bool
+	patchFormat       string
+	noSignature       bool
+	zeroCommit        bool
+	fromPatch         string
+	fromStash         string
+	base              string
+	baseRefs          []string
+	baseFile          string
+	binaryAttachments bool
+	verifyRoundtrip   bool
+	allowMarkers      bool
+	hashFile          string
+	hashEnv           string
+	quiet             bool
+	progressFormat    string
+}
+
+func (flags pushFlags) validate() errors.GitGhostError {
+	if flags.output != "" && flags.output != "json" {
+		return errors.New("output must be one of [json]")
+	}
+	if _, err := parseSize(flags.splitSize); err != nil {
+		return err
+	}
+	if _, err := parseSize(flags.compressMinSize); err != nil {
+		return errors.Errorf("compress-min-size is invalid: %s", err)
+	}
+	if flags.compression != "" && len(util.SubtractStringSlice([]string{flags.compression}, types.Compressions)) > 0 {
+		return errors.Errorf("compression must be one of %v but got '%s'", types.Compressions, flags.compression)
+	}
+	if !flags.includeBinaries && !flags.includeText {
+		return errors.New("include-untracked-binaries and include-untracked-text can not be both false, which drops all the files specified by --include")
+	}
+	if flags.bundle && flags.anonymize {
+		return errors.New("anonymize is not available with --bundle, which keeps commits as they are")
+	}
+	if err := flags.patchFormatOptions().Validate(); err != nil {
+		return err
+	}
+	if flags.bundle && (flags.patchFormat != git.PatchFormatEmail || flags.noSignature || flags.zeroCommit) {
+		return errors.New("patch-format, no-signature and zero-commit are not available with --bundle, which stores commits as they are instead of patches")
+	}
+	if flags.binaryAttachments && globalOpts.pipeThrough != "" {
+		return errors.New("binary-diff-as-attachment is not available with --pipe-through, which attachments would bypass")
+	}
+	if flags.incrementalLast && flags.incrementalFrom != "" {
+		return errors.New("incremental-from-last is not available with --incremental-from")
+	}
+	if _, err := types.ParseMetadata(flags.meta); err != nil {
+		return errors.Errorf("meta is invalid: %s", err)
+	}
+	if flags.unified < -1 {
+		return errors.New("unified must not be negative")
+	}
+	if flags.sizeReport < 0 {
+		return errors.New("size-report must not be negative")
+	}
+	if len(flags.ignoreLines) > 0 {
+		if err := git.RequireVersion("ignore-lines", 2, 30); err != nil {
+			return err
+		}
+	}
+	for name, path := range map[string]string{"hash-file": flags.hashFile, "hash-env": flags.hashEnv} {
+		if path == "" {
+			continue
+		}
+		// checked beforehand so that a ghost is never pushed without its hash written
+		if err := util.ValidateWritableDir(filepath.Dir(path)); err != nil {
+			return errors.Errorf("%s is not writable: %s", name, err)
+		}
+	}
+	return validateProgressFormat(flags.progressFormat)
+}
+
+// recordMetadata sets metadata of --meta recorded in pushed ghosts, which is validated beforehand
+func (flags pushFlags) recordMetadata() {
+	m, _ := types.ParseMetadata(flags.meta)
+	types.SetMetadata(m)
+}
+
+// recordCompression sets the codec and the size from which pushed ghost files are compressed, which are validated beforehand
+func (flags pushFlags) recordCompression() {
+	size, _ := parseSize(flags.compressMinSize)
+	types.SetCompressMinSize(size)
+	types.SetCompression(flags.compression)
+}
+
+// lastTag returns the tag of the diff pushed last time by --incremental-from-last, or empty without it
+func (flags pushFlags) lastTag() string {
+	if !flags.incrementalLast {
+		return ""
+	}
+	tag, err := userTag(globalOpts.srcDir, "last")
+	if err != nil {
+		exitWithConfigError(err)
+	}
+	return tag
+}
+
+// patchFormatOptions returns the format of patches of commits
+func (flags pushFlags) patchFormatOptions() git.PatchFormatOptions {
+	return git.PatchFormatOptions{
+		Format:      flags.patchFormat,
+		NoSignature: flags.noSignature,
+		ZeroCommit:  flags.zeroCommit,
+	}
+}
+
+// unifiedContext returns the number of context lines of a diff, or nil for git's default
+func (flags pushFlags) unifiedContext() *int {
+	if flags.unified < 0 {
+		return nil
+	}
+	unified := flags.unified
+	return &unified
+}
+
+// validateFromPatch checks flags and args for a diff pushed from a patch file by --from-patch
+func (flags pushFlags) validateFromPatch(args []string) errors.GitGhostError {
+	if flags.fromPatch == "" {
+		if flags.base != "" {
+			return errors.New("base is only available with --from-patch")
+		}
+		return nil
+	}
+	if flags.base == "" {
+		return errors.New("from-patch requires --base, which the patch is applied to")
+	}
+	if len(args) > 0 {
+		return errors.New("from-patch takes its base by --base instead of an argument")
+	}
+	if len(flags.includedFilepaths) > 0 || flags.incrementalFrom != "" || flags.incrementalLast || flags.keepEmptyDirs || flags.skipGenerated || flags.ignoreModeChanges || flags.unified >= 0 || len(flags.ignoreLines) > 0 {
+		return errors.New("from-patch is not available with --include, --incremental-from, --incremental-from-last, --keep-empty-dirs, --skip-generated, --ignore-mode-changes, --unified or --ignore-lines, which work on the working dir")
+	}
+	return util.ValidateReadableFile(flags.fromPatch)
+}
+
+// validateFromStash checks flags and args for a diff pushed from a stash entry by --from-stash
+func (flags pushFlags) validateFromStash(args []string) errors.GitGhostError {
+	if flags.fromStash == "" {
+		return nil
+	}
+	if len(args) > 0 || flags.fromPatch != "" || len(flags.baseRefs) > 0 || flags.baseFile != "" {
+		return errors.New("from-stash takes its base from the stash, which can't be specified by from-hash, --from-patch, --base-ref or --base-file")
+	}
+	if len(flags.includedFilepaths) > 0 || flags.incrementalFrom != "" || flags.incrementalLast || flags.keepEmptyDirs || flags.skipGenerated || flags.ignoreModeChanges || flags.unified >= 0 || len(flags.ignoreLines) > 0 {
+		return errors.New("from-stash is not available with --include, --incremental-from, --incremental-from-last, --keep-empty-dirs, --skip-generated, --ignore-mode-changes, --unified or --ignore-lines, which work on the working dir")
+	}
+	_, err := git.ResolveStash(globalOpts.srcDir, flags.fromStash)
+	return err
+}
+
+// validateBaseRefs checks flags and args for a diff based on the octopus merge base of --base-ref
+func (flags pushFlags) validateBaseRefs(args []string) errors.GitGhostError {
+	if len(flags.baseRefs) == 0 {
+		return nil
+	}
+	if len(args) > 0 {
+		return errors.New("base-ref takes the place of from-hash, which can't be specified together")
+	}
+	if flags.fromPatch != "" {
+		return errors.New("base-ref is not available with --from-patch, which takes its base by --base")
+	}
+	for _, ref := range flags.baseRefs {
+		if err := isValidCommittish("base-ref "+ref, ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveBaseRefs replaces the base of arg with the octopus merge base of --base-ref if it is specified
+func (flags pushFlags) resolveBaseRefs(arg *pushDiffArg) errors.GitGhostError {
+	if len(flags.baseRefs) == 0 {
+		return nil
+	}
+	base, err := git.OctopusMergeBase(globalOpts.srcDir, flags.baseRefs...)
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"baseRefs": flags.baseRefs,
+		"base":     base,
+	}).Info("using the merge base of base refs as the base of the diff")
+	arg.diffFrom = base
+	return nil
+}
+
+// validateBaseFile checks flags and args for a diff based on the committish read from --base-file
+func (flags pushFlags) validateBaseFile(args []string) errors.GitGhostError {
+	if flags.baseFile == "" {
+		return nil
+	}
+	if len(args) > 0 {
+		return errors.New("base-file takes the place of from-hash, which can't be specified together")
+	}
+	if flags.fromPatch != "" || len(flags.baseRefs) > 0 {
+		return errors.New("base-file is not available with --from-patch or --base-ref, which take bases of their own")
+	}
+	return util.ValidateReadableFile(flags.baseFile)
+}
+
+// resolveBaseFile replaces the base of arg with the committish read from --base-file if it is specified
+//
+// Leading and trailing whitespaces (e.g. a newline written by echo) are trimmed.
+func (flags pushFlags) resolveBaseFile(arg *pushDiffArg) errors.GitGhostError {
+	if flags.baseFile == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(flags.baseFile)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	committish := strings.TrimSpace(string(data))
+	if committish == "" {
+		return errors.Errorf("base-file %s is empty", flags.baseFile)
+	}
+	if strings.ContainsAny(committish, " \t\r\n") {
+		return errors.Errorf("base-file %s must contain a single committish", flags.baseFile)
+	}
+	if err := isValidCommittish(fmt.Sprintf("%s in base-file %s", committish, flags.baseFile), committish); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"baseFile": flags.baseFile,
+		"base":     committish,
+	}).Info("using the committish in base file as the base of the diff")
+	arg.diffFrom = committish
+	return nil
+}
+
+// validateNoPathspecs rejects pathspecs for a diff, which is created from the original commits
+func (flags pushFlags) validateNoPathspecs() errors.GitGhostError {
+	if len(flags.pathspecs) > 0 {
+		return errors.New("path is only available with 'push commits'")
+	}
+	return nil
+}
+
+var sizeUnits = map[string]int64{"": 1, "K": 1 << 10, "M": 1 << 20, "G": 1 << 30}
+var regexpSizePattern = regexp.MustCompile(`^([0-9]+)([KMG]?)$`)
+
+// parseSize parses a size like "512", "100K" or "50M" in bytes ("" means 0)
+func parseSize(s string) (int64, errors.GitGhostError) {
+	if s == "" {
+		return 0, nil
+	}
+	m := regexpSizePattern.FindStringSubmatch(strings.ToUpper(s))
+	if m == nil {
+		return 0, errors.Errorf("invalid size: %s (e.g. 512, 100K, 50M)", s)
+	}
+	n, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return n * sizeUnits[m[2]], nil
 }
 
 func init() {
@@ -39,11 +328,12 @@ func NewPushCommand() *cobra.Command {
 		flags pushFlags
 	)
 	command := &cobra.Command{
-		Use:   "push [from-hash(default=HEAD)]",
-		Short: "push commits(hash1...hash2), diff(hash...current state) to your ghost repo",
-		Long:  "push commits or diff or all to your ghost repo.  If you didn't specify any subcommand, this commands works as an alias for 'push diff' command.",
-		Args:  cobra.RangeArgs(0, 1),
-		Run:   runPushDiffCommand(&flags),
+		Use:         "push [from-hash(default=HEAD)]",
+		Annotations: writesGhostRepoAnnotations,
+		Short:       "push commits(hash1...hash2), diff(hash...current state) to your ghost repo",
+		Long:        "push commits or diff or all to your ghost repo.  If you didn't specify any subcommand, this commands works as an alias for 'push diff' command.",
+		Args:        cobra.RangeArgs(0, 1),
+		Run:         runPushDiffCommand(&flags),
 	}
 	command.AddCommand(&cobra.Command{
 		Use:   "commits [from-hash] [to-hash(default=HEAD)]",
@@ -67,12 +357,59 @@ func NewPushCommand() *cobra.Command {
 		Run:   runPushAllCommand(&flags),
 	})
 
-	command.PersistentFlags().StringSliceVarP(&flags.includedFilepaths, "include", "I", []string{}, "include a non-indexed file, this flag can be repeated to specify multiple files.")
-	command.PersistentFlags().BoolVar(&flags.followSymlinks, "follow-symlinks", false, "follow symlinks inside the repository.")
+	addDiffFlags(command, &flags)
+	command.PersistentFlags().BoolVar(&flags.anonymize, "anonymize", false, "replace author names and emails in pushed commits with a placeholder.")
+	command.PersistentFlags().BoolVar(&flags.anonymizeDates, "anonymize-dates", false, "replace author dates in pushed commits with the epoch (use with --anonymize).")
+	command.PersistentFlags().BoolVar(&flags.patchID, "patch-id", false, "reuse an existing commits ghost which has the same patch id (e.g. the same commits before rebase) instead of pushing a new one.")
+	command.PersistentFlags().StringVar(&flags.compressMinSize, "compress-min-size", "1M", "compress a patch or a bundle by --compression if it is at least this size (e.g. 10M, or 0 to compress all), storing smaller ones as they are.")
+	command.PersistentFlags().StringVar(&flags.compression, "compression", types.CompressionGzip, fmt.Sprintf("codec to compress a patch or a bundle by, one of %v. A ghost is pulled by the codec recorded in it.", types.Compressions))
+	command.PersistentFlags().StringVar(&flags.splitSize, "split-size", "", "split a patch larger than this size (e.g. 50M) into parts stored as separate files in the ghost branch.")
+	command.PersistentFlags().BoolVarP(&flags.force, "force", "f", false, "push a diff even if it is unchanged since the last push from this repository, and commits exceeding --max-commits.")
+	command.PersistentFlags().StringArrayVar(&flags.meta, "meta", []string{}, "annotate pushed ghosts with metadata key=value (e.g. ticket=ABC-123), which 'show --provenance' and 'which' print and 'list --meta' filters by. this flag can be repeated to specify multiple pairs.")
+	command.PersistentFlags().BoolVar(&flags.createOnly, "create-only", false, "fail with exit code 7 if a pushed ghost branch already exists instead of skipping it.  the branch is created atomically, so only one of concurrent pushes of it succeeds.")
+	command.PersistentFlags().BoolVar(&globalOpts.noSecretScan, "no-secret-scan", false, "push ghosts without scanning lines added by them for secrets by the default patterns and --secret-patterns, e.g. for false positives.")
+	command.PersistentFlags().StringVar(&globalOpts.checkEOL, "check-eol", "", "refuse pushing ghosts which have a file with mixed line endings or ones out of these (comma-separated lf and crlf, e.g. 'lf' to refuse crlf), found in lines around changes, or none not to check them (default to GIT_GHOST_CHECK_EOL env, ghost.checkEol git config, or none)")
+	command.PersistentFlags().StringVar(&globalOpts.maxCommits, "max-commits", "", "maximum number of commits pushed as a commits ghost, 0 for no limit, which guards against a wrong base commit (default to GIT_GHOST_MAX_COMMITS env, ghost.maxCommits git config, or 1000)")
+	command.PersistentFlags().StringVarP(&flags.output, "output", "o", "", "Output format. One of: json")
+	command.PersistentFlags().BoolVar(&flags.stat, "stat", false, "print statistics (files, bytes, commits and the largest file) of pushed ghosts to stderr.")
+	command.PersistentFlags().BoolVarP(&flags.quiet, "quiet", "q", false, "don't print the size and timings of pushed ghosts to stderr.")
+	command.PersistentFlags().StringVar(&flags.progressFormat, "progress-format", "", "report progress of creating and pushing ghosts to stderr in this format, e.g. for a frontend to render a progress bar. One of: json")
+	command.PersistentFlags().IntVar(&flags.sizeReport, "size-report", 0, "print this number of the largest files by their bytes in patches of pushed ghosts to stderr (or in json by -o json), e.g. to find what bloats a ghost.")
+	command.PersistentFlags().BoolVar(&flags.bundle, "bundle", false, "store pushed commits as a git bundle, which keeps them as they are including merges, instead of patches.")
+	command.PersistentFlags().StringVar(&flags.patchFormat, "patch-format", git.PatchFormatEmail, "format of pushed commits. One of: email|format-patch (email is by 'git log --pretty=email' keeping merges as diffs against their first parents, and format-patch is by 'git format-patch', which refuses merges)")
+	command.PersistentFlags().BoolVar(&flags.noSignature, "no-signature", false, "omit the signature at the end of each patch of pushed commits (use with --patch-format=format-patch)")
+	command.PersistentFlags().BoolVar(&flags.zeroCommit, "zero-commit", false, "write all zero hashes instead of commit hashes in 'From' lines of patches of pushed commits (use with --patch-format=format-patch)")
+	command.PersistentFlags().StringSliceVar(&flags.pathspecs, "path", []string{}, "only push commits touching the path like 'git log -- <path>' (only for 'push commits'), this flag can be repeated to specify multiple paths.")
+	command.PersistentFlags().BoolVar(&flags.allowMarkers, "allow-conflict-markers", false, "push a diff even if lines added by it are conflict markers ('<<<<<<<', '|||||||' or '>>>>>>>'), which refuse pushing by default (no effect on commits).")
+	command.PersistentFlags().StringVar(&flags.hashFile, "hash-file", "", "write hashes of pushed ghosts (the last commit hash of commits and the diff hash of a diff, one per line) to the file atomically after pushing succeeds, e.g. for later steps of a pipeline")
+	command.PersistentFlags().StringVar(&flags.hashEnv, "hash-env", "", "append GIT_GHOST_COMMITS_FROM, GIT_GHOST_COMMITS_HASH, GIT_GHOST_DIFF_FROM and GIT_GHOST_DIFF_HASH lines of pushed ghosts to the env file after pushing succeeds, e.g. $GITHUB_ENV")
+	command.PersistentFlags().BoolVar(&flags.verifyRoundtrip, "verify-roundtrip", false, "check that re-diffing the base with a diff applied reproduces the diff before pushing it, which catches a malformed diff (no effect on commits).")
+	command.PersistentFlags().StringVar(&flags.fromPatch, "from-patch", "", "push a diff file (e.g. created by 'git diff') as it is instead of local modifications (only for 'push diff'). it must apply cleanly to --base.")
+	command.PersistentFlags().StringVar(&flags.base, "base", "", "commit which a diff file by --from-patch is based on.")
+	command.PersistentFlags().StringVar(&flags.fromStash, "from-stash", "", "push a stash entry (e.g. stash@{2}) including its untracked files instead of local modifications, based on the commit it was stashed on (only for 'push diff').")
+	command.PersistentFlags().StringVar(&flags.baseFile, "base-file", "", "read from-hash of a diff from this file (trimming whitespaces), e.g. written by an earlier step of CI (only for 'push diff').")
+	command.PersistentFlags().StringVar(&flags.incrementalFrom, "incremental-from", "", "diff hash of a ghost on the same base. the new diff only contains changes from the state the ghost reproduces (falls back to a full diff if it doesn't exist).")
+	command.PersistentFlags().BoolVar(&flags.incrementalLast, "incremental-from-last", false, "push a diff incrementally from the one you pushed last time with this flag, found by a tag last/<user> (<user> is the local part of user.email), which is moved to the new one. falls back to a full diff if there is no such diff on the same base.")
 
 	return command
 }
 
+// addDiffFlags adds flags which change a diff created from the working dir, shared by push and hash
+func addDiffFlags(command *cobra.Command, flags *pushFlags) {
+	command.PersistentFlags().StringSliceVarP(&flags.includedFilepaths, "include", "I", []string{}, "include a non-indexed file, this flag can be repeated to specify multiple files.")
+	command.PersistentFlags().BoolVar(&flags.includeBinaries, "include-untracked-binaries", true, "include binary files (marked binary in .gitattributes, or having a NUL byte in the first 8000 bytes as git detects) out of files specified by --include.")
+	command.PersistentFlags().BoolVar(&flags.includeText, "include-untracked-text", true, "include text files out of files specified by --include.")
+	command.PersistentFlags().BoolVar(&flags.followSymlinks, "follow-symlinks", false, "follow symlinks inside the repository.")
+	command.PersistentFlags().BoolVar(&flags.skipGenerated, "skip-generated", false, "exclude files marked by linguist-generated attribute in .gitattributes from a diff.")
+	command.PersistentFlags().BoolVar(&flags.ignoreModeChanges, "ignore-mode-changes", false, "exclude files whose modes are changed without their contents (e.g. by a filesystem) from a diff. mode changes together with content changes are kept.")
+	command.PersistentFlags().IntVarP(&flags.unified, "unified", "U", -1, "generate a diff with this number of context lines instead of git's default 3, e.g. more for review or 0 for a minimal diff, which may apply to a wrongly moved place. commits are not affected.")
+	command.PersistentFlags().StringArrayVar(&flags.ignoreLines, "ignore-lines", []string{}, "drop hunks of a diff whose changed lines all match this regular expression (POSIX extended, as 'git diff -I'), e.g. '^// Generated at ' for volatile build stamps. the pulled state keeps such lines as they are in the base, so over-filtering loses changes. this flag can be repeated to specify multiple patterns. commits are not affected.")
+	command.PersistentFlags().BoolVar(&flags.keepEmptyDirs, "keep-empty-dirs", false, "record empty directories, which git doesn't track, in a diff to recreate them on pull.")
+	command.PersistentFlags().BoolVar(&flags.noUntracked, "no-untracked", false, "ghost changes of tracked files only, ignoring untracked files specified by --include and empty directories by --keep-empty-dirs, e.g. in an alias.")
+	command.PersistentFlags().StringSliceVar(&flags.baseRefs, "base-ref", []string{}, "base a diff on the merge base of refs by 'git merge-base --octopus' instead of from-hash, so that it applies to any of them (only for 'push diff' and 'hash'), this flag can be repeated to specify multiple refs.")
+	command.PersistentFlags().BoolVar(&flags.binaryAttachments, "binary-diff-as-attachment", false, "store binary files changed by a diff as blobs next to it instead of binary hunks inside it, which keeps the diff human readable.")
+}
+
 type pushCommitsArg struct {
 	commitsFrom string
 	commitsTo   string
@@ -110,10 +447,22 @@ func (arg pushCommitsArg) validate() errors.GitGhostError {
 
 func runPushCommitsCommand(flags *pushFlags) func(cmd *cobra.Command, args []string) {
 	return func(cmd *cobra.Command, args []string) {
+		if err := flags.validate(); err != nil {
+			exitWithConfigError(err)
+		}
+		if len(flags.baseRefs) > 0 {
+			exitWithConfigError(errors.New("base-ref is only available with 'push diff' and 'hash'"))
+		}
+		if flags.baseFile != "" {
+			exitWithConfigError(errors.New("base-file is only available with 'push diff'"))
+		}
+		if flags.fromStash != "" {
+			exitWithConfigError(errors.New("from-stash is only available with 'push diff'"))
+		}
+		splitSize, _ := parseSize(flags.splitSize)
 		pushArg := newPushCommitsArg(args)
 		if err := pushArg.validate(); err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithConfigError(err)
 		}
 		options := ghost.PushOptions{
 			WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
@@ -121,13 +470,41 @@ func runPushCommitsCommand(flags *pushFlags) func(cmd *cobra.Command, args []str
 				Prefix:         globalOpts.ghostPrefix,
 				CommittishFrom: pushArg.commitsFrom,
 				CommittishTo:   pushArg.commitsTo,
+				Anonymize:      flags.anonymize,
+				AnonymizeDates: flags.anonymizeDates,
+				DedupByPatchID: flags.patchID,
+				SplitSize:      splitSize,
+				Bundle:         flags.bundle,
+				PatchFormat:    flags.patchFormatOptions(),
+				MaxCommits:     globalOpts.maxCommitsLimit(flags.force),
+				Pathspecs:      flags.pathspecs,
 			},
+			CreateOnly: flags.createOnly,
 		}
 
+		if err := validateNotProtected(globalOpts.ghostRepo, globalOpts.ghostPrefix); err != nil {
+			exitWithError(err)
+		}
+		flags.recordMetadata()
+		flags.recordCompression()
+		setProgressFormat(flags.progressFormat)
 		result, err := ghost.Push(options)
 		if err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithError(err)
+		}
+		writePushedHashes(flags, result)
+		if flags.output == "json" {
+			printPushResultJSON(result, flags.sizeReport)
+			return
+		}
+		if flags.stat {
+			printPushStats(result)
+		}
+		if flags.sizeReport > 0 {
+			printPushSizeReport(result, flags.sizeReport)
+		}
+		if !flags.quiet {
+			printTransferMetrics("push", result.Metrics)
 		}
 
 		if result.CommitsBranch != nil {
@@ -166,25 +543,98 @@ func (arg pushDiffArg) validate() errors.GitGhostError {
 
 func runPushDiffCommand(flags *pushFlags) func(cmd *cobra.Command, args []string) {
 	return func(cmd *cobra.Command, args []string) {
+		if err := flags.validate(); err != nil {
+			exitWithConfigError(err)
+		}
+		if err := flags.validateNoPathspecs(); err != nil {
+			exitWithConfigError(err)
+		}
+		if err := flags.validateFromPatch(args); err != nil {
+			exitWithConfigError(err)
+		}
+		if err := flags.validateFromStash(args); err != nil {
+			exitWithConfigError(err)
+		}
+		if err := flags.validateBaseRefs(args); err != nil {
+			exitWithConfigError(err)
+		}
+		if err := flags.validateBaseFile(args); err != nil {
+			exitWithConfigError(err)
+		}
+		splitSize, _ := parseSize(flags.splitSize)
 		pushArg := newPushDiffArg(args)
+		if flags.fromPatch != "" {
+			pushArg.diffFrom = flags.base
+		}
+		if err := flags.resolveBaseRefs(&pushArg); err != nil {
+			exitWithError(err)
+		}
+		if err := flags.resolveBaseFile(&pushArg); err != nil {
+			exitWithConfigError(err)
+		}
 		if err := pushArg.validate(); err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithConfigError(err)
+		}
+		patchFile := flags.fromPatch
+		if patchFile != "" {
+			// git reads the patch on the source directory, which may differ from the current one
+			abs, err := filepath.Abs(patchFile)
+			if err != nil {
+				exitWithConfigError(errors.WithStack(err))
+			}
+			patchFile = abs
 		}
 		options := ghost.PushOptions{
 			WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
 			DiffBranchSpec: &types.DiffBranchSpec{
-				Prefix:            globalOpts.ghostPrefix,
-				CommittishFrom:    pushArg.diffFrom,
-				IncludedFilepaths: flags.includedFilepaths,
-				FollowSymlinks:    flags.followSymlinks,
+				Prefix:                 globalOpts.ghostPrefix,
+				CommittishFrom:         pushArg.diffFrom,
+				IncludedFilepaths:      flags.includedFilepaths,
+				FollowSymlinks:         flags.followSymlinks,
+				ParentDiffHash:         flags.incrementalFrom,
+				SplitSize:              splitSize,
+				SkipGenerated:          flags.skipGenerated,
+				IgnoreModeChanges:      flags.ignoreModeChanges,
+				Unified:                flags.unifiedContext(),
+				IgnoreLines:            flags.ignoreLines,
+				KeepEmptyDirs:          flags.keepEmptyDirs,
+				NoUntracked:            flags.noUntracked,
+				SkipNonIndexedBinaries: !flags.includeBinaries,
+				SkipNonIndexedText:     !flags.includeText,
+				BinaryAttachments:      flags.binaryAttachments,
+				VerifyRoundtrip:        flags.verifyRoundtrip,
+				AllowConflictMarkers:   flags.allowMarkers,
+				PatchFile:              patchFile,
+				Stash:                  flags.fromStash,
 			},
+			Force:      flags.force,
+			LastTag:    flags.lastTag(),
+			CreateOnly: flags.createOnly,
 		}
 
+		if err := validateNotProtected(globalOpts.ghostRepo, globalOpts.ghostPrefix); err != nil {
+			exitWithError(err)
+		}
+		flags.recordMetadata()
+		flags.recordCompression()
+		setProgressFormat(flags.progressFormat)
 		result, err := ghost.Push(options)
 		if err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithError(err)
+		}
+		writePushedHashes(flags, result)
+		if flags.output == "json" {
+			printPushResultJSON(result, flags.sizeReport)
+			return
+		}
+		if flags.stat {
+			printPushStats(result)
+		}
+		if flags.sizeReport > 0 {
+			printPushSizeReport(result, flags.sizeReport)
+		}
+		if !flags.quiet {
+			printTransferMetrics("push", result.Metrics)
 		}
 
 		if result.DiffBranch != nil {
@@ -200,16 +650,33 @@ func runPushDiffCommand(flags *pushFlags) func(cmd *cobra.Command, args []string
 
 func runPushAllCommand(flags *pushFlags) func(cmd *cobra.Command, args []string) {
 	return func(cmd *cobra.Command, args []string) {
+		if err := flags.validate(); err != nil {
+			exitWithConfigError(err)
+		}
+		if err := flags.validateNoPathspecs(); err != nil {
+			exitWithConfigError(err)
+		}
+		if flags.fromPatch != "" || flags.base != "" {
+			exitWithConfigError(errors.New("from-patch is only available with 'push diff'"))
+		}
+		if len(flags.baseRefs) > 0 {
+			exitWithConfigError(errors.New("base-ref is only available with 'push diff' and 'hash'"))
+		}
+		if flags.baseFile != "" {
+			exitWithConfigError(errors.New("base-file is only available with 'push diff'"))
+		}
+		if flags.fromStash != "" {
+			exitWithConfigError(errors.New("from-stash is only available with 'push diff'"))
+		}
+		splitSize, _ := parseSize(flags.splitSize)
 		pushCommitsArg := newPushCommitsArg(args[0:1])
 		if err := pushCommitsArg.validate(); err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithConfigError(err)
 		}
 
 		pushDiffArg := newPushDiffArg(args[1:])
 		if err := pushDiffArg.validate(); err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithConfigError(err)
 		}
 
 		options := ghost.PushOptions{
@@ -218,19 +685,61 @@ func runPushAllCommand(flags *pushFlags) func(cmd *cobra.Command, args []string)
 				Prefix:         globalOpts.ghostPrefix,
 				CommittishFrom: pushCommitsArg.commitsFrom,
 				CommittishTo:   pushCommitsArg.commitsTo,
+				Anonymize:      flags.anonymize,
+				AnonymizeDates: flags.anonymizeDates,
+				DedupByPatchID: flags.patchID,
+				SplitSize:      splitSize,
+				Bundle:         flags.bundle,
+				PatchFormat:    flags.patchFormatOptions(),
+				MaxCommits:     globalOpts.maxCommitsLimit(flags.force),
 			},
 			DiffBranchSpec: &types.DiffBranchSpec{
-				Prefix:            globalOpts.ghostPrefix,
-				CommittishFrom:    pushDiffArg.diffFrom,
-				IncludedFilepaths: flags.includedFilepaths,
-				FollowSymlinks:    flags.followSymlinks,
+				Prefix:                 globalOpts.ghostPrefix,
+				CommittishFrom:         pushDiffArg.diffFrom,
+				IncludedFilepaths:      flags.includedFilepaths,
+				FollowSymlinks:         flags.followSymlinks,
+				ParentDiffHash:         flags.incrementalFrom,
+				SplitSize:              splitSize,
+				SkipGenerated:          flags.skipGenerated,
+				IgnoreModeChanges:      flags.ignoreModeChanges,
+				Unified:                flags.unifiedContext(),
+				IgnoreLines:            flags.ignoreLines,
+				KeepEmptyDirs:          flags.keepEmptyDirs,
+				NoUntracked:            flags.noUntracked,
+				SkipNonIndexedBinaries: !flags.includeBinaries,
+				SkipNonIndexedText:     !flags.includeText,
+				BinaryAttachments:      flags.binaryAttachments,
+				VerifyRoundtrip:        flags.verifyRoundtrip,
+				AllowConflictMarkers:   flags.allowMarkers,
 			},
+			Force:      flags.force,
+			LastTag:    flags.lastTag(),
+			CreateOnly: flags.createOnly,
 		}
 
+		if err := validateNotProtected(globalOpts.ghostRepo, globalOpts.ghostPrefix); err != nil {
+			exitWithError(err)
+		}
+		flags.recordMetadata()
+		flags.recordCompression()
+		setProgressFormat(flags.progressFormat)
 		result, err := ghost.Push(options)
 		if err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithError(err)
+		}
+		writePushedHashes(flags, result)
+		if flags.output == "json" {
+			printPushResultJSON(result, flags.sizeReport)
+			return
+		}
+		if flags.stat {
+			printPushStats(result)
+		}
+		if flags.sizeReport > 0 {
+			printPushSizeReport(result, flags.sizeReport)
+		}
+		if !flags.quiet {
+			printTransferMetrics("push", result.Metrics)
 		}
 
 		if result.CommitsBranch != nil {
@@ -254,3 +763,181 @@ func runPushAllCommand(flags *pushFlags) func(cmd *cobra.Command, args []string)
 		}
 	}
 }
+
+// writePushedHashes writes hashes of pushed ghosts into --hash-file and --hash-env, which are only written after pushing succeeds
+func writePushedHashes(flags *pushFlags, result *ghost.PushResult) {
+	hashes := []string{}
+	env := []string{}
+	if result.CommitsBranch != nil {
+		hashes = append(hashes, result.CommitsBranch.CommitHashTo)
+		env = append(env, "GIT_GHOST_COMMITS_FROM="+result.CommitsBranch.CommitHashFrom, "GIT_GHOST_COMMITS_HASH="+result.CommitsBranch.CommitHashTo)
+	}
+	if result.DiffBranch != nil {
+		hashes = append(hashes, result.DiffBranch.DiffHash)
+		env = append(env, "GIT_GHOST_DIFF_FROM="+result.DiffBranch.CommitHashFrom, "GIT_GHOST_DIFF_HASH="+result.DiffBranch.DiffHash)
+	}
+	if flags.hashFile != "" {
+		err := util.WriteFileAtomically(flags.hashFile, []byte(strings.Join(hashes, "\n")+"\n"))
+		if err != nil {
+			exitWithError(err)
+		}
+	}
+	if flags.hashEnv != "" {
+		f, err := os.OpenFile(flags.hashEnv, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			exitWithError(errors.WithStack(err))
+		}
+		// a single write appends all the lines at once
+		_, err = f.WriteString(strings.Join(env, "\n") + "\n")
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			exitWithError(errors.WithStack(err))
+		}
+	}
+}
+
+type pushedCommitsJSON struct {
+	Branch string          `json:"branch"`
+	From   string          `json:"from"`
+	To     string          `json:"to"`
+	Stats  *git.PatchStats `json:"stats,omitempty"`
+	// LargestFiles is only set by --size-report
+	LargestFiles []git.FileSize `json:"largestFiles,omitempty"`
+}
+
+type pushedDiffJSON struct {
+	Branch string          `json:"branch"`
+	From   string          `json:"from"`
+	Hash   string          `json:"hash"`
+	Stats  *git.PatchStats `json:"stats,omitempty"`
+	// LargestFiles is only set by --size-report
+	LargestFiles []git.FileSize `json:"largestFiles,omitempty"`
+}
+
+type pushResultJSON struct {
+	Commits *pushedCommitsJSON      `json:"commits,omitempty"`
+	Diff    *pushedDiffJSON         `json:"diff,omitempty"`
+	Metrics []types.TransferMetrics `json:"metrics"`
+}
+
+func printPushResultJSON(result *ghost.PushResult, sizeReport int) {
+	out := pushResultJSON{Metrics: result.Metrics}
+	if out.Metrics == nil {
+		out.Metrics = []types.TransferMetrics{}
+	}
+	if result.CommitsBranch != nil {
+		out.Commits = &pushedCommitsJSON{
+			Branch: result.CommitsBranch.BranchName(),
+			From:   result.CommitsBranch.CommitHashFrom,
+			To:     result.CommitsBranch.CommitHashTo,
+			Stats:  result.CommitsBranch.Stats,
+		}
+		if sizeReport > 0 && result.CommitsBranch.Stats != nil {
+			out.Commits.LargestFiles = result.CommitsBranch.Stats.LargestFiles(sizeReport)
+		}
+	}
+	if result.DiffBranch != nil {
+		out.Diff = &pushedDiffJSON{
+			Branch: result.DiffBranch.BranchName(),
+			From:   result.DiffBranch.CommitHashFrom,
+			Hash:   result.DiffBranch.DiffHash,
+			Stats:  result.DiffBranch.Stats,
+		}
+		if sizeReport > 0 && result.DiffBranch.Stats != nil {
+			out.Diff.LargestFiles = result.DiffBranch.Stats.LargestFiles(sizeReport)
+		}
+	}
+	bytes, err := json.Marshal(out)
+	if err != nil {
+		exitWithError(errors.WithStack(err))
+	}
+	fmt.Println(string(bytes))
+}
+
+func printPushStats(result *ghost.PushResult) {
+	if result.CommitsBranch != nil && result.CommitsBranch.Stats != nil {
+		stats := result.CommitsBranch.Stats
+		fmt.Fprintf(os.Stderr, "commits: %d commits, %s\n", stats.Commits, formatPatchStats(stats))
+	}
+	if result.DiffBranch != nil && result.DiffBranch.Stats != nil {
+		fmt.Fprintf(os.Stderr, "diff: %s\n", formatPatchStats(result.DiffBranch.Stats))
+	}
+}
+
+// printPushSizeReport prints at most n of the largest files in patches of pushed ghosts with their bytes
+func printPushSizeReport(result *ghost.PushResult, n int) {
+	if result.CommitsBranch != nil && result.CommitsBranch.Stats != nil {
+		printLargestFiles("commits", result.CommitsBranch.Stats.LargestFiles(n))
+	}
+	if result.DiffBranch != nil && result.DiffBranch.Stats != nil {
+		printLargestFiles("diff", result.DiffBranch.Stats.LargestFiles(n))
+	}
+}
+
+// printTransferMetrics prints a line of the size and timings for each pushed or pulled ghost to stderr
+func printTransferMetrics(operation string, metrics []types.TransferMetrics) {
+	for _, m := range metrics {
+		local, transfer := "created", "pushed"
+		if operation == "pull" {
+			local, transfer = "applied", "fetched"
+		}
+		fmt.Fprintf(os.Stderr, "%s: %s: %d bytes, %s in %.2fs, %s in %.2fs (%s/s)\n",
+			operation, m.Branch, m.Bytes, local, m.Local.Seconds(), transfer, m.Transfer.Seconds(), formatBytes(m.Throughput()))
+	}
+}
+
+// progressFormatJSON is a value of --progress-format to report progress as JSON lines of types.ProgressEvent
+const progressFormatJSON = "json"
+
+func validateProgressFormat(format string) errors.GitGhostError {
+	if format != "" && format != progressFormatJSON {
+		return errors.Errorf("progress-format must be %s if specified", progressFormatJSON)
+	}
+	return nil
+}
+
+// setProgressFormat makes progress of pushing or pulling ghosts reported to stderr in format if it is not empty
+func setProgressFormat(format string) {
+	if format != progressFormatJSON {
+		return
+	}
+	types.SetProgressReporter(func(event types.ProgressEvent) {
+		line, err := json.Marshal(event)
+		if err != nil {
+			log.Errorf("failed to encode progress event: %s", err)
+			return
+		}
+		fmt.Fprintln(os.Stderr, string(line))
+	})
+}
+
+// formatBytes formats bytes in a binary unit like "1.5 MiB"
+func formatBytes(bytes float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB"}
+	i := 0
+	for bytes >= 1024 && i < len(units)-1 {
+		bytes /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", bytes, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", bytes, units[i])
+}
+
+func printLargestFiles(kind string, files []git.FileSize) {
+	fmt.Fprintf(os.Stderr, "%s: %d largest files\n", kind, len(files))
+	for _, f := range files {
+		fmt.Fprintf(os.Stderr, "%12d\t%s\n", f.Bytes, f.Path)
+	}
+}
+
+func formatPatchStats(stats *git.PatchStats) string {
+	s := fmt.Sprintf("%d files, %d bytes", stats.Files, stats.Bytes)
+	if stats.LargestFile != "" {
+		s += fmt.Sprintf(" (largest: %s, %d bytes)", stats.LargestFile, stats.LargestFileBytes)
+	}
+	return s
+}
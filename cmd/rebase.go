@@ -0,0 +1,87 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(NewRebaseCommand())
+}
+
+type rebaseFlags struct {
+	onto  string
+	force bool
+}
+
+func (flags rebaseFlags) validate() errors.GitGhostError {
+	return nonEmpty("onto", flags.onto)
+}
+
+func NewRebaseCommand() *cobra.Command {
+	var (
+		flags rebaseFlags
+	)
+	command := &cobra.Command{
+		Use:         "rebase [diff-from-hash(default=HEAD)] [diff-hash] --onto <new-base>",
+		Aliases:     []string{"mv"},
+		Annotations: writesGhostRepoAnnotations,
+		Short:       "re-create a diff ghost on a new base and push it",
+		Long:        "apply the diff from [diff-from-hash] to [diff-hash] onto <new-base> in a temporary worktree and push the result as a new diff ghost based on <new-base>.  working dir is not touched, and nothing is pushed if the diff conflicts with <new-base>.",
+		Args:        cobra.RangeArgs(0, 2),
+		Run:         runRebaseCommand(&flags),
+	}
+	command.Flags().StringVar(&flags.onto, "onto", "", "commit to re-create the diff on")
+	command.Flags().BoolVar(&globalOpts.noSecretScan, "no-secret-scan", false, "push the rebased ghost without scanning it for secrets")
+	command.Flags().BoolVarP(&flags.force, "force", "f", false, "delete the original ghost after pushing the rebased one, which moves the ghost in place")
+	return command
+}
+
+func runRebaseCommand(flags *rebaseFlags) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if err := flags.validate(); err != nil {
+			exitWithConfigError(err)
+		}
+		arg := newPullDiffArg(args)
+		if err := arg.validate(); err != nil {
+			exitWithConfigError(err)
+		}
+		options := ghost.RebaseOptions{
+			WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
+			PullableDiffBranchSpec: &types.PullableDiffBranchSpec{
+				Prefix:         globalOpts.ghostPrefix,
+				CommittishFrom: arg.diffFrom,
+				DiffHash:       arg.diffHash,
+			},
+			Onto:  flags.onto,
+			Force: flags.force,
+		}
+
+		result, err := ghost.Rebase(options)
+		if err != nil {
+			exitWithError(err)
+		}
+		if result.Rebased != nil {
+			fmt.Printf("%s %s\n", result.Rebased.CommitHashFrom, result.Rebased.DiffHash)
+		}
+	}
+}
@@ -15,11 +15,19 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/pfnet-research/git-ghost/pkg/ghost"
 	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/oci"
 	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util"
 	"github.com/pfnet-research/git-ghost/pkg/util/errors"
 
 	log "github.com/sirupsen/logrus"
@@ -29,9 +37,48 @@ import (
 type globalFlags struct {
 	srcDir       string
 	ghostWorkDir string
+	tmpDir       string
 	ghostPrefix  string
 	ghostRepo    string
 	verbose      int
+	timeout      time.Duration
+	fullFetch    bool
+	sshCommand   string
+	identityFile string
+	proxy        string
+	// caBundle is a file of CA certificates in PEM to verify ghost repo over HTTPS with
+	caBundle   string
+	offline    bool
+	noCIDetect bool
+	// allowSameRepo allows writing to ghost repo which is the source repo itself or one of its remotes
+	allowSameRepo bool
+	// allowProtected allows writing ghost refs under ghost-prefix which collides with protected branches of ghost repo
+	allowProtected bool
+	// branchNameScheme is a template of ghost branch names, or empty for the default one
+	branchNameScheme string
+	// pipeThrough and pipeThroughOnPull are shell commands ghost files are piped through on storing and extracting them
+	pipeThrough       string
+	pipeThroughOnPull string
+	// concurrency bounds the parallelism of git commands, which is a string as it is a setting
+	concurrency string
+	// minGitVersion is the oldest version of git in the form of "X.Y" which git-ghost is allowed to run with
+	minGitVersion string
+	// auditLog is a file (or syslog) which audit records of push, pull, delete, rebase and copy are written to
+	auditLog string
+	// ghostUser is an identity in the form of "Name <email>" which ghost commits are created by
+	ghostUser string
+	// postApplyHook is set by a flag of pull, which is a setting as well as the global ones
+	postApplyHook string
+	// maxCommits is set by a flag of push in the same way, which is a string as it is a setting
+	maxCommits string
+	// secretPatterns is a file of regular expressions of secrets scanned for in addition to the default ones
+	secretPatterns string
+	// checkEOL is set by a flag of push in the same way, which is line endings accepted in files changed by pushed ghosts
+	checkEOL string
+	// noSecretScan is set by a flag of push and rebase to push ghosts without scanning them for secrets
+	noSecretScan bool
+	// sources maps names of settings to where their values come from
+	sources map[string]string
 }
 
 func (gf globalFlags) WorkingEnvSpec() types.WorkingEnvSpec {
@@ -39,6 +86,12 @@ func (gf globalFlags) WorkingEnvSpec() types.WorkingEnvSpec {
 		SrcDir:          gf.srcDir,
 		GhostWorkingDir: gf.ghostWorkDir,
 		GhostRepo:       gf.ghostRepo,
+		FullFetch:       gf.fullFetch,
+	}
+	if gf.ghostUser != "" {
+		// validated beforehand
+		workingEnvSpec.GhostUserName, workingEnvSpec.GhostUserEmail, _ = git.ParseIdent(gf.ghostUser)
+		return workingEnvSpec
 	}
 	userName, userEmail, err := git.GetUserConfig(globalOpts.srcDir)
 	if err == nil {
@@ -50,6 +103,15 @@ func (gf globalFlags) WorkingEnvSpec() types.WorkingEnvSpec {
 	return workingEnvSpec
 }
 
+// ociClientOptions returns options to talk to registries of OCI artifacts, which share the proxy, the CA bundle and --timeout with ghost repo
+func (gf globalFlags) ociClientOptions() oci.ClientOptions {
+	return oci.ClientOptions{
+		Proxy:    gf.proxy,
+		CABundle: gf.caBundle,
+		Context:  util.CommandContext(),
+	}
+}
+
 var (
 	Version  string
 	Revision string
@@ -59,22 +121,13 @@ var RootCmd = &cobra.Command{
 	Use:           "git-ghost",
 	Short:         "git-ghost",
 	SilenceErrors: false,
-	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) (err error) {
+		// flags are parsed by now, so errors from here on are not of usage
+		cmd.SilenceUsage = true
 		if cmd.Use == "version" {
 			return nil
 		}
-		err := validateEnvironment()
-		if err != nil {
-			return err
-		}
-		err = globalOpts.SetDefaults()
-		if err != nil {
-			return err
-		}
-		err = globalOpts.Validate()
-		if err != nil {
-			return err
-		}
+		// the log level and the timeout are set first so that they apply to git commands run for validation
 		switch globalOpts.verbose {
 		case 0:
 			log.SetLevel(log.ErrorLevel)
@@ -87,18 +140,167 @@ var RootCmd = &cobra.Command{
 		default:
 			log.SetLevel(log.TraceLevel)
 		}
+		if globalOpts.timeout > 0 {
+			var ctx context.Context
+			ctx, cancelTimeout = context.WithTimeout(context.Background(), globalOpts.timeout)
+			util.SetCommandContext(ctx)
+			// PersistentPostRun cancelling it doesn't run on a failure here
+			defer func() {
+				if err != nil {
+					cancelTimeout()
+				}
+			}()
+		}
+		err = validateEnvironment()
+		if err != nil {
+			return err
+		}
+		err = globalOpts.SetDefaults()
+		if err != nil {
+			return err
+		}
+		if isConfigCommand(cmd) {
+			return nil
+		}
+		err = globalOpts.Validate()
+		if err != nil {
+			return err
+		}
+		if !createsSrcDir(cmd) {
+			err = globalOpts.validateSrcDir()
+			if err != nil {
+				return err
+			}
+		}
+		if globalOpts.offline && writesGhostRepo(cmd) {
+			return errors.Errorf("'%s' is not available in offline mode because it writes to ghost repo", cmd.CommandPath())
+		}
+		if writesGhostRepo(cmd) && !globalOpts.allowSameRepo {
+			err = validateNotSameRepo()
+			if err != nil {
+				return err
+			}
+		}
+		git.SetSSHCommand(git.BuildSSHCommand(globalOpts.sshCommand, globalOpts.identityFile))
+		git.SetOffline(globalOpts.offline)
+		git.SetProxy(globalOpts.proxy)
+		if globalOpts.caBundle != "" {
+			caBundle, err := filepath.Abs(globalOpts.caBundle)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			git.SetCABundle(caBundle)
+		}
+		logEffectiveProxy()
+		types.SetPipeThrough(globalOpts.pipeThrough, globalOpts.pipeThroughOnPull)
+		types.SetGitGhostVersion(Version)
+		if globalOpts.branchNameScheme != "" {
+			scheme, _ := types.ParseBranchNameScheme(globalOpts.branchNameScheme)
+			types.SetBranchNameScheme(scheme)
+		}
+		ghost.SetAuditLog(globalOpts.auditLog)
+		if writesGhostRepo(cmd) && !globalOpts.noSecretScan {
+			rules, err := globalOpts.secretRules()
+			if err != nil {
+				return err
+			}
+			types.SetSecretRules(rules)
+		}
+		if writesGhostRepo(cmd) {
+			eols, _ := types.ParseEOLs(globalOpts.checkEOL)
+			types.SetAcceptedEOLs(eols)
+		}
+		err = git.SetConcurrency(globalOpts.concurrencyLimit())
+		if err != nil {
+			return err
+		}
+		err = git.PinPathQuoting()
+		if err != nil {
+			return err
+		}
+		if writesGhostRepo(cmd) && !globalOpts.allowProtected {
+			// the default branch of ghost repo is checked only by commands pushing ghost branches since it needs network
+			err = validatePrefixNotProtected(globalOpts.ghostPrefix)
+			if err != nil {
+				return err
+			}
+		}
+		if writesGhostRepo(cmd) {
+			repo, err := git.SourceRepo(globalOpts.srcDir)
+			if err != nil {
+				return err
+			}
+			types.SetSourceRepo(repo)
+		}
+		// git would wait for credentials on the terminal forever in CI, or fail obscurely without a terminal
+		git.SetNonInteractive(types.DetectCIEnvironment(os.Getenv) != nil || !util.HasTerminal())
+		if !globalOpts.noCIDetect {
+			ci := types.DetectCIEnvironment(os.Getenv)
+			if ci != nil {
+				log.WithFields(util.ToFields(*ci)).Info("detected CI environment, which is recorded in ghost commits")
+			}
+			types.SetCIEnvironment(ci)
+		}
 		return nil
 	},
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		if cancelTimeout != nil {
+			cancelTimeout()
+		}
+	},
+}
+
+// annotationWritesGhostRepo is an annotation of commands (and their subcommands) which write to ghost repo
+const annotationWritesGhostRepo = "git-ghost/writes-ghost-repo"
+
+var writesGhostRepoAnnotations = map[string]string{annotationWritesGhostRepo: "true"}
+
+func writesGhostRepo(cmd *cobra.Command) bool {
+	for c := cmd; c != nil; c = c.Parent() {
+		if _, ok := c.Annotations[annotationWritesGhostRepo]; ok {
+			return true
+		}
+	}
+	return false
 }
 
+// annotationCreatesSrcDir is an annotation of commands which create a source directory instead of working on src-dir
+const annotationCreatesSrcDir = "git-ghost/creates-src-dir"
+
+func createsSrcDir(cmd *cobra.Command) bool {
+	_, ok := cmd.Annotations[annotationCreatesSrcDir]
+	return ok
+}
+
+var cancelTimeout context.CancelFunc
+
 var globalOpts globalFlags
 
 func init() {
 	cobra.OnInitialize()
 	RootCmd.PersistentFlags().StringVar(&globalOpts.srcDir, "src-dir", "", "source directory which you create ghost from (default to the current directory)")
 	RootCmd.PersistentFlags().StringVar(&globalOpts.ghostWorkDir, "ghost-working-dir", "", "local root directory for git-ghost interacting with ghost repository (default to a temporary directory)")
-	RootCmd.PersistentFlags().StringVar(&globalOpts.ghostPrefix, "ghost-prefix", "", "prefix of ghost branch name (default to GIT_GHOST_PREFIX env, or ghost)")
-	RootCmd.PersistentFlags().StringVar(&globalOpts.ghostRepo, "ghost-repo", "", "git remote url for ghosts repository (default to GIT_GHOST_REPO env)")
+	RootCmd.PersistentFlags().StringVar(&globalOpts.tmpDir, "tmpdir", "", "directory where temporary files and clones are created (default to GIT_GHOST_TMPDIR env, ghost.tmpdir git config, or the system temporary directory)")
+	RootCmd.PersistentFlags().StringVar(&globalOpts.ghostPrefix, "ghost-prefix", "", "prefix of ghost branch name (default to GIT_GHOST_PREFIX env, ghost.prefix git config, or ghost)")
+	RootCmd.PersistentFlags().StringVar(&globalOpts.branchNameScheme, "branch-name-scheme", "", "template of ghost branch names with {prefix}, {type} (commits or diff), {base} and {hash}, e.g. '{prefix}/{type}/{base}/{hash}' (default to GIT_GHOST_BRANCH_NAME_SCHEME env, ghost.branchNameScheme git config, or '{prefix}/{base}-{hash}' for commits and '{prefix}/{base}/{hash}' for diffs)")
+	RootCmd.PersistentFlags().StringVar(&globalOpts.ghostRepo, "ghost-repo", "", "git remote url for ghosts repository (default to GIT_GHOST_REPO env, or ghost.repo git config)")
+	RootCmd.PersistentFlags().StringVar(&globalOpts.sshCommand, "ssh-command", "", "command to connect to ghost repo over SSH instead of GIT_SSH_COMMAND env (default to GIT_GHOST_SSH_COMMAND env, or ghost.sshCommand git config)")
+	RootCmd.PersistentFlags().StringVar(&globalOpts.identityFile, "identity-file", "", "identity file (private key) to connect to ghost repo over SSH (default to GIT_GHOST_IDENTITY_FILE env, or ghost.identityFile git config)")
+	RootCmd.PersistentFlags().StringVar(&globalOpts.proxy, "proxy", "", "proxy to talk to ghost repo over HTTP(S), e.g. http://proxy.example.com:8080, overriding HTTPS_PROXY and HTTP_PROXY envs (default to GIT_GHOST_PROXY env, or ghost.proxy git config)")
+	RootCmd.PersistentFlags().StringVar(&globalOpts.caBundle, "ca-bundle", "", "file of CA certificates in PEM to verify ghost repo over HTTPS with, e.g. a private CA (default to GIT_GHOST_CA_BUNDLE env, or ghost.caBundle git config)")
+	RootCmd.PersistentFlags().StringVar(&globalOpts.secretPatterns, "secret-patterns", "", "file of regular expressions (one per line) of secrets which pushed ghosts are scanned for in addition to the default ones, e.g. AWS keys and private keys (default to GIT_GHOST_SECRET_PATTERNS env, or ghost.secretPatterns git config)")
+	RootCmd.PersistentFlags().StringVar(&globalOpts.pipeThrough, "pipe-through", "", "shell command which ghost files are piped through before being stored by push, e.g. 'gpg --encrypt -r <key>' (default to GIT_GHOST_PIPE_THROUGH env, or ghost.pipeThrough git config)")
+	RootCmd.PersistentFlags().StringVar(&globalOpts.pipeThroughOnPull, "pipe-through-on-pull", "", "shell command reversing --pipe-through, which piped ghost files are piped through after being extracted by pull, show and push --incremental-from, e.g. 'gpg --decrypt' (default to GIT_GHOST_PIPE_THROUGH_ON_PULL env, or ghost.pipeThroughOnPull git config)")
+	RootCmd.PersistentFlags().StringVar(&globalOpts.minGitVersion, "min-git-version", "", "oldest version of git in the form of X.Y which git-ghost runs with, to fail early on an outdated git (default to GIT_GHOST_MIN_GIT_VERSION env or ghost.minGitVersion git config)")
+	RootCmd.PersistentFlags().StringVar(&globalOpts.concurrency, "concurrency", "", "maximum number of threads or processes each git command run by git-ghost uses in parallel, 0 for git's defaults (default to GIT_GHOST_CONCURRENCY env, ghost.concurrency git config, or 1 for a local ghost repo and 0 otherwise)")
+	RootCmd.PersistentFlags().StringVar(&globalOpts.auditLog, "audit-log", "", "file which an audit record of every push, pull, delete, rebase and copy is appended to as a JSON line, or 'syslog' to send them to the local syslog (default to GIT_GHOST_AUDIT_LOG env, or ghost.auditLog git config)")
+	RootCmd.PersistentFlags().StringVar(&globalOpts.ghostUser, "ghost-user", "", "identity in the form of 'Name <email>' which ghost commits are created by (default to GIT_GHOST_USER env, ghost.user git config, the user of the source directory, or 'Git Ghost <git-ghost@example.com>')")
+	RootCmd.PersistentFlags().BoolVar(&globalOpts.offline, "offline", false, "never access ghost repo over network, which must be a local repo (e.g. a mirror) then. commands writing to ghost repo fail immediately")
+	RootCmd.PersistentFlags().BoolVar(&globalOpts.allowProtected, "allow-protected", false, "allow commands writing to ghost repo even if ghost-prefix collides with its protected branches (main, master and its default branch), which ghosts may clobber")
+	RootCmd.PersistentFlags().BoolVar(&globalOpts.allowSameRepo, "allow-same-repo", false, "allow commands writing to ghost repo even if it is the source repo itself or one of its remotes, whose branches and tags are polluted by ghosts")
+	RootCmd.PersistentFlags().BoolVar(&globalOpts.noCIDetect, "no-ci-autodetect", false, "don't record a CI job (commit, branch and job url) detected from environment variables of GitHub Actions, GitLab CI or Jenkins in ghost commits")
+	RootCmd.PersistentFlags().BoolVar(&globalOpts.fullFetch, "full-fetch", false, "fetch all branches of ghost repo instead of only required ones (for debugging)")
+	RootCmd.PersistentFlags().DurationVar(&globalOpts.timeout, "timeout", 0, "timeout of the whole operation, e.g. 5m (default no timeout)")
 	RootCmd.PersistentFlags().CountVarP(&globalOpts.verbose, "verbose", "v", "verbose mode. (1: info, 2: debug, 3: trace)")
 	RootCmd.AddCommand(versionCmd)
 }
@@ -128,28 +330,40 @@ func (flags *globalFlags) SetDefaults() errors.GitGhostError {
 		}
 		globalOpts.srcDir = srcDir
 	}
-	if globalOpts.ghostWorkDir == "" {
-		globalOpts.ghostWorkDir = os.TempDir()
-	}
-	if globalOpts.ghostPrefix == "" {
-		ghostPrefixEnv := os.Getenv("GIT_GHOST_PREFIX")
-		if ghostPrefixEnv == "" {
-			ghostPrefixEnv = "ghost"
-		}
-		globalOpts.ghostPrefix = ghostPrefixEnv
+	// an absolute path makes logs unambiguous
+	srcDir, err := filepath.Abs(globalOpts.srcDir)
+	if err != nil {
+		return errors.WithStack(err)
 	}
-	if globalOpts.ghostRepo == "" {
-		globalOpts.ghostRepo = os.Getenv("GIT_GHOST_REPO")
+	globalOpts.srcDir = srcDir
+	globalOpts.resolveSettings()
+	util.SetTempDir(globalOpts.tmpDir)
+	if globalOpts.ghostWorkDir == "" {
+		globalOpts.ghostWorkDir = util.TempDir()
 	}
 	return nil
 }
 
-func (flags *globalFlags) Validate() errors.GitGhostError {
+// validateSrcDir checks src-dir is a work tree of git, which every command but ones creating it works on
+func (flags *globalFlags) validateSrcDir() errors.GitGhostError {
 	if flags.srcDir == "" {
 		return errors.New("src-dir must be specified")
 	}
-	_, err := os.Stat(flags.ghostWorkDir)
+	err := git.ValidateWorkTree(flags.srcDir)
 	if err != nil {
+		return errors.Errorf("src-dir is invalid: %s", err)
+	}
+	return nil
+}
+
+func (flags *globalFlags) Validate() errors.GitGhostError {
+	if flags.tmpDir != "" {
+		err := util.ValidateWritableDir(flags.tmpDir)
+		if err != nil {
+			return errors.Errorf("tmpdir is not writable (value: %v): %s", flags.tmpDir, err)
+		}
+	}
+	if _, err := os.Stat(flags.ghostWorkDir); err != nil {
 		return errors.Errorf("ghost-working-dir is not found (value: %v)", flags.ghostWorkDir)
 	}
 	if flags.ghostPrefix == "" {
@@ -158,5 +372,178 @@ func (flags *globalFlags) Validate() errors.GitGhostError {
 	if flags.ghostRepo == "" {
 		return errors.New("ghost-repo must be specified")
 	}
+	if err := git.ValidateRemoteHelper(flags.ghostRepo); err != nil {
+		return errors.Errorf("ghost-repo is invalid: %s", err)
+	}
+	if transport := git.RemoteHelper(flags.ghostRepo); transport != "" && flags.offline {
+		return errors.Errorf("offline is not available with ghost-repo through remote helper of %s::, which git doesn't allow in offline mode", transport)
+	}
+	if flags.branchNameScheme != "" {
+		_, err := types.ParseBranchNameScheme(flags.branchNameScheme)
+		if err != nil {
+			return errors.Errorf("branch-name-scheme is invalid: %s", err)
+		}
+	}
+	if flags.timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	if flags.proxy != "" {
+		err := git.ValidateProxy(flags.proxy)
+		if err != nil {
+			return errors.Errorf("proxy is invalid: %s", err)
+		}
+	}
+	if flags.caBundle != "" {
+		err := git.ValidateCABundle(flags.caBundle)
+		if err != nil {
+			return errors.Errorf("ca-bundle is invalid: %s", err)
+		}
+	}
+	if flags.secretPatterns != "" {
+		_, err := git.LoadSecretRules(flags.secretPatterns)
+		if err != nil {
+			return errors.Errorf("secret-patterns is invalid: %s", err)
+		}
+	}
+	if _, err := types.ParseEOLs(flags.checkEOL); err != nil {
+		return errors.Errorf("check-eol is invalid: %s", err)
+	}
+	if flags.concurrency != "" {
+		n, err := strconv.Atoi(flags.concurrency)
+		if err != nil || n < 0 {
+			return errors.Errorf("concurrency must be a non-negative integer (value: %v)", flags.concurrency)
+		}
+		// it is passed by GIT_CONFIG_COUNT envs, which older git ignores silently
+		if n > 0 {
+			ggerr := git.RequireVersion("concurrency", 2, 31)
+			if ggerr != nil {
+				return ggerr
+			}
+		}
+	}
+	if flags.minGitVersion != "" {
+		major, minor, err := git.ParseMinVersion(flags.minGitVersion)
+		if err != nil {
+			return errors.Errorf("min-git-version is invalid: %s", err)
+		}
+		err = git.RequireVersion("git-ghost configured by min-git-version", major, minor)
+		if err != nil {
+			return err
+		}
+	}
+	if flags.maxCommits != "" {
+		n, err := strconv.Atoi(flags.maxCommits)
+		if err != nil || n < 0 {
+			return errors.Errorf("max-commits must be a non-negative integer (value: %v)", flags.maxCommits)
+		}
+	}
+	if flags.ghostUser != "" {
+		_, _, err := git.ParseIdent(flags.ghostUser)
+		if err != nil {
+			return errors.Errorf("ghost-user is invalid: %s", err)
+		}
+	}
+	if flags.identityFile != "" {
+		// the path is not included in the error not to leak it into logs
+		err := util.ValidateReadableFile(flags.identityFile)
+		if err != nil {
+			return errors.New("identity-file is not a readable file")
+		}
+	}
+	return nil
+}
+
+// validateNotSameRepo checks ghost repo is neither the source repo itself nor one of its remotes, which ghosts would pollute
+func validateNotSameRepo() errors.GitGhostError {
+	same, err := git.IsSameRepo(globalOpts.srcDir, globalOpts.ghostRepo)
+	if err != nil {
+		return err
+	}
+	if same {
+		return errors.Errorf("ghost-repo %s is the source repo itself or one of its remotes, whose branches would be polluted by ghosts. please specify another ghost-repo (or --allow-same-repo if it is intended)", util.RedactURLPassword(globalOpts.ghostRepo))
+	}
+	return nil
+}
+
+// validateNotProtected checks ghost refs under prefix don't collide with protected branches of repo including its default branch, which they would clobber
+//
+// A collision is classified as errors.CategoryConfig, while failures on listing refs of repo are remote errors.
+// It does nothing with --allow-protected.
+func validateNotProtected(repo, prefix string) errors.GitGhostError {
+	if globalOpts.allowProtected {
+		return nil
+	}
+	branch, err := git.FindProtectedBranchCollision(repo, prefix)
+	if err != nil {
+		return err
+	}
+	if branch != "" {
+		return errors.WithCategory(errors.Errorf("ghost-prefix %s collides with protected branch %s of %s, which would be clobbered by ghosts. please specify another ghost-prefix (or --allow-protected if it is intended)", prefix, branch, util.RedactURLPassword(repo)), errors.CategoryConfig)
+	}
+	return nil
+}
+
+// validatePrefixNotProtected checks ghost refs under prefix don't collide with main or master without accessing ghost repo
+func validatePrefixNotProtected(prefix string) errors.GitGhostError {
+	if branch := git.ProtectedBranchCollision(prefix); branch != "" {
+		return errors.WithCategory(errors.Errorf("ghost-prefix %s collides with protected branch %s, which would be clobbered by ghosts. please specify another ghost-prefix (or --allow-protected if it is intended)", prefix, branch), errors.CategoryConfig)
+	}
 	return nil
 }
+
+// concurrencyLimit returns the validated concurrency, or the default one for ghost repo if it is not set
+func (flags *globalFlags) concurrencyLimit() int {
+	if flags.concurrency == "" {
+		n := git.DefaultConcurrency(flags.ghostRepo)
+		log.WithField("concurrency", n).Debug("concurrency is defaulted by ghost repo")
+		return n
+	}
+	n, _ := strconv.Atoi(flags.concurrency)
+	return n
+}
+
+// maxCommitsLimit returns the validated max-commits, or 0 for no limit if force is true
+func (flags *globalFlags) maxCommitsLimit(force bool) int {
+	if force {
+		return 0
+	}
+	n, _ := strconv.Atoi(flags.maxCommits)
+	return n
+}
+
+// secretRules returns the default secret rules together with the ones in secret-patterns
+func (flags *globalFlags) secretRules() ([]git.SecretRule, errors.GitGhostError) {
+	rules := append([]git.SecretRule{}, git.DefaultSecretRules...)
+	if flags.secretPatterns == "" {
+		return rules, nil
+	}
+	extra, err := git.LoadSecretRules(flags.secretPatterns)
+	if err != nil {
+		return nil, err
+	}
+	return append(rules, extra...), nil
+}
+
+// logEffectiveProxy logs a proxy which git uses to talk to ghost repo with its password redacted
+func logEffectiveProxy() {
+	repo := globalOpts.ghostRepo
+	if !strings.HasPrefix(repo, "http://") && !strings.HasPrefix(repo, "https://") {
+		if globalOpts.proxy != "" {
+			log.Info("proxy is ignored because ghost repo is not of HTTP(S)")
+		}
+		return
+	}
+	proxy, source := globalOpts.proxy, globalOpts.sources["proxy"]
+	if proxy == "" {
+		var env string
+		proxy, env = git.EnvProxy(repo)
+		source = sourceEnv + " " + env
+	}
+	if proxy == "" {
+		return
+	}
+	log.WithFields(log.Fields{
+		"proxy":  util.RedactURLPassword(proxy),
+		"source": source,
+	}).Info("talking to ghost repo through proxy")
+}
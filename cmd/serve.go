@@ -0,0 +1,129 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(NewServeCommand())
+}
+
+const (
+	// servePasswordEnv is an env of the password of Basic authentication of 'serve', which is not taken by a flag to keep it out of process lists
+	servePasswordEnv = "GIT_GHOST_SERVE_PASSWORD"
+	// serveTokenEnv is an env of the Bearer token of 'serve'
+	serveTokenEnv = "GIT_GHOST_SERVE_TOKEN"
+)
+
+type serveFlags struct {
+	addr     string
+	prefixes []string
+	username string
+}
+
+func (flags serveFlags) validate() errors.GitGhostError {
+	if _, _, err := net.SplitHostPort(flags.addr); err != nil {
+		return errors.Errorf("addr is invalid: %s", err)
+	}
+	for _, p := range flags.prefixes {
+		if p == "" {
+			return errors.New("prefix must not be empty")
+		}
+	}
+	if flags.username != "" && os.Getenv(servePasswordEnv) == "" {
+		return errors.Errorf("basic-auth-user requires a password by %s env", servePasswordEnv)
+	}
+	return nil
+}
+
+func NewServeCommand() *cobra.Command {
+	var (
+		flags serveFlags
+	)
+	command := &cobra.Command{
+		Use:   "serve",
+		Short: "serve a read-only web viewer of ghost branches.",
+		Long:  "serve a read-only web viewer of ghost branches over HTTP, which lists ghost branches with their tags and shows their diffs highlighted, for reviewers without git-ghost.  nothing can be changed through it.  it is authenticated by --basic-auth-user with " + servePasswordEnv + " env and/or a Bearer token by " + serveTokenEnv + " env if given.",
+		Args:  cobra.NoArgs,
+		Run:   runServeCommand(&flags),
+	}
+	command.Flags().StringVar(&flags.addr, "addr", "127.0.0.1:8080", "address to listen on.  listening on others than loopback exposes ghosts to the network, so authentication should be enabled.")
+	command.Flags().StringSliceVar(&flags.prefixes, "prefix", nil, "prefix of ghost branches which can be browsed, which can be repeated (default to --ghost-prefix)")
+	command.Flags().StringVar(&flags.username, "basic-auth-user", "", "require Basic authentication by this user and the password by "+servePasswordEnv+" env")
+	return command
+}
+
+func runServeCommand(flags *serveFlags) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if err := flags.validate(); err != nil {
+			exitWithConfigError(err)
+		}
+		prefixes := flags.prefixes
+		if len(prefixes) == 0 {
+			prefixes = []string{globalOpts.ghostPrefix}
+		}
+		token := os.Getenv(serveTokenEnv)
+		if flags.username == "" && token == "" {
+			if host, _, _ := net.SplitHostPort(flags.addr); !isLoopback(host) {
+				log.Warnf("serving ghosts on %s without authentication. please set --basic-auth-user or %s env", flags.addr, serveTokenEnv)
+			}
+		}
+
+		stop := make(chan struct{})
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-signals
+			close(stop)
+		}()
+
+		err := ghost.Serve(ghost.ServeOptions{
+			WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
+			Prefixes:       prefixes,
+			Addr:           flags.addr,
+			Username:       flags.username,
+			Password:       os.Getenv(servePasswordEnv),
+			Token:          token,
+			OnListen: func(addr string) {
+				fmt.Fprintf(os.Stderr, "serving ghosts on http://%s/\n", addr)
+			},
+			Stop: stop,
+		})
+		if err != nil {
+			exitWithError(err)
+		}
+	}
+}
+
+// isLoopback returns whether host is "localhost" or a loopback address
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
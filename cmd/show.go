@@ -15,10 +15,13 @@
 package cmd
 
 import (
+	"io"
 	"os"
 
 	"github.com/pfnet-research/git-ghost/pkg/ghost"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
 	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util"
 	"github.com/pfnet-research/git-ghost/pkg/util/errors"
 
 	log "github.com/sirupsen/logrus"
@@ -29,38 +32,108 @@ func init() {
 	RootCmd.AddCommand(NewShowCommand())
 }
 
+type showFlags struct {
+	color      string
+	noColor    bool
+	provenance bool
+	files      bool
+	nameOnly   bool
+	outputDir  string
+	combined   bool
+}
+
 func NewShowCommand() *cobra.Command {
+	var (
+		flags showFlags
+	)
 	command := &cobra.Command{
 		Use:   "show [from-hash(default=HEAD)] [diff-hash]",
 		Short: "show commits(hash1...hash2), diff(hash...current state) in ghost repo",
 		Long:  "show commits or diff or all from ghost repo.  If you didn't specify any subcommand, this commands works as an alias for 'show diff' command.",
 		Args:  cobra.RangeArgs(1, 2),
-		Run:   runShowDiffCommand,
+		Run:   runShowDiffCommand(&flags),
 	}
 	command.AddCommand(&cobra.Command{
 		Use:   "diff [diff-from-hash(default=HEAD)] [diff-hash]",
 		Short: "show diff in ghost repo ",
 		Long:  "show diff from [diff-from-hash] to [diff-hash] in ghost repo",
 		Args:  cobra.RangeArgs(1, 2),
-		Run:   runShowDiffCommand,
+		Run:   runShowDiffCommand(&flags),
 	})
 	command.AddCommand(&cobra.Command{
 		Use:   "commits [from-hash(default=HEAD)] [to-hash]",
 		Short: "show commits in ghost repo",
 		Long:  "show commits from [from-hash] to [to-hash] in ghost repo",
 		Args:  cobra.RangeArgs(1, 2),
-		Run:   runShowCommitsCommand,
+		Run:   runShowCommitsCommand(&flags),
 	})
 	command.AddCommand(&cobra.Command{
 		Use:   "all [from-hash(default=HEAD)] [to-hash] [diff-hash]",
 		Short: "show both commits and diff in ghost repo",
 		Long:  "show commits([from-hash]...[to-hash]) and diff([to-hash]...[diff-hash]) in ghost repo",
 		Args:  cobra.RangeArgs(2, 3),
-		Run:   runShowAllCommand,
+		Run:   runShowAllCommand(&flags),
 	})
+	command.PersistentFlags().StringVar(&flags.color, "color", "auto", "color patches. One of: auto|always|never (auto follows color.diff and color.ui of git config, which color only when stdout is a terminal by default, unless NO_COLOR env is set). colors are taken from color.diff.<slot> of git config")
+	command.PersistentFlags().BoolVar(&flags.provenance, "provenance", false, "show where ghosts come from (branch, format version, and who pushed them when) before their contents")
+	command.PersistentFlags().BoolVar(&flags.noColor, "no-color", false, "don't color patches (same as --color=never)")
+	command.PersistentFlags().BoolVar(&flags.files, "files", false, "show only files changed by ghosts with their statuses (A, D, M, R or C) like 'git diff --name-status' instead of patches")
+	command.PersistentFlags().StringVar(&flags.outputDir, "output-dir", "", "write files touched by ghosts as they are after applying into the directory, which must be empty or not exist, and show their paths instead of patches")
+	command.PersistentFlags().BoolVar(&flags.combined, "combined", false, "show ghosts as a single combined patch with a manifest of their sections (commits first and then the diff) in the order to apply, which 'git-ghost apply-combined' applies. it is never colored")
+	command.PersistentFlags().BoolVar(&flags.nameOnly, "name-only", false, "show only paths of files changed by ghosts like 'git diff --name-only' instead of patches")
 	return command
 }
 
+func (flags showFlags) validate() errors.GitGhostError {
+	if (flags.files || flags.nameOnly) && flags.provenance {
+		return errors.New("provenance is not available with --files or --name-only")
+	}
+	if flags.outputDir != "" && (flags.files || flags.nameOnly || flags.provenance) {
+		return errors.New("output-dir is not available with --files, --name-only or --provenance")
+	}
+	if flags.combined && (flags.files || flags.nameOnly || flags.provenance || flags.outputDir != "") {
+		return errors.New("combined is not available with --files, --name-only, --provenance or --output-dir")
+	}
+	switch flags.color {
+	case "auto", "always", "never":
+		return nil
+	}
+	return errors.New("color must be one of [auto always never]")
+}
+
+// writer returns a writer for patches and a function to be called after writing
+func (flags showFlags) writer() (io.Writer, func() errors.GitGhostError, errors.GitGhostError) {
+	flush := func() errors.GitGhostError { return nil }
+	if flags.outputDir != "" || flags.combined {
+		return os.Stdout, flush, nil
+	}
+	if flags.files || flags.nameOnly {
+		writer := git.NewNameStatusWriter(os.Stdout, flags.nameOnly)
+		return writer, writer.Flush, nil
+	}
+	colored := false
+	switch {
+	case flags.noColor, flags.color == "never":
+	case flags.color == "always":
+		colored = true
+	case os.Getenv("NO_COLOR") == "":
+		var err errors.GitGhostError
+		colored, err = git.GetColorBool(globalOpts.srcDir, "color.diff", util.IsTerminal(os.Stdout))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if !colored {
+		return os.Stdout, flush, nil
+	}
+	colors, err := git.GetDiffColors(globalOpts.srcDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	writer := util.NewDiffColorWriter(os.Stdout, colors)
+	return writer, writer.Flush, nil
+}
+
 type showCommitsArg struct {
 	commitsFrom string
 	commitsTo   string
@@ -96,27 +169,41 @@ func (arg showCommitsArg) validate() errors.GitGhostError {
 	return nil
 }
 
-func runShowCommitsCommand(cmd *cobra.Command, args []string) {
-	arg := newShowCommitsArg(args)
-	if err := arg.validate(); err != nil {
-		errors.LogErrorWithStack(err)
-		os.Exit(1)
-	}
+func runShowCommitsCommand(flags *showFlags) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if err := flags.validate(); err != nil {
+			exitWithConfigError(err)
+		}
+		writer, flush, err := flags.writer()
+		if err != nil {
+			exitWithError(err)
+		}
 
-	options := ghost.ShowOptions{
-		WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
-		CommitsBranchSpec: &types.CommitsBranchSpec{
-			Prefix:         globalOpts.ghostPrefix,
-			CommittishFrom: arg.commitsFrom,
-			CommittishTo:   arg.commitsTo,
-		},
-		Writer: os.Stdout,
-	}
+		arg := newShowCommitsArg(args)
+		if err := arg.validate(); err != nil {
+			exitWithConfigError(err)
+		}
 
-	err := ghost.Show(options)
-	if err != nil {
-		errors.LogErrorWithStack(err)
-		os.Exit(1)
+		options := ghost.ShowOptions{
+			WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
+			CommitsBranchSpec: &types.CommitsBranchSpec{
+				Prefix:         globalOpts.ghostPrefix,
+				CommittishFrom: arg.commitsFrom,
+				CommittishTo:   arg.commitsTo,
+			},
+			Writer:     writer,
+			Provenance: flags.provenance,
+			OutputDir:  flags.outputDir,
+			Combined:   flags.combined,
+		}
+
+		err = ghost.Show(options)
+		if err == nil {
+			err = flush()
+		}
+		if err != nil {
+			exitWithError(err)
+		}
 	}
 }
 
@@ -155,73 +242,100 @@ func (arg showDiffArg) validate() errors.GitGhostError {
 	return nil
 }
 
-func runShowDiffCommand(cmd *cobra.Command, args []string) {
-	arg := newShowDiffArg(args)
-	if err := arg.validate(); err != nil {
-		errors.LogErrorWithStack(err)
-		os.Exit(1)
-	}
+func runShowDiffCommand(flags *showFlags) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if err := flags.validate(); err != nil {
+			exitWithConfigError(err)
+		}
+		writer, flush, err := flags.writer()
+		if err != nil {
+			exitWithError(err)
+		}
 
-	options := ghost.ShowOptions{
-		WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
-		PullableDiffBranchSpec: &types.PullableDiffBranchSpec{
-			Prefix:         globalOpts.ghostPrefix,
-			CommittishFrom: arg.diffFrom,
-			DiffHash:       arg.diffHash,
-		},
-		Writer: os.Stdout,
-	}
+		arg := newShowDiffArg(args)
+		if err := arg.validate(); err != nil {
+			exitWithConfigError(err)
+		}
 
-	err := ghost.Show(options)
-	if err != nil {
-		errors.LogErrorWithStack(err)
-		os.Exit(1)
+		options := ghost.ShowOptions{
+			WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
+			PullableDiffBranchSpec: &types.PullableDiffBranchSpec{
+				Prefix:         globalOpts.ghostPrefix,
+				CommittishFrom: arg.diffFrom,
+				DiffHash:       arg.diffHash,
+			},
+			Writer:     writer,
+			Provenance: flags.provenance,
+			OutputDir:  flags.outputDir,
+			Combined:   flags.combined,
+		}
+
+		err = ghost.Show(options)
+		if err == nil {
+			err = flush()
+		}
+		if err != nil {
+			exitWithError(err)
+		}
 	}
 }
 
-func runShowAllCommand(cmd *cobra.Command, args []string) {
-	var showCommitsArg showCommitsArg
-	var showDiffArg showDiffArg
-
-	switch len(args) {
-	case 3:
-		showCommitsArg = newShowCommitsArg(args[0:2])
-		showDiffArg = newShowDiffArg(args[1:])
-	case 2:
-		showCommitsArg = newShowCommitsArg(args[0:1])
-		showDiffArg = newShowDiffArg(args)
-	default:
-		log.Error(cmd.Args(cmd, args))
-		os.Exit(1)
-	}
-
-	if err := showCommitsArg.validate(); err != nil {
-		errors.LogErrorWithStack(err)
-		os.Exit(1)
-	}
-	if err := showDiffArg.validate(); err != nil {
-		errors.LogErrorWithStack(err)
-		os.Exit(1)
-	}
-
-	options := ghost.ShowOptions{
-		WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
-		CommitsBranchSpec: &types.CommitsBranchSpec{
-			Prefix:         globalOpts.ghostPrefix,
-			CommittishFrom: showCommitsArg.commitsFrom,
-			CommittishTo:   showCommitsArg.commitsTo,
-		},
-		PullableDiffBranchSpec: &types.PullableDiffBranchSpec{
-			Prefix:         globalOpts.ghostPrefix,
-			CommittishFrom: showDiffArg.diffFrom,
-			DiffHash:       showDiffArg.diffHash,
-		},
-		Writer: os.Stdout,
-	}
-
-	err := ghost.Show(options)
-	if err != nil {
-		errors.LogErrorWithStack(err)
-		os.Exit(1)
+func runShowAllCommand(flags *showFlags) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if err := flags.validate(); err != nil {
+			exitWithConfigError(err)
+		}
+		writer, flush, err := flags.writer()
+		if err != nil {
+			exitWithError(err)
+		}
+
+		var showCommitsArg showCommitsArg
+		var showDiffArg showDiffArg
+
+		switch len(args) {
+		case 3:
+			showCommitsArg = newShowCommitsArg(args[0:2])
+			showDiffArg = newShowDiffArg(args[1:])
+		case 2:
+			showCommitsArg = newShowCommitsArg(args[0:1])
+			showDiffArg = newShowDiffArg(args)
+		default:
+			log.Error(cmd.Args(cmd, args))
+			os.Exit(exitCodeConfig)
+		}
+
+		if err := showCommitsArg.validate(); err != nil {
+			exitWithConfigError(err)
+		}
+		if err := showDiffArg.validate(); err != nil {
+			exitWithConfigError(err)
+		}
+
+		options := ghost.ShowOptions{
+			WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
+			CommitsBranchSpec: &types.CommitsBranchSpec{
+				Prefix:         globalOpts.ghostPrefix,
+				CommittishFrom: showCommitsArg.commitsFrom,
+				CommittishTo:   showCommitsArg.commitsTo,
+			},
+			PullableDiffBranchSpec: &types.PullableDiffBranchSpec{
+				Prefix:         globalOpts.ghostPrefix,
+				CommittishFrom: showDiffArg.diffFrom,
+				DiffHash:       showDiffArg.diffHash,
+			},
+			Writer:     writer,
+			Provenance: flags.provenance,
+			OutputDir:  flags.outputDir,
+			Combined:   flags.combined,
+		}
+
+		err = ghost.Show(options)
+		if err == nil {
+			err = flush()
+		}
+		if err != nil {
+			exitWithError(err)
+		}
 	}
 }
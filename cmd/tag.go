@@ -0,0 +1,129 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(NewTagCommand())
+}
+
+type tagFlags struct {
+	noHeaders    bool
+	deleteGhosts bool
+}
+
+func NewTagCommand() *cobra.Command {
+	var (
+		flags tagFlags
+	)
+	command := &cobra.Command{
+		Use:   "tag",
+		Short: "manage human-friendly tags of ghost branches.",
+		Long:  "manage human-friendly tags of ghost branches.  tags are stored as tag refs in your ghost repo.",
+	}
+	command.AddCommand(&cobra.Command{
+		Use:         "add [hash] [tag]",
+		Annotations: writesGhostRepoAnnotations,
+		Short:       "add a tag to a ghost branch",
+		Long:        "add [tag] to a ghost branch whose diff hash (or the last hash of its line in 'list' output) is [hash].  it fails with exit code 7 if [tag] already exists, even if it is added concurrently, so that a tag can be used as a lock.",
+		Args:        cobra.ExactArgs(2),
+		Run:         runTagAddCommand,
+	})
+	rmCommand := &cobra.Command{
+		Use:         "rm [tag...]",
+		Annotations: writesGhostRepoAnnotations,
+		Short:       "remove tags",
+		Long:        "remove tags.  ghost branches which the tags point to are kept unless --delete-ghost is specified.",
+		Args:        cobra.MinimumNArgs(1),
+		Run:         runTagRmCommand(&flags),
+	}
+	rmCommand.Flags().BoolVar(&flags.deleteGhosts, "delete-ghost", false, "also delete ghost branches which the tags point to.")
+	command.AddCommand(rmCommand)
+	listCommand := &cobra.Command{
+		Use:   "list [hash]",
+		Short: "list tags",
+		Long:  "list tags.  if [hash] is specified, only tags of the ghost branch are listed.",
+		Args:  cobra.RangeArgs(0, 1),
+		Run:   runTagListCommand(&flags),
+	}
+	listCommand.Flags().BoolVar(&flags.noHeaders, "no-headers", false, "don't print headers (default print headers).")
+	command.AddCommand(listCommand)
+	command.AddCommand(&cobra.Command{
+		Use:         "rename [old-tag] [new-tag]",
+		Annotations: writesGhostRepoAnnotations,
+		Short:       "rename a tag",
+		Long:        "rename [old-tag] to [new-tag] without touching the ghost branch.",
+		Args:        cobra.ExactArgs(2),
+		Run:         runTagRenameCommand,
+	})
+	return command
+}
+
+func newTagOptions() ghost.TagOptions {
+	return ghost.TagOptions{
+		WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
+		Prefix:         globalOpts.ghostPrefix,
+	}
+}
+
+func runTagAddCommand(cmd *cobra.Command, args []string) {
+	if err := nonEmpty("hash", args[0]); err != nil {
+		exitWithConfigError(err)
+	}
+	tag, err := ghost.AddTag(newTagOptions(), args[0], args[1])
+	if err != nil {
+		exitWithError(err)
+	}
+	fmt.Print(ghost.Tags{*tag}.PrettyString(false))
+}
+
+func runTagRmCommand(flags *tagFlags) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		tags, err := ghost.RemoveTags(newTagOptions(), args, flags.deleteGhosts)
+		if err != nil {
+			exitWithError(err)
+		}
+		fmt.Print(tags.PrettyString(false))
+	}
+}
+
+func runTagListCommand(flags *tagFlags) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		hash := ""
+		if len(args) >= 1 {
+			hash = args[0]
+		}
+		tags, err := ghost.ListTags(newTagOptions(), hash)
+		if err != nil {
+			exitWithError(err)
+		}
+		fmt.Print(tags.PrettyString(!flags.noHeaders))
+	}
+}
+
+func runTagRenameCommand(cmd *cobra.Command, args []string) {
+	tag, err := ghost.RenameTag(newTagOptions(), args[0], args[1])
+	if err != nil {
+		exitWithError(err)
+	}
+	fmt.Print(ghost.Tags{*tag}.PrettyString(false))
+}
@@ -0,0 +1,97 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(NewVerifyCommand())
+}
+
+type verifyFlags struct {
+	bases     []string
+	jobs      int
+	noHeaders bool
+}
+
+func (flags verifyFlags) validate() errors.GitGhostError {
+	if len(flags.bases) == 0 {
+		return errors.New("bases must be specified")
+	}
+	for _, base := range flags.bases {
+		if err := nonEmpty("base", base); err != nil {
+			return err
+		}
+	}
+	if flags.jobs < 1 {
+		return errors.Errorf("jobs must be a positive integer (value: %d)", flags.jobs)
+	}
+	return nil
+}
+
+func NewVerifyCommand() *cobra.Command {
+	var (
+		flags verifyFlags
+	)
+	command := &cobra.Command{
+		Use:   "verify [diff-from-hash(default=HEAD)] [diff-hash] --bases <base>,...",
+		Short: "check which bases a diff ghost applies cleanly onto",
+		Long:  "apply the diff from [diff-from-hash] to [diff-hash] onto each of --bases in temporary worktrees and print which ones it applies cleanly onto and which ones it conflicts with.  working dir is not touched, and it exits with 6 if the diff applies cleanly onto none of them.",
+		Args:  cobra.RangeArgs(0, 2),
+		Run:   runVerifyCommand(&flags),
+	}
+	command.Flags().StringSliceVar(&flags.bases, "bases", []string{}, "commits to try applying the diff onto, this flag can be repeated to specify multiple commits.")
+	command.Flags().IntVarP(&flags.jobs, "jobs", "j", 1, "maximum number of bases tried in parallel.")
+	command.Flags().BoolVar(&flags.noHeaders, "no-headers", false, "don't print headers and the summary.")
+	return command
+}
+
+func runVerifyCommand(flags *verifyFlags) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if err := flags.validate(); err != nil {
+			exitWithConfigError(err)
+		}
+		arg := newPullDiffArg(args)
+		if err := arg.validate(); err != nil {
+			exitWithConfigError(err)
+		}
+		options := ghost.VerifyOptions{
+			WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
+			PullableDiffBranchSpec: &types.PullableDiffBranchSpec{
+				Prefix:         globalOpts.ghostPrefix,
+				CommittishFrom: arg.diffFrom,
+				DiffHash:       arg.diffHash,
+			},
+			Bases: flags.bases,
+			Jobs:  flags.jobs,
+		}
+		result, err := ghost.Verify(options)
+		if err != nil {
+			exitWithError(err)
+		}
+		fmt.Print(result.PrettyString(!flags.noHeaders))
+		if result.CleanBases() == 0 {
+			exitWithError(errors.WithCategory(errors.New("the diff applies cleanly onto none of the bases"), errors.CategoryConflict))
+		}
+	}
+}
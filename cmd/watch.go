@@ -0,0 +1,168 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(NewWatchCommand())
+}
+
+type watchFlags struct {
+	pushFlags
+	tag      string
+	interval time.Duration
+	debounce time.Duration
+}
+
+func (flags watchFlags) validate() errors.GitGhostError {
+	if err := flags.pushFlags.validate(); err != nil {
+		return err
+	}
+	if flags.interval <= 0 {
+		return errors.New("interval must be positive")
+	}
+	if flags.debounce < 0 {
+		return errors.New("debounce must not be negative")
+	}
+	return nil
+}
+
+func NewWatchCommand() *cobra.Command {
+	var (
+		flags watchFlags
+	)
+	command := &cobra.Command{
+		Use:         "watch [from-hash(default=HEAD)]",
+		Annotations: writesGhostRepoAnnotations,
+		Short:       "push diff to your ghost repo every time your working dir changes, moving a tag to it",
+		Long:        "push diff from [from-hash] to current state of your working dir to your ghost repo, and push it again every time the working dir changes and stays unchanged for --debounce until interrupted.  a tag (default to live/<user>) is moved to the latest one, so that others can pull it by 'git-ghost pull --latest <tag>'.  the working dir is polled by --interval.",
+		Args:        cobra.RangeArgs(0, 1),
+		Run:         runWatchCommand(&flags),
+	}
+	addDiffFlags(command, &flags.pushFlags)
+	command.Flags().StringVar(&flags.tag, "tag", "", "tag moved to the latest pushed diff (default to live/<user>, where <user> is the local part of user.email)")
+	command.Flags().DurationVar(&flags.interval, "interval", time.Second, "how often the working dir is checked for changes")
+	command.Flags().DurationVar(&flags.debounce, "debounce", 2*time.Second, "how long the working dir has to stay unchanged before a change is pushed, which avoids pushing every save of rapid saves")
+	command.Flags().BoolVar(&globalOpts.noSecretScan, "no-secret-scan", false, "push ghosts without scanning lines added by them for secrets by the default patterns and --secret-patterns, e.g. for false positives.")
+	return command
+}
+
+var regexpInvalidTagChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// defaultWatchTag returns "live/<user>" where <user> is the local part of user.email of srcDir
+func defaultWatchTag(srcDir string) (string, errors.GitGhostError) {
+	tag, err := userTag(srcDir, "live")
+	if err != nil {
+		return "", errors.Errorf("%s. please specify --tag", err)
+	}
+	return tag, nil
+}
+
+// userTag returns "<group>/<user>" where <user> is the local part of user.email of srcDir
+func userTag(srcDir, group string) (string, errors.GitGhostError) {
+	_, email, err := git.GetUserConfig(srcDir)
+	if err != nil {
+		return "", err
+	}
+	user := strings.Trim(regexpInvalidTagChars.ReplaceAllString(strings.SplitN(email, "@", 2)[0], "-"), "-._")
+	if user == "" {
+		return "", errors.Errorf("user.email %s can't be a tag name", email)
+	}
+	return group + "/" + user, nil
+}
+
+func runWatchCommand(flags *watchFlags) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if err := flags.validate(); err != nil {
+			exitWithConfigError(err)
+		}
+		if err := flags.validateBaseRefs(args); err != nil {
+			exitWithConfigError(err)
+		}
+		tag := flags.tag
+		if tag == "" {
+			var err errors.GitGhostError
+			tag, err = defaultWatchTag(globalOpts.srcDir)
+			if err != nil {
+				exitWithConfigError(err)
+			}
+		}
+		arg := newPushDiffArg(args)
+		if err := flags.resolveBaseRefs(&arg); err != nil {
+			exitWithError(err)
+		}
+		if err := arg.validate(); err != nil {
+			exitWithConfigError(err)
+		}
+		if err := validateNotProtected(globalOpts.ghostRepo, globalOpts.ghostPrefix); err != nil {
+			exitWithError(err)
+		}
+
+		stop := make(chan struct{})
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-signals
+			close(stop)
+		}()
+
+		fmt.Fprintf(os.Stderr, "watching %s. others can pull the latest state by 'git-ghost pull --latest %s'\n", globalOpts.srcDir, tag)
+		options := ghost.WatchOptions{
+			WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
+			DiffBranchSpec: &types.DiffBranchSpec{
+				Prefix:                 globalOpts.ghostPrefix,
+				CommittishFrom:         arg.diffFrom,
+				IncludedFilepaths:      flags.includedFilepaths,
+				FollowSymlinks:         flags.followSymlinks,
+				SkipGenerated:          flags.skipGenerated,
+				IgnoreModeChanges:      flags.ignoreModeChanges,
+				Unified:                flags.unifiedContext(),
+				IgnoreLines:            flags.ignoreLines,
+				KeepEmptyDirs:          flags.keepEmptyDirs,
+				NoUntracked:            flags.noUntracked,
+				SkipNonIndexedBinaries: !flags.includeBinaries,
+				SkipNonIndexedText:     !flags.includeText,
+				BinaryAttachments:      flags.binaryAttachments,
+			},
+			Tag:      tag,
+			Interval: flags.interval,
+			Debounce: flags.debounce,
+			OnPush: func(branch *types.DiffBranch) {
+				fmt.Printf("%s %s\n", branch.CommitHashFrom, branch.DiffHash)
+			},
+			Stop: stop,
+		}
+		err := ghost.Watch(options)
+		if err != nil {
+			exitWithError(err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(NewWatchPullCommand())
+}
+
+type watchPullFlags struct {
+	autoStash bool
+	force     bool
+	strict    bool
+	interval  time.Duration
+}
+
+func (flags watchPullFlags) validate() errors.GitGhostError {
+	if flags.interval <= 0 {
+		return errors.New("interval must be positive")
+	}
+	return nil
+}
+
+func NewWatchPullCommand() *cobra.Command {
+	var (
+		flags watchPullFlags
+	)
+	command := &cobra.Command{
+		Use:   "watch-pull [tag]",
+		Short: "apply diff of a tag to working dir, and replace it every time the tag is moved, e.g. by 'git-ghost watch'",
+		Long:  "apply diff which [tag] points to to working dir, and replace it with a new one every time the tag is moved until interrupted.  every version is applied on the clean base by reverting the previous one, and applied versions are printed.  if the previous one can't be reverted (e.g. its files are changed), applying is paused until working dir has no local changes.  the tag is polled by --interval.",
+		Args:  cobra.ExactArgs(1),
+		Run:   runWatchPullCommand(&flags),
+	}
+	command.Flags().BoolVar(&flags.autoStash, "autostash", false, "stash local changes while watching and restore them after that, which are refused otherwise")
+	command.Flags().BoolVarP(&flags.force, "force", "f", false, "abort 'git am' or 'git rebase' left in progress in working dir before applying, and apply ghosts refused by --strict")
+	command.Flags().BoolVar(&flags.strict, "strict", false, "refuse ghosts created in a repo which is neither working dir nor one of its remotes, which are only warned about by default")
+	command.Flags().DurationVar(&flags.interval, "interval", 2*time.Second, "how often the tag is checked for updates")
+	return command
+}
+
+func runWatchPullCommand(flags *watchPullFlags) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if err := flags.validate(); err != nil {
+			exitWithConfigError(err)
+		}
+
+		stop := make(chan struct{})
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-signals
+			close(stop)
+		}()
+
+		options := ghost.WatchPullOptions{
+			WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
+			ApplyOptions: types.ApplyOptions{
+				Force:            flags.force,
+				StrictSourceRepo: flags.strict,
+				Strip:            1,
+			},
+			Prefix:    globalOpts.ghostPrefix,
+			Tag:       args[0],
+			AutoStash: flags.autoStash,
+			Interval:  flags.interval,
+			OnApply: func(branch *types.DiffBranch) {
+				fmt.Printf("%s %s\n", branch.CommitHashFrom, branch.DiffHash)
+			},
+			Stop: stop,
+		}
+		err := ghost.WatchPull(options)
+		if err != nil {
+			exitWithError(err)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(NewWhichCommand())
+}
+
+type whichFlags struct {
+	output string
+}
+
+func NewWhichCommand() *cobra.Command {
+	var (
+		flags whichFlags
+	)
+	command := &cobra.Command{
+		Use:   "which [hash]",
+		Short: "describe where a ghost branch is stored and its metadata",
+		Long:  "describe a ghost branch whose diff hash (or the last hash of its line in 'list' output) is [hash]: its ref in ghost repo, type, base commit, size, checksum, format version and who pushed it when.  contents are not shown, see 'show' for them.",
+		Args:  cobra.ExactArgs(1),
+		Run:   runWhichCommand(&flags),
+	}
+	command.Flags().StringVarP(&flags.output, "output", "o", "", "Output format. One of: json")
+	return command
+}
+
+func (flags whichFlags) validate() errors.GitGhostError {
+	if flags.output != "" && flags.output != "json" {
+		return errors.New("output must be json if specified")
+	}
+	return nil
+}
+
+func runWhichCommand(flags *whichFlags) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if err := flags.validate(); err != nil {
+			exitWithConfigError(err)
+		}
+		if err := nonEmpty("hash", args[0]); err != nil {
+			exitWithConfigError(err)
+		}
+		options := ghost.WhichOptions{
+			WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
+			Prefix:         globalOpts.ghostPrefix,
+		}
+		res, err := ghost.Which(options, args[0])
+		if err != nil {
+			exitWithError(err)
+		}
+		if flags.output == "json" {
+			printWhichResultJSON(res)
+			return
+		}
+		fmt.Print(res.PrettyString())
+	}
+}
+
+type describedGhostJSON struct {
+	Branch        string  `json:"branch"`
+	Repo          string  `json:"repo"`
+	Ref           string  `json:"ref"`
+	Type          string  `json:"type"`
+	From          string  `json:"from"`
+	To            string  `json:"to,omitempty"`
+	Hash          string  `json:"hash,omitempty"`
+	File          string  `json:"file"`
+	Bundle        bool    `json:"bundle"`
+	Split         bool    `json:"split"`
+	Piped         bool    `json:"piped"`
+	Compression   string  `json:"compression"`
+	Attachments   bool    `json:"attachments"`
+	Incremental   bool    `json:"incremental"`
+	Size          int64   `json:"size"`
+	Checksum      string  `json:"checksum"`
+	Commit        string  `json:"commit"`
+	FormatVersion int     `json:"formatVersion"`
+	PushedBy      string  `json:"pushedBy"`
+	PushedAt      string  `json:"pushedAt"`
+	SourceRepo    string  `json:"sourceRepo,omitempty"`
+	CI            *ciJSON `json:"ci,omitempty"`
+	// Meta is metadata given by 'push --meta'
+	Meta map[string]string `json:"meta,omitempty"`
+}
+
+type ciJSON struct {
+	System string `json:"system"`
+	Commit string `json:"commit,omitempty"`
+	Branch string `json:"branch,omitempty"`
+	JobURL string `json:"jobURL,omitempty"`
+}
+
+func printWhichResultJSON(res *ghost.WhichResult) {
+	out := []describedGhostJSON{}
+	for _, d := range res.Descriptors {
+		var ci *ciJSON
+		if d.CI != nil {
+			ci = &ciJSON{System: d.CI.System, Commit: d.CI.Commit, Branch: d.CI.Branch, JobURL: d.CI.JobURL}
+		}
+		out = append(out, describedGhostJSON{
+			Branch:        d.Branch,
+			Repo:          res.Repo,
+			Ref:           d.Ref,
+			Type:          d.Type,
+			From:          d.From,
+			To:            d.To,
+			Hash:          d.Hash,
+			File:          d.File,
+			Bundle:        d.Bundle,
+			Split:         d.Split,
+			Piped:         d.Piped,
+			Compression:   d.Compression,
+			Attachments:   d.Attachments,
+			Incremental:   d.Incremental,
+			Size:          d.Size,
+			Checksum:      d.Checksum,
+			Commit:        d.Commit,
+			FormatVersion: d.FormatVersion,
+			PushedBy:      d.PushedBy,
+			PushedAt:      d.PushedAt,
+			SourceRepo:    d.SourceRepo,
+			CI:            ci,
+			Meta:          d.Metadata,
+		})
+	}
+	bytes, err := json.Marshal(out)
+	if err != nil {
+		exitWithError(errors.WithStack(err))
+	}
+	fmt.Println(string(bytes))
+}
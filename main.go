@@ -29,6 +29,6 @@ func init() {
 func main() {
 	// RootCmd prints errors if exists
 	if err := cmd.RootCmd.Execute(); err != nil {
-		os.Exit(-1)
+		os.Exit(cmd.ExitCode(err))
 	}
 }
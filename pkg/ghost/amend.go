@@ -0,0 +1,191 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"fmt"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// AmendOptions represents arg for Amend func
+type AmendOptions struct {
+	types.WorkingEnvSpec
+	Prefix string
+	// Target is a tag, a diff hash or a branch name of the local mod branch to amend
+	Target string
+	// IncludedFilepaths are untracked files included in the amended diff like push
+	IncludedFilepaths []string
+}
+
+// AmendResult contains the original and the amended ghost branches of Amend func
+type AmendResult struct {
+	Original *types.DiffBranch
+	Amended  *types.DiffBranch
+	// Tags are ones moved from the original ghost branch to the amended one
+	Tags Tags
+	// Deleted is true if the original ghost branch was deleted
+	Deleted bool
+}
+
+// branches returns ghost branches pushed or deleted by Amend
+func (result AmendResult) branches() []types.GhostBranch {
+	branches := []types.GhostBranch{}
+	if result.Amended != nil {
+		branches = append(branches, result.Amended)
+	}
+	if result.Deleted {
+		branches = append(branches, result.Original)
+	}
+	return branches
+}
+
+// Amend replaces a local mod branch with local modifications of the source directory on the same base
+//
+// Since a ghost branch is named by its diff hash, the amended diff is pushed as a new local mod branch,
+// and then the tags of the original one are moved to it and the original one is deleted in a single push.
+// It fails with errors.CategoryConflict unless HEAD of the source directory is the base of the original one.
+func Amend(options AmendOptions) (*AmendResult, errors.GitGhostError) {
+	log.WithFields(util.ToFields(options)).Debug("amend command with")
+
+	var result AmendResult
+	err := amend(options, &result)
+	writeAuditRecord("amend", options.WorkingEnvSpec, result.branches(), err)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func amend(options AmendOptions, result *AmendResult) errors.GitGhostError {
+	tagOptions := TagOptions{WorkingEnvSpec: options.WorkingEnvSpec, Prefix: options.Prefix}
+	original, err := findAmendTarget(tagOptions, options.Target)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	result.Original = original
+	head, err := git.ResolveCommittish(options.SrcDir, "HEAD")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if head != original.CommitHashFrom {
+		return errors.WithCategory(
+			errors.Errorf("%s is based on %s but HEAD is %s, so it is not amended (checkout its base or use rebase)", original.BranchName(), original.CommitHashFrom, head),
+			errors.CategoryConflict,
+		)
+	}
+
+	we, err := options.WorkingEnvSpec.Initialize()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer util.LogDeferredGitGhostError(we.Clean)
+	err = git.FetchBranches(we.GhostDir, original.BranchName())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	// the amended ghost keeps metadata of the original one
+	provenance, err := types.GetProvenance(we.GhostDir, git.ORIGIN+"/"+original.BranchName(), original)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	types.SetMetadata(provenance.Metadata)
+
+	pushed, _, err := pushGhostBranch(&types.DiffBranchSpec{
+		Prefix:            options.Prefix,
+		CommittishFrom:    original.CommitHashFrom,
+		IncludedFilepaths: options.IncludedFilepaths,
+	}, options.WorkingEnvSpec, false)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	amended, _ := pushed.(*types.DiffBranch)
+	if amended == nil {
+		return errors.Errorf("no local modifications on %s to amend %s with", head, original.BranchName())
+	}
+	result.Amended = amended
+	if amended.BranchName() == original.BranchName() {
+		log.WithFields(log.Fields{
+			"branch":    original.BranchName(),
+			"ghostRepo": we.GhostRepo,
+		}).Info("skipped amending branch unchanged")
+		return nil
+	}
+
+	tags, err := ListTags(tagOptions, original.BranchName())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = git.FetchBranches(we.GhostDir, amended.BranchName())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	source := fmt.Sprintf("refs/remotes/%s/%s", git.ORIGIN, amended.BranchName())
+	refspecs := []string{}
+	for _, tag := range tags {
+		refspecs = append(refspecs, fmt.Sprintf("+%s:%s", source, tagRef(options.Prefix, tag.Name)))
+	}
+	refspecs = append(refspecs, ":refs/heads/"+original.BranchName())
+	log.WithFields(log.Fields{
+		"branch":    original.BranchName(),
+		"amended":   amended.BranchName(),
+		"tags":      len(tags),
+		"ghostRepo": we.GhostRepo,
+	}).Info("moving tags and deleting the original branch")
+	err = git.Push(we.GhostDir, refspecs...)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for i := range tags {
+		tags[i].Branch = amended
+	}
+	result.Tags = tags
+	result.Deleted = true
+	return nil
+}
+
+// findAmendTarget finds a local mod branch by a tag name, or by its diff hash or branch name if no tag has the name
+func findAmendTarget(options TagOptions, target string) (*types.DiffBranch, errors.GitGhostError) {
+	tags, err := ListTags(options, "")
+	if err != nil {
+		return nil, err
+	}
+	var found types.GhostBranch
+	for _, tag := range tags {
+		if tag.Name != target {
+			continue
+		}
+		if tag.Branch == nil {
+			return nil, errors.WithCategory(errors.Errorf("ghost branch of tag %s was deleted", target), errors.CategoryNotFound)
+		}
+		found = tag.Branch
+	}
+	if found == nil {
+		found, _, err = findGhostBranch(options.GhostRepo, options.Prefix, target)
+		if err != nil {
+			return nil, err
+		}
+	}
+	branch, ok := found.(*types.DiffBranch)
+	if !ok {
+		return nil, errors.WithCategory(errors.Errorf("%s is not a local mod branch, which is the only type amended", found.BranchName()), errors.CategoryConfig)
+	}
+	return branch, nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// AuditLogSyslog is a value of SetAuditLog to send audit records to the local syslog
+const AuditLogSyslog = "syslog"
+
+var auditLog string
+
+// SetAuditLog sets where audit records of push, pull, delete, rebase, copy and oci push are written (empty writes nothing)
+//
+// It is a path of a file which records are appended to as JSON lines, or AuditLogSyslog.
+func SetAuditLog(sink string) {
+	auditLog = sink
+}
+
+// AuditRecord is a record of an operation on ghosts written to the audit log
+type AuditRecord struct {
+	// Time is when the operation finished in RFC 3339
+	Time string `json:"time"`
+	// Operation is "push", "pull", "delete", "rebase", "copy" or "oci push"
+	Operation string `json:"operation"`
+	// User is an identity of ghost commits in the form of "Name <email>"
+	User string `json:"user"`
+	// OSUser is a name of the user running git-ghost
+	OSUser    string `json:"osUser,omitempty"`
+	SrcDir    string `json:"srcDir"`
+	GhostRepo string `json:"ghostRepo"`
+	// Branches are ghost branches pushed, applied or deleted, which may be partial on failure
+	Branches []string `json:"branches"`
+	// Result is "success" or "failure"
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// writeAuditRecord writes a record of an operation to the audit log if it is set
+//
+// Failing to write never fails the operation, but is logged as an error together with the record so that it is never dropped silently.
+func writeAuditRecord(operation string, spec types.WorkingEnvSpec, branches []types.GhostBranch, err errors.GitGhostError) {
+	if auditLog == "" {
+		return
+	}
+	record := AuditRecord{
+		Time:      time.Now().Format(time.RFC3339),
+		Operation: operation,
+		User:      fmt.Sprintf("%s <%s>", spec.GhostUserName, spec.GhostUserEmail),
+		SrcDir:    spec.SrcDir,
+		GhostRepo: util.RedactURLPassword(spec.GhostRepo),
+		Branches:  []string{},
+		Result:    "success",
+	}
+	if u, uerr := user.Current(); uerr == nil {
+		record.OSUser = u.Username
+	}
+	for _, branch := range branches {
+		record.Branches = append(record.Branches, branch.BranchName())
+	}
+	record.Branches = util.UniqueStringSlice(record.Branches)
+	if err != nil {
+		record.Result = "failure"
+		record.Error = util.RedactURLPassword(err.Error())
+	}
+	line, jerr := json.Marshal(record)
+	if jerr != nil {
+		log.Errorf("failed to encode audit record: %s", jerr)
+		return
+	}
+	werr := appendAuditLine(line)
+	if werr != nil {
+		log.WithFields(log.Fields{
+			"auditLog": auditLog,
+			"record":   string(line),
+		}).Errorf("failed to write audit record: %s", werr)
+	}
+}
+
+func appendAuditLine(line []byte) errors.GitGhostError {
+	if auditLog == AuditLogSyslog {
+		w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_USER, "git-ghost")
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		defer util.LogDeferredError(w.Close)
+		return errors.WithStack(w.Info(string(line)))
+	}
+	f, err := os.OpenFile(auditLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	// a single write of a whole line keeps records of concurrent git-ghost from interleaving
+	_, err = f.Write(append(line, '\n'))
+	if err != nil {
+		util.LogDeferredError(f.Close)
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(f.Close())
+}
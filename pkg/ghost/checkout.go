@@ -0,0 +1,202 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CheckoutOptions represents arg for Checkout func
+type CheckoutOptions struct {
+	// WorkingEnvSpec has the directory which the source repo is cloned into as SrcDir, which must be missing or empty
+	types.WorkingEnvSpec
+	types.ApplyOptions
+	Prefix string
+	// SourceRepo is a repo cloned, which defaults to the source repo recorded in the ghost
+	SourceRepo string
+}
+
+// CheckoutResult represents a state of a directory which a ghost is checked out into
+type CheckoutResult struct {
+	Dir        string
+	SourceRepo string
+	Branch     types.GhostBranch
+	// Base is a commit which the ghost is applied onto
+	Base string
+	// Head is a commit which HEAD points to after applying the ghost
+	Head string
+}
+
+// Checkout clones a source repo into a new directory, checks out the base of a ghost branch found by its diff hash,
+// its local base commit hash or its branch name detaching HEAD, and applies the ghost branch on it
+//
+// The directory is removed if any of them fails, so that it is never left half-done.
+func Checkout(options CheckoutOptions, hash string) (*CheckoutResult, errors.GitGhostError) {
+	log.WithFields(util.ToFields(options)).Debug("checkout command with")
+	dir, absErr := filepath.Abs(options.SrcDir)
+	if absErr != nil {
+		return nil, errors.WithStack(absErr)
+	}
+	existed, err := validateCheckoutDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	branch, _, err := findGhostBranch(options.GhostRepo, options.Prefix, hash)
+	if err != nil {
+		return nil, err
+	}
+	sourceRepo := options.SourceRepo
+	if sourceRepo == "" {
+		sourceRepo, err = recordedSourceRepo(options.WorkingEnvSpec, branch)
+		if err != nil {
+			return nil, err
+		}
+	}
+	res, err := checkout(options, dir, sourceRepo, branch)
+	if err != nil {
+		removeCheckoutDir(dir, existed)
+		return nil, err
+	}
+	return res, nil
+}
+
+func checkout(options CheckoutOptions, dir, sourceRepo string, branch types.GhostBranch) (*CheckoutResult, errors.GitGhostError) {
+	log.WithFields(log.Fields{
+		"sourceRepo": util.RedactURLPassword(sourceRepo),
+		"dir":        dir,
+	}).Info("cloning source repo")
+	err := git.CloneNoCheckout(dir, sourceRepo)
+	if err != nil {
+		return nil, err
+	}
+	pullOptions := PullOptions{
+		WorkingEnvSpec: options.WorkingEnvSpec,
+		ApplyOptions:   options.ApplyOptions,
+	}
+	pullOptions.SrcDir = dir
+	var base string
+	switch b := branch.(type) {
+	case *types.CommitsBranch:
+		base = b.CommitHashFrom
+		pullOptions.CommitsBranchSpec = &types.CommitsBranchSpec{Prefix: b.Prefix, CommittishFrom: b.CommitHashFrom, CommittishTo: b.CommitHashTo}
+	case *types.DiffBranch:
+		base = b.CommitHashFrom
+		pullOptions.PullableDiffBranchSpec = &types.PullableDiffBranchSpec{Prefix: b.Prefix, CommittishFrom: b.CommitHashFrom, DiffHash: b.DiffHash}
+	}
+	err = checkoutBase(dir, base)
+	if err != nil {
+		return nil, err
+	}
+	err = Pull(pullOptions)
+	if err != nil {
+		return nil, err
+	}
+	head, err := git.ResolveCommittish(dir, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	return &CheckoutResult{
+		Dir:        dir,
+		SourceRepo: sourceRepo,
+		Branch:     branch,
+		Base:       base,
+		Head:       head,
+	}, nil
+}
+
+// checkoutBase checks out base in dir, fetching it from origin if it is not in the cloned branches (e.g. a commit only pushed to a pull request)
+func checkoutBase(dir, base string) errors.GitGhostError {
+	err := git.ValidateCommittish(dir, base)
+	if errors.CategoryOf(err) == errors.CategoryNotFound {
+		log.WithFields(log.Fields{
+			"base": base,
+		}).Info("fetching base commit which is not in the cloned branches")
+		err = git.FetchRefs(dir, base)
+		if err != nil {
+			return errors.WithCategory(errors.Errorf("base commit %s of the ghost is not found in the source repo: %s", base, err), errors.CategoryNotFound)
+		}
+	} else if err != nil {
+		return err
+	}
+	return git.CheckoutDetached(dir, base)
+}
+
+// validateCheckoutDir checks dir is missing or an empty directory, and returns whether it exists
+func validateCheckoutDir(dir string) (bool, errors.GitGhostError) {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.WithCategory(errors.Errorf("%s is not a directory which the source repo can be cloned into: %s", dir, err), errors.CategoryConfig)
+	}
+	if len(files) > 0 {
+		return false, errors.WithCategory(errors.Errorf("%s already exists and is not empty", dir), errors.CategoryConfig)
+	}
+	return true, nil
+}
+
+// removeCheckoutDir removes what checkout created in dir, leaving dir itself empty if it existed beforehand
+func removeCheckoutDir(dir string, existed bool) {
+	paths := []string{dir}
+	if existed {
+		files, _ := ioutil.ReadDir(dir)
+		paths = paths[:0]
+		for _, f := range files {
+			paths = append(paths, filepath.Join(dir, f.Name()))
+		}
+	}
+	for _, path := range paths {
+		if err := os.RemoveAll(path); err != nil {
+			log.WithFields(log.Fields{
+				"path": path,
+			}).Errorf("failed to remove a checkout left half-done: %s", err)
+		}
+	}
+}
+
+// recordedSourceRepo returns a source repo recorded in branch, fetching it into a temporary directory
+func recordedSourceRepo(spec types.WorkingEnvSpec, branch types.GhostBranch) (string, errors.GitGhostError) {
+	ghostDir, err := ioutil.TempDir(spec.GhostWorkingDir, "git-ghost-")
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer util.LogDeferredError(func() error { return os.RemoveAll(ghostDir) })
+	ggerr := git.InitializeEmptyGitDir(ghostDir, spec.GhostRepo)
+	if ggerr != nil {
+		return "", ggerr
+	}
+	ggerr = git.FetchBranches(ghostDir, branch.BranchName())
+	if ggerr != nil {
+		return "", ggerr
+	}
+	provenance, ggerr := types.GetProvenance(ghostDir, git.ORIGIN+"/"+branch.BranchName(), branch)
+	if ggerr != nil {
+		return "", ggerr
+	}
+	if provenance.SourceRepo == "" {
+		return "", errors.WithCategory(errors.Errorf("source repo is not recorded in %s. please specify it by --source-repo", branch.BranchName()), errors.CategoryConfig)
+	}
+	return provenance.SourceRepo, nil
+}
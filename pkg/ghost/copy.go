@@ -0,0 +1,165 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"fmt"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CopyOptions represents arg for Copy func
+type CopyOptions struct {
+	types.WorkingEnvSpec
+	Prefix string
+	// ToPrefix is a prefix of the copied branch, which defaults to Prefix
+	ToPrefix string
+	// ToRepo is a ghost repo which the ghost branch is copied into, which defaults to GhostRepo
+	ToRepo string
+	// Tag is a tag added to the copied branch under ToPrefix if not empty
+	Tag string
+}
+
+// CopyResult represents a copied ghost branch
+type CopyResult struct {
+	Source types.GhostBranch
+	Copy   types.GhostBranch
+	// Commit is a commit which both branches point to
+	Commit string
+	// Exists is true if the copied branch already pointed to Commit
+	Exists bool
+}
+
+// Copy writes a ghost branch found by its diff hash, its local base commit hash or its branch name under another prefix, another ghost repo or both
+//
+// The copied branch points to the same commit as the source one, so it is an identical ghost without recreating it.
+func Copy(options CopyOptions, hash string) (*CopyResult, errors.GitGhostError) {
+	log.WithFields(util.ToFields(options)).Debug("copy command with")
+	res, err := copyGhostBranch(options, hash)
+	var branches []types.GhostBranch
+	if res != nil {
+		branches = []types.GhostBranch{res.Source, res.Copy}
+	}
+	writeAuditRecord("copy", options.WorkingEnvSpec, branches, err)
+	return res, err
+}
+
+// copyGhostBranch returns a result once the branches are known, even if copying fails after that
+func copyGhostBranch(options CopyOptions, hash string) (*CopyResult, errors.GitGhostError) {
+	toPrefix := options.ToPrefix
+	if toPrefix == "" {
+		toPrefix = options.Prefix
+	}
+	toRepo := options.ToRepo
+	if toRepo == "" {
+		toRepo = options.GhostRepo
+	}
+	if options.Tag != "" {
+		err := validateTagName(options.Tag)
+		if err != nil {
+			return nil, err
+		}
+	} else if toPrefix == options.Prefix && toRepo == options.GhostRepo {
+		return nil, errors.WithCategory(errors.New("nothing to copy to: specify another prefix, another ghost repo or a tag"), errors.CategoryConfig)
+	}
+
+	found, commit, err := findGhostBranch(options.GhostRepo, options.Prefix, hash)
+	if err != nil {
+		return nil, err
+	}
+	var copied types.GhostBranch
+	switch b := found.(type) {
+	case *types.CommitsBranch:
+		c := *b
+		c.Prefix = toPrefix
+		copied = &c
+	case *types.DiffBranch:
+		d := *b
+		d.Prefix = toPrefix
+		copied = &d
+	}
+	if copied == nil || types.CreateGhostBranchByName(copied.BranchName()) == nil {
+		return nil, errors.WithCategory(errors.Errorf("invalid prefix to copy to: %s", toPrefix), errors.CategoryConfig)
+	}
+	res := &CopyResult{Source: found, Copy: copied, Commit: commit}
+
+	branchRef := fmt.Sprintf("refs/heads/%s", copied.BranchName())
+	refs := []string{branchRef}
+	if options.Tag != "" {
+		refs = append(refs, tagRef(toPrefix, options.Tag))
+	}
+	existing, err := git.ListRemoteRefHashes(toRepo, refs...)
+	if err != nil {
+		return res, err
+	}
+	if c, ok := existing[branchRef]; ok {
+		if c != commit {
+			return res, errors.WithCategory(errors.Errorf("%s already exists with another commit %s", copied.BranchName(), c), errors.CategoryExists)
+		}
+		res.Exists = true
+	}
+	if options.Tag != "" {
+		if _, ok := existing[tagRef(toPrefix, options.Tag)]; ok {
+			return res, errors.Errorf("tag %s already exists", options.Tag)
+		}
+	}
+
+	var refspecs []string
+	if !res.Exists {
+		refspecs = append(refspecs, fmt.Sprintf("%s:%s", commit, branchRef))
+	}
+	if options.Tag != "" {
+		refspecs = append(refspecs, fmt.Sprintf("%s:%s", commit, tagRef(toPrefix, options.Tag)))
+	}
+	if len(refspecs) == 0 {
+		log.Infof("%s already exists as a copy of %s", copied.BranchName(), found.BranchName())
+		return res, nil
+	}
+
+	we, err := options.WorkingEnvSpec.Initialize()
+	if err != nil {
+		return res, err
+	}
+	defer util.LogDeferredGitGhostError(we.Clean)
+	err = git.FetchBranches(we.GhostDir, found.BranchName())
+	if err != nil {
+		return res, err
+	}
+	// pushing a commit which the ghost repo already has only updates refs
+	err = git.PushTo(we.GhostDir, toRepo, refspecs...)
+	if err != nil {
+		return res, err
+	}
+	return res, verifyCopiedRefs(toRepo, commit, refs)
+}
+
+// verifyCopiedRefs checks refs in repo point to commit, whose hash covers every file of the ghost
+func verifyCopiedRefs(repo, commit string, refs []string) errors.GitGhostError {
+	copied, err := git.ListRemoteRefHashes(repo, refs...)
+	if err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		if copied[ref] != commit {
+			return errors.Errorf("%s in %s doesn't match the source commit %s after copying (got: %q)", ref, util.RedactURLPassword(repo), commit, copied[ref])
+		}
+	}
+	return nil
+}
@@ -17,12 +17,15 @@ package ghost
 import (
 	"bytes"
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
 	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
 	"github.com/pfnet-research/git-ghost/pkg/util"
 	"github.com/pfnet-research/git-ghost/pkg/util/errors"
 
+	multierror "github.com/hashicorp/go-multierror"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -41,29 +44,55 @@ type DeleteResult struct {
 }
 
 // Delete deletes ghost branches from ghost repo and returns deleted branches
-func Delete(options DeleteOptions) (*DeleteResult, errors.GitGhostError) {
+func Delete(options DeleteOptions) (_ *DeleteResult, ggerr errors.GitGhostError) {
 	log.WithFields(util.ToFields(options)).Debug("delete command with")
 
+	deleted := []types.GhostBranch{}
+	if !options.Dryrun {
+		defer func() { writeAuditRecord("delete", options.WorkingEnvSpec, deleted, ggerr) }()
+	}
+
 	res := DeleteResult{}
 
 	if options.ListCommitsBranchSpec != nil {
 		resolved := options.ListCommitsBranchSpec.Resolve(options.SrcDir)
-		branches, err := resolved.GetBranches(options.GhostRepo)
-		if err != nil {
-			return nil, errors.WithStack(err)
+		hashTo, err := resolveHashToPrefix(options.GhostRepo, resolved.Prefix, resolved.HashFrom, resolved.HashTo, true)
+		branches := types.CommitsBranches{}
+		if err != nil && errors.CategoryOf(err) != errors.CategoryNotFound {
+			return nil, err
+		}
+		if err == nil {
+			resolved.HashTo = hashTo
+			branches, err = resolved.GetBranches(options.GhostRepo)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
 		}
 		res.CommitsBranches = &branches
 	}
 
 	if options.ListDiffBranchSpec != nil {
 		resolved := options.ListDiffBranchSpec.Resolve(options.SrcDir)
-		branches, err := resolved.GetBranches(options.GhostRepo)
-		if err != nil {
-			return nil, errors.WithStack(err)
+		hashTo, err := resolveHashToPrefix(options.GhostRepo, resolved.Prefix, resolved.HashFrom, resolved.HashTo, false)
+		branches := types.DiffBranches{}
+		if err != nil && errors.CategoryOf(err) != errors.CategoryNotFound {
+			return nil, err
+		}
+		if err == nil {
+			resolved.HashTo = hashTo
+			branches, err = resolved.GetBranches(options.GhostRepo)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
 		}
 		res.DiffBranches = &branches
 	}
 
+	// a prefix not found for one type is checked here, since the ghost may be of the other type
+	if err := checkGhostsFound(options, res); err != nil {
+		return nil, err
+	}
+
 	workingEnv, err := options.WorkingEnvSpec.Initialize()
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -85,7 +114,11 @@ func Delete(options DeleteOptions) (*DeleteResult, errors.GitGhostError) {
 			return nil
 		}
 		err := git.DeleteRemoteBranches(workingEnv.GhostDir, branchNames...)
-		return errors.WithStack(err)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		deleted = append(deleted, branches...)
+		return nil
 	}
 
 	if res.CommitsBranches != nil {
@@ -105,6 +138,133 @@ func Delete(options DeleteOptions) (*DeleteResult, errors.GitGhostError) {
 	return &res, nil
 }
 
+// checkGhostsFound returns an error of CategoryNotFound if a ghost is specified by its hash but no ghost branch of it is found
+//
+// Deleting ghost branches without a hash, e.g. all of a base commit, doesn't fail even if nothing is found.
+func checkGhostsFound(options DeleteOptions, res DeleteResult) errors.GitGhostError {
+	hashTo := ""
+	if options.ListCommitsBranchSpec != nil && options.ListCommitsBranchSpec.HashTo != "" {
+		hashTo = options.ListCommitsBranchSpec.HashTo
+	}
+	if options.ListDiffBranchSpec != nil && options.ListDiffBranchSpec.HashTo != "" {
+		hashTo = options.ListDiffBranchSpec.HashTo
+	}
+	if hashTo == "" {
+		return nil
+	}
+	if res.CommitsBranches != nil && len(*res.CommitsBranches) > 0 {
+		return nil
+	}
+	if res.DiffBranches != nil && len(*res.DiffBranches) > 0 {
+		return nil
+	}
+	return errors.WithCategory(errors.Errorf("no ghost branch is found for %s", hashTo), errors.CategoryNotFound)
+}
+
+// TaggedGhost is a ghost branch to be deleted together with its tags
+type TaggedGhost struct {
+	// Branch is nil if the ghost branch was already deleted, when only the tags are deleted
+	Branch types.GhostBranch
+	Tags   []string
+	// Err is an error on deleting the ghost, or nil if it is deleted
+	Err errors.GitGhostError
+}
+
+// FindTaggedGhosts returns ghost branches of tags matching a glob pattern, sorted by their branch names
+func FindTaggedGhosts(options TagOptions, pattern string) ([]TaggedGhost, errors.GitGhostError) {
+	tags, err := ListTags(options, "")
+	if err != nil {
+		return nil, err
+	}
+	tags, err = tags.Match(pattern)
+	if err != nil {
+		return nil, err
+	}
+	ghosts := []TaggedGhost{}
+	indices := map[string]int{}
+	for _, tag := range tags {
+		if tag.Branch != nil {
+			if i, ok := indices[tag.Branch.BranchName()]; ok {
+				ghosts[i].Tags = append(ghosts[i].Tags, tag.Name)
+				continue
+			}
+			indices[tag.Branch.BranchName()] = len(ghosts)
+		}
+		ghosts = append(ghosts, TaggedGhost{Branch: tag.Branch, Tags: []string{tag.Name}})
+	}
+	sort.SliceStable(ghosts, func(i, j int) bool { return ghosts[i].name() < ghosts[j].name() })
+	return ghosts, nil
+}
+
+func (g TaggedGhost) name() string {
+	if g.Branch == nil {
+		return ""
+	}
+	return g.Branch.BranchName()
+}
+
+// DeleteTaggedGhosts deletes ghost branches found by FindTaggedGhosts and their tags one by one
+//
+// A failure on a ghost doesn't stop deleting the others. It is recorded in Err of the returned ghost,
+// and the returned error aggregates all of them.
+func DeleteTaggedGhosts(options TagOptions, ghosts []TaggedGhost) (_ []TaggedGhost, ggerr errors.GitGhostError) {
+	log.WithFields(util.ToFields(options)).Debug("delete tagged ghosts with")
+
+	if len(ghosts) == 0 {
+		return ghosts, nil
+	}
+	deletedBranches := []types.GhostBranch{}
+	defer func() { writeAuditRecord("delete", options.WorkingEnvSpec, deletedBranches, ggerr) }()
+	we, ggerr := options.WorkingEnvSpec.Initialize()
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	defer util.LogDeferredGitGhostError(we.Clean)
+
+	deleted := make([]TaggedGhost, 0, len(ghosts))
+	var errs error
+	for _, g := range ghosts {
+		refspecs := []string{}
+		if g.Branch != nil {
+			refspecs = append(refspecs, ":refs/heads/"+g.Branch.BranchName())
+		}
+		for _, tag := range g.Tags {
+			refspecs = append(refspecs, ":"+tagRef(options.Prefix, tag))
+		}
+		g.Err = git.Push(we.GhostDir, refspecs...)
+		if g.Err != nil {
+			errs = multierror.Append(errs, errors.Errorf("failed to delete %s: %s", g.String(), g.Err))
+		} else if g.Branch != nil {
+			deletedBranches = append(deletedBranches, g.Branch)
+		}
+		deleted = append(deleted, g)
+	}
+	if errs != nil {
+		return deleted, errors.WithStack(errs)
+	}
+	return deleted, nil
+}
+
+// String returns the branch name with its tags
+func (g TaggedGhost) String() string {
+	branch := "(deleted)"
+	if g.Branch != nil {
+		branch = g.Branch.BranchName()
+	}
+	return fmt.Sprintf("%s (%s)", branch, strings.Join(g.Tags, ", "))
+}
+
+// resolveHashToPrefix resolves an abbreviated hash of ghost branches to delete so that a prefix never deletes several ghosts
+func resolveHashToPrefix(repo, prefix, hashFrom, hashTo string, commits bool) (string, errors.GitGhostError) {
+	if hashTo == "" {
+		return "", nil
+	}
+	if hashFrom == "" {
+		hashFrom = "*"
+	}
+	return types.ResolveGhostHashPrefix(repo, prefix, hashFrom, hashTo, commits)
+}
+
 // PrettyString pretty prints ListResult
 func (res *DeleteResult) PrettyString() string {
 	// TODO: Make it prettier
@@ -0,0 +1,85 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"io"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DiffLocalOptions represents arg for DiffLocal func
+type DiffLocalOptions struct {
+	types.WorkingEnvSpec
+	*types.PullableDiffBranchSpec
+	// IncludedFilepaths are non-indexed files in the source directory compared with the ghost
+	IncludedFilepaths []string
+	// Writer is where the diff is written to
+	Writer io.Writer
+}
+
+// DiffLocal writes a diff from the current working state of the source directory to the state which applying the ghost results in
+//
+// Neither the index nor the working tree of the source directory is modified.
+func DiffLocal(options DiffLocalOptions) errors.GitGhostError {
+	log.WithFields(util.ToFields(options)).Debug("diff-local command with")
+
+	if options.PullableDiffBranchSpec == nil {
+		log.WithFields(util.ToFields(options)).Warn("diff-local command has nothing to do with")
+		return nil
+	}
+
+	we, err := options.WorkingEnvSpec.Initialize()
+	if err != nil {
+		return err
+	}
+	defer util.LogDeferredGitGhostError(we.Clean)
+
+	branch, err := options.PullableDiffBranchSpec.PullBranch(*we)
+	if err != nil {
+		return err
+	}
+	diffBranch, ok := branch.(*types.DiffBranch)
+	if !ok {
+		return errors.Errorf("not a local mod branch: %s", branch.BranchName())
+	}
+	ghostTree, err := diffBranch.WriteResultTree(*we)
+	if err != nil {
+		return err
+	}
+
+	includedFilepaths := []string{}
+	if len(options.IncludedFilepaths) > 0 {
+		resolved, err := types.DiffBranchSpec{
+			CommittishFrom:    diffBranch.CommitHashFrom,
+			IncludedFilepaths: options.IncludedFilepaths,
+		}.Resolve(we.SrcDir)
+		if err != nil {
+			return err
+		}
+		includedFilepaths = resolved.IncludedFilepaths
+	}
+	localTree, err := git.WriteWorkingTree(we.SrcDir, includedFilepaths)
+	if err != nil {
+		return err
+	}
+
+	return git.WriteTreeDiff(we.SrcDir, localTree, ghostTree, options.Writer)
+}
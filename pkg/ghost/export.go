@@ -0,0 +1,55 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ExportPatchesOptions represents arg for ExportPatches func
+type ExportPatchesOptions struct {
+	types.WorkingEnvSpec
+	*types.CommitsBranchSpec
+	// OutputDir is a directory to write the patches into. It must be empty or not exist.
+	OutputDir string
+}
+
+// ExportPatches writes commits of a local base branch into options.OutputDir as 'git format-patch' does and returns paths of the patches
+func ExportPatches(options ExportPatchesOptions) ([]string, errors.GitGhostError) {
+	log.WithFields(util.ToFields(options)).Debug("export-patches command with")
+
+	if options.CommitsBranchSpec == nil {
+		return nil, errors.New("commits to export are not specified")
+	}
+	err := prepareOutputDir(options.OutputDir)
+	if err != nil {
+		return nil, err
+	}
+	we, err := options.WorkingEnvSpec.Initialize()
+	if err != nil {
+		return nil, err
+	}
+	defer util.LogDeferredGitGhostError(we.Clean)
+	branch, err := options.CommitsBranchSpec.PullBranch(*we)
+	if err != nil {
+		return nil, err
+	}
+	commitsBranch, _ := branch.(*types.CommitsBranch)
+	return types.ExportPatches(*we, commitsBranch, options.OutputDir)
+}
@@ -0,0 +1,64 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// WalkFilesOptions represents arg for WalkFiles func
+type WalkFilesOptions struct {
+	types.WorkingEnvSpec
+	// Either of CommitsBranchSpec or PullableDiffBranchSpec must be set
+	*types.CommitsBranchSpec
+	*types.PullableDiffBranchSpec
+}
+
+// WalkFiles calls f for each file touched by a ghost branch with its content after applying it, e.g. to build a review tool
+//
+// The working tree, the index and refs of the source repository are not modified, while objects of the applied ghost
+// (blobs and trees, or commits of a bundle) are added into its object database as git apply --cached and git fetch do.
+// The ghost repository is not modified. The temporary working env is removed when WalkFiles returns,
+// either after all files are walked or when f returns an error to stop walking.
+func WalkFiles(options WalkFilesOptions, f func(types.GhostFile) errors.GitGhostError) errors.GitGhostError {
+	log.WithFields(util.ToFields(options)).Debug("walk files with")
+
+	var branchSpec types.PullableGhostBranchSpec
+	switch {
+	case options.CommitsBranchSpec != nil && options.PullableDiffBranchSpec != nil:
+		return errors.New("only one of commits or diff can be walked")
+	case options.CommitsBranchSpec != nil:
+		branchSpec = options.CommitsBranchSpec
+	case options.PullableDiffBranchSpec != nil:
+		branchSpec = options.PullableDiffBranchSpec
+	default:
+		return errors.New("ghost to walk is not specified")
+	}
+
+	we, err := options.WorkingEnvSpec.Initialize()
+	if err != nil {
+		return err
+	}
+	defer util.LogDeferredGitGhostError(we.Clean)
+	branch, err := branchSpec.PullBranch(*we)
+	if err != nil {
+		return err
+	}
+	return types.WalkFiles(*we, branch, f)
+}
@@ -0,0 +1,104 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost_test
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+	"github.com/pfnet-research/git-ghost/test/util"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWalkFiles(t *testing.T) {
+	srcDir, err := util.CreateGitWorkDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srcDir.Remove()
+	ghostRepo, err := util.CreateWorkDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ghostRepo.Remove()
+	_, _, err = ghostRepo.RunCommmand("git", "init", "-q", "--bare")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = srcDir.RunCommmand("bash", "-c", "echo a > modified.txt && echo b > deleted.txt && git add . && git commit -q -m 'initial commit' && echo modified > modified.txt && git rm -q deleted.txt && ln -s modified.txt link && git add link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	weSpec := types.WorkingEnvSpec{SrcDir: srcDir.Dir, GhostRepo: ghostRepo.Dir}
+	result, ggerr := ghost.Push(ghost.PushOptions{
+		WorkingEnvSpec: weSpec,
+		DiffBranchSpec: &types.DiffBranchSpec{Prefix: "ghost", CommittishFrom: "HEAD"},
+	})
+	if ggerr != nil {
+		t.Fatal(ggerr)
+	}
+	options := ghost.WalkFilesOptions{
+		WorkingEnvSpec: weSpec,
+		PullableDiffBranchSpec: &types.PullableDiffBranchSpec{
+			Prefix:         "ghost",
+			CommittishFrom: result.DiffBranch.CommitHashFrom,
+			DiffHash:       result.DiffBranch.DiffHash,
+		},
+	}
+	status, _, err := srcDir.RunCommmand("git", "status", "--porcelain")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	walked := []string{}
+	ggerr = ghost.WalkFiles(options, func(file types.GhostFile) errors.GitGhostError {
+		content, err := ioutil.ReadAll(file.Content)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		walked = append(walked, strings.Join([]string{file.Path, file.Mode, string(content)}, ":"))
+		return nil
+	})
+	if ggerr != nil {
+		t.Fatal(ggerr)
+	}
+	// in path order, with the target path of a symlink and nothing of a deleted file
+	assert.Equal(t, []string{"deleted.txt::", "link:120000:modified.txt", "modified.txt:100644:modified\n"}, walked)
+
+	// the working tree and the index are left as they are
+	stdout, _, err := srcDir.RunCommmand("git", "status", "--porcelain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, status, stdout)
+
+	// an error of the function stops walking and is returned as it is
+	stop := errors.New("stop")
+	walked = []string{}
+	ggerr = ghost.WalkFiles(options, func(file types.GhostFile) errors.GitGhostError {
+		walked = append(walked, file.Path)
+		if file.Deleted() {
+			return stop
+		}
+		return nil
+	})
+	assert.Equal(t, stop, ggerr)
+	assert.Equal(t, []string{"deleted.txt"}, walked)
+}
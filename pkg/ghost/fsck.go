@@ -0,0 +1,286 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Kinds of problems found by Fsck
+const (
+	// FsckInvalidRef is a ref in the namespace of git-ghost whose name is not of a ghost branch or a tag
+	FsckInvalidRef = "invalid-ref"
+	// FsckDanglingTag is a tag pointing to a ghost branch which doesn't exist
+	FsckDanglingTag = "dangling-tag"
+	// FsckUnreadable is a ghost branch which can't be fetched because of missing or corrupt objects
+	FsckUnreadable = "unreadable"
+	// FsckMissingFile is a ghost branch without its ghost file
+	FsckMissingFile = "missing-file"
+	// FsckCorruptFile is a ghost branch whose ghost file can't be reassembled from its parts
+	FsckCorruptFile = "corrupt-file"
+	// FsckObject is an error reported by 'git fsck' on a local ghost repo
+	FsckObject = "object"
+)
+
+// pruneExpire is the expiry of unreachable objects pruned from a local ghost repo, which is the default of 'git gc'
+const pruneExpire = "2.weeks.ago"
+
+// FsckOptions represents arg for Fsck func
+type FsckOptions struct {
+	types.WorkingEnvSpec
+	Prefix string
+	// Repair deletes refs of problems found and prunes unreachable objects of a local ghost repo
+	Repair bool
+}
+
+// FsckProblem is an inconsistency found in ghost repo
+type FsckProblem struct {
+	Kind string
+	// Ref is a full ref name the problem is found on, which is empty for FsckObject
+	Ref    string
+	Detail string
+	// Repaired is true if the ref is deleted by FsckOptions.Repair
+	Repaired bool
+}
+
+// FsckResult is a summary of Fsck func
+type FsckResult struct {
+	// Branches and Tags are the numbers of checked ghost branches and tags
+	Branches int
+	Tags     int
+	Problems []FsckProblem
+	// ObjectsChecked is true if objects are checked by 'git fsck', which is only for a local ghost repo
+	ObjectsChecked bool
+	// Pruned is true if unreachable objects are pruned from a local ghost repo
+	Pruned bool
+}
+
+// Unrepaired returns the number of problems left unrepaired
+func (res *FsckResult) Unrepaired() int {
+	n := 0
+	for _, p := range res.Problems {
+		if !p.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+// Fsck checks consistency of ghost branches and tags in ghost repo, and repairs problems by deleting their refs if requested
+//
+// Problems are sorted by their refs.
+func Fsck(options FsckOptions) (*FsckResult, errors.GitGhostError) {
+	log.WithFields(util.ToFields(options)).Debug("fsck command with")
+
+	heads, err := git.ListRemoteRefHashes(options.GhostRepo, fmt.Sprintf("refs/heads/%s/*", options.Prefix))
+	if err != nil {
+		return nil, err
+	}
+	tags, err := git.ListRemoteRefHashes(options.GhostRepo, fmt.Sprintf("refs/tags/%s/*", options.Prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	res := FsckResult{}
+	// deletions maps refs of problems to be repaired to whether they are deleted
+	deletions := map[string]bool{}
+	branches := map[string]types.GhostBranch{}
+	commits := map[string]bool{}
+	for ref, commit := range heads {
+		branch := types.CreateGhostBranchByName(strings.TrimPrefix(ref, "refs/heads/"))
+		if branch == nil && types.CreateGroupBranchByName(strings.TrimPrefix(ref, "refs/heads/")) != nil {
+			// a group branch only lists ghost branches of its repos
+			continue
+		}
+		if branch == nil {
+			res.Problems = append(res.Problems, FsckProblem{Kind: FsckInvalidRef, Ref: ref, Detail: "not a name of ghost branch"})
+			continue
+		}
+		branches[ref] = branch
+		commits[commit] = true
+	}
+	res.Branches = len(branches)
+
+	// knownTags are tags and patch id tags created by git-ghost
+	knownTags := map[string]string{}
+	tagPrefix := tagRef(options.Prefix, "")
+	patchIDPrefix := fmt.Sprintf("refs/tags/%s/patch-id/", options.Prefix)
+	for ref, commit := range tags {
+		if !strings.HasPrefix(ref, tagPrefix) && !strings.HasPrefix(ref, patchIDPrefix) {
+			res.Problems = append(res.Problems, FsckProblem{Kind: FsckInvalidRef, Ref: ref, Detail: "not a name of tag"})
+			continue
+		}
+		knownTags[ref] = commit
+		if !commits[commit] {
+			res.Problems = append(res.Problems, FsckProblem{Kind: FsckDanglingTag, Ref: ref, Detail: fmt.Sprintf("no ghost branch is at %s", commit)})
+			deletions[ref] = true
+		}
+	}
+
+	res.Tags = len(knownTags)
+
+	we, err := options.WorkingEnvSpec.Initialize()
+	if err != nil {
+		return nil, err
+	}
+	defer util.LogDeferredGitGhostError(we.Clean)
+
+	refs := make([]string, 0, len(branches))
+	for ref := range branches {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	fetched, err := fetchGhostBranches(we.GhostDir, refs, branches)
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range refs {
+		branch := branches[ref]
+		var kind string
+		var ggerr errors.GitGhostError
+		if ferr, ok := fetched[ref]; ok {
+			kind, ggerr = FsckUnreadable, ferr
+		} else if verr := types.VerifyGhostFile(we.GhostDir, fmt.Sprintf("%s/%s", git.ORIGIN, branch.BranchName()), branch); verr != nil {
+			kind, ggerr = FsckCorruptFile, verr
+			if errors.CategoryOf(verr) == errors.CategoryNotFound {
+				kind = FsckMissingFile
+			}
+		} else {
+			continue
+		}
+		res.Problems = append(res.Problems, FsckProblem{Kind: kind, Ref: ref, Detail: firstLine(ggerr.Error())})
+		deletions[ref] = true
+		// tags of a deleted branch would be dangling, so they are deleted together
+		for tag, commit := range knownTags {
+			if commit == heads[ref] && !deletions[tag] {
+				res.Problems = append(res.Problems, FsckProblem{Kind: kind, Ref: tag, Detail: fmt.Sprintf("tag of %s", ref)})
+				deletions[tag] = true
+			}
+		}
+	}
+
+	problems, checked, err := git.FsckRepo(options.GhostRepo)
+	if err != nil {
+		return nil, err
+	}
+	res.ObjectsChecked = checked
+	for _, p := range problems {
+		res.Problems = append(res.Problems, FsckProblem{Kind: FsckObject, Detail: p})
+	}
+	sort.SliceStable(res.Problems, func(i, j int) bool { return res.Problems[i].Ref < res.Problems[j].Ref })
+
+	if !options.Repair {
+		return &res, nil
+	}
+	if len(deletions) > 0 {
+		// each ref is deleted only if it is still where it is checked, so that ghosts pushed again meanwhile are kept
+		leases := map[string]string{}
+		for ref := range deletions {
+			if commit, ok := heads[ref]; ok {
+				leases[ref] = commit
+			} else {
+				leases[ref] = tags[ref]
+			}
+		}
+		log.WithField("refs", leases).Info("delete refs of problems")
+		err = git.DeleteRefsWithLease(we.GhostDir, leases)
+		if err != nil {
+			return nil, err
+		}
+		for i := range res.Problems {
+			res.Problems[i].Repaired = deletions[res.Problems[i].Ref]
+		}
+	}
+	res.Pruned, err = git.PruneRepo(options.GhostRepo, pruneExpire)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// fetchGhostBranches fetches ghost branches of refs, one by one if fetching all at once fails, and returns errors per ref
+//
+// Only ones failed by missing or corrupt objects are returned per ref. The others (e.g. of network or authentication) abort it,
+// so that healthy ghost branches are never regarded as unreadable because of talking to ghost repo.
+func fetchGhostBranches(ghostDir string, refs []string, branches map[string]types.GhostBranch) (map[string]errors.GitGhostError, errors.GitGhostError) {
+	failed := map[string]errors.GitGhostError{}
+	if len(refs) == 0 {
+		return failed, nil
+	}
+	names := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		names = append(names, branches[ref].BranchName())
+	}
+	err := git.FetchBranches(ghostDir, names...)
+	if err == nil {
+		return failed, nil
+	}
+	if !git.IsObjectError(err) {
+		return nil, err
+	}
+	for _, ref := range refs {
+		err := git.FetchBranches(ghostDir, branches[ref].BranchName())
+		if err == nil {
+			continue
+		}
+		if !git.IsObjectError(err) {
+			return nil, err
+		}
+		failed[ref] = err
+	}
+	return failed, nil
+}
+
+func firstLine(s string) string {
+	return strings.SplitN(strings.TrimSpace(s), "\n", 2)[0]
+}
+
+// PrettyString pretty prints FsckResult
+func (res *FsckResult) PrettyString(headers bool) string {
+	var buffer bytes.Buffer
+	if headers && len(res.Problems) > 0 {
+		buffer.WriteString(fmt.Sprintf("%-14s %-8s %-40s %s\n", "Kind", "Repaired", "Ref", "Detail"))
+	}
+	for _, p := range res.Problems {
+		ref := p.Ref
+		if ref == "" {
+			ref = "-"
+		}
+		repaired := "no"
+		if p.Repaired {
+			repaired = "yes"
+		}
+		buffer.WriteString(fmt.Sprintf("%-14s %-8s %-40s %s\n", p.Kind, repaired, ref, p.Detail))
+	}
+	if headers {
+		objects := "objects not checked (ghost repo is not local)"
+		if res.ObjectsChecked {
+			objects = "objects checked"
+		}
+		buffer.WriteString(fmt.Sprintf("%d branches and %d tags checked, %s: %d problems found, %d repaired\n",
+			res.Branches, res.Tags, objects, len(res.Problems), len(res.Problems)-res.Unrepaired()))
+	}
+	return buffer.String()
+}
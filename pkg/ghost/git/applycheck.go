@@ -0,0 +1,77 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+var (
+	regexpPatchFailed   = regexp.MustCompile(`^error: patch failed: (.+):[0-9]+$`)
+	regexpPatchRejected = regexp.MustCompile(`^error: (.+): (patch does not apply|already exists in working directory|does not exist in working directory|does not exist in index|does not match index|No such file or directory)$`)
+)
+
+// CheckPatchFiles checks patch files (diffs or patches created by format-patch) apply to the working tree of dir in order like 'git apply --check'
+//
+// Nothing in dir is touched. It returns paths which patches don't apply to with an error of CategoryConflict if any of them doesn't apply.
+// Empty patch files are ignored.
+func CheckPatchFiles(dir string, filepaths []string, pathOpts PatchPathOptions) ([]string, errors.GitGhostError) {
+	args := append([]string{"-C", dir, "apply", "--check"}, pathOpts.args()...)
+	nonEmpty := []string{}
+	unidiffZero := false
+	for _, p := range filepaths {
+		size, ggerr := util.FileSize(p)
+		if ggerr != nil {
+			return nil, ggerr
+		}
+		if size == 0 {
+			continue
+		}
+		zero, ggerr := hasZeroContext(p)
+		if ggerr != nil {
+			return nil, ggerr
+		}
+		unidiffZero = unidiffZero || zero
+		nonEmpty = append(nonEmpty, p)
+	}
+	if len(nonEmpty) == 0 {
+		return []string{}, nil
+	}
+	if unidiffZero {
+		args = append(args, "--unidiff-zero")
+	}
+	// all the patches are given at once for git to apply later ones onto the results of earlier ones in memory
+	ggerr := util.JustRunCmd(exec.Command("git", append(args, nonEmpty...)...))
+	if ggerr == nil || util.CommandContextDone() {
+		return []string{}, ggerr
+	}
+	paths := []string{}
+	for _, line := range strings.Split(ggerr.Error(), "\n") {
+		if m := regexpPatchFailed.FindStringSubmatch(line); m != nil {
+			paths = append(paths, m[1])
+		} else if m := regexpPatchRejected.FindStringSubmatch(line); m != nil {
+			paths = append(paths, m[1])
+		}
+	}
+	paths = util.UniqueStringSlice(paths)
+	sort.Strings(paths)
+	return paths, errors.WithCategory(ggerr, errors.CategoryConflict)
+}
@@ -0,0 +1,188 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// BinaryPatchSection is a section of a patch file (created with --binary) for a binary file without its binary hunks
+type BinaryPatchSection struct {
+	// Header is lines of the section before "GIT binary patch" (e.g. "diff --git" and "index" lines)
+	Header string `json:"header"`
+	// Blob is a hash of the file content after applying the section, which is all zero for a deleted file
+	Blob string `json:"blob"`
+}
+
+// Deleted returns true if the section deletes the file
+func (s BinaryPatchSection) Deleted() bool {
+	return strings.Trim(s.Blob, "0") == ""
+}
+
+const binaryPatchMarker = "GIT binary patch"
+
+var fullIndexLinePattern = regexp.MustCompile(`^index [0-9a-f]{40}\.\.([0-9a-f]{40})`)
+
+// SplitBinaryPatchFile removes sections for binary files from a patch file created on dir and returns them
+//
+// The patch file keeps only sections for text files, which are human readable.
+// The binary hunks can be restored by WriteBinaryPatchSection from the file contents after applying them,
+// whose blobs are written into the object database of dir if missing (since 'git diff' doesn't write blobs of the working tree).
+func SplitBinaryPatchFile(dir, filepath string) ([]BinaryPatchSection, errors.GitGhostError) {
+	sections := []BinaryPatchSection{}
+	ggerr := rewritePatchFile(filepath, isDiffSectionStart, func(s string) (string, errors.GitGhostError) {
+		i := strings.Index(s, "\n"+binaryPatchMarker+"\n")
+		if i < 0 {
+			return s, nil
+		}
+		header := s[:i+1]
+		var blob string
+		for _, line := range strings.Split(header, "\n") {
+			if m := fullIndexLinePattern.FindStringSubmatch(line); m != nil {
+				blob = m[1]
+			}
+		}
+		if blob == "" {
+			return "", errors.Errorf("binary patch without full index line: %s", strings.SplitN(header, "\n", 2)[0])
+		}
+		section := BinaryPatchSection{Header: header, Blob: blob}
+		ggerr := ensureBlob(dir, section, s)
+		if ggerr != nil {
+			return "", ggerr
+		}
+		sections = append(sections, section)
+		return "", nil
+	})
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	return sections, nil
+}
+
+// WriteBinaryPatchSection writes a section split by SplitBinaryPatchFile with a binary hunk creating content
+func WriteBinaryPatchSection(w io.Writer, section BinaryPatchSection, content []byte) errors.GitGhostError {
+	var compressed bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&compressed, zlib.BestCompression)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = zw.Write(content)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = zw.Close()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%s%s\nliteral %d\n", section.Header, binaryPatchMarker, len(content))
+	writeBase85Lines(bw, compressed.Bytes())
+	bw.WriteString("\n")
+	return errors.WithStack(bw.Flush())
+}
+
+const base85Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz!#$%&()*+-;<=>?@^_`{|}~"
+
+// writeBase85Lines writes data in lines of git's base85 encoding, each of which has up to 52 bytes prefixed by its length
+func writeBase85Lines(w *bufio.Writer, data []byte) {
+	for len(data) > 0 {
+		n := len(data)
+		if n > 52 {
+			n = 52
+		}
+		line := data[:n]
+		data = data[n:]
+		if n <= 26 {
+			w.WriteByte(byte('A' + n - 1))
+		} else {
+			w.WriteByte(byte('a' + n - 27))
+		}
+		for i := 0; i < n; i += 4 {
+			var acc uint32
+			for j := 0; j < 4; j++ {
+				acc <<= 8
+				if i+j < n {
+					acc |= uint32(line[i+j])
+				}
+			}
+			var encoded [5]byte
+			for k := 4; k >= 0; k-- {
+				encoded[k] = base85Alphabet[acc%85]
+				acc /= 85
+			}
+			w.Write(encoded[:])
+		}
+		w.WriteByte('\n')
+	}
+}
+
+// ensureBlob writes the blob of section (whose whole text is patch) from the working tree of dir if it is missing
+func ensureBlob(dir string, section BinaryPatchSection, patch string) errors.GitGhostError {
+	if section.Deleted() || blobExists(dir, section.Blob) {
+		return nil
+	}
+	f, err := ioutil.TempFile(util.TempDir(), "git-ghost-binary-section")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer util.LogDeferredError(func() error { return os.Remove(f.Name()) })
+	_, err = f.WriteString(patch)
+	util.LogDeferredError(f.Close)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	stats, ggerr := ListPatchFileStats(dir, f.Name(), PatchPathOptions{})
+	if ggerr != nil {
+		return ggerr
+	}
+	if len(stats) != 1 {
+		return errors.Errorf("unexpected binary patch: %s", section.Header)
+	}
+	toplevel, ggerr := util.JustOutputCmd(exec.Command("git", "-C", dir, "rev-parse", "--show-toplevel"))
+	if ggerr != nil {
+		return ggerr
+	}
+	path := filepath.Join(strings.TrimSpace(string(toplevel)), stats[0].Path)
+	hash, ggerr := util.JustOutputCmd(exec.Command("git", "-C", dir, "hash-object", "-w", "--", path))
+	if ggerr != nil {
+		return ggerr
+	}
+	if strings.TrimSpace(string(hash)) != section.Blob {
+		return errors.Errorf("%s has been changed while creating a patch", stats[0].Path)
+	}
+	return nil
+}
+
+func blobExists(dir, hash string) bool {
+	return util.JustRunCmd(exec.Command("git", "-C", dir, "cat-file", "-e", hash+"^{blob}")) == nil
+}
+
+// ReadBlob returns a content of a blob on dir specified by its hash or an expression like <committish>:<path>
+func ReadBlob(dir, hash string) ([]byte, errors.GitGhostError) {
+	return util.JustOutputCmd(exec.Command("git", "-C", dir, "cat-file", "blob", hash))
+}
@@ -0,0 +1,32 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"os/exec"
+
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// CreateBranchBundle writes a bundle of the whole history of commit to filepath as branch, which can be fetched from it like a ghost repo
+func CreateBranchBundle(dir, filepath, branch, commit string) errors.GitGhostError {
+	ref := "refs/heads/" + branch
+	err := util.JustRunCmd(exec.Command("git", "-C", dir, "update-ref", ref, commit))
+	if err != nil {
+		return err
+	}
+	return util.JustRunCmd(exec.Command("git", "-C", dir, "bundle", "create", "-q", filepath, ref))
+}
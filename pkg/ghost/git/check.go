@@ -16,6 +16,8 @@ package git
 
 import (
 	"os/exec"
+	"path/filepath"
+	"strings"
 
 	"github.com/pfnet-research/git-ghost/pkg/util"
 	"github.com/pfnet-research/git-ghost/pkg/util/errors"
@@ -23,11 +25,96 @@ import (
 
 // ValidateRemoteBranchExistence checks repo has branch or not.
 func ValidateRemoteBranchExistence(repo, branch string) (bool, errors.GitGhostError) {
+	output, err := outputRemoteCommand("ls-remote", "--heads", repo, branch)
+	if err != nil {
+		return false, err
+	}
+	return string(output) != "", nil
+}
+
+// HasLocalChanges checks dir has modifications of indexed files (in the index or the working tree) or not.
+func HasLocalChanges(dir string) (bool, errors.GitGhostError) {
 	output, err := util.JustOutputCmd(
-		exec.Command("git", "ls-remote", "--heads", repo, branch),
+		exec.Command("git", "-C", dir, "status", "--porcelain", "--untracked-files=no"),
 	)
 	if err != nil {
 		return false, err
 	}
-	return string(output) != "", nil
+	return len(strings.TrimSpace(string(output))) > 0, nil
+}
+
+// HasStagedChanges checks dir has changes staged in the index or not.
+func HasStagedChanges(dir string) (bool, errors.GitGhostError) {
+	output, err := util.JustOutputCmd(
+		exec.Command("git", "-C", dir, "diff", "--cached", "--name-only"),
+	)
+	if err != nil {
+		return false, err
+	}
+	return len(strings.TrimSpace(string(output))) > 0, nil
+}
+
+// OperationInProgress returns a name of git operation ("am" or "rebase") which is left in progress on dir.
+// It returns an empty string if there is no such operation.
+func OperationInProgress(dir string) (string, errors.GitGhostError) {
+	rebaseApplyDir, err := ResolveGitPath(dir, "rebase-apply")
+	if err != nil {
+		return "", err
+	}
+	exists, err := util.FileExists(rebaseApplyDir)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		// 'git am' leaves "applying" file in rebase-apply directory while 'git rebase' doesn't
+		applying, err := util.FileExists(filepath.Join(rebaseApplyDir, "applying"))
+		if err != nil {
+			return "", err
+		}
+		if applying {
+			return "am", nil
+		}
+		return "rebase", nil
+	}
+	rebaseMergeDir, err := ResolveGitPath(dir, "rebase-merge")
+	if err != nil {
+		return "", err
+	}
+	exists, err = util.FileExists(rebaseMergeDir)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		return "rebase", nil
+	}
+	return "", nil
+}
+
+// AbortOperation aborts an operation ("am" or "rebase") in progress on dir
+func AbortOperation(dir, operation string) errors.GitGhostError {
+	return util.JustRunCmd(
+		exec.Command("git", "-C", dir, operation, "--abort"),
+	)
+}
+
+// QuitOperation stops an operation ("am" or "rebase") in progress on dir keeping HEAD, the index and the working tree as they are
+func QuitOperation(dir, operation string) errors.GitGhostError {
+	return util.JustRunCmd(
+		exec.Command("git", "-C", dir, operation, "--quit"),
+	)
+}
+
+// ResolveGitPath resolves path in the git directory of dir (e.g. "index") as an absolute path
+func ResolveGitPath(dir, path string) (string, errors.GitGhostError) {
+	output, err := util.JustOutputCmd(
+		exec.Command("git", "-C", dir, "rev-parse", "--git-path", path),
+	)
+	if err != nil {
+		return "", err
+	}
+	resolved := strings.TrimRight(string(output), "\r\n")
+	if !filepath.IsAbs(resolved) {
+		resolved = filepath.Join(dir, resolved)
+	}
+	return resolved, nil
 }
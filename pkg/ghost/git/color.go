@@ -0,0 +1,63 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// GetColorBool returns whether output is colored by name (e.g. "color.diff") in git config seen from dir like git does,
+// which falls back to color.ui and colors only when stdoutIsTerminal if it is "auto" or unset
+func GetColorBool(dir, name string, stdoutIsTerminal bool) (bool, errors.GitGhostError) {
+	output, err := util.JustOutputCmd(
+		exec.Command("git", "-C", dir, "config", "--get-colorbool", name, strconv.FormatBool(stdoutIsTerminal)),
+	)
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(output)) == "true", nil
+}
+
+// GetDiffColors returns colors of lines of patches by color.diff.<slot> in git config seen from dir,
+// defaulting to the ones of 'git diff'
+func GetDiffColors(dir string) (util.DiffColors, errors.GitGhostError) {
+	colors := util.DiffColors{}
+	slots := []struct {
+		name         string
+		defaultColor string
+		color        *string
+	}{
+		{"meta", "bold", &colors.Meta},
+		{"frag", "cyan", &colors.Frag},
+		{"old", "red", &colors.Old},
+		{"new", "green", &colors.New},
+		{"commit", "yellow", &colors.Commit},
+	}
+	for _, slot := range slots {
+		output, err := util.JustOutputCmd(
+			exec.Command("git", "-C", dir, "config", "--get-color", "color.diff."+slot.name, slot.defaultColor),
+		)
+		if err != nil {
+			return colors, err
+		}
+		*slot.color = string(output)
+	}
+	return colors, nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// concurrencyConfigKeys are git configs which bound the number of threads or processes a git command runs in parallel
+var concurrencyConfigKeys = []string{"pack.threads", "index.threads", "checkout.workers"}
+
+// DefaultConcurrency returns the default concurrency for repo
+//
+// It is 1 for local repos, which can be on a shared filesystem like NFS, and 0 (git's defaults) otherwise.
+func DefaultConcurrency(repo string) int {
+	if _, ok := localRepoPath(repo); ok {
+		return 1
+	}
+	return 0
+}
+
+var concurrency int
+
+// SetConcurrency bounds the parallelism of all the git commands run after it by n, or leaves git's defaults if n is 0
+//
+// The configs are passed by GIT_CONFIG_COUNT envs, so that git commands spawned by git
+// (e.g. receive-pack on a local ghost repo) are bounded as well.
+func SetConcurrency(n int) errors.GitGhostError {
+	concurrency = n
+	if n <= 0 {
+		return nil
+	}
+	values := make([]string, len(concurrencyConfigKeys))
+	for i := range values {
+		values[i] = strconv.Itoa(n)
+	}
+	return setConfigEnvs(concurrencyConfigKeys, values)
+}
+
+// BoundByConcurrency returns n bounded by the concurrency set by SetConcurrency unless it is 0,
+// which git-ghost bounds its own parallel operations on multiple ghosts by as well as git commands
+func BoundByConcurrency(n int) int {
+	if concurrency > 0 && n > concurrency {
+		return concurrency
+	}
+	return n
+}
+
+// setConfigEnvs passes git configs of keys with values to all the git commands run after it by GIT_CONFIG_COUNT envs,
+// appending them to the ones already passed
+func setConfigEnvs(keys, values []string) errors.GitGhostError {
+	count := 0
+	if c := os.Getenv("GIT_CONFIG_COUNT"); c != "" {
+		var err error
+		count, err = strconv.Atoi(c)
+		if err != nil {
+			return errors.Errorf("GIT_CONFIG_COUNT is invalid: %s", c)
+		}
+	}
+	envs := map[string]string{}
+	for i, key := range keys {
+		envs[fmt.Sprintf("GIT_CONFIG_KEY_%d", count)] = key
+		envs[fmt.Sprintf("GIT_CONFIG_VALUE_%d", count)] = values[i]
+		count++
+	}
+	envs["GIT_CONFIG_COUNT"] = strconv.Itoa(count)
+	for name, value := range envs {
+		err := os.Setenv(name, value)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
@@ -15,7 +15,9 @@
 package git
 
 import (
+	"fmt"
 	"os/exec"
+	"strconv"
 	"strings"
 
 	"github.com/pfnet-research/git-ghost/pkg/util"
@@ -32,3 +34,58 @@ func ResolveCommittish(dir, committish string) (string, errors.GitGhostError) {
 	}
 	return strings.TrimRight(string(commit), "\r\n"), nil
 }
+
+// OctopusMergeBase returns the best common ancestor of all the committishes on dir like 'git merge-base --octopus'
+func OctopusMergeBase(dir string, committishes ...string) (string, errors.GitGhostError) {
+	for _, c := range committishes {
+		err := ValidateCommittish(dir, c)
+		if err != nil {
+			return "", err
+		}
+	}
+	args := append([]string{"-C", dir, "merge-base", "--octopus"}, committishes...)
+	output, err := util.JustOutputCmd(exec.Command("git", args...))
+	if err != nil && util.GetExitCode(err.Cause()) == 1 && len(output) == 0 {
+		// exit 1 without output is for no common ancestor
+		return "", errors.WithCategory(errors.Errorf("%s have no common ancestor", strings.Join(committishes, ", ")), errors.CategoryNotFound)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(output), "\r\n"), nil
+}
+
+// IsAncestor returns true if ancestor is an ancestor of (or the same as) descendant on dir
+func IsAncestor(dir, ancestor, descendant string) (bool, errors.GitGhostError) {
+	err := util.JustRunCmd(exec.Command("git", "-C", dir, "merge-base", "--is-ancestor", ancestor, descendant))
+	if err != nil && util.GetExitCode(err.Cause()) == 1 {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// CountCommits returns the number of commits in fromCommittish..toCommittish on dir
+func CountCommits(dir, fromCommittish, toCommittish string) (int, errors.GitGhostError) {
+	output, err := util.JustOutputCmd(
+		exec.Command("git", "-C", dir, "rev-list", "--count", fmt.Sprintf("%s..%s", fromCommittish, toCommittish)),
+	)
+	if err != nil {
+		return 0, err
+	}
+	count, cerr := strconv.Atoi(strings.TrimSpace(string(output)))
+	if cerr != nil {
+		return 0, errors.WithStack(cerr)
+	}
+	return count, nil
+}
+
+// ResolveTree resolves committish as full hash of its tree on dir
+func ResolveTree(dir, committish string) (string, errors.GitGhostError) {
+	tree, err := util.JustOutputCmd(
+		exec.Command("git", "-C", dir, "rev-parse", "--verify", committish+"^{tree}"),
+	)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(tree), "\r\n"), nil
+}
@@ -0,0 +1,51 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"regexp"
+
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+var nonInteractive bool
+
+// SetNonInteractive makes git fail immediately instead of prompting for credentials on the terminal if n is true,
+// unless GIT_TERMINAL_PROMPT env is set explicitly
+//
+// Credential helpers and GIT_ASKPASS supply credentials as usual.
+func SetNonInteractive(n bool) {
+	nonInteractive = n
+}
+
+var (
+	regexpCredentialMissing = regexp.MustCompile(`(?m)^fatal: could not read (?:Username|Password) for '([^']*)': (.*)$`)
+	regexpAuthFailed        = regexp.MustCompile(`(?m)^fatal: Authentication failed for '([^']*)'`)
+)
+
+// remoteError classifies err of a git command which talks to a remote repo as errors.CategoryRemote,
+// replacing errors of missing or rejected credentials of HTTP(S) with ones telling how to supply them
+func remoteError(err errors.GitGhostError) errors.GitGhostError {
+	if err == nil {
+		return nil
+	}
+	if m := regexpCredentialMissing.FindStringSubmatch(err.Error()); m != nil {
+		err = errors.Errorf("no credentials for %s are supplied by credential helpers, and git can't prompt for them (%s). please configure a credential helper (e.g. by 'git config --global credential.helper'), or set GIT_ASKPASS", util.RedactURLPassword(m[1]), m[2])
+	} else if m := regexpAuthFailed.FindStringSubmatch(err.Error()); m != nil {
+		err = errors.Errorf("authentication failed for %s. credentials supplied by credential helpers (or in the url) are rejected, which may be stale", util.RedactURLPassword(m[1]))
+	}
+	return errors.WithCategory(err, errors.CategoryRemote)
+}
@@ -0,0 +1,88 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// ListEmptyDirs returns paths (relative to dir) of empty directories in the working tree of dir, which git doesn't track
+//
+// Only leaves are returned since their parents are recreated with them. Directories ignored by .gitignore are not listed.
+func ListEmptyDirs(dir string) ([]string, errors.GitGhostError) {
+	untracked, ggerr := listUntrackedDirs(dir)
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	nonEmpty, ggerr := listUntrackedDirs(dir, "--no-empty-directory")
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	emptyDirs := []string{}
+	for _, d := range util.SubtractStringSlice(untracked, nonEmpty) {
+		// nested empty directories are listed only by the outermost one
+		leaves, ggerr := emptyLeafDirs(dir, d)
+		if ggerr != nil {
+			return nil, ggerr
+		}
+		emptyDirs = append(emptyDirs, leaves...)
+	}
+	sort.Strings(emptyDirs)
+	return emptyDirs, nil
+}
+
+func listUntrackedDirs(dir string, flags ...string) ([]string, errors.GitGhostError) {
+	args := append([]string{"-C", dir, "ls-files", "-z", "--others", "--exclude-standard", "--directory"}, flags...)
+	output, ggerr := util.JustOutputCmd(exec.Command("git", args...))
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	dirs := []string{}
+	for _, p := range strings.Split(string(output), "\x00") {
+		if strings.HasSuffix(p, "/") {
+			dirs = append(dirs, strings.TrimSuffix(p, "/"))
+		}
+	}
+	return dirs, nil
+}
+
+func emptyLeafDirs(dir, path string) ([]string, errors.GitGhostError) {
+	entries, err := ioutil.ReadDir(filepath.Join(dir, path))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	leaves := []string{}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		sub, ggerr := emptyLeafDirs(dir, filepath.Join(path, e.Name()))
+		if ggerr != nil {
+			return nil, ggerr
+		}
+		leaves = append(leaves, sub...)
+	}
+	if len(leaves) == 0 {
+		return []string{path}, nil
+	}
+	return leaves, nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// Kinds of line endings
+const (
+	EOLLF   = "lf"
+	EOLCRLF = "crlf"
+)
+
+// FileEOLs represents line endings of lines which a patch file adds or has as context in a file
+type FileEOLs struct {
+	Path string
+	// Lines are line numbers (in the file after the patch) of the lines by their line endings
+	Lines map[string][]int
+}
+
+// ScanPatchEOLs returns line endings of files in a patch file (a diff or patches of commits) in the order of their first appearance
+//
+// Added and context lines of text hunks are scanned, which are what the files have after applying the patch around the hunks,
+// and the last line of a file without a newline has no line ending.
+func ScanPatchEOLs(filepath string) ([]FileEOLs, errors.GitGhostError) {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer util.LogDeferredError(f.Close)
+
+	files := []FileEOLs{}
+	indices := map[string]int{}
+	path := ""
+	line := 0
+	inHunk := false
+	// counted is the line ending of the previous line if it is counted
+	counted := ""
+	reader := bufio.NewReader(f)
+	for {
+		raw, err := reader.ReadString('\n')
+		text := strings.TrimRight(raw, "\n")
+		previous := counted
+		counted = ""
+		switch {
+		case strings.HasPrefix(text, "diff --git "), emailPatchStartPattern.MatchString(text):
+			inHunk = false
+		case !inHunk && strings.HasPrefix(text, "+++ "):
+			path = unquotePatchPath(strings.TrimPrefix(text, "+++ "))
+		case strings.HasPrefix(text, "@@ "):
+			m := hunkHeaderPattern.FindStringSubmatch(text)
+			inHunk = m != nil
+			if inHunk {
+				line, _ = strconv.Atoi(m[2])
+			}
+		case !inHunk:
+		case strings.HasPrefix(text, "+"), strings.HasPrefix(text, " "), text == "":
+			if strings.HasSuffix(raw, "\n") {
+				counted = EOLLF
+				if strings.HasSuffix(raw, "\r\n") {
+					counted = EOLCRLF
+				}
+				i, ok := indices[path]
+				if !ok {
+					i = len(files)
+					indices[path] = i
+					files = append(files, FileEOLs{Path: path, Lines: map[string][]int{}})
+				}
+				files[i].Lines[counted] = append(files[i].Lines[counted], line)
+			}
+			line++
+		case strings.HasPrefix(text, "\\"):
+			// "\ No newline at end of file" tells the previous line has no line ending
+			if previous != "" {
+				lines := files[indices[path]].Lines
+				lines[previous] = lines[previous][:len(lines[previous])-1]
+				if len(lines[previous]) == 0 {
+					delete(lines, previous)
+				}
+			}
+		case strings.HasPrefix(text, "-"):
+		default:
+			inHunk = false
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	return files, nil
+}
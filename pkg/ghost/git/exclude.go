@@ -0,0 +1,296 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ExcludeFile is a repo-local file listing patterns (in the format of .gitignore) of paths excluded from ghosts
+const ExcludeFile = "info/git-ghost-exclude"
+
+func excludeFilePath(dir string) (string, errors.GitGhostError) {
+	path, ggerr := ResolveGitPath(dir, ExcludeFile)
+	if ggerr != nil {
+		return "", ggerr
+	}
+	exists, ggerr := util.FileExists(path)
+	if ggerr != nil || !exists {
+		return "", ggerr
+	}
+	return path, nil
+}
+
+// ExcludedNonIndexedFiles returns paths in nonIndexedFilepaths which match patterns in ExcludeFile of dir
+func ExcludedNonIndexedFiles(dir string, nonIndexedFilepaths []string) ([]string, errors.GitGhostError) {
+	path, ggerr := excludeFilePath(dir)
+	if ggerr != nil || path == "" || len(nonIndexedFilepaths) == 0 {
+		return []string{}, ggerr
+	}
+	args := append([]string{"-C", dir, "ls-files", "-z", "--others", "--ignored", "--exclude-from=" + path, "--"}, nonIndexedFilepaths...)
+	output, ggerr := util.JustOutputCmd(exec.Command("git", args...))
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	return splitNulls(string(output)), nil
+}
+
+// GitDirFiles returns paths in nonIndexedFilepaths which are inside a .git directory, e.g. of a repo embedded in the working tree
+//
+// They are internal data of git which 'git diff' and 'git add' mishandle. A name is compared case-insensitively as git does on case-insensitive file systems.
+func GitDirFiles(nonIndexedFilepaths []string) []string {
+	files := []string{}
+	for _, p := range nonIndexedFilepaths {
+		for _, name := range strings.Split(filepath.ToSlash(p), "/") {
+			if strings.EqualFold(name, ".git") {
+				files = append(files, p)
+				break
+			}
+		}
+	}
+	return files
+}
+
+// DiffOptions represents options to select files in diffs of local modifications
+type DiffOptions struct {
+	// SkipGenerated excludes files with linguist-generated attribute in .gitattributes
+	SkipGenerated bool
+	// IgnoreModeChanges excludes files whose modes are changed without their contents
+	IgnoreModeChanges bool
+	// Unified is the number of context lines of the diff, or nil for git's default (3)
+	Unified *int
+	// IgnoreLines drops hunks whose changed lines all match either of these regular expressions (POSIX extended) by 'git diff -I'
+	IgnoreLines []string
+}
+
+// contextArgs returns git diff options for the context lines required by opts
+func (opts DiffOptions) contextArgs() []string {
+	if opts.Unified == nil {
+		return []string{}
+	}
+	return []string{fmt.Sprintf("--unified=%d", *opts.Unified)}
+}
+
+// ignoreLinesArgs returns git diff options for the hunks dropped by opts
+func (opts DiffOptions) ignoreLinesArgs() []string {
+	args := []string{}
+	for _, pattern := range opts.IgnoreLines {
+		args = append(args, "--ignore-matching-lines="+pattern)
+	}
+	return args
+}
+
+// diffPathspecs returns pathspecs for git diff with diffArgs which exclude indexed files matching patterns
+// in ExcludeFile of dir, and generated files and files changed only in their modes if required by opts
+//
+// It returns no pathspecs if nothing is excluded.
+func diffPathspecs(dir string, opts DiffOptions, diffArgs ...string) ([]string, errors.GitGhostError) {
+	excluded, ggerr := excludedIndexedFiles(dir)
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	if opts.SkipGenerated {
+		generated, ggerr := generatedDiffFiles(dir, diffArgs...)
+		if ggerr != nil {
+			return nil, ggerr
+		}
+		excluded = util.UniqueStringSlice(append(excluded, generated...))
+	}
+	if opts.IgnoreModeChanges {
+		modeOnly, ggerr := modeOnlyChangedFiles(dir, diffArgs...)
+		if ggerr != nil {
+			return nil, ggerr
+		}
+		if len(modeOnly) > 0 {
+			log.WithFields(log.Fields{
+				"excluded": modeOnly,
+			}).Info("excluded files changed only in their modes")
+		}
+		excluded = util.UniqueStringSlice(append(excluded, modeOnly...))
+	}
+	if len(excluded) == 0 {
+		return []string{}, nil
+	}
+	pathspecs := []string{"--", ":/"}
+	for _, p := range excluded {
+		pathspecs = append(pathspecs, ":(top,literal,exclude)"+p)
+	}
+	return pathspecs, nil
+}
+
+// excludedIndexedFiles returns paths (relative to the top of the repo) of indexed files matching patterns in ExcludeFile of dir
+func excludedIndexedFiles(dir string) ([]string, errors.GitGhostError) {
+	path, ggerr := excludeFilePath(dir)
+	if ggerr != nil || path == "" {
+		return []string{}, ggerr
+	}
+	output, ggerr := util.JustOutputCmd(
+		exec.Command("git", "-C", dir, "ls-files", "-z", "--cached", "--ignored", "--full-name", "--exclude-from="+path),
+	)
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	return splitNulls(string(output)), nil
+}
+
+// generatedDiffFiles returns paths (relative to the top of the repo) of generated files changed in git diff with diffArgs
+func generatedDiffFiles(dir string, diffArgs ...string) ([]string, errors.GitGhostError) {
+	args := append([]string{"-C", dir, "diff", "--name-only", "-z"}, diffArgs...)
+	output, ggerr := util.JustOutputCmd(exec.Command("git", args...))
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	changed := strings.Split(strings.TrimSuffix(string(output), "\x00"), "\x00")
+	if len(changed) == 1 && changed[0] == "" {
+		return []string{}, nil
+	}
+	topDir, ggerr := util.JustOutputCmd(exec.Command("git", "-C", dir, "rev-parse", "--show-toplevel"))
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	return GeneratedFiles(strings.TrimSuffix(string(topDir), "\n"), changed)
+}
+
+const nullObjectHash = "0000000000000000000000000000000000000000"
+
+// modeOnlyChangedFiles returns paths (relative to the top of the repo) of files whose modes are changed without their contents in git diff with diffArgs
+func modeOnlyChangedFiles(dir string, diffArgs ...string) ([]string, errors.GitGhostError) {
+	args := append([]string{"-C", dir, "diff", "--raw", "--no-abbrev", "--no-renames", "-z"}, diffArgs...)
+	output, ggerr := util.JustOutputCmd(exec.Command("git", args...))
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	// each entry is ":<old mode> <new mode> <old hash> <new hash> <status>\0<path>\0"
+	tokens := strings.Split(strings.TrimSuffix(string(output), "\x00"), "\x00")
+	modeOnly := []string{}
+	// a content on the working tree is not hashed by git diff, which is compared ignoring modes instead
+	unhashed := []string{}
+	for i := 0; i+1 < len(tokens); i += 2 {
+		fields := strings.Fields(strings.TrimPrefix(tokens[i], ":"))
+		if len(fields) != 5 || fields[4] != "M" || fields[0] == fields[1] {
+			continue
+		}
+		switch fields[3] {
+		case fields[2]:
+			modeOnly = append(modeOnly, tokens[i+1])
+		case nullObjectHash:
+			unhashed = append(unhashed, tokens[i+1])
+		}
+	}
+	if len(unhashed) == 0 {
+		return modeOnly, nil
+	}
+
+	args = append([]string{"-C", dir, "-c", "core.fileMode=false", "diff", "--name-only", "--no-renames", "-z"}, diffArgs...)
+	args = append(args, "--")
+	for _, p := range unhashed {
+		args = append(args, ":(top,literal)"+p)
+	}
+	output, ggerr = util.JustOutputCmd(exec.Command("git", args...))
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	changed := strings.Split(strings.TrimSuffix(string(output), "\x00"), "\x00")
+	return append(modeOnly, util.SubtractStringSlice(unhashed, changed)...), nil
+}
+
+// GeneratedFiles returns paths in filepaths (relative to dir) which are marked as generated by linguist-generated attribute in .gitattributes
+func GeneratedFiles(dir string, filepaths []string) ([]string, errors.GitGhostError) {
+	if len(filepaths) == 0 {
+		return []string{}, nil
+	}
+	args := append([]string{"-C", dir, "check-attr", "-z", "linguist-generated", "--"}, filepaths...)
+	output, ggerr := util.JustOutputCmd(exec.Command("git", args...))
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	// each entry is "<path>\0linguist-generated\0<value>\0"
+	tokens := strings.Split(string(output), "\x00")
+	generated := []string{}
+	for i := 0; i+2 < len(tokens); i += 3 {
+		if value := tokens[i+2]; value == "set" || value == "true" {
+			generated = append(generated, tokens[i])
+		}
+	}
+	return generated, nil
+}
+
+// BinaryFiles returns paths in filepaths (relative to dir) which are binary when they are diffed
+//
+// The diff and text attributes in .gitattributes take precedence over the heuristic of git: a file whose diff attribute is unset
+// (e.g. marked binary) is binary even without NUL bytes, and a file whose diff or text attribute is set is text.
+// A symlink is never binary.
+func BinaryFiles(dir string, filepaths []string) ([]string, errors.GitGhostError) {
+	if len(filepaths) == 0 {
+		return []string{}, nil
+	}
+	args := append([]string{"-C", dir, "check-attr", "-z", "diff", "text", "--"}, filepaths...)
+	output, ggerr := util.JustOutputCmd(exec.Command("git", args...))
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	// each entry is "<path>\0<attribute>\0<value>\0"
+	tokens := strings.Split(string(output), "\x00")
+	attrs := map[string]map[string]string{}
+	for i := 0; i+2 < len(tokens); i += 3 {
+		if attrs[tokens[i]] == nil {
+			attrs[tokens[i]] = map[string]string{}
+		}
+		attrs[tokens[i]][tokens[i+1]] = tokens[i+2]
+	}
+	binaries := []string{}
+	for _, p := range filepaths {
+		islink, ggerr := util.IsSymlink(filepath.Join(dir, p))
+		if ggerr != nil {
+			return nil, ggerr
+		}
+		if islink {
+			continue
+		}
+		binary := false
+		switch {
+		case attrs[p]["diff"] == "unset":
+			binary = true
+		case attrs[p]["diff"] == "set" || attrs[p]["text"] == "set":
+		default:
+			binary, ggerr = util.IsBinaryFile(filepath.Join(dir, p))
+			if ggerr != nil {
+				return nil, ggerr
+			}
+		}
+		if binary {
+			binaries = append(binaries, p)
+		}
+	}
+	return binaries, nil
+}
+
+func splitLines(s string) []string {
+	lines := []string{}
+	for _, line := range strings.Split(s, "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,132 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// ListChangedTreePaths returns paths of files which exist in treeTo among ones changed from treeFrom, and paths of files deleted from treeFrom
+//
+// Renamed files are listed as a deleted file and an added one.
+func ListChangedTreePaths(dir, treeFrom, treeTo string) ([]string, []string, errors.GitGhostError) {
+	output, ggerr := util.JustOutputCmd(
+		exec.Command("git", "-C", dir, "diff", "--name-status", "--no-renames", "-z", treeFrom, treeTo),
+	)
+	if ggerr != nil {
+		return nil, nil, ggerr
+	}
+	// each entry is "<status>\0<path>\0"
+	tokens := strings.Split(strings.TrimSuffix(string(output), "\x00"), "\x00")
+	changed := []string{}
+	deleted := []string{}
+	for i := 0; i+1 < len(tokens); i += 2 {
+		if tokens[i] == "D" {
+			deleted = append(deleted, tokens[i+1])
+		} else {
+			changed = append(changed, tokens[i+1])
+		}
+	}
+	return changed, deleted, nil
+}
+
+// CheckoutTreeFiles writes files of paths in a tree object on dir into outputDir keeping their paths and modes
+//
+// It uses a temporary index so that neither the index nor the working tree of dir is modified.
+func CheckoutTreeFiles(dir, tree, outputDir string, paths []string) errors.GitGhostError {
+	if len(paths) == 0 {
+		return nil
+	}
+	absOutputDir, err := filepath.Abs(outputDir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	indexFile, err := ioutil.TempFile(util.TempDir(), "git-ghost-index")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	util.LogDeferredError(indexFile.Close)
+	defer util.LogDeferredError(func() error { return os.Remove(indexFile.Name()) })
+	env := indexEnv(indexFile.Name())
+
+	ggerr := util.JustRunCmd(withEnv(exec.Command("git", "-C", dir, "read-tree", tree), env))
+	if ggerr != nil {
+		return ggerr
+	}
+	cmd := withEnv(exec.Command("git", "-C", dir, "checkout-index", "-f", "-z", "--stdin", fmt.Sprintf("--prefix=%s/", absOutputDir)), env)
+	cmd.Stdin = strings.NewReader(strings.Join(paths, "\x00") + "\x00")
+	return util.JustRunCmd(cmd)
+}
+
+// TreeEntry represents an entry of a tree object
+type TreeEntry struct {
+	// Mode is a git file mode, e.g. "100644", "100755", "120000" for a symlink or "160000" for a submodule
+	Mode string
+	// Type is an object type, e.g. "blob" or "commit" for a submodule
+	Type string
+	// Hash is a hash of the object
+	Hash string
+	Path string
+}
+
+// ListTreeEntries returns entries of files of paths in a tree object on dir
+func ListTreeEntries(dir, tree string, paths []string) ([]TreeEntry, errors.GitGhostError) {
+	if len(paths) == 0 {
+		return []TreeEntry{}, nil
+	}
+	output, ggerr := util.JustOutputCmd(
+		exec.Command("git", append([]string{"-C", dir, "ls-tree", "-r", "-z", tree, "--"}, paths...)...),
+	)
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	entries := []TreeEntry{}
+	// each entry is "<mode> <type> <hash>\t<path>\0"
+	for _, line := range splitNulls(string(output)) {
+		tab := strings.IndexByte(line, '\t')
+		if tab < 0 {
+			return nil, errors.Errorf("unexpected output of git ls-tree: %s", line)
+		}
+		fields := strings.Fields(line[:tab])
+		if len(fields) != 3 {
+			return nil, errors.Errorf("unexpected output of git ls-tree: %s", line)
+		}
+		entries = append(entries, TreeEntry{Mode: fields[0], Type: fields[1], Hash: fields[2], Path: line[tab+1:]})
+	}
+	return entries, nil
+}
+
+// StreamBlob calls f with a reader of the content of a blob object on dir, which is streamed from git as it is read
+//
+// The content is converted as it is checked out at path, e.g. by eol and filter attributes in .gitattributes, unless path is empty.
+func StreamBlob(dir, hash, path string, f func(io.Reader) errors.GitGhostError) errors.GitGhostError {
+	if path == "" {
+		return util.ReadCmdOutput(exec.Command("git", "-C", dir, "cat-file", "blob", hash), f)
+	}
+	ggerr := RequireVersion("converting files by .gitattributes", 2, 11)
+	if ggerr != nil {
+		return ggerr
+	}
+	return util.ReadCmdOutput(exec.Command("git", "-C", dir, "cat-file", "--filters", "--path="+path, hash), f)
+}
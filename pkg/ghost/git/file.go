@@ -15,9 +15,16 @@
 package git
 
 import (
+	"bufio"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/pfnet-research/git-ghost/pkg/util"
 	"github.com/pfnet-research/git-ghost/pkg/util/errors"
@@ -26,27 +33,358 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-// CreateDiffBundleFile creates patches for fromCommittish..toCommittish and save it to filepath
-func CreateDiffBundleFile(dir, filepath, fromCommittish, toCommittish string) errors.GitGhostError {
+const (
+	// PatchFormatEmail is a format of patches by 'git log --pretty=email', which keeps merges as diffs against their first parents
+	PatchFormatEmail = "email"
+	// PatchFormatFormatPatch is a format of patches by 'git format-patch', which can't have merges
+	PatchFormatFormatPatch = "format-patch"
+)
+
+// PatchFormatOptions represents a format of patches created by CreateDiffBundleFile, all of which can be applied by 'git am'
+type PatchFormatOptions struct {
+	// Format is PatchFormatEmail (default if empty) or PatchFormatFormatPatch
+	Format string
+	// NoSignature omits the signature at the end of each patch (only for PatchFormatFormatPatch)
+	NoSignature bool
+	// ZeroCommit writes all zero hashes instead of commit hashes in "From" lines (only for PatchFormatFormatPatch)
+	ZeroCommit bool
+}
+
+// Validate checks opts is a known format with options available for it
+func (opts PatchFormatOptions) Validate() errors.GitGhostError {
+	switch opts.Format {
+	case "", PatchFormatEmail:
+		if opts.NoSignature || opts.ZeroCommit {
+			return errors.Errorf("no-signature and zero-commit are only available with patch format %s", PatchFormatFormatPatch)
+		}
+	case PatchFormatFormatPatch:
+	default:
+		return errors.Errorf("patch format must be one of [%s %s] (value: %s)", PatchFormatEmail, PatchFormatFormatPatch, opts.Format)
+	}
+	return nil
+}
+
+// CreateDiffBundleFile creates patches for fromCommittish..toCommittish in the format of opts and save it to filepath
+//
+// PatchFormatFormatPatch fails if there are merges in the range, which 'git format-patch' would drop silently.
+func CreateDiffBundleFile(dir, filepath, fromCommittish, toCommittish string, opts PatchFormatOptions) errors.GitGhostError {
+	ggerr := opts.Validate()
+	if ggerr != nil {
+		return ggerr
+	}
+	if opts.Format == PatchFormatFormatPatch {
+		output, ggerr := util.JustOutputCmd(
+			exec.Command("git", "-C", dir, "rev-list", "--merges", "--count", fmt.Sprintf("%s..%s", fromCommittish, toCommittish)),
+		)
+		if ggerr != nil {
+			return ggerr
+		}
+		if merges := strings.TrimSpace(string(output)); merges != "0" {
+			return errors.Errorf("%s..%s has %s merge commits, which can't be in patch format %s. please use patch format %s or --bundle", fromCommittish, toCommittish, merges, PatchFormatFormatPatch, PatchFormatEmail)
+		}
+	}
+
 	f, err := os.OpenFile(filepath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 	defer util.LogDeferredError(f.Close)
 
+	if opts.Format != PatchFormatFormatPatch {
+		return WriteDiffBundle(dir, fromCommittish, toCommittish, f)
+	}
+	args := []string{"-C", dir, "format-patch", "--stdout", "--binary"}
+	if opts.NoSignature {
+		args = append(args, "--no-signature")
+	}
+	if opts.ZeroCommit {
+		args = append(args, "--zero-commit")
+	}
+	cmd := exec.Command("git", append(args, fmt.Sprintf("%s..%s", fromCommittish, toCommittish))...)
+	cmd.Stdout = f
+	return util.JustRunCmd(cmd)
+}
+
+// WriteDiffBundle writes patches for fromCommittish..toCommittish to writer in the format of CreateDiffBundleFile
+func WriteDiffBundle(dir, fromCommittish, toCommittish string, writer io.Writer) errors.GitGhostError {
 	cmd := exec.Command("git", "-C", dir,
 		"log", "-p", "--reverse", "--pretty=email", "--stat", "-m", "--first-parent", "--binary",
 		fmt.Sprintf("%s..%s", fromCommittish, toCommittish),
 	)
-	cmd.Stdout = f
+	cmd.Stdout = writer
+	return util.JustRunCmd(cmd)
+}
+
+// WriteLog writes 'git log' of fromCommittish..toCommittish with args to writer
+func WriteLog(dir, fromCommittish, toCommittish string, args []string, writer io.Writer) errors.GitGhostError {
+	args = append(append([]string{"--no-pager", "-C", dir, "log"}, args...), fmt.Sprintf("%s..%s", fromCommittish, toCommittish))
+	cmd := exec.Command("git", args...)
+	cmd.Stdout = writer
 	return util.JustRunCmd(cmd)
 }
 
+// FormatPatches writes patches for fromCommittish..toCommittish into outputDir as a numbered series by 'git format-patch' and returns their paths
+//
+// The series is named and formatted as 'git format-patch -o' does, following format.* git config of dir.
+func FormatPatches(dir, fromCommittish, toCommittish, outputDir string) ([]string, errors.GitGhostError) {
+	output, ggerr := util.JustOutputCmd(
+		exec.Command("git", "-C", dir, "format-patch", "--binary", "-o", outputDir, fmt.Sprintf("%s..%s", fromCommittish, toCommittish)),
+	)
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	paths := []string{}
+	for _, line := range strings.Split(string(output), "\n") {
+		if line != "" {
+			paths = append(paths, line)
+		}
+	}
+	return paths, nil
+}
+
+// commitGhostBundleRef is a temporary ref to create and fetch a bundle by CreateCommitGhostBundle
+const commitGhostBundleRef = "refs/git-ghost/bundle"
+
+// CreateCommitGhostBundle creates a git bundle of commits in fromCommittish..toCommittish and save it to filepath
+//
+// The bundle contains a single ref, which is created temporarily on dir because a bundle can not be created only from hashes.
+func CreateCommitGhostBundle(dir, filepath, fromCommittish, toCommittish string) errors.GitGhostError {
+	err := util.JustRunCmd(exec.Command("git", "-C", dir, "update-ref", "--no-deref", commitGhostBundleRef, toCommittish))
+	if err != nil {
+		return err
+	}
+	defer util.LogDeferredGitGhostError(func() errors.GitGhostError { return deleteRef(dir, commitGhostBundleRef) })
+	return util.JustRunCmd(
+		exec.Command("git", "-C", dir, "bundle", "create", "-q", filepath, fmt.Sprintf("%s..%s", fromCommittish, commitGhostBundleRef)),
+	)
+}
+
+// InvalidBundleError is an error for a bundle which fails 'git bundle verify', e.g. a truncated bundle or one whose prerequisite commits are missing
+type InvalidBundleError struct {
+	reason string
+}
+
+func (e *InvalidBundleError) Error() string {
+	return fmt.Sprintf("invalid ghost bundle, which is not applied: %s", e.reason)
+}
+
+// VerifyCommitGhostBundle verifies a bundle created by CreateCommitGhostBundle can be fetched into dir
+//
+// It checks only the header and prerequisites of the bundle, so truncated packs are detected on fetching.
+// It returns an error whose cause is *InvalidBundleError if the bundle is invalid.
+func VerifyCommitGhostBundle(dir, filepath string) errors.GitGhostError {
+	return asInvalidBundleError(util.JustRunCmd(exec.Command("git", "-C", dir, "bundle", "verify", "-q", filepath)))
+}
+
+func asInvalidBundleError(err errors.GitGhostError) errors.GitGhostError {
+	if err == nil || util.CommandContextDone() {
+		// errors by timeout or cancellation are kept as they are
+		return err
+	}
+	return errors.WithStack(&InvalidBundleError{reason: strings.TrimSpace(err.Error())})
+}
+
+// FetchCommitGhostBundle verifies a bundle created by CreateCommitGhostBundle and fetches it into dir
+//
+// It returns the hash of the last commit in the bundle. The prerequisite commits of the bundle must exist on dir.
+// It returns an error whose cause is *InvalidBundleError if the bundle is invalid, and nothing is changed in dir then.
+func FetchCommitGhostBundle(dir, filepath string) (string, errors.GitGhostError) {
+	err := VerifyCommitGhostBundle(dir, filepath)
+	if err != nil {
+		return "", err
+	}
+	err = asInvalidBundleError(util.JustRunCmd(
+		exec.Command("git", "-C", dir, "fetch", "-q", "--no-tags", filepath, fmt.Sprintf("+%s:%s", commitGhostBundleRef, commitGhostBundleRef)),
+	))
+	if err != nil {
+		return "", err
+	}
+	defer util.LogDeferredGitGhostError(func() errors.GitGhostError { return deleteRef(dir, commitGhostBundleRef) })
+	return ResolveCommittish(dir, commitGhostBundleRef)
+}
+
+// ApplyCommitGhostBundle applies a bundle created by CreateCommitGhostBundle by fast-forwarding HEAD of dir
+func ApplyCommitGhostBundle(dir, filepath string) errors.GitGhostError {
+	hash, err := FetchCommitGhostBundle(dir, filepath)
+	if err != nil {
+		return err
+	}
+	return FastForward(dir, hash)
+}
+
+// FastForward fast-forwards HEAD of dir to committish
+func FastForward(dir, committish string) errors.GitGhostError {
+	return errors.WithCategory(util.JustRunCmd(
+		exec.Command("git", "-C", dir, "merge", "-q", "--ff-only", committish),
+	), errors.CategoryConflict)
+}
+
+func deleteRef(dir, ref string) errors.GitGhostError {
+	return util.JustRunCmd(exec.Command("git", "-C", dir, "update-ref", "-d", ref))
+}
+
+// GetPatchID returns a stable hash over patch ids of all the commits in a patch file created in CreateDiffBundleFile
+//
+// It is the same for commits which introduce the same changes in the same order even if their hashes differ (e.g. rebased ones).
+// It returns an empty string if the patch file contains no changes.
+func GetPatchID(dir, filepath string) (string, errors.GitGhostError) {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer util.LogDeferredError(f.Close)
+
+	cmd := exec.Command("git", "-C", dir, "patch-id", "--stable")
+	cmd.Stdin = f
+	output, ggerr := util.JustOutputCmd(cmd)
+	if ggerr != nil {
+		return "", ggerr
+	}
+	patchIDs := []string{}
+	for _, line := range strings.Split(string(output), "\n") {
+		tokens := strings.Fields(line)
+		if len(tokens) == 0 {
+			continue
+		}
+		patchIDs = append(patchIDs, tokens[0])
+	}
+	if len(patchIDs) == 0 {
+		return "", nil
+	}
+	return util.GenerateStringsHash(patchIDs...), nil
+}
+
+const (
+	anonymousAuthor = "From: Anonymous <anonymous@example.com>\n"
+	anonymousDate   = "Date: Thu, 1 Jan 1970 00:00:00 +0000\n"
+)
+
+var emailPatchStartPattern = regexp.MustCompile(`^From [0-9a-f]{40} `)
+
+// AnonymizeDiffBundleFile rewrites author information in headers of a patch file created in CreateDiffBundleFile
+//
+// If anonymizeDates is true, author dates are also replaced with the epoch.
+func AnonymizeDiffBundleFile(filepath string, anonymizeDates bool) errors.GitGhostError {
+	src, err := os.Open(filepath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer util.LogDeferredError(src.Close)
+	dstPath := filepath + ".anonymized"
+	dst, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer util.LogDeferredError(func() error { return os.Remove(dstPath) })
+
+	reader := bufio.NewReader(src)
+	writer := bufio.NewWriter(dst)
+	inHeader := false
+	inReplacedHeader := false
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			switch {
+			case emailPatchStartPattern.MatchString(line):
+				inHeader = true
+				inReplacedHeader = false
+			case !inHeader:
+			case line == "\n":
+				inHeader = false
+				inReplacedHeader = false
+			case inReplacedHeader && (line[0] == ' ' || line[0] == '\t'):
+				// skip folded lines of the replaced header
+				continue
+			case strings.HasPrefix(line, "From: "):
+				line = anonymousAuthor
+				inReplacedHeader = true
+			case anonymizeDates && strings.HasPrefix(line, "Date: "):
+				line = anonymousDate
+				inReplacedHeader = true
+			default:
+				inReplacedHeader = false
+			}
+			_, werr := writer.WriteString(line)
+			if werr != nil {
+				util.LogDeferredError(dst.Close)
+				return errors.WithStack(werr)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			util.LogDeferredError(dst.Close)
+			return errors.WithStack(err)
+		}
+	}
+	err = writer.Flush()
+	if err != nil {
+		util.LogDeferredError(dst.Close)
+		return errors.WithStack(err)
+	}
+	err = dst.Close()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(dstPath, filepath))
+}
+
+// AddTrailersToDiffBundleFile appends trailers (in the form of "Key: value") to the message of every patch in a patch file created in CreateDiffBundleFile
+//
+// Each patch is passed through 'git interpret-trailers', which puts the trailers after the existing ones before the '---' line.
+func AddTrailersToDiffBundleFile(filepath string, trailers []string) errors.GitGhostError {
+	args := []string{"interpret-trailers"}
+	for _, t := range trailers {
+		args = append(args, "--trailer", t)
+	}
+	return rewritePatchFile(filepath, isEmailPatchStart, func(patch string) (string, errors.GitGhostError) {
+		if !isEmailPatchStart(patch) {
+			return patch, nil
+		}
+		cmd := exec.Command("git", args...)
+		cmd.Stdin = strings.NewReader(patch)
+		output, ggerr := util.JustOutputCmd(cmd)
+		return string(output), ggerr
+	})
+}
+
+// PatchPathOptions represents options to rebase paths in a patch on applying it, e.g. into a subdirectory of another repo
+type PatchPathOptions struct {
+	// Directory is prepended to paths in the patch if not empty. It is relative to the top of the repo.
+	Directory string
+	// Strip is the number of leading components removed from paths in the patch like 'git apply -p' (default to 1, which removes "a/" and "b/")
+	Strip int
+}
+
+func (opts PatchPathOptions) args() []string {
+	args := []string{}
+	if opts.Strip > 1 {
+		args = append(args, fmt.Sprintf("-p%d", opts.Strip))
+	}
+	if opts.Directory != "" {
+		args = append(args, fmt.Sprintf("--directory=%s", opts.Directory))
+	}
+	return args
+}
+
 // ApplyDiffBundleFile apply a patch file created in CreateDiffBundleFile
-func ApplyDiffBundleFile(dir, filepath string) errors.GitGhostError {
+func ApplyDiffBundleFile(dir, filepath string, pathOpts PatchPathOptions) errors.GitGhostError {
+	return applyDiffBundleFile(dir, filepath, pathOpts.args()...)
+}
+
+// ApplyDiffBundleFileWith3way apply a patch file created in CreateDiffBundleFile falling back on 3-way merges like 'git am --3way'
+//
+// It requires blobs which the patches are based on to be in the repo of dir.
+func ApplyDiffBundleFileWith3way(dir, filepath string, pathOpts PatchPathOptions) errors.GitGhostError {
+	return applyDiffBundleFile(dir, filepath, append([]string{"--3way"}, pathOpts.args()...)...)
+}
+
+func applyDiffBundleFile(dir, filepath string, flags ...string) errors.GitGhostError {
 	var errs error
+	args := append(append([]string{"-C", dir, "am"}, flags...), filepath)
 	err := util.JustRunCmd(
-		exec.Command("git", "-C", dir, "am", filepath),
+		exec.Command("git", args...),
 	)
 	if err != nil {
 		errs = multierror.Append(errs, err)
@@ -63,22 +401,360 @@ func ApplyDiffBundleFile(dir, filepath string) errors.GitGhostError {
 			errs = multierror.Append(errs, resetErr)
 		}
 	}
-	return errors.WithStack(errs)
+	return errors.WithCategory(errs, errors.CategoryConflict)
+}
+
+// CherryPickDiffBundleFile applies patches created in CreateDiffBundleFile by cherry-picking them with a merge strategy option (e.g. "theirs")
+//
+// The patches are committed on base in a temporary worktree first, so they have to apply cleanly to base itself.
+// Conflicts with commits after base are resolved by strategyOption, and the cherry-pick is aborted if they still can't be.
+func CherryPickDiffBundleFile(dir, filepath, base, strategyOption string) errors.GitGhostError {
+	var head string
+	ggerr := WithTemporaryWorktree(dir, base, func(worktree string) errors.GitGhostError {
+		ggerr := util.JustRunCmd(
+			exec.Command("git", "-C", worktree, "am", filepath),
+		)
+		if ggerr != nil {
+			return errors.WithCategory(errors.Errorf("commits can not be recreated on %s before cherry-picking: %s", base, ggerr), errors.CategoryConflict)
+		}
+		head, ggerr = ResolveCommittish(worktree, "HEAD")
+		return ggerr
+	})
+	if ggerr != nil {
+		return ggerr
+	}
+	ggerr = util.JustRunCmd(
+		exec.Command("git", "-C", dir, "cherry-pick", "-X", strategyOption, fmt.Sprintf("%s..%s", base, head)),
+	)
+	if ggerr != nil {
+		var errs error
+		errs = multierror.Append(errs, ggerr)
+		log.WithFields(util.MergeFields(
+			log.Fields{
+				"srcDir":         dir,
+				"filepath":       filepath,
+				"strategyOption": strategyOption,
+				"error":          ggerr.Error(),
+			})).Info("apply('git cherry-pick') failed. aborting.")
+		abortErr := util.JustRunCmd(
+			exec.Command("git", "-C", dir, "cherry-pick", "--abort"),
+		)
+		if abortErr != nil {
+			errs = multierror.Append(errs, abortErr)
+		}
+		return errors.WithCategory(errs, errors.CategoryConflict)
+	}
+	return nil
+}
+
+// worktreeLocks serializes adding and removing worktrees of each repo (by its directory),
+// which race inside git on administrative files of worktrees when run at the same time
+var worktreeLocks sync.Map
+
+func lockWorktrees(dir string) func() {
+	lock, _ := worktreeLocks.LoadOrStore(dir, &sync.Mutex{})
+	mutex := lock.(*sync.Mutex)
+	mutex.Lock()
+	return mutex.Unlock
+}
+
+// WithTemporaryWorktree calls f with a temporary worktree of dir whose HEAD is detached at committish
+//
+// The worktree is removed after f returns, whether it succeeds or not. Worktrees of the same dir can be used in parallel,
+// though they are added and removed one by one.
+func WithTemporaryWorktree(dir, committish string, f func(worktree string) errors.GitGhostError) errors.GitGhostError {
+	// 'git worktree remove' is the newest subcommand used
+	ggerr := RequireVersion("a temporary worktree", 2, 17)
+	if ggerr != nil {
+		return ggerr
+	}
+	worktree, err := ioutil.TempDir(util.TempDir(), "git-ghost-worktree")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer util.LogDeferredError(func() error { return os.RemoveAll(worktree) })
+	unlock := lockWorktrees(dir)
+	ggerr = util.JustRunCmd(
+		exec.Command("git", "-C", dir, "worktree", "add", "--detach", worktree, committish),
+	)
+	unlock()
+	if ggerr != nil {
+		return ggerr
+	}
+	defer util.LogDeferredGitGhostError(func() errors.GitGhostError {
+		defer lockWorktrees(dir)()
+		return util.JustRunCmd(exec.Command("git", "-C", dir, "worktree", "remove", "--force", worktree))
+	})
+	return f(worktree)
+}
+
+// WriteWorktreeState writes a tree object of the whole state of a temporary worktree and returns its hash
+//
+// All the files including untracked and ignored ones are staged, so it must not be called on a worktree of a user.
+func WriteWorktreeState(worktree string) (string, errors.GitGhostError) {
+	ggerr := util.JustRunCmd(exec.Command("git", "-C", worktree, "add", "--all", "--force"))
+	if ggerr != nil {
+		return "", ggerr
+	}
+	return writeTree(worktree, nil)
 }
 
 // CreateDiffPatchFile creates a diff from committish to current working state of `dir` and save it to filepath
-func CreateDiffPatchFile(dir, filepath, committish string) errors.GitGhostError {
+func CreateDiffPatchFile(dir, filepath, committish string, opts DiffOptions) errors.GitGhostError {
 	f, err := os.OpenFile(filepath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 	defer util.LogDeferredError(f.Close)
 
-	cmd := exec.Command("git", "-C", dir, "diff", "--patience", "--binary", committish)
-	cmd.Stdout = f
+	pathspecs, ggerr := diffPathspecs(dir, opts, committish)
+	if ggerr != nil {
+		return ggerr
+	}
+	return writeDiff(dir, opts, []string{committish}, pathspecs, f)
+}
+
+// CreateTreeDiffPatchFile creates a diff from treeFrom to treeTo and save it to filepath
+func CreateTreeDiffPatchFile(dir, filepath, treeFrom, treeTo string, opts DiffOptions) errors.GitGhostError {
+	f, err := os.OpenFile(filepath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer util.LogDeferredError(f.Close)
+
+	pathspecs, ggerr := diffPathspecs(dir, opts, treeFrom, treeTo)
+	if ggerr != nil {
+		return ggerr
+	}
+	return writeDiff(dir, opts, []string{treeFrom, treeTo}, pathspecs, f)
+}
+
+// writeDiff writes a diff of git diff with revs and pathspecs in opts to writer
+//
+// With opts.IgnoreLines, files which are created or deleted are diffed without it, since a file created or deleted
+// only by ignored lines would be left as a section without hunks, which doesn't apply.
+func writeDiff(dir string, opts DiffOptions, revs, pathspecs []string, writer io.Writer) errors.GitGhostError {
+	args := append([]string{"-C", dir, "diff", "--patience", "--binary"}, opts.contextArgs()...)
+	wholeFiles := []string{}
+	if len(opts.IgnoreLines) > 0 {
+		var ggerr errors.GitGhostError
+		wholeFiles, ggerr = createdOrDeletedFiles(dir, revs, pathspecs)
+		if ggerr != nil {
+			return ggerr
+		}
+		if len(wholeFiles) > 0 && len(pathspecs) == 0 {
+			pathspecs = []string{"--", ":/"}
+		}
+		for _, p := range wholeFiles {
+			pathspecs = append(pathspecs, ":(top,literal,exclude)"+p)
+		}
+	}
+	filtered := append(append([]string{}, args...), opts.ignoreLinesArgs()...)
+	cmd := exec.Command("git", append(append(filtered, revs...), pathspecs...)...)
+	cmd.Stdout = writer
+	ggerr := util.JustRunCmd(cmd)
+	if ggerr != nil || len(wholeFiles) == 0 {
+		return ggerr
+	}
+	args = append(append(args, revs...), "--")
+	for _, p := range wholeFiles {
+		args = append(args, ":(top,literal)"+p)
+	}
+	cmd = exec.Command("git", args...)
+	cmd.Stdout = writer
 	return util.JustRunCmd(cmd)
 }
 
+// createdOrDeletedFiles returns paths (relative to the top of the repo) of files created or deleted in git diff with revs and pathspecs
+func createdOrDeletedFiles(dir string, revs, pathspecs []string) ([]string, errors.GitGhostError) {
+	args := append(append([]string{"-C", dir, "diff", "--name-only", "-z", "--diff-filter=AD"}, revs...), pathspecs...)
+	output, ggerr := util.JustOutputCmd(exec.Command("git", args...))
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	return splitNulls(string(output)), nil
+}
+
+// WriteTreeDiff writes a diff between two tree objects on dir to writer
+func WriteTreeDiff(dir, treeFrom, treeTo string, writer io.Writer) errors.GitGhostError {
+	pathspecs, ggerr := diffPathspecs(dir, DiffOptions{}, treeFrom, treeTo)
+	if ggerr != nil {
+		return ggerr
+	}
+	args := append([]string{"-C", dir, "diff", treeFrom, treeTo}, pathspecs...)
+	cmd := exec.Command("git", args...)
+	cmd.Stdout = writer
+	return util.JustRunCmd(cmd)
+}
+
+// WritePatchedTree writes a tree object of committish with patch files applied sequentially and returns its hash
+//
+// It uses a temporary index so that neither the index nor the working tree of dir is modified.
+func WritePatchedTree(dir, committish string, patchFilepaths []string) (string, errors.GitGhostError) {
+	return writePatchedTree(dir, committish, patchFilepaths, nil)
+}
+
+// HashPatchedTree returns a hash of the tree object which WritePatchedTree would write without writing any object into dir
+//
+// Objects are written into a temporary object directory instead, which reads objects of dir as alternates.
+func HashPatchedTree(dir, committish string, patchFilepaths []string) (string, errors.GitGhostError) {
+	objects, ggerr := ResolveGitPath(dir, "objects")
+	if ggerr != nil {
+		return "", ggerr
+	}
+	if alternates := os.Getenv("GIT_ALTERNATE_OBJECT_DIRECTORIES"); alternates != "" {
+		objects = objects + string(os.PathListSeparator) + alternates
+	}
+	objectDir, err := ioutil.TempDir(util.TempDir(), "git-ghost-objects")
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer util.LogDeferredError(func() error { return os.RemoveAll(objectDir) })
+	return writePatchedTree(dir, committish, patchFilepaths, []string{
+		fmt.Sprintf("GIT_OBJECT_DIRECTORY=%s", objectDir),
+		fmt.Sprintf("GIT_ALTERNATE_OBJECT_DIRECTORIES=%s", objects),
+	})
+}
+
+func writePatchedTree(dir, committish string, patchFilepaths []string, objectEnv []string) (string, errors.GitGhostError) {
+	indexFile, err := ioutil.TempFile(util.TempDir(), "git-ghost-index")
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	util.LogDeferredError(indexFile.Close)
+	defer util.LogDeferredError(func() error { return os.Remove(indexFile.Name()) })
+	env := append(indexEnv(indexFile.Name()), objectEnv...)
+
+	ggerr := util.JustRunCmd(withEnv(exec.Command("git", "-C", dir, "read-tree", committish), env))
+	if ggerr != nil {
+		return "", ggerr
+	}
+	for _, p := range patchFilepaths {
+		size, ggerr := util.FileSize(p)
+		if ggerr != nil {
+			return "", ggerr
+		}
+		if size == 0 {
+			continue
+		}
+		args, ggerr := applyContextArgs(p)
+		if ggerr != nil {
+			return "", ggerr
+		}
+		args = append(append([]string{"-C", dir, "apply", "--cached"}, args...), p)
+		ggerr = util.JustRunCmd(withEnv(exec.Command("git", args...), env))
+		if ggerr != nil {
+			return "", ggerr
+		}
+	}
+	return writeTree(dir, env)
+}
+
+// WriteAppliedTree writes a tree object of committish with paths replaced by their states in the working tree of dir and returns its hash
+//
+// Paths missing in the working tree are removed from the tree, and files are staged through their clean filters as 'git add' does.
+// It uses a temporary index so that the index of dir is not modified.
+func WriteAppliedTree(dir, committish string, paths []string) (string, errors.GitGhostError) {
+	indexFile, err := ioutil.TempFile(util.TempDir(), "git-ghost-index")
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	util.LogDeferredError(indexFile.Close)
+	defer util.LogDeferredError(func() error { return os.Remove(indexFile.Name()) })
+	env := indexEnv(indexFile.Name())
+
+	ggerr := util.JustRunCmd(withEnv(exec.Command("git", "-C", dir, "read-tree", committish), env))
+	if ggerr != nil {
+		return "", ggerr
+	}
+	if len(paths) > 0 {
+		cmd := withEnv(exec.Command("git", "-C", dir, "update-index", "--add", "--remove", "-z", "--stdin"), env)
+		cmd.Stdin = strings.NewReader(strings.Join(paths, "\x00") + "\x00")
+		ggerr = util.JustRunCmd(cmd)
+		if ggerr != nil {
+			return "", ggerr
+		}
+	}
+	return writeTree(dir, env)
+}
+
+// TreeExists checks a tree object exists in dir
+func TreeExists(dir, tree string) bool {
+	return util.JustRunCmd(exec.Command("git", "-C", dir, "cat-file", "-e", tree+"^{tree}")) == nil
+}
+
+// ListTreeDiffPaths returns paths of files which differ between two tree objects on dir
+func ListTreeDiffPaths(dir, treeFrom, treeTo string) ([]string, errors.GitGhostError) {
+	output, ggerr := util.JustOutputCmd(exec.Command("git", "-C", dir, "diff-tree", "-r", "--name-only", "--no-renames", "-z", treeFrom, treeTo))
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	return splitNulls(string(output)), nil
+}
+
+// WriteWorkingTree writes a tree object of the current working state of dir and returns its hash
+//
+// The tree contains modifications of indexed files and non-indexed files specified by nonIndexedFilepaths.
+// It uses a temporary index so that the index of dir is not modified.
+func WriteWorkingTree(dir string, nonIndexedFilepaths []string) (string, errors.GitGhostError) {
+	indexFile, err := ioutil.TempFile(util.TempDir(), "git-ghost-index")
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer util.LogDeferredError(func() error { return os.Remove(indexFile.Name()) })
+
+	// Start from the current index to keep the same indexed files as 'git diff'
+	indexPath, ggerr := ResolveGitPath(dir, "index")
+	if ggerr != nil {
+		util.LogDeferredError(indexFile.Close)
+		return "", ggerr
+	}
+	index, err := os.Open(indexPath)
+	if err == nil {
+		_, err = io.Copy(indexFile, index)
+		util.LogDeferredError(index.Close)
+	}
+	util.LogDeferredError(indexFile.Close)
+	if os.IsNotExist(err) {
+		// git fails with an empty index file, so let git create it
+		err = os.Remove(indexFile.Name())
+	}
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	env := indexEnv(indexFile.Name())
+
+	ggerr = util.JustRunCmd(withEnv(exec.Command("git", "-C", dir, "add", "-u"), env))
+	if ggerr != nil {
+		return "", ggerr
+	}
+	if len(nonIndexedFilepaths) > 0 {
+		args := append([]string{"-C", dir, "add", "-f", "--"}, nonIndexedFilepaths...)
+		ggerr = util.JustRunCmd(withEnv(exec.Command("git", args...), env))
+		if ggerr != nil {
+			return "", ggerr
+		}
+	}
+	return writeTree(dir, env)
+}
+
+func writeTree(dir string, env []string) (string, errors.GitGhostError) {
+	output, err := util.JustOutputCmd(withEnv(exec.Command("git", "-C", dir, "write-tree"), env))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(output), "\r\n"), nil
+}
+
+func indexEnv(indexFile string) []string {
+	return []string{fmt.Sprintf("GIT_INDEX_FILE=%s", indexFile)}
+}
+
+func withEnv(cmd *exec.Cmd, env []string) *exec.Cmd {
+	cmd.Env = append(os.Environ(), env...)
+	return cmd
+}
+
 // AppendNonIndexedDiffFiles appends non-indexed diff files
 func AppendNonIndexedDiffFiles(dir, filepath string, nonIndexedFilepaths []string) errors.GitGhostError {
 	f, err := os.OpenFile(filepath, os.O_APPEND|os.O_WRONLY, 0600)
@@ -104,7 +780,28 @@ func AppendNonIndexedDiffFiles(dir, filepath string, nonIndexedFilepaths []strin
 }
 
 // ApplyDiffPatchFile apply a diff file created by CreateDiffPatchFile
-func ApplyDiffPatchFile(dir, filepath string) errors.GitGhostError {
+func ApplyDiffPatchFile(dir, filepath string, pathOpts PatchPathOptions) errors.GitGhostError {
+	return applyDiffPatchFile(dir, filepath, pathOpts.args()...)
+}
+
+// ApplyDiffPatchFileWithIndex apply a diff file created by CreateDiffPatchFile to both the index and the working tree
+func ApplyDiffPatchFileWithIndex(dir, filepath string, pathOpts PatchPathOptions) errors.GitGhostError {
+	return applyDiffPatchFile(dir, filepath, append([]string{"--index"}, pathOpts.args()...)...)
+}
+
+// ApplyDiffPatchFileWithReject apply a diff file created by CreateDiffPatchFile leaving hunks which fail to apply in *.rej files
+//
+// It still returns an error if any hunk is rejected.
+func ApplyDiffPatchFileWithReject(dir, filepath string, pathOpts PatchPathOptions) errors.GitGhostError {
+	return applyDiffPatchFile(dir, filepath, append([]string{"--reject"}, pathOpts.args()...)...)
+}
+
+// RevertDiffPatchFile reverts a diff file created by CreateDiffPatchFile which is applied on dir like 'git apply --reverse'
+func RevertDiffPatchFile(dir, filepath string, pathOpts PatchPathOptions) errors.GitGhostError {
+	return applyDiffPatchFile(dir, filepath, append([]string{"--reverse"}, pathOpts.args()...)...)
+}
+
+func applyDiffPatchFile(dir, filepath string, flags ...string) errors.GitGhostError {
 	// Handle empty patch
 	fi, err := os.Stat(filepath)
 	if err != nil {
@@ -118,7 +815,136 @@ func ApplyDiffPatchFile(dir, filepath string) errors.GitGhostError {
 			})).Info("ignore empty patch")
 		return nil
 	}
-	return util.JustRunCmd(
-		exec.Command("git", "-C", dir, "apply", filepath),
+	contextArgs, ggerr := applyContextArgs(filepath)
+	if ggerr != nil {
+		return ggerr
+	}
+	args := append(append(append([]string{"-C", dir, "apply"}, contextArgs...), flags...), filepath)
+	return errors.WithCategory(util.JustRunCmd(
+		exec.Command("git", args...),
+	), errors.CategoryConflict)
+}
+
+// applyContextArgs returns git apply options to apply a patch file, which needs --unidiff-zero if it has no context lines
+func applyContextArgs(filepath string) ([]string, errors.GitGhostError) {
+	zero, ggerr := hasZeroContext(filepath)
+	if ggerr != nil || !zero {
+		return []string{}, ggerr
+	}
+	return []string{"--unidiff-zero"}, nil
+}
+
+// hasZeroContext checks a patch file has hunks but none of them has context lines, like one created by 'git diff --unified=0'
+//
+// git apply refuses such a patch unless --unidiff-zero, which loosens checks of the other patches, so it is given only to them.
+func hasZeroContext(filepath string) (bool, errors.GitGhostError) {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	defer util.LogDeferredError(f.Close)
+	reader := bufio.NewReader(f)
+	inHunk := false
+	hunks := false
+	for {
+		line, err := reader.ReadString('\n')
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			inHunk = false
+		case strings.HasPrefix(line, "@@ "):
+			inHunk = true
+			hunks = true
+		case inHunk && strings.HasPrefix(line, " "):
+			return false, nil
+		}
+		if err == io.EOF {
+			return hunks, nil
+		}
+		if err != nil {
+			return false, errors.WithStack(err)
+		}
+	}
+}
+
+// ListPatchPaths returns paths which a patch file (a diff or patches created by format-patch) touches on dir
+//
+// The paths are rebased by pathOpts as they are on applying.
+func ListPatchPaths(dir, filepath string, pathOpts PatchPathOptions) ([]string, errors.GitGhostError) {
+	fileStats, ggerr := ListPatchFileStats(dir, filepath, pathOpts)
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	paths := []string{}
+	for _, fs := range fileStats {
+		if fs.OldPath != "" {
+			paths = append(paths, fs.OldPath)
+		}
+		paths = append(paths, fs.Path)
+	}
+	return util.UniqueStringSlice(paths), nil
+}
+
+// PatchFileStat represents changes of a file in a patch file
+type PatchFileStat struct {
+	// Path is a path of the file after applying
+	Path string `json:"path"`
+	// OldPath is a path of the file before applying if it is renamed
+	OldPath string `json:"oldPath,omitempty"`
+	// Added is the number of added lines (always 0 for a binary file)
+	Added int `json:"added"`
+	// Deleted is the number of deleted lines (always 0 for a binary file)
+	Deleted int `json:"deleted"`
+	// Binary is true if the file is binary
+	Binary bool `json:"binary,omitempty"`
+}
+
+// ListPatchFileStats returns changes of every file which a patch file (a diff or patches created by format-patch) touches on dir
+//
+// Changes of a file in multiple patches are summed up. The paths are rebased by pathOpts as they are on applying.
+func ListPatchFileStats(dir, filepath string, pathOpts PatchPathOptions) ([]PatchFileStat, errors.GitGhostError) {
+	fi, err := os.Stat(filepath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if fi.Size() == 0 {
+		return []PatchFileStat{}, nil
+	}
+	args := append(append([]string{"-C", dir, "apply", "--numstat", "-z"}, pathOpts.args()...), filepath)
+	output, ggerr := util.JustOutputCmd(
+		exec.Command("git", args...),
 	)
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	// each entry is "<added>\t<deleted>\t<path>\0", or "<added>\t<deleted>\t\0<old path>\0<new path>\0" for a rename
+	fileStats := []PatchFileStat{}
+	indices := map[string]int{}
+	tokens := strings.Split(string(output), "\x00")
+	for i := 0; i < len(tokens); i++ {
+		fields := strings.SplitN(tokens[i], "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		fs := PatchFileStat{Path: fields[2]}
+		if fs.Path == "" && i+2 < len(tokens) {
+			fs.OldPath = tokens[i+1]
+			fs.Path = tokens[i+2]
+			i += 2
+		}
+		if fields[0] == "-" {
+			fs.Binary = true
+		} else {
+			fs.Added, _ = strconv.Atoi(fields[0])
+			fs.Deleted, _ = strconv.Atoi(fields[1])
+		}
+		if index, ok := indices[fs.Path]; ok {
+			fileStats[index].Added += fs.Added
+			fileStats[index].Deleted += fs.Deleted
+			fileStats[index].Binary = fileStats[index].Binary || fs.Binary
+			continue
+		}
+		indices[fs.Path] = len(fileStats)
+		fileStats = append(fileStats, fs)
+	}
+	return fileStats, nil
 }
@@ -0,0 +1,71 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"bytes"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// FsckRepo returns errors reported by 'git fsck' on repo, which is checked only if it is a local repo
+//
+// The second return value reports whether repo is checked or not.
+func FsckRepo(repo string) ([]string, bool, errors.GitGhostError) {
+	path, ok := localRepoPath(repo)
+	if !ok {
+		return nil, false, nil
+	}
+	var output bytes.Buffer
+	// fsck exits with non-zero on errors, which are all in its output
+	ggerr := util.StreamCmd(exec.Command("git", "-C", path, "fsck", "--no-dangling", "--no-progress"), &output)
+	if ggerr == nil {
+		return []string{}, true, nil
+	}
+	problems := []string{}
+	for _, line := range strings.Split(output.String(), "\n") {
+		// notices like "HEAD points to an unborn branch" are not errors
+		if line != "" && !strings.HasPrefix(line, "notice: ") {
+			problems = append(problems, line)
+		}
+	}
+	if len(problems) == 0 {
+		return nil, true, ggerr
+	}
+	return problems, true, nil
+}
+
+// PruneRepo removes unreachable objects older than expire from repo if it is a local repo
+//
+// The return value reports whether repo is pruned or not.
+func PruneRepo(repo, expire string) (bool, errors.GitGhostError) {
+	path, ok := localRepoPath(repo)
+	if !ok {
+		return false, nil
+	}
+	return true, util.JustRunCmd(exec.Command("git", "-C", path, "prune", "--expire", expire))
+}
+
+// regexpObjectError matches errors of git on objects which are missing or corrupt, e.g. of fetching a branch whose history is broken
+var regexpObjectError = regexp.MustCompile(`(?:missing|bad) (?:(?:blob|tree|commit|tag) )?object|unable to read [0-9a-f]{40}|possible repository corruption|not our ref|did not send all necessary objects|did not receive expected object`)
+
+// IsObjectError reports whether err is of missing or corrupt objects rather than of talking to a remote repo
+func IsObjectError(err error) bool {
+	return err != nil && regexpObjectError.MatchString(err.Error())
+}
@@ -0,0 +1,99 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// FuzzedHunk represents a hunk which 'patch' applied ignoring some lines of its context
+type FuzzedHunk struct {
+	// Path is a path of the patched file in the repo
+	Path string `json:"path"`
+	// Hunk is the number of the hunk in the file starting from 1
+	Hunk int `json:"hunk"`
+	// Line is the line where the hunk is applied
+	Line int `json:"line"`
+	// Fuzz is the number of ignored lines of context
+	Fuzz int `json:"fuzz"`
+}
+
+func (h FuzzedHunk) String() string {
+	return fmt.Sprintf("%s: hunk #%d applied at line %d with fuzz %d", h.Path, h.Hunk, h.Line, h.Fuzz)
+}
+
+var (
+	regexpPatchingFile = regexp.MustCompile(`^patching file (.+)$`)
+	regexpFuzzedHunk   = regexp.MustCompile(`^Hunk #([0-9]+) succeeded at ([0-9]+) with fuzz ([0-9]+)`)
+)
+
+// ApplyDiffPatchFileWithFuzz applies a diff file created by CreateDiffPatchFile by 'patch' ignoring up to fuzz lines of context of each hunk
+//
+// It is tried in a dry run first, so nothing is changed if any hunk fails. It returns hunks applied with fuzz,
+// which may be applied to wrong places. Binary hunks can't be applied by 'patch'.
+func ApplyDiffPatchFileWithFuzz(dir, patchFile string, pathOpts PatchPathOptions, fuzz int) ([]FuzzedHunk, errors.GitGhostError) {
+	if _, err := exec.LookPath("patch"); err != nil {
+		return nil, errors.New("patch command is not found, which is required to apply hunks with fuzz")
+	}
+	strip := pathOpts.Strip
+	if strip < 1 {
+		strip = 1
+	}
+	// 'patch' has no option to prepend a directory to paths, so it is run in the directory instead
+	args := []string{"-d", filepath.Join(dir, pathOpts.Directory), fmt.Sprintf("-p%d", strip), fmt.Sprintf("--fuzz=%d", fuzz), "--forward", "--batch", "--no-backup-if-mismatch", "-i", patchFile}
+	var output bytes.Buffer
+	ggerr := util.StreamCmd(exec.Command("patch", append([]string{"--dry-run"}, args...)...), &output)
+	if ggerr != nil {
+		return nil, errors.WithCategory(errors.Errorf("patch can't apply the diff even with fuzz %d: %s", fuzz, strings.TrimSpace(output.String())), errors.CategoryConflict)
+	}
+	output.Reset()
+	ggerr = util.StreamCmd(exec.Command("patch", args...), &output)
+	if ggerr != nil {
+		return nil, errors.WithCategory(errors.Errorf("patch failed to apply the diff with fuzz %d: %s", fuzz, strings.TrimSpace(output.String())), errors.CategoryConflict)
+	}
+	return parseFuzzedHunks(output.String(), pathOpts.Directory), nil
+}
+
+// parseFuzzedHunks parses the output of 'patch' into hunks applied with fuzz, prepending directory to their paths
+func parseFuzzedHunks(output, directory string) []FuzzedHunk {
+	hunks := []FuzzedHunk{}
+	file := ""
+	for _, line := range splitLines(output) {
+		if m := regexpPatchingFile.FindStringSubmatch(line); m != nil {
+			// newer versions of 'patch' quote paths with spaces
+			file = path.Join(directory, strings.Trim(m[1], "'"))
+			continue
+		}
+		m := regexpFuzzedHunk.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		hunk, _ := strconv.Atoi(m[1])
+		at, _ := strconv.Atoi(m[2])
+		fuzz, _ := strconv.Atoi(m[3])
+		hunks = append(hunks, FuzzedHunk{Path: file, Hunk: hunk, Line: at, Fuzz: fuzz})
+	}
+	return hunks
+}
@@ -17,6 +17,7 @@ package git
 import (
 	"fmt"
 	"os/exec"
+	"strconv"
 	"strings"
 
 	"github.com/pfnet-research/git-ghost/pkg/util"
@@ -38,7 +39,7 @@ func ListRemoteBranchNames(repo string, branchnames []string) ([]string, errors.
 		branchNamesToSearch = append(branchNamesToSearch, prefixed)
 	}
 	opts := append([]string{"ls-remote", "-q", "--heads", "--refs", repo}, branchNamesToSearch...)
-	output, err := util.JustOutputCmd(exec.Command("git", opts...))
+	output, err := outputRemoteCommand(opts...)
 	if err != nil {
 		return []string{}, errors.WithStack(err)
 	}
@@ -59,3 +60,84 @@ func ListRemoteBranchNames(repo string, branchnames []string) ([]string, errors.
 	}
 	return branchNames, nil
 }
+
+// StreamRemoteBranchNames calls f with each remote branch name as soon as the remote repo lists it
+//
+// Names come in lexicographic order of their refs as ls-remote lists them.
+func StreamRemoteBranchNames(repo string, branchnames []string, f func(name string) errors.GitGhostError) errors.GitGhostError {
+	if len(branchnames) == 0 {
+		return nil
+	}
+
+	branchNamesToSearch := []string{}
+	for _, b := range branchnames {
+		prefixed := b
+		if !strings.HasPrefix(b, "refs/heads/") {
+			prefixed = fmt.Sprintf("%s%s", "refs/heads/", b)
+		}
+		branchNamesToSearch = append(branchNamesToSearch, prefixed)
+	}
+	opts := append([]string{"ls-remote", "-q", "--heads", "--refs", repo}, branchNamesToSearch...)
+	return scanRemoteCommand(func(line string) errors.GitGhostError {
+		if line == "" {
+			return nil
+		}
+		tokens := strings.Fields(line)
+		if len(tokens) != 2 {
+			return errors.Errorf("Got unexpected line: %s", line)
+		}
+		// Assume it starts from "refs/heads/"
+		return f(tokens[1][11:])
+	}, opts...)
+}
+
+// ListRemoteRefHashes returns a map from full ref names matching patterns to their object hashes
+func ListRemoteRefHashes(repo string, patterns ...string) (map[string]string, errors.GitGhostError) {
+	opts := append([]string{"ls-remote", "-q", "--refs", repo}, patterns...)
+	output, err := outputRemoteCommand(opts...)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	refs := map[string]string{}
+	for _, line := range strings.Split(string(output), "\n") {
+		if line == "" {
+			continue
+		}
+		tokens := strings.Fields(line)
+		if len(tokens) != 2 {
+			return nil, errors.Errorf("Got unexpected line: %s", line)
+		}
+		refs[tokens[1]] = tokens[0]
+	}
+	return refs, nil
+}
+
+// GetTreeSize returns the total size of blobs in the tree of committish on dir
+func GetTreeSize(dir, committish string) (int64, errors.GitGhostError) {
+	output, err := util.JustOutputCmd(exec.Command("git", "-C", dir, "ls-tree", "-r", "-l", committish))
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	var size int64
+	for _, line := range strings.Split(string(output), "\n") {
+		if line == "" {
+			continue
+		}
+		// each line is formatted as "<mode> <type> <object> <size>\t<file>"
+		tokens := strings.Fields(strings.SplitN(line, "\t", 2)[0])
+		if len(tokens) != 4 {
+			return 0, errors.Errorf("Got unexpected line: %s", line)
+		}
+		if tokens[1] != "blob" {
+			continue
+		}
+		s, perr := strconv.ParseInt(tokens[3], 10, 64)
+		if perr != nil {
+			return 0, errors.WithStack(perr)
+		}
+		size += s
+	}
+	return size, nil
+}
@@ -0,0 +1,149 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// changedFile is a file changed by patches
+type changedFile struct {
+	// Status is one of A (added), D (deleted), M (modified), R (renamed) and C (copied) like 'git diff --name-status'
+	Status string
+	Path   string
+	// From is the source path of a renamed or copied file
+	From string
+}
+
+// NameStatusWriter is a writer which receives patches and writes only files changed by them like 'git diff --name-status'
+//
+// Changes of the same file over commits are combined into one, e.g. a file added and then modified is listed as added.
+// Flush must be called after all the writes to write the files.
+type NameStatusWriter struct {
+	writer   io.Writer
+	nameOnly bool
+	buffer   bytes.Buffer
+	files    map[string]*changedFile
+	current  *changedFile
+	// inHeader is true while lines of the current section are still before its hunks
+	inHeader bool
+}
+
+// NewNameStatusWriter returns a NameStatusWriter writing to writer, which writes only paths like 'git diff --name-only' if nameOnly is true
+func NewNameStatusWriter(writer io.Writer, nameOnly bool) *NameStatusWriter {
+	return &NameStatusWriter{writer: writer, nameOnly: nameOnly, files: map[string]*changedFile{}}
+}
+
+// Write parses complete lines in p and buffers the rest
+func (w *NameStatusWriter) Write(p []byte) (int, error) {
+	w.buffer.Write(p)
+	for {
+		i := bytes.IndexByte(w.buffer.Bytes(), '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		w.parseLine(strings.TrimSuffix(string(w.buffer.Next(i+1)), "\n"))
+	}
+}
+
+func (w *NameStatusWriter) parseLine(line string) {
+	if strings.HasPrefix(line, diffHeaderPrefix) {
+		w.endSection()
+		w.current = &changedFile{Status: "M", Path: diffTargetPath(line)}
+		w.inHeader = true
+		return
+	}
+	if w.current == nil || !w.inHeader {
+		return
+	}
+	switch {
+	case strings.HasPrefix(line, "new file mode "):
+		w.current.Status = "A"
+	case strings.HasPrefix(line, "deleted file mode "):
+		w.current.Status = "D"
+	case strings.HasPrefix(line, "rename from "):
+		w.current.Status = "R"
+		w.current.From = unquotePath(strings.TrimPrefix(line, "rename from "))
+	case strings.HasPrefix(line, "copy from "):
+		w.current.Status = "C"
+		w.current.From = unquotePath(strings.TrimPrefix(line, "copy from "))
+	case strings.HasPrefix(line, "rename to "):
+		w.current.Path = unquotePath(strings.TrimPrefix(line, "rename to "))
+	case strings.HasPrefix(line, "copy to "):
+		w.current.Path = unquotePath(strings.TrimPrefix(line, "copy to "))
+	case strings.HasPrefix(line, "--- "), strings.HasPrefix(line, "@@"),
+		line == binaryPatchMarker, strings.HasPrefix(line, "Binary files "):
+		w.inHeader = false
+	}
+}
+
+// endSection combines the current section into files changed so far
+func (w *NameStatusWriter) endSection() {
+	c := w.current
+	w.current = nil
+	if c == nil {
+		return
+	}
+	prev, ok := w.files[c.Path]
+	switch {
+	case !ok:
+		w.files[c.Path] = c
+	case c.Status == "D" && prev.Status == "A":
+		// a file added and then deleted is not changed at all
+		delete(w.files, c.Path)
+	case c.Status == "D":
+		prev.Status = "D"
+	case prev.Status == "D":
+		// a file deleted and then added again
+		prev.Status = "M"
+	}
+}
+
+// Flush writes the changed files sorted by their paths
+func (w *NameStatusWriter) Flush() errors.GitGhostError {
+	if w.buffer.Len() > 0 {
+		w.parseLine(string(w.buffer.Next(w.buffer.Len())))
+	}
+	w.endSection()
+	paths := make([]string, 0, len(w.files))
+	for path := range w.files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		f := w.files[path]
+		var line string
+		switch {
+		case w.nameOnly:
+			line = f.Path
+		case f.From != "":
+			line = fmt.Sprintf("%s\t%s\t%s", f.Status, f.From, f.Path)
+		default:
+			line = fmt.Sprintf("%s\t%s", f.Status, f.Path)
+		}
+		_, err := fmt.Fprintln(w.writer, line)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	w.files = map[string]*changedFile{}
+	return nil
+}
@@ -0,0 +1,65 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// PromisorRemote returns a promisor remote of dir, which objects missing in a partial clone are fetched from, or empty if dir is not a partial clone
+func PromisorRemote(dir string) (string, errors.GitGhostError) {
+	output, ggerr := util.JustOutputCmd(
+		exec.Command("git", "-C", dir, "config", "--bool", "--get-regexp", `^remote\..*\.promisor$`),
+	)
+	if ggerr != nil {
+		// exit 1 is for no promisor remotes
+		if util.GetExitCode(ggerr.Cause()) == 1 {
+			return "", nil
+		}
+		return "", ggerr
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[1] == "true" {
+			return strings.TrimSuffix(strings.TrimPrefix(fields[0], "remote."), ".promisor"), nil
+		}
+	}
+	return "", nil
+}
+
+// CommitExistsWithoutFetching checks commit exists in dir without fetching it lazily from a promisor remote of a partial clone
+func CommitExistsWithoutFetching(dir, commit string) (bool, errors.GitGhostError) {
+	cmd := exec.Command("git", "-C", dir, "cat-file", "-e", commit)
+	// git warns about the disabled lazy fetch on stderr, so the exit code is checked instead of an output
+	cmd.Env = append(os.Environ(), "GIT_NO_LAZY_FETCH=1")
+	err := util.JustRunCmd(cmd)
+	if err == nil {
+		return true, nil
+	}
+	if cmd.ProcessState != nil && cmd.ProcessState.ExitCode() == 1 {
+		return false, nil
+	}
+	return false, err
+}
+
+// FetchCommit fetches commit from remote of dir, following its partial clone filter if dir is a partial clone
+func FetchCommit(dir, remote, commit string) errors.GitGhostError {
+	return runRemoteCommand("-C", dir, "fetch", "-q", "--no-tags", remote, commit)
+}
@@ -0,0 +1,158 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// MatchPaths returns the first of paths which p is or is under, or an empty string if none
+//
+// Paths are relative to the top of the repo, and "." matches every path.
+func MatchPaths(p string, paths []string) string {
+	for _, pp := range paths {
+		cleaned := path.Clean(pp)
+		if cleaned == "." || p == cleaned || strings.HasPrefix(p, cleaned+"/") {
+			return pp
+		}
+	}
+	return ""
+}
+
+// FilterDiffSections removes sections of files which are not under any of paths from a diff file created by CreateDiffPatchFile
+//
+// A section of a renamed or copied file is kept entirely if either of its old path or its new path matches.
+// Paths in the diff are compared as they are in the ghost, before being rebased on applying.
+// It returns paths which match any section of the diff.
+func FilterDiffSections(dir, filepath string, paths []string) ([]string, errors.GitGhostError) {
+	matched := []string{}
+	ggerr := rewriteDiffSections(dir, filepath, PatchPathOptions{}, func(section string, sectionPaths []string) bool {
+		keep := false
+		for _, p := range sectionPaths {
+			if m := MatchPaths(p, paths); m != "" {
+				matched = append(matched, m)
+				keep = true
+			}
+		}
+		return keep
+	})
+	return util.UniqueStringSlice(matched), ggerr
+}
+
+// ExcludeDiffSections removes sections of files at any of paths, which are rebased by pathOpts as on applying, from a diff file
+func ExcludeDiffSections(dir, filepath string, paths []string, pathOpts PatchPathOptions) errors.GitGhostError {
+	excluded := map[string]bool{}
+	for _, p := range paths {
+		excluded[p] = true
+	}
+	return rewriteDiffSections(dir, filepath, pathOpts, func(section string, sectionPaths []string) bool {
+		for _, p := range sectionPaths {
+			if excluded[p] {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// ListCreatedPaths returns paths of files which a diff file creates, including new paths of renamed or copied files,
+// rebased by pathOpts as on applying
+func ListCreatedPaths(dir, filepath string, pathOpts PatchPathOptions) ([]string, errors.GitGhostError) {
+	created := []string{}
+	ggerr := forEachDiffSection(dir, filepath, pathOpts, func(section string, sectionPaths []string) errors.GitGhostError {
+		// headers of a section are never confused with its hunks, whose lines start with ' ', '+' or '-'
+		if len(sectionPaths) > 0 && (strings.Contains(section, "\nnew file mode ") || strings.Contains(section, "\nrename to ") || strings.Contains(section, "\ncopy to ")) {
+			// the new path of a renamed or copied file comes after its old path
+			created = append(created, sectionPaths[len(sectionPaths)-1])
+		}
+		return nil
+	})
+	return util.UniqueStringSlice(created), ggerr
+}
+
+// rewriteDiffSections rewrites a diff file only with its sections for which keep returns true
+func rewriteDiffSections(dir, filepath string, pathOpts PatchPathOptions, keep func(section string, sectionPaths []string) bool) errors.GitGhostError {
+	return withSectionFile(func(sectionFile string) errors.GitGhostError {
+		return rewritePatchFile(filepath, isDiffSectionStart, func(section string) (string, errors.GitGhostError) {
+			if !isDiffSectionStart(section) {
+				return "", nil
+			}
+			sectionPaths, ggerr := listSectionPaths(dir, sectionFile, section, pathOpts)
+			if ggerr != nil {
+				return "", ggerr
+			}
+			if keep(section, sectionPaths) {
+				return section, nil
+			}
+			return "", nil
+		})
+	})
+}
+
+// forEachDiffSection calls f with each section of a file in a diff file and paths of the file rebased by pathOpts
+func forEachDiffSection(dir, filepath string, pathOpts PatchPathOptions, f func(section string, sectionPaths []string) errors.GitGhostError) errors.GitGhostError {
+	return withSectionFile(func(sectionFile string) errors.GitGhostError {
+		return scanPatchFile(filepath, isDiffSectionStart, func(section string) errors.GitGhostError {
+			if !isDiffSectionStart(section) {
+				return nil
+			}
+			sectionPaths, ggerr := listSectionPaths(dir, sectionFile, section, pathOpts)
+			if ggerr != nil {
+				return ggerr
+			}
+			return f(section, sectionPaths)
+		})
+	})
+}
+
+// withSectionFile calls f with a path of a temporary file, which sections of a patch are written to one by one
+func withSectionFile(f func(sectionFile string) errors.GitGhostError) errors.GitGhostError {
+	sectionFile, err := ioutil.TempFile(util.TempDir(), "git-ghost-section")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	util.LogDeferredError(sectionFile.Close)
+	defer util.LogDeferredError(func() error { return os.Remove(sectionFile.Name()) })
+	return f(sectionFile.Name())
+}
+
+// listSectionPaths writes a section of a patch to sectionFile and returns paths of its file rebased by pathOpts
+func listSectionPaths(dir, sectionFile, section string, pathOpts PatchPathOptions) ([]string, errors.GitGhostError) {
+	err := ioutil.WriteFile(sectionFile, []byte(section), 0600)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return ListPatchPaths(dir, sectionFile, pathOpts)
+}
+
+// ListTrackedPaths returns paths in the index of dir out of paths, which are relative to the top of the repo
+func ListTrackedPaths(dir string, paths []string) ([]string, errors.GitGhostError) {
+	if len(paths) == 0 {
+		return []string{}, nil
+	}
+	args := append([]string{"-C", dir, "--literal-pathspecs", "ls-files", "-z", "--cached", "--full-name", "--"}, paths...)
+	output, ggerr := util.JustOutputCmd(exec.Command("git", args...))
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	return splitNulls(string(output)), nil
+}
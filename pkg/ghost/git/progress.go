@@ -0,0 +1,62 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"regexp"
+	"strconv"
+
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// transferProgressPattern matches a line of progress of objects sent by 'git push' or received by 'git fetch'
+var transferProgressPattern = regexp.MustCompile(`^(?:remote: )?(?:Writing|Receiving) objects:\s+([0-9]+)%`)
+
+// runRemoteCommandWithProgress is the same as runRemoteCommand except that it calls progress with the percentage of objects transferred
+// as git reports it with --progress, which args have to include
+//
+// Other lines of progress (e.g. of counting or compressing objects) are dropped.
+func runRemoteCommandWithProgress(progress func(percent int), args ...string) errors.GitGhostError {
+	err := util.ScanCmdStderr(remoteCommand(args...), func(line string) bool {
+		if m := transferProgressPattern.FindStringSubmatch(line); m != nil {
+			percent, _ := strconv.Atoi(m[1])
+			progress(percent)
+			return true
+		}
+		return progressLinePattern.MatchString(line)
+	})
+	return remoteError(err)
+}
+
+// progressLinePattern matches a line of any progress of git, e.g. "Compressing objects:  50% (1/2)"
+var progressLinePattern = regexp.MustCompile(`^(?:remote: )?[A-Z][a-z]+(?: [a-z]+)*: +[0-9]+%`)
+
+// PushWithProgress is the same as Push except that it calls progress with the percentage of objects sent as they are
+func PushWithProgress(dir string, progress func(percent int), refspecs ...string) errors.GitGhostError {
+	if progress == nil {
+		return Push(dir, refspecs...)
+	}
+	args := append([]string{"-C", dir, "push", "--progress", "origin"}, refspecs...)
+	return runRemoteCommandWithProgress(progress, args...)
+}
+
+// FetchBranchesWithProgress is the same as FetchBranches except that it calls progress with the percentage of objects received as they are
+func FetchBranchesWithProgress(dir string, progress func(percent int), branches ...string) errors.GitGhostError {
+	if progress == nil {
+		return FetchBranches(dir, branches...)
+	}
+	return fetchRefs(dir, progress, branchRefspecs(branches)...)
+}
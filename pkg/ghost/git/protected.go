@@ -0,0 +1,67 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// protectedBranches are branches which ghost branches must not collide with in any ghost repo, in addition to its default branch
+var protectedBranches = []string{"main", "master"}
+
+// FindProtectedBranchCollision returns a protected branch of repo which collides with ghost refs under prefix, or "" if nothing collides
+//
+// Protected branches are "main", "master" and the default branch of repo (where its HEAD points to), which is looked up by listing refs of repo.
+func FindProtectedBranchCollision(repo, prefix string) (string, errors.GitGhostError) {
+	head, err := DefaultBranch(repo)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if head == "" {
+		return ProtectedBranchCollision(prefix), nil
+	}
+	return ProtectedBranchCollision(prefix, head), nil
+}
+
+// ProtectedBranchCollision returns one of "main", "master" and extra branches which collides with ghost refs under prefix, or "" if nothing collides
+//
+// A branch collides if it is prefix itself or a ref hierarchy containing or contained by prefix,
+// since ghost branches would then replace it, block it from being created or be mixed up with it on deleting them.
+// It never accesses any repo.
+func ProtectedBranchCollision(prefix string, extra ...string) string {
+	for _, branch := range append(extra, protectedBranches...) {
+		if branch == prefix || strings.HasPrefix(prefix, branch+"/") || strings.HasPrefix(branch, prefix+"/") {
+			return branch
+		}
+	}
+	return ""
+}
+
+// DefaultBranch returns the branch which HEAD of repo points to, or "" if HEAD is detached or points to no commit yet
+func DefaultBranch(repo string) (string, errors.GitGhostError) {
+	output, err := outputRemoteCommand("ls-remote", "-q", "--symref", repo, "HEAD")
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		// e.g. "ref: refs/heads/main\tHEAD"
+		if strings.HasPrefix(line, "ref: refs/heads/") && strings.HasSuffix(line, "\tHEAD") {
+			return strings.TrimSuffix(strings.TrimPrefix(line, "ref: refs/heads/"), "\tHEAD"), nil
+		}
+	}
+	return "", nil
+}
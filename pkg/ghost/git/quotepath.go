@@ -0,0 +1,64 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// PinPathQuoting makes all the git commands run after it quote paths in their outputs as git does by default
+//
+// Otherwise core.quotePath=false of a user writes non-ASCII bytes of paths into diff headers as they are,
+// so the same local modification gets a different patch (and a different hash) depending on who pushes it.
+func PinPathQuoting() errors.GitGhostError {
+	return setConfigEnvs([]string{"core.quotePath"}, []string{"true"})
+}
+
+// unquotePath returns a path quoted by git (e.g. "a/caf\303\251.txt") as its raw bytes, or path as it is if it is not quoted
+func unquotePath(path string) string {
+	if !strings.HasPrefix(path, "\"") {
+		return path
+	}
+	if unquoted, err := strconv.Unquote(path); err == nil {
+		return unquoted
+	}
+	return path
+}
+
+// splitQuotedPath splits a path quoted by git at the head of s from the rest of s, and returns s as it is if it is not quoted
+func splitQuotedPath(s string) (string, string) {
+	if !strings.HasPrefix(s, "\"") {
+		return s, ""
+	}
+	quoted, err := strconv.QuotedPrefix(s)
+	if err != nil {
+		return s, ""
+	}
+	return unquotePath(quoted), strings.TrimPrefix(s[len(quoted):], " ")
+}
+
+// splitNulls splits output of a git command with -z into its entries
+func splitNulls(s string) []string {
+	entries := []string{}
+	for _, entry := range strings.Split(s, "\x00") {
+		if entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
@@ -0,0 +1,79 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// remoteHelperURLPattern matches a URL of the form "<transport>::<address>", which git hands to 'git-remote-<transport>'
+var remoteHelperURLPattern = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9+.-]*)::`)
+
+// RemoteHelper returns the transport of repo if it is of the form "<transport>::<address>", or empty otherwise
+func RemoteHelper(repo string) string {
+	m := remoteHelperURLPattern.FindStringSubmatch(repo)
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+// ValidateRemoteHelper checks the remote helper 'git-remote-<transport>' of repo is found as git looks it up,
+// i.e. in the exec path of git or PATH, if repo is of the form "<transport>::<address>"
+//
+// Remote helpers built into git (e.g. "ext" and "fd") are always found.
+func ValidateRemoteHelper(repo string) errors.GitGhostError {
+	transport := RemoteHelper(repo)
+	if transport == "" {
+		return nil
+	}
+	helper := "git-remote-" + transport
+	output, ggerr := util.JustOutputCmd(exec.Command("git", "--exec-path"))
+	if ggerr != nil {
+		return ggerr
+	}
+	execPath := strings.TrimSpace(string(output))
+	if fi, err := os.Stat(filepath.Join(execPath, helper)); err == nil && !fi.IsDir() {
+		return nil
+	}
+	if _, err := exec.LookPath(helper); err == nil {
+		return nil
+	}
+	if isBuiltinCommand("remote-" + transport) {
+		return nil
+	}
+	return errors.Errorf("remote helper %s for %s:: is not found in PATH or the exec path of git (%s)", helper, transport, execPath)
+}
+
+// isBuiltinCommand checks git has command built into it
+func isBuiltinCommand(command string) bool {
+	output, ggerr := util.JustOutputCmd(exec.Command("git", "--list-cmds=builtins"))
+	if ggerr != nil {
+		return false
+	}
+	for _, c := range strings.Split(string(output), "\n") {
+		if c == command {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// ListCommits returns hashes of commits in fromCommittish..toCommittish along first parents from the oldest one
+//
+// If pathspecs are given, only commits touching them are returned like 'git log -- <pathspec>'.
+func ListCommits(dir, fromCommittish, toCommittish string, pathspecs ...string) ([]string, errors.GitGhostError) {
+	args := []string{"-C", dir, "rev-list", "--reverse", "--first-parent", fmt.Sprintf("%s..%s", fromCommittish, toCommittish)}
+	if len(pathspecs) > 0 {
+		args = append(append(args, "--"), pathspecs...)
+	}
+	output, err := util.JustOutputCmd(exec.Command("git", args...))
+	if err != nil {
+		return nil, err
+	}
+	return splitLines(string(output)), nil
+}
+
+// ReplayCommits recreates commits on top of base sequentially and returns a hash of the last recreated one
+//
+// Each commit is recreated from its diff against its first parent, keeping its message, author and committer,
+// so replaying the same commits on the same base always results in the same hashes.
+// It uses a temporary index so that neither the index nor the working tree of dir is modified.
+func ReplayCommits(dir, base string, commits []string) (string, errors.GitGhostError) {
+	indexFile, err := ioutil.TempFile(util.TempDir(), "git-ghost-index")
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	util.LogDeferredError(indexFile.Close)
+	defer util.LogDeferredError(func() error { return os.Remove(indexFile.Name()) })
+	env := indexEnv(indexFile.Name())
+
+	ggerr := util.JustRunCmd(withEnv(exec.Command("git", "-C", dir, "read-tree", base), env))
+	if ggerr != nil {
+		return "", ggerr
+	}
+	parent := base
+	for _, commit := range commits {
+		diff, ggerr := util.JustOutputCmd(exec.Command("git", "-C", dir, "diff", "--binary", commit+"^", commit))
+		if ggerr != nil {
+			return "", ggerr
+		}
+		if len(diff) > 0 {
+			cmd := withEnv(exec.Command("git", "-C", dir, "apply", "--cached"), env)
+			cmd.Stdin = bytes.NewReader(diff)
+			ggerr = util.JustRunCmd(cmd)
+			if ggerr != nil {
+				return "", errors.Errorf("commit %s can not be applied without commits before it: %s", commit, ggerr)
+			}
+		}
+		tree, ggerr := writeTree(dir, env)
+		if ggerr != nil {
+			return "", ggerr
+		}
+		parent, ggerr = recreateCommit(dir, commit, tree, parent)
+		if ggerr != nil {
+			return "", ggerr
+		}
+	}
+	return parent, nil
+}
+
+// recreateCommit creates a commit of tree on parent with the same message, author and committer as commit
+func recreateCommit(dir, commit, tree, parent string) (string, errors.GitGhostError) {
+	output, ggerr := util.JustOutputCmd(
+		exec.Command("git", "-C", dir, "log", "-1", "--date=raw", "--format=%an%x00%ae%x00%ad%x00%cn%x00%ce%x00%cd", commit),
+	)
+	if ggerr != nil {
+		return "", ggerr
+	}
+	fields := strings.Split(strings.TrimRight(string(output), "\n"), "\x00")
+	if len(fields) != 6 {
+		return "", errors.Errorf("Got unexpected metadata of commit %s: %q", commit, output)
+	}
+	raw, ggerr := util.JustOutputCmd(exec.Command("git", "-C", dir, "cat-file", "commit", commit))
+	if ggerr != nil {
+		return "", ggerr
+	}
+	message := ""
+	if i := strings.Index(string(raw), "\n\n"); i >= 0 {
+		message = string(raw)[i+2:]
+	}
+
+	cmd := withEnv(exec.Command("git", "-C", dir, "commit-tree", tree, "-p", parent), []string{
+		"GIT_AUTHOR_NAME=" + fields[0],
+		"GIT_AUTHOR_EMAIL=" + fields[1],
+		"GIT_AUTHOR_DATE=" + fields[2],
+		"GIT_COMMITTER_NAME=" + fields[3],
+		"GIT_COMMITTER_EMAIL=" + fields[4],
+		"GIT_COMMITTER_DATE=" + fields[5],
+	})
+	cmd.Stdin = strings.NewReader(message)
+	output, ggerr = util.JustOutputCmd(cmd)
+	if ggerr != nil {
+		return "", ggerr
+	}
+	return strings.TrimRight(string(output), "\n"), nil
+}
@@ -16,7 +16,11 @@ package git
 
 import (
 	"fmt"
+	"os"
 	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/pfnet-research/git-ghost/pkg/util"
@@ -37,8 +41,60 @@ func InitializeGitDir(dir, repo, branch string) errors.GitGhostError {
 		args = append(args, "-b", branch)
 	}
 	args = append(args, repo, dir)
-	cmd := exec.Command("git", args...)
-	return util.JustRunCmd(cmd)
+	return runRemoteCommand(args...)
+}
+
+// CloneNoCheckout clones repo to dir without checking out any files
+func CloneNoCheckout(dir, repo string) errors.GitGhostError {
+	return runRemoteCommand("clone", "-q", "--no-checkout", "-o", ORIGIN, repo, dir)
+}
+
+// CheckoutDetached checks out committish in dir detaching HEAD
+func CheckoutDetached(dir, committish string) errors.GitGhostError {
+	return util.JustRunCmd(exec.Command("git", "-C", dir, "checkout", "-q", "--detach", committish))
+}
+
+// InitializeEmptyGitDir initializes an empty git repository in dir whose origin is repo without fetching anything
+func InitializeEmptyGitDir(dir, repo string) errors.GitGhostError {
+	err := util.JustRunCmd(exec.Command("git", "init", "-q", dir))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return util.JustRunCmd(exec.Command("git", "-C", dir, "remote", "add", ORIGIN, repo))
+}
+
+// FetchBranches fetches branches from origin as its remote tracking branches
+func FetchBranches(dir string, branches ...string) errors.GitGhostError {
+	return FetchRefs(dir, branchRefspecs(branches)...)
+}
+
+func branchRefspecs(branches []string) []string {
+	refspecs := make([]string, 0, len(branches))
+	for _, b := range branches {
+		refspecs = append(refspecs, fmt.Sprintf("+refs/heads/%s:refs/remotes/%s/%s", b, ORIGIN, b))
+	}
+	return refspecs
+}
+
+// FetchRefs fetches refspecs from origin
+//
+// An error of a missing ref is classified as errors.CategoryNotFound.
+func FetchRefs(dir string, refspecs ...string) errors.GitGhostError {
+	return fetchRefs(dir, nil, refspecs...)
+}
+
+// fetchRefs fetches refspecs from origin calling progress with the percentage of objects received if it is not nil
+func fetchRefs(dir string, progress func(percent int), refspecs ...string) errors.GitGhostError {
+	var err errors.GitGhostError
+	if progress == nil {
+		err = runRemoteCommand(append([]string{"-C", dir, "fetch", "-q", "--no-tags", ORIGIN}, refspecs...)...)
+	} else {
+		err = runRemoteCommandWithProgress(progress, append([]string{"-C", dir, "fetch", "--progress", "--no-tags", ORIGIN}, refspecs...)...)
+	}
+	if err != nil && strings.Contains(err.Error(), "couldn't find remote ref") {
+		return errors.WithCategory(err, errors.CategoryNotFound)
+	}
+	return err
 }
 
 // CopyUserConfig copies user config from source directory to destination directory.
@@ -80,6 +136,58 @@ func SetUserConfig(dir, name, email string) errors.GitGhostError {
 	return nil
 }
 
+var identPattern = regexp.MustCompile(`^([^<>]*[^<>\s])\s*<([^<>\s]+)>$`)
+
+// ParseIdent parses an identity in the form of "Name <email>" into its name and email
+func ParseIdent(ident string) (string, string, errors.GitGhostError) {
+	m := identPattern.FindStringSubmatch(strings.TrimSpace(ident))
+	if m == nil {
+		return "", "", errors.Errorf("identity must be in the form of 'Name <email>' (value: %s)", ident)
+	}
+	return m[1], m[2], nil
+}
+
+// GetConfig returns a value of key in git config seen from dir and whether it is set or not.
+func GetConfig(dir, key string) (string, bool, errors.GitGhostError) {
+	output, err := util.JustOutputCmd(exec.Command("git", "-C", dir, "config", "--get", key))
+	if err != nil {
+		if util.GetExitCode(err.Cause()) == 1 {
+			// exit 1 is for unset key.
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return strings.TrimSuffix(string(output), "\n"), true, nil
+}
+
+// GetConfigWithScope returns a value of key in git config seen from dir with its scope ("local", "global", "system" and so on)
+// and whether it is set or not.
+func GetConfigWithScope(dir, key string) (string, string, bool, errors.GitGhostError) {
+	output, err := util.JustOutputCmd(exec.Command("git", "-C", dir, "config", "--show-scope", "--get", key))
+	if err != nil {
+		if util.GetExitCode(err.Cause()) == 1 {
+			// exit 1 is for unset key.
+			return "", "", false, nil
+		}
+		return "", "", false, err
+	}
+	tokens := strings.SplitN(strings.TrimSuffix(string(output), "\n"), "\t", 2)
+	if len(tokens) != 2 {
+		return "", "", false, errors.Errorf("Got unexpected output of git config: %s", string(output))
+	}
+	return tokens[1], tokens[0], true, nil
+}
+
+// SetConfig sets a value of key in git config of dir, or the global one if global is true.
+func SetConfig(dir, key, value string, global bool) errors.GitGhostError {
+	args := []string{"-C", dir, "config"}
+	if global {
+		args = append(args, "--global")
+	}
+	args = append(args, key, value)
+	return util.JustRunCmd(exec.Command("git", args...))
+}
+
 // CommitAndPush commits and push to its origin
 func CommitAndPush(dir, filename, message, committish string) errors.GitGhostError {
 	err := CommitFile(dir, filename, message)
@@ -106,31 +214,183 @@ func CommitFile(dir, filename, message string) errors.GitGhostError {
 	)
 }
 
+// CommitFiles commits additions and deletions of files matching pathspecs
+func CommitFiles(dir, message string, pathspecs ...string) errors.GitGhostError {
+	args := append([]string{"-C", dir, "add", "-A", "--"}, pathspecs...)
+	err := util.JustRunCmd(
+		exec.Command("git", args...),
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	args = append([]string{"-C", dir, "commit", "-q", "-m", message, "--"}, pathspecs...)
+	return util.JustRunCmd(
+		exec.Command("git", args...),
+	)
+}
+
+// CommitIndex creates a commit of the index on dir.
+// The commit is authored by author (in the form of "Name <email>") if it is not empty.
+func CommitIndex(dir, message, author string) errors.GitGhostError {
+	args := []string{"-C", dir, "commit", "-q", "-m", message}
+	if author != "" {
+		args = append(args, "--author", author)
+	}
+	return util.JustRunCmd(
+		exec.Command("git", args...),
+	)
+}
+
+// CommitMetadata represents metadata of a commit
+type CommitMetadata struct {
+	// Author is in the form of "Name <email>"
+	Author string
+	// Date is an author date in the strict ISO 8601 format
+	Date    string
+	Message string
+}
+
+// GetCommitMetadata returns metadata of committish on dir
+func GetCommitMetadata(dir, committish string) (*CommitMetadata, errors.GitGhostError) {
+	output, err := util.JustOutputCmd(
+		exec.Command("git", "-C", dir, "log", "-1", "--format=%an <%ae>%x00%aI%x00%B", committish),
+	)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.SplitN(string(output), "\x00", 3)
+	if len(fields) != 3 {
+		return nil, errors.Errorf("Got unexpected metadata of commit %s: %q", committish, output)
+	}
+	return &CommitMetadata{
+		Author:  fields[0],
+		Date:    fields[1],
+		Message: fields[2],
+	}, nil
+}
+
+// GetCommitTimestamps returns committer dates of commits on dir in unix time keyed by their hashes
+func GetCommitTimestamps(dir string, commits ...string) (map[string]int64, errors.GitGhostError) {
+	args := append([]string{"-C", dir, "show", "-s", "--format=%H %ct"}, commits...)
+	output, err := util.JustOutputCmd(exec.Command("git", args...))
+	if err != nil {
+		return nil, err
+	}
+	timestamps := map[string]int64{}
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, errors.Errorf("Got unexpected timestamp of commit: %q", line)
+		}
+		timestamp, perr := strconv.ParseInt(fields[1], 10, 64)
+		if perr != nil {
+			return nil, errors.WithStack(perr)
+		}
+		timestamps[fields[0]] = timestamp
+	}
+	return timestamps, nil
+}
+
+// FileExistsAt checks a file exists at committish on dir or not
+func FileExistsAt(dir, committish, filename string) (bool, errors.GitGhostError) {
+	output, err := util.JustOutputCmd(
+		exec.Command("git", "-C", dir, "ls-tree", "--name-only", committish, "--", filename),
+	)
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(output)) != "", nil
+}
+
 // DeleteRemoteBranches delete branches from its origin
 func DeleteRemoteBranches(dir string, branchNames ...string) errors.GitGhostError {
 	args := []string{"-C", dir, "push", "origin"}
 	for _, name := range branchNames {
 		args = append(args, fmt.Sprintf(":%s", name))
 	}
-	return util.JustRunCmd(
-		exec.Command("git", args...),
-	)
+	return runRemoteCommand(args...)
 }
 
 // Push pushes current HEAD to its origin
 func Push(dir string, committishes ...string) errors.GitGhostError {
 	args := []string{"-C", dir, "push", "origin"}
 	args = append(args, committishes...)
-	return util.JustRunCmd(
-		exec.Command("git", args...),
-	)
+	return runRemoteCommand(args...)
+}
+
+// PushTo pushes refspecs to repo instead of its origin
+func PushTo(dir, repo string, refspecs ...string) errors.GitGhostError {
+	args := []string{"-C", dir, "push", repo}
+	args = append(args, refspecs...)
+	return runRemoteCommand(args...)
+}
+
+// regexpRefCreatedConcurrently matches an error of the remote rejecting a ref which is created by another push after the lease is checked
+var regexpRefCreatedConcurrently = regexp.MustCompile(`cannot lock ref '[^']*': reference already exists`)
+
+// PushIfAbsent pushes refspecs to its origin atomically only if none of their destination refs exist in it
+//
+// Each destination is guarded by a lease expecting it to be missing, so that only one of concurrent pushes creates it.
+// Refspecs starting with '+' are forced without the lease. Destinations which exist are failed with errors.CategoryExists,
+// including ones already at the pushed commits, which git push regards as up to date.
+func PushIfAbsent(dir string, refspecs ...string) errors.GitGhostError {
+	args := []string{"-C", dir, "push", "--porcelain", "--atomic"}
+	forced := map[string]bool{}
+	for _, refspec := range refspecs {
+		dst := refspec[strings.LastIndex(refspec, ":")+1:]
+		if strings.HasPrefix(refspec, "+") {
+			forced[strings.TrimPrefix(dst, "+")] = true
+		} else {
+			args = append(args, fmt.Sprintf("--force-with-lease=%s:", dst))
+		}
+	}
+	args = append(args, "origin")
+	args = append(args, refspecs...)
+	existing := []string{}
+	err := scanRemoteCommand(func(line string) errors.GitGhostError {
+		// each ref is reported as "<flag>\t<from>:<to>\t<summary>"
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			return nil
+		}
+		dst := fields[1][strings.LastIndex(fields[1], ":")+1:]
+		if (fields[0] == "=" && !forced[dst]) || (fields[0] == "!" && strings.HasSuffix(fields[2], "(stale info)")) {
+			existing = append(existing, dst)
+		}
+		return nil
+	}, args...)
+	if len(existing) > 0 {
+		return errors.WithCategory(errors.Errorf("%s already exists in ghost repo", strings.Join(existing, ", ")), errors.CategoryExists)
+	}
+	if err != nil && regexpRefCreatedConcurrently.MatchString(err.Error()) {
+		return errors.WithCategory(err, errors.CategoryExists)
+	}
+	return err
+}
+
+// DeleteRefsWithLease deletes refs from its origin, each of which is guarded by a lease expecting it to be at the commit given in refs
+//
+// The push fails if any of them is moved by others after it is listed.
+func DeleteRefsWithLease(dir string, refs map[string]string) errors.GitGhostError {
+	names := make([]string, 0, len(refs))
+	for ref := range refs {
+		names = append(names, ref)
+	}
+	sort.Strings(names)
+	args := []string{"-C", dir, "push"}
+	for _, ref := range names {
+		args = append(args, fmt.Sprintf("--force-with-lease=%s:%s", ref, refs[ref]))
+	}
+	args = append(args, "origin")
+	for _, ref := range names {
+		args = append(args, ":"+ref)
+	}
+	return runRemoteCommand(args...)
 }
 
 // Pull pulls committish from its origin
 func Pull(dir, committish string) errors.GitGhostError {
-	return util.JustRunCmd(
-		exec.Command("git", "-C", dir, "pull", "origin", committish),
-	)
+	return runRemoteCommand("-C", dir, "pull", "origin", committish)
 }
 
 // CreateOrphanBranch creates an orphan branch on dir
@@ -140,6 +400,60 @@ func CreateOrphanBranch(dir, branch string) errors.GitGhostError {
 	)
 }
 
+// CreateBranchFrom creates a branch starting at startPoint on dir and checks it out
+func CreateBranchFrom(dir, branch, startPoint string) errors.GitGhostError {
+	return util.JustRunCmd(
+		exec.Command("git", "-C", dir, "checkout", "-q", "-b", branch, startPoint),
+	)
+}
+
+// ListFirstParentCommits returns commits reachable from committish following first parents in chronological order
+func ListFirstParentCommits(dir, committish string) ([]string, errors.GitGhostError) {
+	output, err := util.JustOutputCmd(
+		exec.Command("git", "-C", dir, "rev-list", "--first-parent", "--reverse", committish),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(output)), nil
+}
+
+// ExtractFile writes a content of filename at committish on dir to dstPath
+func ExtractFile(dir, committish, filename, dstPath string) errors.GitGhostError {
+	f, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer util.LogDeferredError(f.Close)
+
+	cmd := exec.Command("git", "-C", dir, "cat-file", "-p", fmt.Sprintf("%s:%s", committish, filename))
+	cmd.Stdout = f
+	return util.JustRunCmd(cmd)
+}
+
+// CreateTag creates (or moves) a lightweight tag pointing to HEAD on dir
+func CreateTag(dir, tag string) errors.GitGhostError {
+	return util.JustRunCmd(
+		exec.Command("git", "-C", dir, "tag", "-f", tag),
+	)
+}
+
+// StashPush stashes local changes of indexed files on dir with message
+func StashPush(dir, message string) errors.GitGhostError {
+	return util.JustRunCmd(
+		exec.Command("git", "-C", dir, "stash", "push", "-q", "-m", message),
+	)
+}
+
+// StashPop restores the latest stash on dir and drops it
+//
+// The stash is kept if restoring it conflicts.
+func StashPop(dir string) errors.GitGhostError {
+	return util.JustRunCmd(
+		exec.Command("git", "-C", dir, "stash", "pop", "-q"),
+	)
+}
+
 // ResetHardToBranch reset dir to branch with --hard option
 func ResetHardToBranch(dir, branch string) errors.GitGhostError {
 	return util.JustRunCmd(
@@ -0,0 +1,118 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"os/exec"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	multierror "github.com/hashicorp/go-multierror"
+	log "github.com/sirupsen/logrus"
+)
+
+// ApplyDiffBundleFileWithRerere applies a patch file created in CreateDiffBundleFile by 'git am --3way' with rerere enabled,
+// and returns paths of conflicts resolved by resolutions recorded by rerere
+//
+// 'git am' is continued as long as rerere resolves all the conflicts of a patch, and aborted otherwise.
+// Nothing is resolved if it fails. It requires blobs which the patches are based on to be in the repo of dir.
+func ApplyDiffBundleFileWithRerere(dir, filepath string, pathOpts PatchPathOptions) ([]string, errors.GitGhostError) {
+	args := append(append([]string{"am", "--3way"}, pathOpts.args()...), filepath)
+	err := runWithRerere(dir, args...)
+	resolved := []string{}
+	for err != nil {
+		var unresolved []string
+		unmerged, uerr := unmergedPaths(dir)
+		if uerr == nil {
+			unresolved, uerr = rerereRemaining(dir)
+		}
+		if uerr != nil || len(unmerged) == 0 || len(unresolved) > 0 {
+			return nil, abortAm(dir, filepath, err, unresolved)
+		}
+		for _, path := range unmerged {
+			log.WithFields(log.Fields{
+				"srcDir": dir,
+				"path":   path,
+			}).Info("conflict is resolved by a recorded resolution of rerere")
+		}
+		addArgs := append([]string{"-C", dir, "add", "--"}, unmerged...)
+		err = util.JustRunCmd(exec.Command("git", addArgs...))
+		if err != nil {
+			return nil, abortAm(dir, filepath, err, nil)
+		}
+		resolved = append(resolved, unmerged...)
+		err = runWithRerere(dir, "am", "--continue")
+	}
+	return resolved, nil
+}
+
+func runWithRerere(dir string, args ...string) errors.GitGhostError {
+	return util.JustRunCmd(exec.Command("git", append([]string{"-c", "rerere.enabled=true", "-C", dir}, args...)...))
+}
+
+// abortAm aborts 'git am' which failed by err, leaving the recorded preimages of conflicts for rerere
+func abortAm(dir, filepath string, err errors.GitGhostError, unresolved []string) errors.GitGhostError {
+	var errs error
+	if len(unresolved) > 0 {
+		err = errors.Errorf("rerere has no recorded resolution for conflicts in %s: %s", strings.Join(unresolved, ", "), err)
+	}
+	errs = multierror.Append(errs, err)
+	log.WithFields(log.Fields{
+		"srcDir":   dir,
+		"filepath": filepath,
+		"error":    err.Error(),
+	}).Info("apply('git am --3way') with rerere failed. aborting.")
+	resetErr := util.JustRunCmd(exec.Command("git", "-C", dir, "am", "--abort"))
+	if resetErr != nil {
+		errs = multierror.Append(errs, resetErr)
+	}
+	return errors.WithCategory(errs, errors.CategoryConflict)
+}
+
+// unmergedPaths returns paths of files which are unmerged in the index of dir
+func unmergedPaths(dir string) ([]string, errors.GitGhostError) {
+	output, err := util.JustOutputCmd(exec.Command("git", "-C", dir, "diff", "--name-only", "--diff-filter=U", "-z"))
+	if err != nil {
+		return nil, err
+	}
+	return splitPaths(string(output)), nil
+}
+
+// rerereRemaining returns paths of conflicts in dir which rerere can't resolve by recorded resolutions
+func rerereRemaining(dir string) ([]string, errors.GitGhostError) {
+	output, err := util.JustOutputCmd(exec.Command("git", "-c", "rerere.enabled=true", "-C", dir, "rerere", "remaining"))
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{}
+	for _, p := range strings.Split(string(output), "\n") {
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths, nil
+}
+
+func splitPaths(output string) []string {
+	paths := []string{}
+	for _, p := range strings.Split(output, "\x00") {
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
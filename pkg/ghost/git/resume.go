@@ -0,0 +1,138 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// SkipAppliedDiffBundlePatches removes patches of commits already in fromCommittish..HEAD on dir from a patch file created in CreateDiffBundleFile
+//
+// Commits are compared by their stable patch ids, so ones applied by 'git am' with new hashes are detected.
+// It returns hashes of the removed commits in "From" lines of the patch file.
+func SkipAppliedDiffBundlePatches(dir, filepath, fromCommittish string) ([]string, errors.GitGhostError) {
+	applied, err := ioutil.TempFile(util.TempDir(), "git-ghost-applied")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer util.LogDeferredError(func() error { return os.Remove(applied.Name()) })
+	ggerr := WriteDiffBundle(dir, fromCommittish, "HEAD", applied)
+	if cerr := applied.Close(); ggerr == nil {
+		ggerr = errors.WithStack(cerr)
+	}
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	appliedIDs, ggerr := listPatchIDs(dir, applied.Name())
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	appliedPatchIDs := map[string]bool{}
+	for _, patchID := range appliedIDs {
+		appliedPatchIDs[patchID] = true
+	}
+	patchIDs, ggerr := listPatchIDs(dir, filepath)
+	if ggerr != nil {
+		return nil, ggerr
+	}
+
+	// patch ids are listed in order only for patches having diffs.
+	// they are matched by the order since hashes in "From" lines may be all zero by 'git format-patch --zero-commit'.
+	skipped := []string{}
+	ggerr = rewritePatchFile(filepath, isEmailPatchStart, func(patch string) (string, errors.GitGhostError) {
+		if !isEmailPatchStart(patch) || !strings.Contains(patch, "\ndiff --git ") || len(patchIDs) == 0 {
+			return patch, nil
+		}
+		patchID := patchIDs[0]
+		patchIDs = patchIDs[1:]
+		if appliedPatchIDs[patchID] {
+			skipped = append(skipped, strings.Fields(patch)[1])
+			return "", nil
+		}
+		return patch, nil
+	})
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	return skipped, nil
+}
+
+// listPatchIDs returns stable patch ids of commits in a patch file in order
+func listPatchIDs(dir, filepath string) ([]string, errors.GitGhostError) {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer util.LogDeferredError(f.Close)
+	cmd := exec.Command("git", "-C", dir, "patch-id", "--stable")
+	cmd.Stdin = f
+	output, ggerr := util.JustOutputCmd(cmd)
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	patchIDs := []string{}
+	for _, line := range strings.Split(string(output), "\n") {
+		tokens := strings.Fields(line)
+		if len(tokens) != 2 {
+			continue
+		}
+		patchIDs = append(patchIDs, tokens[0])
+	}
+	return patchIDs, nil
+}
+
+// SkipAppliedDiffSections removes sections of files already changed as a diff file created by CreateDiffPatchFile does on dir from it
+//
+// A section is already applied if it can be applied in reverse, e.g. by 'git apply' interrupted after writing some files.
+// It returns paths of the removed files.
+func SkipAppliedDiffSections(dir, filepath string, pathOpts PatchPathOptions) ([]string, errors.GitGhostError) {
+	contextArgs, ggerr := applyContextArgs(filepath)
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	args := append(append([]string{"-C", dir, "apply", "--reverse", "--check"}, contextArgs...), pathOpts.args()...)
+
+	skipped := []string{}
+	ggerr = withSectionFile(func(sectionFile string) errors.GitGhostError {
+		return rewritePatchFile(filepath, isDiffSectionStart, func(section string) (string, errors.GitGhostError) {
+			if !isDiffSectionStart(section) {
+				return section, nil
+			}
+			err := ioutil.WriteFile(sectionFile, []byte(section), 0600)
+			if err != nil {
+				return "", errors.WithStack(err)
+			}
+			if util.JustRunCmd(exec.Command("git", append(args, sectionFile)...)) != nil {
+				return section, nil
+			}
+			paths, ggerr := ListPatchPaths(dir, sectionFile, pathOpts)
+			if ggerr != nil {
+				return "", ggerr
+			}
+			skipped = append(skipped, paths...)
+			return "", nil
+		})
+	})
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	return skipped, nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// VerifyPatchRoundtrip checks that re-diffing base (a commit or a tree) against itself with a patch file applied reproduces the patch file
+//
+// The patch is applied to a temporary index, so neither the index nor the working tree of dir is modified.
+// Both diffs are compared by their sections normalized by normalizeSection regardless of their order
+// (sections of non-indexed files are appended after the others, see AppendNonIndexedDiffFiles),
+// since they may differ in ways which never change their results.
+// The re-diff has the same context lines and ignored lines as opts.
+func VerifyPatchRoundtrip(dir, base, filepath string, opts DiffOptions) errors.GitGhostError {
+	tree, ggerr := WritePatchedTree(dir, base, []string{filepath})
+	if ggerr != nil {
+		return errors.WithCategory(errors.Errorf("diff does not apply cleanly to %s: %s", base, ggerr), errors.CategoryConflict)
+	}
+	rediff, err := ioutil.TempFile(util.TempDir(), "git-ghost-rediff")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer util.LogDeferredError(func() error { return os.Remove(rediff.Name()) })
+	ggerr = writeDiff(dir, opts, []string{base, tree}, nil, rediff)
+	if cerr := rediff.Close(); ggerr == nil {
+		ggerr = errors.WithStack(cerr)
+	}
+	if ggerr != nil {
+		return ggerr
+	}
+	original, ggerr := sectionDigests(filepath)
+	if ggerr != nil {
+		return ggerr
+	}
+	rediffed, ggerr := sectionDigests(rediff.Name())
+	if ggerr != nil {
+		return ggerr
+	}
+	onlyOriginal := sectionsOnlyIn(original, rediffed)
+	onlyRediff := sectionsOnlyIn(rediffed, original)
+	if len(onlyOriginal) > 0 || len(onlyRediff) > 0 {
+		log.WithFields(log.Fields{
+			"base":     base,
+			"original": onlyOriginal,
+			"rediff":   onlyRediff,
+		}).Debug("diff does not round-trip")
+		return errors.Errorf("diff does not round-trip: re-diffing %s with the diff applied results in a different diff (see -vv for sections differing in them)", base)
+	}
+	return nil
+}
+
+// sectionDigests maps digests of sections of a patch file normalized by normalizeSection to their first lines
+//
+// Only digests are kept, so a huge patch file is compared with memory bounded by its largest section.
+func sectionDigests(filepath string) (map[string]string, errors.GitGhostError) {
+	digests := map[string]string{}
+	ggerr := scanPatchFile(filepath, isDiffSectionStart, func(section string) errors.GitGhostError {
+		sum := sha256.Sum256([]byte(normalizeSection(section)))
+		digests[hex.EncodeToString(sum[:])] = strings.SplitN(section, "\n", 2)[0]
+		return nil
+	})
+	return digests, ggerr
+}
+
+// sectionsOnlyIn returns first lines of sections in digests which are not in others in sorted order
+func sectionsOnlyIn(digests, others map[string]string) []string {
+	lines := []string{}
+	for digest, line := range digests {
+		if _, ok := others[digest]; !ok {
+			lines = append(lines, line)
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// normalizeSection returns a section of a patch without "index" lines, whose hashes are abbreviated to lengths depending on objects in the repo
+func normalizeSection(section string) string {
+	lines := []string{}
+	for _, line := range strings.SplitAfter(section, "\n") {
+		if !strings.HasPrefix(line, "index ") {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "")
+}
@@ -0,0 +1,179 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// scpLikeURLPattern matches a URL in the scp-like syntax "[user@]host:path", which git distinguishes from a local path by a colon before any slash
+var scpLikeURLPattern = regexp.MustCompile(`^[^/]*:`)
+
+// IsSameRepo checks repo, a URL or a local path of a ghost repo, points to the repository of dir itself
+//
+// A local path is compared with dir by their git dirs (the common one for linked worktrees),
+// and a URL with remote URLs of dir, so that ghost branches are never pushed to the source repo or its remotes.
+func IsSameRepo(dir, repo string) (bool, errors.GitGhostError) {
+	if path, ok := localRepoPath(repo); ok {
+		repoGitDir, ggerr := commonGitDir(path)
+		if ggerr != nil {
+			// not a repository, which fails later with a clearer error
+			return false, nil
+		}
+		srcGitDir, ggerr := commonGitDir(dir)
+		if ggerr != nil {
+			return false, ggerr
+		}
+		if repoGitDir == srcGitDir {
+			return true, nil
+		}
+	}
+
+	output, ggerr := util.JustOutputCmd(
+		exec.Command("git", "-C", dir, "config", "--get-regexp", `^remote\..*\.url$`),
+	)
+	if ggerr != nil {
+		// exit 1 is for no remotes
+		if util.GetExitCode(ggerr.Cause()) == 1 {
+			return false, nil
+		}
+		return false, ggerr
+	}
+	normalized := normalizeRepoURL(dir, repo)
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && normalizeRepoURL(dir, fields[1]) == normalized {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// SourceRepo returns a URL identifying the repository of dir to be recorded in ghosts created from it
+//
+// It is a URL of the remote "origin" (or the first remote if there is no origin) without user info, which may contain a password,
+// or an absolute path of the top directory of dir if it has no remotes.
+func SourceRepo(dir string) (string, errors.GitGhostError) {
+	output, ggerr := util.JustOutputCmd(
+		exec.Command("git", "-C", dir, "remote"),
+	)
+	if ggerr != nil {
+		return "", ggerr
+	}
+	remotes := strings.Fields(string(output))
+	if len(remotes) == 0 {
+		output, ggerr = util.JustOutputCmd(
+			exec.Command("git", "-C", dir, "rev-parse", "--show-toplevel"),
+		)
+		if ggerr != nil {
+			return "", ggerr
+		}
+		return evalPath(strings.TrimSpace(string(output)))
+	}
+	remote := remotes[0]
+	for _, r := range remotes {
+		if r == ORIGIN {
+			remote = r
+		}
+	}
+	repo, _, ggerr := GetConfig(dir, "remote."+remote+".url")
+	if ggerr != nil {
+		return "", ggerr
+	}
+	if path, ok := localRepoPath(repo); ok {
+		return normalizeRepoURL(dir, path), nil
+	}
+	return stripURLUser(repo), nil
+}
+
+// stripURLUser removes user info (e.g. "user:password@") from repo if it is a URL with a protocol
+func stripURLUser(repo string) string {
+	if !strings.Contains(repo, "://") {
+		return repo
+	}
+	u, err := url.Parse(repo)
+	if err != nil || u.User == nil {
+		return repo
+	}
+	u.User = nil
+	return u.String()
+}
+
+// localRepoPath returns a path of repo if it is a local one
+func localRepoPath(repo string) (string, bool) {
+	if strings.HasPrefix(repo, "file://") {
+		return strings.TrimPrefix(repo, "file://"), true
+	}
+	if strings.Contains(repo, "://") || scpLikeURLPattern.MatchString(repo) {
+		return "", false
+	}
+	return repo, true
+}
+
+// commonGitDir returns an absolute path of the git dir shared by all the worktrees of the repository containing dir
+func commonGitDir(dir string) (string, errors.GitGhostError) {
+	output, ggerr := util.JustOutputCmd(
+		exec.Command("git", "-C", dir, "rev-parse", "--git-common-dir"),
+	)
+	if ggerr != nil {
+		return "", ggerr
+	}
+	path := strings.TrimSpace(string(output))
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	return evalPath(path)
+}
+
+// normalizeRepoURL normalizes repo so that URLs of the same repository compare equal,
+// resolving a local path relative to dir as a remote URL of dir is and ignoring user info of a URL
+func normalizeRepoURL(dir, repo string) string {
+	if path, ok := localRepoPath(repo); ok {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		if resolved, ggerr := evalPath(path); ggerr == nil {
+			path = resolved
+		}
+		repo = path
+	} else {
+		repo = stripURLUser(repo)
+	}
+	repo = strings.TrimSuffix(strings.TrimRight(repo, "/"), ".git")
+	return strings.TrimRight(repo, "/")
+}
+
+func evalPath(path string) (string, errors.GitGhostError) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	resolved, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return filepath.Clean(abs), nil
+		}
+		return "", errors.WithStack(err)
+	}
+	return resolved, nil
+}
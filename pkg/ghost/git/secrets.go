@@ -0,0 +1,141 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// SecretRule is a pattern of a secret (e.g. a credential) which must not be ghosted
+type SecretRule struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+// DefaultSecretRules are rules of well-known credentials which are easy to match without false positives
+var DefaultSecretRules = []SecretRule{
+	{Name: "AWS access key ID", Pattern: regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{Name: "AWS secret access key", Pattern: regexp.MustCompile(`(?i)aws_secret_access_key["']?\s*[:=]\s*["']?[A-Za-z0-9/+=]{40}\b`)},
+	{Name: "private key", Pattern: regexp.MustCompile(`-----BEGIN ([A-Z0-9]+ )*PRIVATE KEY( BLOCK)?-----`)},
+	{Name: "GitHub token", Pattern: regexp.MustCompile(`\b(gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{Name: "Slack token", Pattern: regexp.MustCompile(`\bxox[abposr]-[0-9A-Za-z-]{10,}`)},
+}
+
+// LoadSecretRules reads rules from a file which has a regular expression (RE2 syntax) on each line
+//
+// Empty lines and lines starting with '#' are ignored. Each rule is named after its file and line number.
+func LoadSecretRules(filepath string) ([]SecretRule, errors.GitGhostError) {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer util.LogDeferredError(f.Close)
+	rules := []SecretRule{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pattern, err := regexp.Compile(line)
+		if err != nil {
+			return nil, errors.Errorf("%s:%d: %s", filepath, n, err)
+		}
+		rules = append(rules, SecretRule{Name: fmt.Sprintf("%s:%d", filepath, n), Pattern: pattern})
+	}
+	return rules, errors.WithStack(scanner.Err())
+}
+
+// SecretFinding is a line added by a patch which matches a SecretRule
+type SecretFinding struct {
+	Path string
+	// Line is the line number in the file after applying the patch
+	Line int
+	Rule string
+}
+
+func (f SecretFinding) String() string {
+	return fmt.Sprintf("%s:%d (%s)", f.Path, f.Line, f.Rule)
+}
+
+var hunkHeaderPattern = regexp.MustCompile(`^@@ -[0-9]+(,[0-9]+)? \+([0-9]+)(,[0-9]+)? @@`)
+
+// ScanPatchSecrets returns lines added by a patch file (a diff or patches of commits) which match any of rules
+//
+// Only added lines of text hunks are scanned, so neither removed lines, commit messages nor binary hunks are.
+// Matched texts are not included in the findings so that they are never leaked into logs.
+func ScanPatchSecrets(filepath string, rules []SecretRule) ([]SecretFinding, errors.GitGhostError) {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer util.LogDeferredError(f.Close)
+
+	findings := []SecretFinding{}
+	path := ""
+	line := 0
+	inHunk := false
+	reader := bufio.NewReader(f)
+	for {
+		text, err := reader.ReadString('\n')
+		text = strings.TrimRight(text, "\n")
+		switch {
+		case strings.HasPrefix(text, "diff --git "), emailPatchStartPattern.MatchString(text):
+			inHunk = false
+		case !inHunk && strings.HasPrefix(text, "+++ "):
+			path = unquotePatchPath(strings.TrimPrefix(text, "+++ "))
+		case strings.HasPrefix(text, "@@ "):
+			m := hunkHeaderPattern.FindStringSubmatch(text)
+			inHunk = m != nil
+			if inHunk {
+				line, _ = strconv.Atoi(m[2])
+			}
+		case !inHunk:
+		case strings.HasPrefix(text, "+"):
+			for _, rule := range rules {
+				if rule.Pattern.MatchString(text[1:]) {
+					findings = append(findings, SecretFinding{Path: path, Line: line, Rule: rule.Name})
+				}
+			}
+			line++
+		case strings.HasPrefix(text, " "), text == "":
+			line++
+		case strings.HasPrefix(text, "-"), strings.HasPrefix(text, "\\"):
+		default:
+			inHunk = false
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	return findings, nil
+}
+
+// unquotePatchPath returns a path in a '+++' line of a patch without its "b/" prefix
+func unquotePatchPath(path string) string {
+	return strings.TrimPrefix(unquotePath(path), "b/")
+}
@@ -0,0 +1,173 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Snapshot is a state of a work tree taken by TakeSnapshot, which RestoreSnapshot brings it back to
+type Snapshot struct {
+	// Head is the commit HEAD points to
+	Head string
+	// Ref is the branch HEAD is a symbolic ref to, or empty if HEAD is detached
+	Ref string
+	// WorkTree is a tree of all the files in the work tree including untracked ones, but not ignored ones
+	WorkTree string
+	// index is the content of the index file as it is, or nil if there is no index file
+	index []byte
+}
+
+// TakeSnapshot takes a snapshot of HEAD, the index and the work tree of dir
+//
+// Files are written into the object database of dir as a tree like 'git stash -u' but no ref or reflog is updated.
+func TakeSnapshot(dir string) (*Snapshot, errors.GitGhostError) {
+	head, err := ResolveCommittish(dir, "HEAD")
+	if err != nil {
+		return nil, errors.Errorf("HEAD of %s can not be resolved: %s", dir, err)
+	}
+	s := Snapshot{Head: head}
+	output, err := util.JustOutputCmd(exec.Command("git", "-C", dir, "symbolic-ref", "-q", "HEAD"))
+	if err == nil {
+		s.Ref = strings.TrimRight(string(output), "\r\n")
+	}
+	indexPath, err := ResolveGitPath(dir, "index")
+	if err != nil {
+		return nil, err
+	}
+	index, rerr := ioutil.ReadFile(indexPath)
+	if rerr != nil && !os.IsNotExist(rerr) {
+		return nil, errors.WithStack(rerr)
+	}
+	s.index = index
+	s.WorkTree, err = withWorkTreeIndex(dir, index, func(env []string) (string, errors.GitGhostError) {
+		return writeTree(dir, env)
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.WithFields(log.Fields{
+		"dir":      dir,
+		"head":     s.Head,
+		"ref":      s.Ref,
+		"workTree": s.WorkTree,
+	}).Debug("took a snapshot")
+	return &s, nil
+}
+
+// RestoreSnapshot brings HEAD, the index and the work tree of dir back to s
+//
+// An operation ('git am' or 'git rebase') left in progress is quit, and files created after s was taken are removed unless they are ignored.
+func RestoreSnapshot(dir string, s *Snapshot) errors.GitGhostError {
+	operation, err := OperationInProgress(dir)
+	if err != nil {
+		return err
+	}
+	if operation != "" {
+		err = QuitOperation(dir, operation)
+		if err != nil {
+			return errors.Errorf("failed to quit '%s' in progress: %s", operation, err)
+		}
+	}
+	cherryPickHead, err := ResolveGitPath(dir, "CHERRY_PICK_HEAD")
+	if err != nil {
+		return err
+	}
+	if exists, _ := util.FileExists(cherryPickHead); exists {
+		err = util.JustRunCmd(exec.Command("git", "-C", dir, "cherry-pick", "--quit"))
+		if err != nil {
+			return errors.Errorf("failed to quit 'cherry-pick' in progress: %s", err)
+		}
+	}
+
+	if s.Ref != "" {
+		err = util.JustRunCmd(exec.Command("git", "-C", dir, "update-ref", "-m", "git-ghost: rollback", s.Ref, s.Head))
+		if err == nil {
+			err = util.JustRunCmd(exec.Command("git", "-C", dir, "symbolic-ref", "HEAD", s.Ref))
+		}
+	} else {
+		err = util.JustRunCmd(exec.Command("git", "-C", dir, "update-ref", "--no-deref", "-m", "git-ghost: rollback", "HEAD", s.Head))
+	}
+	if err != nil {
+		return errors.Errorf("failed to reset HEAD to %s: %s", s.Head, err)
+	}
+
+	// all the files in the work tree are staged in a temporary index first,
+	// so that reading the tree of s removes ones created after s was taken as well as updates the others
+	indexPath, err := ResolveGitPath(dir, "index")
+	if err != nil {
+		return err
+	}
+	current, rerr := ioutil.ReadFile(indexPath)
+	if rerr != nil && !os.IsNotExist(rerr) {
+		return errors.WithStack(rerr)
+	}
+	_, err = withWorkTreeIndex(dir, current, func(env []string) (string, errors.GitGhostError) {
+		return "", util.JustRunCmd(withEnv(exec.Command("git", "-C", dir, "read-tree", "--reset", "-u", s.WorkTree), env))
+	})
+	if err != nil {
+		return errors.Errorf("failed to restore the work tree: %s", err)
+	}
+
+	if s.index == nil {
+		rerr = os.Remove(indexPath)
+		if rerr != nil && !os.IsNotExist(rerr) {
+			return errors.WithStack(rerr)
+		}
+		return nil
+	}
+	err = util.WriteFileAtomically(indexPath, s.index)
+	if err != nil {
+		return errors.Errorf("failed to restore the index: %s", err)
+	}
+	// stat info of the files rewritten above is stale in the restored index, which exits with 1 on files modified in the work tree
+	_ = util.JustRunCmd(exec.Command("git", "-C", dir, "update-index", "-q", "--refresh"))
+	return nil
+}
+
+// withWorkTreeIndex calls f with envs of a temporary index starting from index, to which all the files in the work tree of dir are staged
+func withWorkTreeIndex(dir string, index []byte, f func(env []string) (string, errors.GitGhostError)) (string, errors.GitGhostError) {
+	indexFile, err := ioutil.TempFile(util.TempDir(), "git-ghost-index")
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer util.LogDeferredError(func() error { return os.Remove(indexFile.Name()) })
+	_, err = indexFile.Write(index)
+	util.LogDeferredError(indexFile.Close)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if index == nil {
+		// git fails with an empty index file, so let git create it
+		err = os.Remove(indexFile.Name())
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+	}
+	env := indexEnv(indexFile.Name())
+	ggerr := util.JustRunCmd(withEnv(exec.Command("git", "-C", dir, "add", "--all"), env))
+	if ggerr != nil {
+		return "", ggerr
+	}
+	return f(env)
+}
@@ -0,0 +1,146 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+var sshCommand string
+
+// SetSSHCommand sets a command which git uses to connect to remote repos over SSH (as GIT_SSH_COMMAND)
+//
+// The command is never logged because it may contain a path to an identity file.
+func SetSSHCommand(command string) {
+	sshCommand = command
+}
+
+// BuildSSHCommand returns an SSH command which uses identityFile (if not empty) on top of base command (default to ssh)
+func BuildSSHCommand(base, identityFile string) string {
+	if identityFile == "" {
+		return base
+	}
+	if base == "" {
+		base = "ssh"
+	}
+	return base + " -i " + util.ShellQuote(identityFile) + " -o IdentitiesOnly=yes"
+}
+
+var offline bool
+
+// SetOffline restricts remote repos which git talks to to local ones (with file protocol) if offline is true
+//
+// Talking to a remote repo over network fails immediately then.
+func SetOffline(o bool) {
+	offline = o
+}
+
+var proxy string
+
+// SetProxy sets a proxy which git uses to talk to remote repos over HTTP(S) (as http.proxy config) if not empty
+//
+// It overrides proxies in environment variables (e.g. HTTPS_PROXY), while NO_PROXY is still honored.
+func SetProxy(p string) {
+	proxy = p
+}
+
+var caBundle string
+
+// SetCABundle sets a file of CA certificates in PEM which git verifies remote repos over HTTPS with (as http.sslCAInfo config) if not empty
+//
+// It overrides GIT_SSL_CAINFO env as well. caBundle must be an absolute path because remote git commands may run in other directories.
+func SetCABundle(b string) {
+	caBundle = b
+}
+
+// EnvProxy returns a proxy in environment variables which git uses to talk to repo and the name of the variable
+//
+// Both of them are empty if repo is not of HTTP(S), or no proxy is set.
+func EnvProxy(repo string) (string, string) {
+	var names []string
+	switch {
+	case strings.HasPrefix(repo, "https://"):
+		names = []string{"https_proxy", "HTTPS_PROXY", "all_proxy", "ALL_PROXY"}
+	case strings.HasPrefix(repo, "http://"):
+		// curl ignores HTTP_PROXY in upper case
+		names = []string{"http_proxy", "all_proxy", "ALL_PROXY"}
+	}
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value, name
+		}
+	}
+	return "", ""
+}
+
+// remoteCommand returns a git command which talks to a remote repo
+func remoteCommand(args ...string) *exec.Cmd {
+	if proxy != "" {
+		args = append([]string{"-c", "http.proxy=" + proxy}, args...)
+	}
+	if caBundle != "" {
+		args = append([]string{"-c", "http.sslCAInfo=" + caBundle}, args...)
+	}
+	cmd := exec.Command("git", args...)
+	env := []string{}
+	if sshCommand != "" {
+		env = append(env, "GIT_SSH_COMMAND="+sshCommand)
+	}
+	if offline {
+		env = append(env, "GIT_ALLOW_PROTOCOL=file")
+	}
+	if caBundle != "" {
+		// GIT_SSL_CAINFO env takes precedence over http.sslCAInfo config
+		env = append(env, "GIT_SSL_CAINFO="+caBundle)
+	}
+	if nonInteractive && os.Getenv("GIT_TERMINAL_PROMPT") == "" {
+		env = append(env, "GIT_TERMINAL_PROMPT=0")
+	}
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	return cmd
+}
+
+// runRemoteCommand runs a git command which talks to a remote repo, whose failure is classified as errors.CategoryRemote
+func runRemoteCommand(args ...string) errors.GitGhostError {
+	return remoteError(util.JustRunCmd(remoteCommand(args...)))
+}
+
+// outputRemoteCommand is the same as runRemoteCommand except that it returns the output
+func outputRemoteCommand(args ...string) ([]byte, errors.GitGhostError) {
+	output, err := util.JustOutputCmd(remoteCommand(args...))
+	return output, remoteError(err)
+}
+
+// scanRemoteCommand is the same as runRemoteCommand except that it calls f with each line of the output as it comes
+//
+// Errors returned by f are returned as they are without being classified.
+func scanRemoteCommand(f func(line string) errors.GitGhostError, args ...string) errors.GitGhostError {
+	var ferr errors.GitGhostError
+	err := util.ScanCmdLines(remoteCommand(args...), func(line string) errors.GitGhostError {
+		ferr = f(line)
+		return ferr
+	})
+	if ferr != nil {
+		return ferr
+	}
+	return remoteError(err)
+}
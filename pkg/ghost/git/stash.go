@@ -0,0 +1,90 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// Stash is a stash entry created by 'git stash'
+//
+// A stash is a merge commit of Base (HEAD at stashing) and the index state, and has the untracked files as the third parent
+// if it is created by 'git stash -u'.
+type Stash struct {
+	Commit    string
+	Base      string
+	Untracked string
+}
+
+// ResolveStash resolves a stash ref (e.g. stash@{2}) in dir and checks it is really a stash entry
+func ResolveStash(dir, ref string) (*Stash, errors.GitGhostError) {
+	output, ggerr := util.JustOutputCmd(
+		exec.Command("git", "-C", dir, "rev-list", "--parents", "-n", "1", ref, "--"),
+	)
+	if ggerr != nil {
+		return nil, errors.WithCategory(errors.Errorf("stash %s does not exist", ref), errors.CategoryNotFound)
+	}
+	commits := strings.Fields(string(output))
+	if len(commits) != 3 && len(commits) != 4 {
+		return nil, errors.Errorf("%s is not a stash entry, which has two or three parents", ref)
+	}
+	indexParent, ggerr := util.JustOutputCmd(
+		exec.Command("git", "-C", dir, "rev-parse", commits[2]+"^1"),
+	)
+	if ggerr != nil || strings.TrimSpace(string(indexParent)) != commits[1] {
+		return nil, errors.Errorf("%s is not a stash entry, whose second parent is the index state on its base", ref)
+	}
+	stash := &Stash{
+		Commit: commits[0],
+		Base:   commits[1],
+	}
+	if len(commits) == 4 {
+		stash.Untracked = commits[3]
+	}
+	return stash, nil
+}
+
+// CreateStashPatchFile creates a diff of a stash from its base including its untracked files and save it to filepath
+func CreateStashPatchFile(dir, filepath string, stash Stash) errors.GitGhostError {
+	f, err := os.OpenFile(filepath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer util.LogDeferredError(f.Close)
+
+	cmd := exec.Command("git", "-C", dir, "diff", "--patience", "--binary", stash.Base, stash.Commit)
+	cmd.Stdout = f
+	if ggerr := util.JustRunCmd(cmd); ggerr != nil {
+		return ggerr
+	}
+	if stash.Untracked == "" {
+		return nil
+	}
+	// the untracked commit is a root commit, so its files are diffed from the empty tree
+	emptyTree, ggerr := util.JustOutputCmd(
+		exec.Command("git", "-C", dir, "hash-object", "-t", "tree", "/dev/null"),
+	)
+	if ggerr != nil {
+		return ggerr
+	}
+	cmd = exec.Command("git", "-C", dir, "diff", "--patience", "--binary", strings.TrimSpace(string(emptyTree)), stash.Untracked)
+	cmd.Stdout = f
+	return util.JustRunCmd(cmd)
+}
@@ -0,0 +1,140 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// PatchStats represents statistics of a patch file created by CreateDiffBundleFile or CreateDiffPatchFile
+type PatchStats struct {
+	// Files is the number of distinct files changed in the patch
+	Files int `json:"files"`
+	// Bytes is the size of the patch file
+	Bytes int64 `json:"bytes"`
+	// Commits is the number of commits in the patch (always 0 for a diff patch)
+	Commits int `json:"commits"`
+	// LargestFile is the file whose diff is largest in the patch
+	LargestFile string `json:"largestFile,omitempty"`
+	// LargestFileBytes is the size of diff of LargestFile
+	LargestFileBytes int64 `json:"largestFileBytes"`
+	// fileBytes maps each file to the size of its diff in the patch
+	fileBytes map[string]int64
+}
+
+// FileSize represents a file and the size of its diff in a patch
+type FileSize struct {
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+}
+
+// LargestFiles returns at most n files whose diffs are largest in the patch, in descending order of their sizes
+//
+// Files of the same size are ordered by their paths.
+func (stats *PatchStats) LargestFiles(n int) []FileSize {
+	files := make([]FileSize, 0, len(stats.fileBytes))
+	for path, size := range stats.fileBytes {
+		files = append(files, FileSize{Path: path, Bytes: size})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].Bytes != files[j].Bytes {
+			return files[i].Bytes > files[j].Bytes
+		}
+		return files[i].Path < files[j].Path
+	})
+	if len(files) > n {
+		files = files[:n]
+	}
+	return files
+}
+
+const diffHeaderPrefix = "diff --git "
+
+// GetPatchStats computes statistics of a patch file by streaming it
+func GetPatchStats(filepath string) (*PatchStats, errors.GitGhostError) {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer util.LogDeferredError(f.Close)
+
+	stats := PatchStats{fileBytes: map[string]int64{}}
+	current := ""
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			stats.Bytes += int64(len(line))
+			switch {
+			case emailPatchStartPattern.MatchString(line):
+				stats.Commits++
+				current = ""
+			case strings.HasPrefix(line, diffHeaderPrefix):
+				current = diffTargetPath(line)
+			}
+			if current != "" {
+				stats.fileBytes[current] += int64(len(line))
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	stats.Files = len(stats.fileBytes)
+	if largest := stats.LargestFiles(1); len(largest) > 0 {
+		stats.LargestFile = largest[0].Path
+		stats.LargestFileBytes = largest[0].Bytes
+	}
+	return &stats, nil
+}
+
+// diffTargetPath extracts a path from a line like "diff --git a/path b/path"
+func diffTargetPath(line string) string {
+	paths := strings.TrimSuffix(strings.TrimPrefix(line, diffHeaderPrefix), "\n")
+	if strings.HasPrefix(paths, "\"") {
+		// paths with special characters (e.g. non-ASCII bytes) are quoted by git
+		_, rest := splitQuotedPath(paths)
+		target, _ := splitQuotedPath(rest)
+		return strings.TrimPrefix(target, "b/")
+	}
+	if !strings.HasPrefix(paths, "a/") {
+		return paths
+	}
+	paths = paths[2:]
+	if i := strings.LastIndex(paths, " \"b/"); i >= 0 && strings.HasSuffix(paths, "\"") {
+		// only a renamed path is quoted
+		return strings.TrimPrefix(unquotePath(paths[i+1:]), "b/")
+	}
+	// both paths are the same except for renames, so split at the middle when they are
+	if n := (len(paths) - 3) / 2; n >= 0 && len(paths)%2 == 1 && paths[:n] == paths[n+3:] && paths[n:n+3] == " b/" {
+		return paths[:n]
+	}
+	i := strings.LastIndex(paths, " b/")
+	if i < 0 {
+		return paths
+	}
+	return paths[i+3:]
+}
@@ -0,0 +1,108 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// isDiffSectionStart checks line starts a section of a file in a patch
+func isDiffSectionStart(line string) bool {
+	return strings.HasPrefix(line, "diff --git ")
+}
+
+// isEmailPatchStart checks line starts a patch of a commit in patches in the email format
+func isEmailPatchStart(line string) bool {
+	return emailPatchStartPattern.MatchString(line)
+}
+
+// scanPatchFile calls f with each part of a patch file, which is split before lines for which start returns true
+//
+// Lines before the first of them are passed as the first part.
+// Only one part is read into memory at a time, so a huge patch file is scanned with memory bounded by its largest part
+// (e.g. a section of one file or a patch of one commit).
+func scanPatchFile(path string, start func(line string) bool, f func(part string) errors.GitGhostError) errors.GitGhostError {
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer util.LogDeferredError(file.Close)
+
+	reader := bufio.NewReader(file)
+	var part strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			if start(line) && part.Len() > 0 {
+				ggerr := f(part.String())
+				if ggerr != nil {
+					return ggerr
+				}
+				part.Reset()
+			}
+			part.WriteString(line)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	if part.Len() > 0 {
+		return f(part.String())
+	}
+	return nil
+}
+
+// rewritePatchFile rewrites a patch file in place with what rewrite returns for each part split as scanPatchFile does
+//
+// The rewritten parts are written to a temporary file next to the patch file as they come, which replaces it at last,
+// so the patch file is left as it is on errors.
+func rewritePatchFile(path string, start func(line string) bool, rewrite func(part string) (string, errors.GitGhostError)) errors.GitGhostError {
+	dst, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".rewritten")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	dstPath := dst.Name()
+	writer := bufio.NewWriter(dst)
+	ggerr := scanPatchFile(path, start, func(part string) errors.GitGhostError {
+		rewritten, ggerr := rewrite(part)
+		if ggerr != nil {
+			return ggerr
+		}
+		_, err := writer.WriteString(rewritten)
+		return errors.WithStack(err)
+	})
+	if ggerr == nil {
+		ggerr = errors.WithStack(writer.Flush())
+	}
+	if cerr := dst.Close(); ggerr == nil {
+		ggerr = errors.WithStack(cerr)
+	}
+	if ggerr != nil {
+		util.LogDeferredError(func() error { return os.Remove(dstPath) })
+		return ggerr
+	}
+	return errors.WithStack(os.Rename(dstPath, path))
+}
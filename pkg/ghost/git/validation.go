@@ -15,17 +15,95 @@
 package git
 
 import (
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/url"
+	"os"
 	"os/exec"
+	"strings"
 
 	"github.com/pfnet-research/git-ghost/pkg/util"
 	"github.com/pfnet-research/git-ghost/pkg/util/errors"
 )
 
-// ValidateGit check the environment has 'git' command or not.
+// ValidateGit check the environment has 'git' command or not, detecting its version at once
+//
+// An unknown version of git is accepted, which RequireVersion lets fail by itself.
 func ValidateGit() errors.GitGhostError {
-	return util.JustRunCmd(
-		exec.Command("git", "version"),
+	detectVersion()
+	return gitErr
+}
+
+// ValidateWorkTree checks dir exists and is inside a git work tree
+//
+// It prevents confusing errors of git commands run on dir by '-C'.
+func ValidateWorkTree(dir string) errors.GitGhostError {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return errors.WithCategory(errors.Errorf("directory %s is not found", dir), errors.CategoryNotFound)
+	}
+	if !fi.IsDir() {
+		return errors.Errorf("%s is not a directory", dir)
+	}
+	output, ggerr := util.JustOutputCmd(
+		exec.Command("git", "-C", dir, "rev-parse", "--is-inside-work-tree"),
 	)
+	if ggerr != nil || strings.TrimSpace(string(output)) != "true" {
+		return errors.Errorf("%s is not inside a git work tree", dir)
+	}
+	return nil
+}
+
+// ValidateProxy checks proxy is a URL of a proxy which git supports ([protocol://][user[:password]@]host[:port] by http.proxy config)
+//
+// The password is never included in errors.
+func ValidateProxy(proxy string) errors.GitGhostError {
+	value := proxy
+	if !strings.Contains(value, "://") {
+		value = "http://" + value
+	}
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		return errors.Errorf("%s is not a valid proxy url", util.RedactURLPassword(value))
+	}
+	switch u.Scheme {
+	case "http", "https", "socks4", "socks4a", "socks5", "socks5h":
+		return nil
+	}
+	return errors.Errorf("protocol %s of proxy is not supported. it must be one of http, https, socks4, socks4a, socks5 or socks5h", u.Scheme)
+}
+
+// ValidateCABundle checks caBundle is a readable file of CA certificates in PEM, which contains at least one certificate
+func ValidateCABundle(caBundle string) errors.GitGhostError {
+	err := util.ValidateReadableFile(caBundle)
+	if err != nil {
+		return err
+	}
+	data, rerr := ioutil.ReadFile(caBundle)
+	if rerr != nil {
+		return errors.WithStack(rerr)
+	}
+	found := false
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		_, perr := x509.ParseCertificate(block.Bytes)
+		if perr != nil {
+			return errors.Errorf("%s contains an invalid certificate: %s", caBundle, perr)
+		}
+		found = true
+	}
+	if !found {
+		return errors.Errorf("%s contains no certificate in PEM", caBundle)
+	}
+	return nil
 }
 
 // ValidateCommittish check committish is valid on dir
@@ -35,7 +113,16 @@ func ValidateCommittish(dir, committish string) errors.GitGhostError {
 	)
 	if err != nil && util.GetExitCode(err.Cause()) == 1 && len(output) == 0 {
 		// exit 1 is for unexisting committish.
-		return errors.Errorf("%s does not exist", committish)
+		return errors.WithCategory(errors.Errorf("%s does not exist", committish), errors.CategoryNotFound)
 	}
 	return err
 }
+
+// ValidateRefName checks ref is a valid full ref name like "refs/heads/<branch>" by git's rules
+func ValidateRefName(ref string) errors.GitGhostError {
+	err := util.JustRunCmd(exec.Command("git", "check-ref-format", ref))
+	if err != nil {
+		return errors.Errorf("%s is not a valid ref name", ref)
+	}
+	return nil
+}
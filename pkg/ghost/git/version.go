@@ -0,0 +1,125 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Version is a version of git
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+	// Raw is the version as git prints it (e.g. "2.20.1 (Apple Git-117)")
+	Raw string
+}
+
+func (v Version) String() string {
+	return v.Raw
+}
+
+// AtLeast checks v is major.minor or newer
+func (v Version) AtLeast(major, minor int) bool {
+	if v.Major != major {
+		return v.Major > major
+	}
+	return v.Minor >= minor
+}
+
+var (
+	// versions of vendors like "2.39.5.windows.1" or "2.20.1 (Apple Git-117)" are accepted by their leading numbers
+	regexpGitVersion = regexp.MustCompile(`^git version ((\d+)\.(\d+)(?:\.(\d+))?.*)$`)
+	regexpMinVersion = regexp.MustCompile(`^(\d+)\.(\d+)$`)
+
+	installedVersion    Version
+	installedVersionErr errors.GitGhostError
+	// gitErr is an error of running git itself, while installedVersionErr is of parsing its version
+	gitErr            errors.GitGhostError
+	detectVersionOnce sync.Once
+)
+
+// ParseVersion parses an output of 'git version'
+func ParseVersion(output string) (Version, errors.GitGhostError) {
+	m := regexpGitVersion.FindStringSubmatch(output)
+	if m == nil {
+		return Version{}, errors.Errorf("unknown output of git version: %s", output)
+	}
+	v := Version{Raw: m[1]}
+	v.Major, _ = strconv.Atoi(m[2])
+	v.Minor, _ = strconv.Atoi(m[3])
+	if m[4] != "" {
+		v.Patch, _ = strconv.Atoi(m[4])
+	}
+	return v, nil
+}
+
+// ParseMinVersion parses a minimum version of git in the form of "X.Y"
+func ParseMinVersion(s string) (int, int, errors.GitGhostError) {
+	m := regexpMinVersion.FindStringSubmatch(s)
+	if m == nil {
+		return 0, 0, errors.Errorf("%s is not a version in the form of X.Y", s)
+	}
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+	return major, minor, nil
+}
+
+// InstalledVersion returns the version of git in PATH, which is detected only once per run
+func InstalledVersion() (Version, errors.GitGhostError) {
+	detectVersion()
+	if gitErr != nil {
+		return Version{}, gitErr
+	}
+	return installedVersion, installedVersionErr
+}
+
+func detectVersion() {
+	detectVersionOnce.Do(func() {
+		output, err := util.JustOutputCmd(exec.Command("git", "version"))
+		if err != nil {
+			gitErr = err
+			return
+		}
+		installedVersion, installedVersionErr = ParseVersion(strings.TrimRight(string(output), "\r\n"))
+		if installedVersionErr == nil {
+			log.WithField("version", installedVersion.Raw).Debug("detected git version")
+		}
+	})
+}
+
+// RequireVersion checks the installed git is major.minor or newer, which operation requires
+//
+// It lets git itself fail if the version is unknown, so git of an unusual version string is never rejected.
+func RequireVersion(operation string, major, minor int) errors.GitGhostError {
+	v, err := InstalledVersion()
+	if err != nil {
+		log.WithField("operation", operation).Debugf("failed to detect git version: %s", err)
+		return nil
+	}
+	if v.AtLeast(major, minor) {
+		return nil
+	}
+	return errors.WithCategory(errors.Errorf("%s requires git >= %d.%d; found %s. please upgrade git", operation, major, minor, v), errors.CategoryConfig)
+}
@@ -0,0 +1,299 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	multierror "github.com/hashicorp/go-multierror"
+	log "github.com/sirupsen/logrus"
+)
+
+// PushGroupOptions represents arg for PushGroup func
+type PushGroupOptions struct {
+	// WorkingEnvSpec is of the root directory of the group, which the paths of the repos are relative to
+	types.WorkingEnvSpec
+	Prefix string
+	// Components are pushes of the repos in the group, whose SrcDir are the directories of the repos
+	Components []PushOptions
+}
+
+// PullGroupOptions represents arg for PullGroup func
+type PullGroupOptions struct {
+	// WorkingEnvSpec is of the root directory of the group, which the paths of the repos are relative to
+	types.WorkingEnvSpec
+	Prefix string
+	// GroupHash is a hash (or its unique prefix) of the group branch to pull
+	GroupHash string
+	types.ApplyOptions
+	// AutoStash stashes local changes of each repo before applying its ghost branches and restores them after that
+	AutoStash bool
+	// AllowSiblings allows repos in siblings of the root directory, e.g. "../lib", which are rejected by default
+	AllowSiblings bool
+}
+
+// GroupComponentResult is a result of a repo in a group
+type GroupComponentResult struct {
+	types.GroupComponent
+	// Branches are ghost branches of the repo which are pushed or applied
+	Branches []types.GhostBranch
+	// Err is an error on the repo, or nil if it succeeds
+	Err errors.GitGhostError
+}
+
+// GroupResult contains a group branch and results of its repos
+type GroupResult struct {
+	*types.GroupBranch
+	Components []GroupComponentResult
+}
+
+// PushGroup pushes ghost branches of every repo in the group and a group branch listing them
+//
+// A failure on a repo doesn't stop pushing the others. It is recorded in Err of its result,
+// and the group branch is pushed only if all of them succeed, so that a group is always complete.
+func PushGroup(options PushGroupOptions) (*GroupResult, errors.GitGhostError) {
+	log.WithFields(util.ToFields(options)).Debug("push group command with")
+
+	if len(options.Components) == 0 {
+		return nil, errors.New("no repos to push as a group")
+	}
+	root, err := filepath.Abs(options.SrcDir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	result := GroupResult{}
+	var errs error
+	manifest := types.GroupManifest{}
+	for _, component := range options.Components {
+		c := GroupComponentResult{}
+		c.Path, c.Err = groupComponentPath(root, component.SrcDir)
+		if c.Err == nil {
+			c.Branches, c.Err = pushGroupComponent(component)
+		}
+		if c.Err != nil {
+			errs = multierror.Append(errs, errors.Errorf("failed to push %s: %s", component.SrcDir, c.Err))
+		}
+		for _, branch := range c.Branches {
+			switch branch.(type) {
+			case *types.CommitsBranch:
+				c.Commits = branch.BranchName()
+			case *types.DiffBranch:
+				c.Diff = branch.BranchName()
+			}
+		}
+		manifest.Components = append(manifest.Components, c.GroupComponent)
+		result.Components = append(result.Components, c)
+	}
+	// ghost commits of the group branch records the root as their source repo
+	repo, ggerr := git.SourceRepo(root)
+	if ggerr != nil {
+		return &result, ggerr
+	}
+	types.SetSourceRepo(repo)
+	if errs != nil {
+		return &result, errors.Errorf("%s\nthe group is not pushed because some of the repos failed", errs)
+	}
+
+	we, ggerr := options.WorkingEnvSpec.Initialize()
+	if ggerr != nil {
+		return &result, ggerr
+	}
+	defer util.LogDeferredGitGhostError(we.Clean)
+	result.GroupBranch, ggerr = manifest.CreateBranch(*we, options.Prefix)
+	if ggerr != nil {
+		return &result, ggerr
+	}
+	existence, ggerr := git.ValidateRemoteBranchExistence(we.GhostRepo, result.BranchName())
+	if ggerr != nil || existence {
+		return &result, ggerr
+	}
+	log.WithFields(log.Fields{
+		"branch":    result.BranchName(),
+		"ghostRepo": we.GhostRepo,
+	}).Info("pushing branch")
+	return &result, git.Push(we.GhostDir, result.BranchName())
+}
+
+// groupComponentPath returns a directory of a repo relative to the root of a group
+func groupComponentPath(root, dir string) (string, errors.GitGhostError) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	path, err := filepath.Rel(root, dir)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return filepath.ToSlash(path), nil
+}
+
+// groupComponentDir returns a directory to apply ghost branches of a repo at path relative to the root of a group
+//
+// path is read from ghost repo, so it must not point outside the root: absolute paths and ones going up from the root
+// are rejected, except directories in siblings of the root (e.g. "../lib") if allowSiblings is true.
+func groupComponentDir(root, path string, allowSiblings bool) (string, errors.GitGhostError) {
+	path = filepath.FromSlash(path)
+	if filepath.IsAbs(path) || filepath.VolumeName(path) != "" {
+		return "", errors.Errorf("directory %s of a repo in the group is absolute, which is not applied", path)
+	}
+	clean := filepath.Clean(path)
+	up := ".." + string(filepath.Separator)
+	rel := clean
+	if allowSiblings {
+		rel = strings.TrimPrefix(clean, up)
+	}
+	if rel == ".." || strings.HasPrefix(rel, up) {
+		hint := ""
+		if !allowSiblings && !strings.HasPrefix(strings.TrimPrefix(clean, up), "..") {
+			hint = " (use --allow-siblings to apply it into a sibling of src-dir)"
+		}
+		return "", errors.Errorf("directory %s of a repo in the group is outside src-dir, which is not applied%s", path, hint)
+	}
+	return filepath.Join(root, clean), nil
+}
+
+// pushGroupComponent pushes ghost branches of a repo in a group, which record the repo as their source repo
+func pushGroupComponent(options PushOptions) ([]types.GhostBranch, errors.GitGhostError) {
+	repo, err := git.SourceRepo(options.SrcDir)
+	if err != nil {
+		return nil, err
+	}
+	types.SetSourceRepo(repo)
+	result, err := Push(options)
+	if err != nil {
+		return nil, err
+	}
+	return result.branches(), nil
+}
+
+// PullGroup pulls a group branch and applies ghost branches of every repo in it to the repo
+//
+// A failure on a repo doesn't stop applying the others. It is recorded in Err of its result,
+// and the returned error aggregates all of them.
+func PullGroup(options PullGroupOptions) (*GroupResult, errors.GitGhostError) {
+	log.WithFields(util.ToFields(options)).Debug("pull group command with")
+
+	branch, err := resolveGroupBranch(options.GhostRepo, options.Prefix, options.GroupHash)
+	if err != nil {
+		return nil, err
+	}
+	we, err := options.WorkingEnvSpec.Initialize()
+	if err != nil {
+		return nil, err
+	}
+	defer util.LogDeferredGitGhostError(we.Clean)
+	manifest, err := branch.ReadManifest(*we)
+	if err != nil {
+		return nil, err
+	}
+
+	// every directory is resolved before applying anything, so that a group with a directory outside the root applies nothing
+	dirs := make([]string, 0, len(manifest.Components))
+	for _, component := range manifest.Components {
+		dir, err := groupComponentDir(we.SrcDir, component.Path, options.AllowSiblings)
+		if err != nil {
+			return nil, err
+		}
+		log.WithFields(log.Fields{
+			"path":   component.Path,
+			"srcDir": dir,
+		}).Info("resolved directory of repo in group")
+		dirs = append(dirs, dir)
+	}
+
+	result := GroupResult{GroupBranch: branch}
+	var errs error
+	for i, component := range manifest.Components {
+		c := GroupComponentResult{GroupComponent: component}
+		spec := options.WorkingEnvSpec
+		spec.SrcDir = dirs[i]
+		pullOptions := PullOptions{
+			WorkingEnvSpec: spec,
+			ApplyOptions:   options.ApplyOptions,
+			AutoStash:      options.AutoStash,
+		}
+		if b, ok := types.CreateGhostBranchByName(component.Commits).(*types.CommitsBranch); ok {
+			pullOptions.CommitsBranchSpec = &types.CommitsBranchSpec{
+				Prefix:         b.Prefix,
+				CommittishFrom: b.CommitHashFrom,
+				CommittishTo:   b.CommitHashTo,
+			}
+		}
+		if b, ok := types.CreateGhostBranchByName(component.Diff).(*types.DiffBranch); ok {
+			pullOptions.PullableDiffBranchSpec = &types.PullableDiffBranchSpec{
+				Prefix:         b.Prefix,
+				CommittishFrom: b.CommitHashFrom,
+				DiffHash:       b.DiffHash,
+			}
+		}
+		if pullOptions.CommitsBranchSpec != nil || pullOptions.PullableDiffBranchSpec != nil {
+			log.WithFields(log.Fields{
+				"path":   component.Path,
+				"srcDir": spec.SrcDir,
+			}).Info("applying ghost branches of repo in group")
+			c.Branches, c.Err = pull(pullOptions)
+			writeAuditRecord("pull", spec, c.Branches, c.Err)
+		}
+		if c.Err != nil {
+			errs = multierror.Append(errs, errors.Errorf("failed to apply %s into %s: %s", strings.Join(c.names(), ", "), spec.SrcDir, c.Err))
+		}
+		result.Components = append(result.Components, c)
+	}
+	if errs != nil {
+		return &result, errors.WithStack(errs)
+	}
+	return &result, nil
+}
+
+// names returns names of ghost branches of the repo
+func (c GroupComponentResult) names() []string {
+	names := []string{}
+	for _, name := range []string{c.Commits, c.Diff} {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// resolveGroupBranch returns a group branch in ghost repo whose hash is groupHash or starts with it
+func resolveGroupBranch(repo, prefix, groupHash string) (*types.GroupBranch, errors.GitGhostError) {
+	refs, err := git.ListRemoteRefHashes(repo, fmt.Sprintf("refs/heads/%s/group/*", prefix))
+	if err != nil {
+		return nil, err
+	}
+	var found *types.GroupBranch
+	for ref := range refs {
+		branch := types.CreateGroupBranchByName(strings.TrimPrefix(ref, "refs/heads/"))
+		if branch == nil || !strings.HasPrefix(branch.GroupHash, groupHash) {
+			continue
+		}
+		if found != nil {
+			return nil, errors.Errorf("%s is ambiguous: %s, %s", groupHash, found.BranchName(), branch.BranchName())
+		}
+		found = branch
+	}
+	if found == nil {
+		return nil, errors.WithCategory(errors.Errorf("no group is found for %s", groupHash), errors.CategoryNotFound)
+	}
+	return found, nil
+}
@@ -0,0 +1,41 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// HashOptions represents arg for Hash func
+type HashOptions struct {
+	types.WorkingEnvSpec
+	*types.DiffBranchSpec
+}
+
+// Hash returns a local mod branch which pushing the diff would create, whose diff hash is the same as the one push assigns
+//
+// Neither ghost repo is accessed nor anything but temporary files is created.
+func Hash(options HashOptions) (*types.DiffBranch, errors.GitGhostError) {
+	log.WithFields(util.ToFields(options)).Debug("hash command with")
+
+	if options.DiffBranchSpec == nil {
+		return nil, errors.New("diff to hash is not specified")
+	}
+	return options.DiffBranchSpec.PredictBranch(options.WorkingEnvSpec)
+}
@@ -0,0 +1,61 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// runPostApplyHook runs hook by 'sh -c' in srcDir after applied ghost branches are applied
+//
+// The hook gets the last applied ghost branch by envs. Its output goes to stderr so that stdout of git-ghost is kept clean.
+func runPostApplyHook(hook, srcDir string, applied []types.GhostBranch) errors.GitGhostError {
+	names := []string{}
+	for _, branch := range applied {
+		names = append(names, branch.BranchName())
+	}
+	var ghostType, from, hash string
+	switch b := applied[len(applied)-1].(type) {
+	case *types.CommitsBranch:
+		ghostType, from, hash = "commits", b.CommitHashFrom, b.CommitHashTo
+	case *types.DiffBranch:
+		ghostType, from, hash = "diff", b.CommitHashFrom, b.DiffHash
+	}
+	cmd := exec.Command("sh", "-c", hook)
+	cmd.Dir = srcDir
+	cmd.Env = append(os.Environ(),
+		"GIT_GHOST_TYPE="+ghostType,
+		"GIT_GHOST_FROM="+from,
+		"GIT_GHOST_HASH="+hash,
+		"GIT_GHOST_BRANCHES="+strings.Join(names, " "),
+	)
+	log.WithFields(log.Fields{
+		"srcDir":   srcDir,
+		"branches": names,
+	}).Info("running post-apply hook")
+	err := util.StreamCmd(cmd, os.Stderr)
+	if err != nil {
+		return errors.Errorf("post-apply hook failed: %s", err)
+	}
+	return nil
+}
@@ -17,8 +17,10 @@ package ghost
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"strings"
 
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
 	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
 	"github.com/pfnet-research/git-ghost/pkg/util"
 	"github.com/pfnet-research/git-ghost/pkg/util/errors"
@@ -31,12 +33,26 @@ type ListOptions struct {
 	types.WorkingEnvSpec
 	*types.ListCommitsBranchSpec
 	*types.ListDiffBranchSpec
+	// MaxCount limits the number of listed branches of all ghost branch types (0 means unlimited)
+	MaxCount int
+	// After lists branches following the one whose name equals to this value in the order they are listed,
+	// local base branches first and local mod branches next, so that it pages through branches of all types.
+	// The branch doesn't have to exist, so that paging resumes even if it is deleted between pages
+	After string
+	// Size computes stored sizes of listed branches, which requires fetching them
+	Size bool
+	// Metadata lists only branches whose metadata meets all of its conditions, which requires fetching them
+	Metadata types.MetadataFilter
+	// Jobs is the maximum number of branches whose metadata is read in parallel (default to 1), bounded by the concurrency of git
+	Jobs int
 }
 
 // ListResult contains results of List func
 type ListResult struct {
 	*types.CommitsBranches
 	*types.DiffBranches
+	// Sizes maps branch names to their stored sizes in bytes if sizes are computed
+	Sizes map[string]int64
 }
 
 // List returns ghost branches list per ghost branch type
@@ -63,9 +79,241 @@ func List(options ListOptions) (*ListResult, errors.GitGhostError) {
 		res.DiffBranches = &branches
 	}
 
+	after := options.After
+	if len(options.Metadata) > 0 {
+		// branches up to after are dropped first so that filtering stops as soon as MaxCount branches match
+		if after != "" {
+			err := res.paginate(after, 0)
+			if err != nil {
+				return nil, err
+			}
+			after = ""
+		}
+		err := res.filterByMetadata(options.WorkingEnvSpec, options.Metadata, options.MaxCount, options.Jobs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if options.MaxCount > 0 || after != "" {
+		err := res.paginate(after, options.MaxCount)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if options.Size {
+		err := res.computeSizes(options.WorkingEnvSpec)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &res, nil
 }
 
+// branchNames returns names of all branches in ListResult
+func (res *ListResult) branchNames() []string {
+	names := []string{}
+	if res.CommitsBranches != nil {
+		for _, branch := range *res.CommitsBranches {
+			names = append(names, branch.BranchName())
+		}
+	}
+	if res.DiffBranches != nil {
+		for _, branch := range *res.DiffBranches {
+			names = append(names, branch.BranchName())
+		}
+	}
+	return names
+}
+
+func (res *ListResult) computeSizes(spec types.WorkingEnvSpec) errors.GitGhostError {
+	res.Sizes = map[string]int64{}
+	names := res.branchNames()
+	if len(names) == 0 {
+		return nil
+	}
+
+	we, err := spec.Initialize()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer util.LogDeferredGitGhostError(we.Clean)
+	err = git.FetchBranches(we.GhostDir, names...)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, name := range names {
+		size, err := git.GetTreeSize(we.GhostDir, fmt.Sprintf("%s/%s", git.ORIGIN, name))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		res.Sizes[name] = size
+	}
+	return nil
+}
+
+// filterByMetadata drops branches in ListResult whose metadata doesn't meet filter, keeping at most maxCount branches per type (0 means unlimited)
+func (res *ListResult) filterByMetadata(spec types.WorkingEnvSpec, filter types.MetadataFilter, maxCount, jobs int) errors.GitGhostError {
+	if len(res.branchNames()) == 0 {
+		return nil
+	}
+
+	we, err := spec.Initialize()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer util.LogDeferredGitGhostError(we.Clean)
+	if res.CommitsBranches != nil {
+		res.CommitsBranches.Sort()
+		matched, err := matchMetadata(we.GhostDir, res.CommitsBranches.AsGhostBranches(), filter, maxCount, jobs)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		branches := types.CommitsBranches{}
+		for _, i := range matched {
+			branches = append(branches, (*res.CommitsBranches)[i])
+		}
+		res.CommitsBranches = &branches
+	}
+	if res.DiffBranches != nil {
+		// local mod branches fill the rest of the page left by local base branches
+		if maxCount > 0 && res.CommitsBranches != nil {
+			maxCount -= len(*res.CommitsBranches)
+			if maxCount == 0 {
+				res.DiffBranches = &types.DiffBranches{}
+				return nil
+			}
+		}
+		res.DiffBranches.Sort()
+		matched, err := matchMetadata(we.GhostDir, res.DiffBranches.AsGhostBranches(), filter, maxCount, jobs)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		branches := types.DiffBranches{}
+		for _, i := range matched {
+			branches = append(branches, (*res.DiffBranches)[i])
+		}
+		res.DiffBranches = &branches
+	}
+	return nil
+}
+
+// matchMetadata returns indices of at most maxCount branches whose metadata meets filter (0 means unlimited)
+//
+// Branches are fetched to ghostDir in batches of the branches still needed, so that the rest is never fetched
+// once enough branches match.
+func matchMetadata(ghostDir string, branches []types.GhostBranch, filter types.MetadataFilter, maxCount, jobs int) ([]int, errors.GitGhostError) {
+	if jobs < 1 {
+		jobs = 1
+	}
+	if bounded := git.BoundByConcurrency(jobs); bounded < jobs {
+		log.WithFields(log.Fields{"jobs": jobs, "bounded": bounded}).Debug("jobs are bounded by concurrency")
+		jobs = bounded
+	}
+	matched := []int{}
+	for start := 0; start < len(branches); {
+		end := len(branches)
+		if maxCount > 0 {
+			needed := maxCount - len(matched)
+			if needed < jobs {
+				needed = jobs
+			}
+			if start+needed < end {
+				end = start + needed
+			}
+		}
+		batch := branches[start:end]
+		names := make([]string, len(batch))
+		for i, branch := range batch {
+			names[i] = branch.BranchName()
+		}
+		err := git.FetchBranches(ghostDir, names...)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		// every worker writes only its own element of ok, so they don't have to be locked
+		ok := make([]bool, len(batch))
+		indices := make(chan int)
+		errs := make(chan errors.GitGhostError, len(batch))
+		for w := 0; w < jobs && w < len(batch); w++ {
+			go func() {
+				for i := range indices {
+					provenance, err := types.GetProvenance(ghostDir, fmt.Sprintf("%s/%s", git.ORIGIN, batch[i].BranchName()), batch[i])
+					if err == nil {
+						ok[i] = provenance.Metadata.Matches(filter)
+					}
+					errs <- err
+				}
+			}()
+		}
+		for i := range batch {
+			indices <- i
+		}
+		close(indices)
+		for range batch {
+			if e := <-errs; e != nil && err == nil {
+				err = e
+			}
+		}
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		for i := range batch {
+			if !ok[i] {
+				continue
+			}
+			matched = append(matched, start+i)
+			if maxCount > 0 && len(matched) == maxCount {
+				return matched, nil
+			}
+		}
+		start = end
+	}
+	return matched, nil
+}
+
+// TotalSize returns the sum of sizes of all branches in ListResult
+func (res *ListResult) TotalSize() int64 {
+	var total int64
+	for _, size := range res.Sizes {
+		total += size
+	}
+	return total
+}
+
+// paginate keeps at most maxCount branches following the branch named after in the order they are listed (0 means unlimited)
+func (res *ListResult) paginate(after string, maxCount int) errors.GitGhostError {
+	page, err := newStreamPage(after, maxCount)
+	if err != nil {
+		return err
+	}
+	if res.CommitsBranches != nil {
+		res.CommitsBranches.Sort()
+		branches := types.CommitsBranches{}
+		for _, branch := range *res.CommitsBranches {
+			if ok, _ := page.accept(branch.BranchName()); ok {
+				branches = append(branches, branch)
+			}
+		}
+		res.CommitsBranches = &branches
+	}
+	if res.DiffBranches != nil {
+		res.DiffBranches.Sort()
+		branches := types.DiffBranches{}
+		for _, branch := range *res.DiffBranches {
+			if ok, _ := page.accept(branch.BranchName()); ok {
+				branches = append(branches, branch)
+			}
+		}
+		res.DiffBranches = &branches
+	}
+	return nil
+}
+
 // PrettyString pretty prints ListResult
 func (res *ListResult) PrettyString(headers bool, output string) string {
 	// TODO: Make it prettier
@@ -74,32 +322,10 @@ func (res *ListResult) PrettyString(headers bool, output string) string {
 		branches := *res.CommitsBranches
 		branches.Sort()
 		if headers {
-			buffer.WriteString("Local Base Branches:\n")
-			buffer.WriteString("\n")
-			columns := []string{}
-			switch output {
-			case "only-from":
-				columns = append(columns, fmt.Sprintf("%-40s", "Remote Base"))
-			case "only-to":
-				columns = append(columns, fmt.Sprintf("%-40s", "Local Base"))
-			default:
-				columns = append(columns, fmt.Sprintf("%-40s", "Remote Base"))
-				columns = append(columns, fmt.Sprintf("%-40s", "Local Base"))
-			}
-			buffer.WriteString(fmt.Sprintf("%s\n", strings.Join(columns, " ")))
+			writeCommitsHeader(&buffer, output, res.Sizes != nil)
 		}
 		for _, branch := range branches {
-			columns := []string{}
-			switch output {
-			case "only-from":
-				columns = append(columns, branch.CommitHashFrom)
-			case "only-to":
-				columns = append(columns, branch.CommitHashTo)
-			default:
-				columns = append(columns, branch.CommitHashFrom)
-				columns = append(columns, branch.CommitHashTo)
-			}
-			buffer.WriteString(fmt.Sprintf("%s\n", strings.Join(columns, " ")))
+			writeCommitsRow(&buffer, branch, output, res.Sizes)
 		}
 		if headers {
 			buffer.WriteString("\n")
@@ -109,36 +335,271 @@ func (res *ListResult) PrettyString(headers bool, output string) string {
 		branches := *res.DiffBranches
 		branches.Sort()
 		if headers {
-			buffer.WriteString("Local Mod Branches:\n")
-			buffer.WriteString("\n")
-			columns := []string{}
-			switch output {
-			case "only-from":
-				columns = append(columns, fmt.Sprintf("%-40s", "Local Base"))
-			case "only-to":
-				columns = append(columns, fmt.Sprintf("%-40s", "Local Mod"))
-			default:
-				columns = append(columns, fmt.Sprintf("%-40s", "Local Base"))
-				columns = append(columns, fmt.Sprintf("%-40s", "Local Mod"))
-			}
-			buffer.WriteString(fmt.Sprintf("%s\n", strings.Join(columns, " ")))
+			writeDiffHeader(&buffer, output, res.Sizes != nil)
 		}
 		for _, branch := range branches {
-			columns := []string{}
-			switch output {
-			case "only-from":
-				columns = append(columns, branch.CommitHashFrom)
-			case "only-to":
-				columns = append(columns, branch.DiffHash)
-			default:
-				columns = append(columns, branch.CommitHashFrom)
-				columns = append(columns, branch.DiffHash)
-			}
-			buffer.WriteString(fmt.Sprintf("%s\n", strings.Join(columns, " ")))
+			writeDiffRow(&buffer, branch, output, res.Sizes)
 		}
 		if headers {
 			buffer.WriteString("\n")
 		}
 	}
+	if headers && res.Sizes != nil {
+		buffer.WriteString(fmt.Sprintf("Total Size: %d bytes\n", res.TotalSize()))
+	}
 	return buffer.String()
 }
+
+func writeCommitsHeader(writer io.Writer, output string, sizes bool) {
+	fmt.Fprint(writer, "Local Base Branches:\n")
+	fmt.Fprint(writer, "\n")
+	columns := []string{}
+	switch output {
+	case "only-from":
+		columns = append(columns, fmt.Sprintf("%-40s", "Remote Base"))
+	case "only-to":
+		columns = append(columns, fmt.Sprintf("%-40s", "Local Base"))
+	default:
+		columns = append(columns, fmt.Sprintf("%-40s", "Remote Base"))
+		columns = append(columns, fmt.Sprintf("%-40s", "Local Base"))
+	}
+	if sizes {
+		columns = append(columns, "Size")
+	}
+	fmt.Fprintf(writer, "%s\n", strings.Join(columns, " "))
+}
+
+func writeCommitsRow(writer io.Writer, branch types.CommitsBranch, output string, sizes map[string]int64) {
+	columns := []string{}
+	switch output {
+	case "only-from":
+		columns = append(columns, branch.CommitHashFrom)
+	case "only-to":
+		columns = append(columns, branch.CommitHashTo)
+	default:
+		columns = append(columns, branch.CommitHashFrom)
+		columns = append(columns, branch.CommitHashTo)
+	}
+	if sizes != nil {
+		columns = append(columns, fmt.Sprintf("%d", sizes[branch.BranchName()]))
+	}
+	fmt.Fprintf(writer, "%s\n", strings.Join(columns, " "))
+}
+
+func writeDiffHeader(writer io.Writer, output string, sizes bool) {
+	fmt.Fprint(writer, "Local Mod Branches:\n")
+	fmt.Fprint(writer, "\n")
+	columns := []string{}
+	switch output {
+	case "only-from":
+		columns = append(columns, fmt.Sprintf("%-40s", "Local Base"))
+	case "only-to":
+		columns = append(columns, fmt.Sprintf("%-40s", "Local Mod"))
+	default:
+		columns = append(columns, fmt.Sprintf("%-40s", "Local Base"))
+		columns = append(columns, fmt.Sprintf("%-40s", "Local Mod"))
+	}
+	if sizes {
+		columns = append(columns, "Size")
+	}
+	fmt.Fprintf(writer, "%s\n", strings.Join(columns, " "))
+}
+
+func writeDiffRow(writer io.Writer, branch types.DiffBranch, output string, sizes map[string]int64) {
+	columns := []string{}
+	switch output {
+	case "only-from":
+		columns = append(columns, branch.CommitHashFrom)
+	case "only-to":
+		columns = append(columns, branch.DiffHash)
+	default:
+		columns = append(columns, branch.CommitHashFrom)
+		columns = append(columns, branch.DiffHash)
+	}
+	if sizes != nil {
+		columns = append(columns, fmt.Sprintf("%d", sizes[branch.BranchName()]))
+	}
+	fmt.Fprintf(writer, "%s\n", strings.Join(columns, " "))
+}
+
+// errStreamPageDone stops streaming branches of a ghost branch type once a page is filled
+var errStreamPageDone = errors.New("page is filled")
+
+// streamPage tracks a page of listed branches of all ghost branch types, which is shared by them in the order they are listed
+type streamPage struct {
+	after    string
+	maxCount int
+	started  bool
+	count    int
+}
+
+// newStreamPage returns streamPage of at most maxCount branches following the branch named after, which must be a name of ghost branch
+func newStreamPage(after string, maxCount int) (*streamPage, errors.GitGhostError) {
+	if after != "" && types.CreateGhostBranchByName(after) == nil {
+		return nil, errors.WithCategory(errors.Errorf("no ghost branch is found for %s", after), errors.CategoryNotFound)
+	}
+	return &streamPage{after: after, maxCount: maxCount}, nil
+}
+
+// accept reports whether a branch named branchName is in the page, or returns errStreamPageDone if the page is filled
+//
+// The page starts at the first branch listed after the branch named after, which may not exist any longer.
+func (p *streamPage) accept(branchName string) (bool, errors.GitGhostError) {
+	if !p.started {
+		if p.after != "" && !listedAfter(branchName, p.after) {
+			return false, nil
+		}
+		p.started = true
+	}
+	if !p.fill() {
+		return false, errStreamPageDone
+	}
+	return true, nil
+}
+
+// listedAfter reports whether a branch named branchName is listed after the one named cursor,
+// i.e. it is of a later type (local base branches first and local mod branches next) or is of the same type and sorts after it
+func listedAfter(branchName, cursor string) bool {
+	rank, cursorRank := listRank(branchName), listRank(cursor)
+	if rank != cursorRank {
+		return rank > cursorRank
+	}
+	return branchName > cursor
+}
+
+func listRank(branchName string) int {
+	if _, ok := types.CreateGhostBranchByName(branchName).(*types.CommitsBranch); ok {
+		return 0
+	}
+	return 1
+}
+
+func (p *streamPage) fill() bool {
+	if p.maxCount > 0 && p.count >= p.maxCount {
+		return false
+	}
+	p.count++
+	return true
+}
+
+// ListStream is the same as List except that it calls f with each ghost branch as soon as the ghost repo lists it
+//
+// Branches are passed as types.CommitsBranch first and then as types.DiffBranch, each type in lexicographic order of their names.
+// Sizes can't be computed while streaming.
+func ListStream(options ListOptions, f func(types.GhostBranch) errors.GitGhostError) errors.GitGhostError {
+	log.WithFields(util.ToFields(options)).Debug("list command with streaming")
+
+	if options.Size {
+		return errors.New("sizes can't be computed while streaming ghost branches")
+	}
+	if len(options.Metadata) > 0 {
+		return errors.New("ghost branches can't be filtered by metadata while streaming them")
+	}
+
+	page, ggerr := newStreamPage(options.After, options.MaxCount)
+	if ggerr != nil {
+		return ggerr
+	}
+	done := false
+	if options.ListCommitsBranchSpec != nil {
+		resolved := options.ListCommitsBranchSpec.Resolve(options.SrcDir)
+		err := resolved.StreamBranches(options.GhostRepo, func(branch types.CommitsBranch) errors.GitGhostError {
+			ok, err := page.accept(branch.BranchName())
+			if !ok {
+				return err
+			}
+			return f(branch)
+		})
+		if err != nil && err != errStreamPageDone {
+			return errors.WithStack(err)
+		}
+		done = err == errStreamPageDone
+	}
+
+	if options.ListDiffBranchSpec != nil && !done {
+		resolved := options.ListDiffBranchSpec.Resolve(options.SrcDir)
+		err := resolved.StreamBranches(options.GhostRepo, func(branch types.DiffBranch) errors.GitGhostError {
+			ok, err := page.accept(branch.BranchName())
+			if !ok {
+				return err
+			}
+			return f(branch)
+		})
+		if err != nil && err != errStreamPageDone {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// ListStreamPrinter prints ghost branches passed by ListStream as they come in the same format as ListResult.PrettyString
+type ListStreamPrinter struct {
+	writer  io.Writer
+	headers bool
+	output  string
+	commits bool
+	diffs   bool
+	section string
+}
+
+// NewListStreamPrinter returns ListStreamPrinter writing branches listed by options to writer
+func NewListStreamPrinter(writer io.Writer, options ListOptions, headers bool, output string) *ListStreamPrinter {
+	return &ListStreamPrinter{
+		writer:  writer,
+		headers: headers,
+		output:  output,
+		commits: options.ListCommitsBranchSpec != nil,
+		diffs:   options.ListDiffBranchSpec != nil,
+	}
+}
+
+// Print prints a ghost branch, which can be passed to ListStream as it is
+func (p *ListStreamPrinter) Print(branch types.GhostBranch) errors.GitGhostError {
+	switch br := branch.(type) {
+	case types.CommitsBranch:
+		p.enter("commits")
+		writeCommitsRow(p.writer, br, p.output, nil)
+	case types.DiffBranch:
+		p.enter("diff")
+		writeDiffRow(p.writer, br, p.output, nil)
+	default:
+		return errors.Errorf("unknown ghost branch type %T", branch)
+	}
+	return nil
+}
+
+// Close prints headers of sections without branches and finishes the last section
+func (p *ListStreamPrinter) Close() {
+	if p.diffs {
+		p.enter("diff")
+	} else if p.commits {
+		p.enter("commits")
+	}
+	p.leave()
+}
+
+func (p *ListStreamPrinter) enter(section string) {
+	if p.section == section {
+		return
+	}
+	if section == "diff" && p.section == "" && p.commits {
+		p.enter("commits")
+	}
+	p.leave()
+	p.section = section
+	if !p.headers {
+		return
+	}
+	switch section {
+	case "commits":
+		writeCommitsHeader(p.writer, p.output, false)
+	case "diff":
+		writeDiffHeader(p.writer, p.output, false)
+	}
+}
+
+func (p *ListStreamPrinter) leave() {
+	if p.section != "" && p.headers {
+		fmt.Fprint(p.writer, "\n")
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"io"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// LogOptions represents arg for Log func
+type LogOptions struct {
+	types.WorkingEnvSpec
+	*types.CommitsBranchSpec
+	types.LogFormat
+	Writer io.Writer
+}
+
+// Log writes commits of a local base branch to options.Writer like 'git log' without applying them
+func Log(options LogOptions) errors.GitGhostError {
+	log.WithFields(util.ToFields(options)).Debug("log command with")
+
+	if options.CommitsBranchSpec == nil {
+		return errors.New("commits to show are not specified")
+	}
+	we, err := options.WorkingEnvSpec.Initialize()
+	if err != nil {
+		return err
+	}
+	defer util.LogDeferredGitGhostError(we.Clean)
+	branch, err := options.CommitsBranchSpec.PullBranch(*we)
+	if err != nil {
+		return err
+	}
+	commitsBranch, _ := branch.(*types.CommitsBranch)
+	return types.WriteLog(*we, commitsBranch, options.LogFormat, options.Writer)
+}
@@ -0,0 +1,314 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/oci"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// ociArtifactType is an artifact type of manifests of ghost branches
+	ociArtifactType = "application/vnd.git-ghost.ghost.v1"
+	// ociConfigMediaType is a media type of configs of ghost branches, which are ociConfig in JSON
+	ociConfigMediaType = "application/vnd.git-ghost.ghost.config.v1+json"
+	// ociBundleMediaType is a media type of the only layer of ghost branches, which is a git bundle of the branch
+	ociBundleMediaType = "application/vnd.git-ghost.ghost.bundle.v1"
+	// ociBranchAnnotation is an annotation of manifests with the name of the ghost branch
+	ociBranchAnnotation = "dev.git-ghost.branch"
+)
+
+// ociConfig is a config of a ghost branch stored as an OCI artifact
+type ociConfig struct {
+	Branch string `json:"branch"`
+	Commit string `json:"commit"`
+}
+
+// OCIOptions represents arg for OCI funcs
+type OCIOptions struct {
+	types.WorkingEnvSpec
+	Prefix string
+	Client oci.ClientOptions
+}
+
+// OCIArtifact represents a ghost branch stored in a registry
+type OCIArtifact struct {
+	// Reference is of the repository and the tag of the artifact
+	Reference oci.Reference
+	// Digest is of the manifest of the artifact
+	Digest string
+	// Branch is the name of the ghost branch, which is empty if the tag is not of a ghost branch
+	Branch string
+}
+
+// OCIArtifacts is an alias for []OCIArtifact
+type OCIArtifacts []OCIArtifact
+
+// ociTag returns a tag of an artifact of branch for a reference without a tag
+func ociTag(branch types.GhostBranch) string {
+	switch b := branch.(type) {
+	case *types.CommitsBranch:
+		return fmt.Sprintf("commits-%s-%s", b.CommitHashFrom, b.CommitHashTo)
+	case *types.DiffBranch:
+		return fmt.Sprintf("diff-%s-%s", b.CommitHashFrom, b.DiffHash)
+	}
+	return ""
+}
+
+// PushOCI pushes a ghost branch specified by a diff hash, a local base commit hash or a branch name to a registry as an OCI artifact
+//
+// The artifact is a git bundle of the ghost branch, tagged as the tag of reference or the one derived from the branch if it has no tag.
+func PushOCI(options OCIOptions, hash, reference string) (*OCIArtifact, errors.GitGhostError) {
+	log.WithFields(util.ToFields(options)).Debug("oci push command with")
+	artifact, err := pushOCI(options, hash, reference)
+	branches := []types.GhostBranch{}
+	if artifact != nil {
+		branches = append(branches, types.CreateGhostBranchByName(artifact.Branch))
+	}
+	writeAuditRecord("oci push", options.WorkingEnvSpec, branches, err)
+	return artifact, err
+}
+
+func pushOCI(options OCIOptions, hash, reference string) (*OCIArtifact, errors.GitGhostError) {
+	ref, err := oci.ParseReference(reference)
+	if err != nil {
+		return nil, err
+	}
+	if ref.Digest != "" {
+		return nil, errors.WithCategory(errors.Errorf("%s has a digest, but artifacts are pushed by tags", reference), errors.CategoryConfig)
+	}
+	client, err := oci.NewClient(options.Client)
+	if err != nil {
+		return nil, err
+	}
+	found, commit, err := findGhostBranch(options.GhostRepo, options.Prefix, hash)
+	if err != nil {
+		return nil, err
+	}
+	if ref.Tag == "" {
+		ref = ref.WithTag(ociTag(found))
+	}
+
+	we, err := options.WorkingEnvSpec.Initialize()
+	if err != nil {
+		return nil, err
+	}
+	defer util.LogDeferredGitGhostError(we.Clean)
+	err = git.FetchBranches(we.GhostDir, found.BranchName())
+	if err != nil {
+		return nil, err
+	}
+	bundlePath := filepath.Join(we.GhostDir, "ghost.bundle")
+	err = git.CreateBranchBundle(we.GhostDir, bundlePath, found.BranchName(), commit)
+	if err != nil {
+		return nil, err
+	}
+	// the bundle is streamed from the file, which may be huge
+	bundleDesc, err := oci.NewFileDescriptor(ociBundleMediaType, bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	config, jerr := json.Marshal(ociConfig{Branch: found.BranchName(), Commit: commit})
+	if jerr != nil {
+		return nil, errors.WithStack(jerr)
+	}
+
+	manifest := oci.Manifest{
+		SchemaVersion: 2,
+		MediaType:     oci.MediaTypeManifest,
+		ArtifactType:  ociArtifactType,
+		Config:        oci.NewDescriptor(ociConfigMediaType, config),
+		Layers:        []oci.Descriptor{bundleDesc},
+		Annotations:   map[string]string{ociBranchAnnotation: found.BranchName()},
+	}
+	err = client.PushBlob(ref, manifest.Config, bytes.NewReader(config))
+	if err != nil {
+		return nil, err
+	}
+	bundle, oerr := os.Open(bundlePath)
+	if oerr != nil {
+		return nil, errors.WithStack(oerr)
+	}
+	defer util.LogDeferredError(bundle.Close)
+	err = client.PushBlob(ref, manifest.Layers[0], bundle)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := client.PushManifest(ref, manifest)
+	if err != nil {
+		return nil, err
+	}
+	log.WithFields(log.Fields{
+		"branch":    found.BranchName(),
+		"reference": ref.String(),
+		"digest":    digest,
+	}).Info("ghost branch was pushed as an OCI artifact")
+	return &OCIArtifact{Reference: ref, Digest: digest, Branch: found.BranchName()}, nil
+}
+
+// ListOCI returns ghost branches stored as OCI artifacts in the repository of reference sorted by their tags
+//
+// Tags of manifests which are not of ghost branches (e.g. images) are listed with empty branches.
+func ListOCI(options OCIOptions, reference string) (OCIArtifacts, errors.GitGhostError) {
+	log.WithFields(util.ToFields(options)).Debug("oci list command with")
+	ref, err := oci.ParseReference(reference)
+	if err != nil {
+		return nil, err
+	}
+	client, err := oci.NewClient(options.Client)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := client.ListTags(ref)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(tags)
+	artifacts := OCIArtifacts{}
+	for _, tag := range tags {
+		tagged := ref.WithTag(tag)
+		manifest, digest, err := client.PullManifest(tagged)
+		if err != nil {
+			return nil, err
+		}
+		artifact := OCIArtifact{Reference: tagged, Digest: digest}
+		if manifest.ArtifactType == ociArtifactType || manifest.Config.MediaType == ociConfigMediaType {
+			artifact.Branch = manifest.Annotations[ociBranchAnnotation]
+		}
+		artifacts = append(artifacts, artifact)
+	}
+	return artifacts, nil
+}
+
+// ResolveOCI returns a ghost branch stored as an OCI artifact at reference, whose reference is pinned to the digest of its manifest
+//
+// Pulling the returned reference by PullOptions.OCIReference pulls the same artifact even if the tag is moved meanwhile.
+func ResolveOCI(options oci.ClientOptions, reference string) (types.GhostBranch, *oci.Reference, errors.GitGhostError) {
+	ref, err := oci.ParseReference(reference)
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := oci.NewClient(options)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, config, digest, err := pullOCIManifest(client, ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	branch := types.CreateGhostBranchByName(config.Branch)
+	if branch == nil {
+		return nil, nil, errors.Errorf("%s is of %s, which is not a ghost branch", ref, config.Branch)
+	}
+	pinned := ref.WithDigest(digest)
+	return branch, &pinned, nil
+}
+
+// pullOCIManifest returns the manifest, the config and the digest of a ghost branch stored as an OCI artifact at ref
+func pullOCIManifest(client *oci.Client, ref oci.Reference) (*oci.Manifest, *ociConfig, string, errors.GitGhostError) {
+	manifest, digest, err := client.PullManifest(ref)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if manifest.Config.MediaType != ociConfigMediaType || len(manifest.Layers) != 1 || manifest.Layers[0].MediaType != ociBundleMediaType {
+		return nil, nil, "", errors.Errorf("%s is not a ghost branch pushed by 'git-ghost oci push'", ref)
+	}
+	if manifest.Config.Size > 1024*1024 {
+		return nil, nil, "", errors.Errorf("config of %s is too large: %d bytes", ref, manifest.Config.Size)
+	}
+	var buf bytes.Buffer
+	err = client.PullBlob(ref, manifest.Config, &buf)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	var config ociConfig
+	jerr := json.Unmarshal(buf.Bytes(), &config)
+	if jerr != nil {
+		return nil, nil, "", errors.Errorf("config of %s is invalid: %s", ref, jerr)
+	}
+	return manifest, &config, digest, nil
+}
+
+// withOCIBundle downloads the bundle of a ghost branch stored as an OCI artifact at reference into dir,
+// and calls f with spec whose ghost repo is the bundle, which is removed after that
+func withOCIBundle(spec types.WorkingEnvSpec, options oci.ClientOptions, reference string, f func(types.WorkingEnvSpec) errors.GitGhostError) errors.GitGhostError {
+	ref, err := oci.ParseReference(reference)
+	if err != nil {
+		return err
+	}
+	client, err := oci.NewClient(options)
+	if err != nil {
+		return err
+	}
+	manifest, config, _, err := pullOCIManifest(client, ref)
+	if err != nil {
+		return err
+	}
+	file, cerr := ioutil.TempFile(spec.GhostWorkingDir, "git-ghost-oci-")
+	if cerr != nil {
+		return errors.WithStack(cerr)
+	}
+	defer util.LogDeferredError(func() error { return os.Remove(file.Name()) })
+	err = client.PullBlob(ref, manifest.Layers[0], file)
+	cerr = file.Close()
+	if err != nil {
+		return err
+	}
+	if cerr != nil {
+		return errors.WithStack(cerr)
+	}
+	heads, err := git.ListRemoteRefHashes(file.Name(), "refs/heads/"+config.Branch)
+	if err != nil {
+		return errors.Errorf("bundle of %s is invalid: %s", ref, err)
+	}
+	if heads["refs/heads/"+config.Branch] != config.Commit {
+		return errors.Errorf("bundle of %s doesn't contain %s at %s", ref, config.Branch, config.Commit)
+	}
+	log.WithFields(log.Fields{
+		"reference": ref.String(),
+		"branch":    config.Branch,
+	}).Debug("ghost branch is downloaded from registry")
+	spec.GhostRepo = file.Name()
+	return f(spec)
+}
+
+// PrettyString pretty prints OCIArtifacts
+func (artifacts OCIArtifacts) PrettyString(headers bool) string {
+	var buffer bytes.Buffer
+	if headers {
+		buffer.WriteString(fmt.Sprintf("%-20s %-71s %s\n", "Tag", "Digest", "Ghost Branch"))
+	}
+	for _, a := range artifacts {
+		branchName := "(not a ghost)"
+		if a.Branch != "" {
+			branchName = a.Branch
+		}
+		buffer.WriteString(fmt.Sprintf("%-20s %-71s %s\n", a.Reference.Tag, a.Digest, branchName))
+	}
+	return buffer.String()
+}
@@ -0,0 +1,478 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MediaTypeManifest is the media type of manifests of artifacts
+const MediaTypeManifest = "application/vnd.oci.image.manifest.v1+json"
+
+// Descriptor describes content in a registry
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// NewDescriptor returns a descriptor of data of mediaType
+func NewDescriptor(mediaType string, data []byte) Descriptor {
+	return Descriptor{MediaType: mediaType, Digest: digestOf(data), Size: int64(len(data))}
+}
+
+// NewFileDescriptor returns a descriptor of the contents of a file of mediaType, reading them through once to compute the digest
+func NewFileDescriptor(mediaType, path string) (Descriptor, errors.GitGhostError) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Descriptor{}, errors.WithStack(err)
+	}
+	defer util.LogDeferredError(f.Close)
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return Descriptor{}, errors.WithStack(err)
+	}
+	return Descriptor{MediaType: mediaType, Digest: "sha256:" + hex.EncodeToString(h.Sum(nil)), Size: n}, nil
+}
+
+// Manifest is an image manifest of an artifact
+type Manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	ArtifactType  string            `json:"artifactType,omitempty"`
+	Config        Descriptor        `json:"config"`
+	Layers        []Descriptor      `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+func digestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// ClientOptions are options of connections to registries
+type ClientOptions struct {
+	// Proxy is a proxy URL which requests go through if not empty, which overrides the ones in environment variables
+	Proxy string
+	// CABundle is a file of CA certificates in PEM trusted instead of the system ones if not empty
+	CABundle string
+	// Context bounds every request including reading its response (e.g. by a timeout), which defaults to context.Background()
+	Context context.Context
+}
+
+// Client talks to OCI registries by the distribution API
+//
+// It logs in to a registry by credentials stored by 'docker login', answering both Basic and Bearer token challenges.
+type Client struct {
+	httpClient *http.Client
+	ctx        context.Context
+	// authorizations are Authorization headers accepted by registries, keyed by registry and repository
+	authorizations map[string]string
+}
+
+// NewClient returns a client of registries
+func NewClient(opts ClientOptions) (*Client, errors.GitGhostError) {
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if opts.Proxy != "" {
+		proxy := opts.Proxy
+		if !strings.Contains(proxy, "://") {
+			proxy = "http://" + proxy
+		}
+		u, err := url.Parse(proxy)
+		if err != nil {
+			return nil, errors.Errorf("%s is not a valid proxy url", util.RedactURLPassword(proxy))
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+	if opts.CABundle != "" {
+		// the bundle replaces the system CA certificates as it does for git
+		pool := x509.NewCertPool()
+		data, rerr := ioutil.ReadFile(opts.CABundle)
+		if rerr != nil {
+			return nil, errors.WithStack(rerr)
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.Errorf("%s contains no certificate in PEM", opts.CABundle)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &Client{
+		httpClient:     &http.Client{Transport: transport},
+		ctx:            ctx,
+		authorizations: map[string]string{},
+	}, nil
+}
+
+// registryError is an error response of the distribution API
+type registryError struct {
+	Errors []struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// responseError returns an error of an unexpected response, classified as errors.CategoryNotFound for 404 and errors.CategoryRemote otherwise
+func responseError(ref Reference, what string, resp *http.Response) errors.GitGhostError {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	message := resp.Status
+	var e registryError
+	if json.Unmarshal(body, &e) == nil && len(e.Errors) > 0 {
+		messages := []string{}
+		for _, m := range e.Errors {
+			messages = append(messages, fmt.Sprintf("%s: %s", m.Code, m.Message))
+		}
+		message = fmt.Sprintf("%s (%s)", resp.Status, strings.Join(messages, ", "))
+	}
+	err := errors.Errorf("%s of %s failed: %s", what, ref, message)
+	if resp.StatusCode == http.StatusNotFound {
+		return errors.WithCategory(err, errors.CategoryNotFound)
+	}
+	return errors.WithCategory(err, errors.CategoryRemote)
+}
+
+// requestError returns an error of what failing by err, telling the context of the client is done if so, classified as errors.CategoryRemote
+func (c *Client) requestError(what string, err error) errors.GitGhostError {
+	switch c.ctx.Err() {
+	case context.DeadlineExceeded:
+		return errors.WithCategory(errors.Errorf("%s timed out", what), errors.CategoryRemote)
+	case context.Canceled:
+		return errors.WithCategory(errors.Errorf("%s was canceled", what), errors.CategoryRemote)
+	}
+	return errors.WithCategory(errors.Errorf("%s failed: %s", what, err), errors.CategoryRemote)
+}
+
+// do sends a request made by newRequest to the repository of ref, logging in by a challenge of the registry if required
+//
+// newRequest is called again to retry after logging in. actions are ones for the scope of a Bearer token (e.g. "pull,push").
+func (c *Client) do(ref Reference, actions string, newRequest func() (*http.Request, error)) (*http.Response, errors.GitGhostError) {
+	key := ref.Registry + "/" + ref.Repository
+	send := func() (*http.Response, errors.GitGhostError) {
+		req, err := newRequest()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if auth, ok := c.authorizations[key]; ok {
+			req.Header.Set("Authorization", auth)
+		}
+		log.WithFields(log.Fields{
+			"method": req.Method,
+			"url":    req.URL.String(),
+		}).Debug("sending a request to registry")
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return nil, c.requestError(fmt.Sprintf("request to registry %s", ref.Registry), err)
+		}
+		return resp, nil
+	}
+	resp, err := send()
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	util.LogDeferredError(resp.Body.Close)
+	auth, err := c.authorize(ref, actions, challenge)
+	if err != nil {
+		return nil, err
+	}
+	c.authorizations[key] = auth
+	resp, err = send()
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		util.LogDeferredError(resp.Body.Close)
+		return nil, errors.WithCategory(errors.Errorf("authentication to registry %s failed: %s. please log in by 'docker login %s' (or a compatible tool)", ref.Registry, resp.Status, ref.Registry), errors.CategoryRemote)
+	}
+	return resp, nil
+}
+
+var regexpChallengeParam = regexp.MustCompile(`([a-zA-Z_]+)="([^"]*)"`)
+
+// authorize returns an Authorization header answering challenge of the registry of ref
+func (c *Client) authorize(ref Reference, actions, challenge string) (string, errors.GitGhostError) {
+	creds, err := LoadCredentials(ref.Registry)
+	if err != nil {
+		return "", err
+	}
+	scheme := strings.ToLower(strings.SplitN(challenge, " ", 2)[0])
+	switch scheme {
+	case "basic":
+		if creds == nil {
+			return "", errors.WithCategory(errors.Errorf("registry %s requires credentials, which are not found. please log in by 'docker login %s' (or a compatible tool)", ref.Registry, ref.Registry), errors.CategoryRemote)
+		}
+		return "Basic " + basicAuth(*creds), nil
+	case "bearer":
+		params := map[string]string{}
+		for _, m := range regexpChallengeParam.FindAllStringSubmatch(challenge, -1) {
+			params[m[1]] = m[2]
+		}
+		return c.fetchToken(ref, actions, params, creds)
+	}
+	return "", errors.WithCategory(errors.Errorf("registry %s requires an unsupported authentication: %s", ref.Registry, challenge), errors.CategoryRemote)
+}
+
+func basicAuth(creds Credentials) string {
+	return base64.StdEncoding.EncodeToString([]byte(creds.Username + ":" + creds.Password))
+}
+
+// fetchToken gets a Bearer token for actions on the repository of ref from the realm in params, anonymously if creds is nil
+func (c *Client) fetchToken(ref Reference, actions string, params map[string]string, creds *Credentials) (string, errors.GitGhostError) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", errors.WithCategory(errors.Errorf("registry %s sent an invalid token realm: %q", ref.Registry, params["realm"]), errors.CategoryRemote)
+	}
+	query := realm.Query()
+	if service, ok := params["service"]; ok {
+		query.Set("service", service)
+	}
+	query.Set("scope", fmt.Sprintf("repository:%s:%s", ref.Repository, actions))
+	realm.RawQuery = query.Encode()
+	req, rerr := http.NewRequestWithContext(c.ctx, http.MethodGet, realm.String(), nil)
+	if rerr != nil {
+		return "", errors.WithStack(rerr)
+	}
+	if creds != nil {
+		req.Header.Set("Authorization", "Basic "+basicAuth(*creds))
+	}
+	resp, rerr := c.httpClient.Do(req)
+	if rerr != nil {
+		return "", c.requestError(fmt.Sprintf("request for a token of registry %s", ref.Registry), rerr)
+	}
+	defer util.LogDeferredError(resp.Body.Close)
+	if resp.StatusCode != http.StatusOK {
+		hint := ""
+		if creds == nil {
+			hint = fmt.Sprintf(". no credentials are found, so please log in by 'docker login %s' (or a compatible tool)", ref.Registry)
+		}
+		return "", errors.WithCategory(errors.Errorf("authentication to registry %s failed: %s%s", ref.Registry, resp.Status, hint), errors.CategoryRemote)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	jerr := json.NewDecoder(resp.Body).Decode(&token)
+	if jerr != nil {
+		return "", errors.WithCategory(errors.Errorf("registry %s sent an invalid token: %s", ref.Registry, jerr), errors.CategoryRemote)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	return "Bearer " + token.Token, nil
+}
+
+func (c *Client) endpoint(ref Reference, path string) string {
+	return fmt.Sprintf("%s://%s/v2/%s/%s", ref.scheme(), ref.Registry, ref.Repository, path)
+}
+
+// PushBlob uploads content of desc to the repository of ref unless the repository already has it
+//
+// content is streamed as the body of the upload, and rewound if the upload is retried (e.g. after authentication).
+func (c *Client) PushBlob(ref Reference, desc Descriptor, content io.ReadSeeker) errors.GitGhostError {
+	resp, err := c.do(ref, "pull,push", func() (*http.Request, error) {
+		return http.NewRequestWithContext(c.ctx, http.MethodHead, c.endpoint(ref, "blobs/"+desc.Digest), nil)
+	})
+	if err != nil {
+		return err
+	}
+	util.LogDeferredError(resp.Body.Close)
+	if resp.StatusCode == http.StatusOK {
+		log.WithField("digest", desc.Digest).Debug("registry already has the blob")
+		return nil
+	}
+
+	resp, err = c.do(ref, "pull,push", func() (*http.Request, error) {
+		return http.NewRequestWithContext(c.ctx, http.MethodPost, c.endpoint(ref, "blobs/uploads/"), nil)
+	})
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusAccepted {
+		defer util.LogDeferredError(resp.Body.Close)
+		return responseError(ref, "starting an upload", resp)
+	}
+	util.LogDeferredError(resp.Body.Close)
+	location, perr := resp.Request.URL.Parse(resp.Header.Get("Location"))
+	if perr != nil || resp.Header.Get("Location") == "" {
+		return errors.WithCategory(errors.Errorf("registry %s sent an invalid upload location: %q", ref.Registry, resp.Header.Get("Location")), errors.CategoryRemote)
+	}
+	query := location.Query()
+	query.Set("digest", desc.Digest)
+	location.RawQuery = query.Encode()
+	resp, err = c.do(ref, "pull,push", func() (*http.Request, error) {
+		_, err := content.Seek(0, io.SeekStart)
+		if err != nil {
+			return nil, err
+		}
+		// the body is wrapped so that http doesn't close it, which is closed by the caller
+		req, err := http.NewRequestWithContext(c.ctx, http.MethodPut, location.String(), ioutil.NopCloser(content))
+		if err == nil {
+			req.ContentLength = desc.Size
+			req.Header.Set("Content-Type", "application/octet-stream")
+		}
+		return req, err
+	})
+	if err != nil {
+		return err
+	}
+	defer util.LogDeferredError(resp.Body.Close)
+	if resp.StatusCode != http.StatusCreated {
+		return responseError(ref, "uploading blob "+desc.Digest, resp)
+	}
+	return nil
+}
+
+// PushManifest puts manifest as the tag of ref and returns its digest
+func (c *Client) PushManifest(ref Reference, manifest Manifest) (string, errors.GitGhostError) {
+	data, jerr := json.Marshal(manifest)
+	if jerr != nil {
+		return "", errors.WithStack(jerr)
+	}
+	resp, err := c.do(ref, "pull,push", func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(c.ctx, http.MethodPut, c.endpoint(ref, "manifests/"+ref.name()), bytes.NewReader(data))
+		if err == nil {
+			req.Header.Set("Content-Type", MediaTypeManifest)
+		}
+		return req, err
+	})
+	if err != nil {
+		return "", err
+	}
+	defer util.LogDeferredError(resp.Body.Close)
+	if resp.StatusCode != http.StatusCreated {
+		return "", responseError(ref, "putting manifest", resp)
+	}
+	return digestOf(data), nil
+}
+
+// PullManifest gets the manifest of ref and returns it with its digest
+func (c *Client) PullManifest(ref Reference) (*Manifest, string, errors.GitGhostError) {
+	resp, err := c.do(ref, "pull", func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(c.ctx, http.MethodGet, c.endpoint(ref, "manifests/"+ref.name()), nil)
+		if err == nil {
+			req.Header.Set("Accept", MediaTypeManifest)
+		}
+		return req, err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	defer util.LogDeferredError(resp.Body.Close)
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", responseError(ref, "getting manifest", resp)
+	}
+	data, rerr := ioutil.ReadAll(io.LimitReader(resp.Body, 4*1024*1024))
+	if rerr != nil {
+		return nil, "", c.requestError(fmt.Sprintf("getting manifest of %s", ref), rerr)
+	}
+	digest := digestOf(data)
+	if ref.Digest != "" && ref.Digest != digest {
+		return nil, "", errors.WithCategory(errors.Errorf("manifest of %s doesn't match its digest (got: %s)", ref, digest), errors.CategoryRemote)
+	}
+	var manifest Manifest
+	jerr := json.Unmarshal(data, &manifest)
+	if jerr != nil {
+		return nil, "", errors.Errorf("manifest of %s is invalid: %s", ref, jerr)
+	}
+	return &manifest, digest, nil
+}
+
+// PullBlob gets the blob of desc in the repository of ref into w, verifying its digest and size
+func (c *Client) PullBlob(ref Reference, desc Descriptor, w io.Writer) errors.GitGhostError {
+	resp, err := c.do(ref, "pull", func() (*http.Request, error) {
+		return http.NewRequestWithContext(c.ctx, http.MethodGet, c.endpoint(ref, "blobs/"+desc.Digest), nil)
+	})
+	if err != nil {
+		return err
+	}
+	defer util.LogDeferredError(resp.Body.Close)
+	if resp.StatusCode != http.StatusOK {
+		return responseError(ref, "getting blob "+desc.Digest, resp)
+	}
+	var h hash.Hash = sha256.New()
+	n, cerr := io.Copy(io.MultiWriter(w, h), io.LimitReader(resp.Body, desc.Size+1))
+	if cerr != nil {
+		return c.requestError(fmt.Sprintf("getting blob %s of %s", desc.Digest, ref), cerr)
+	}
+	if n != desc.Size || "sha256:"+hex.EncodeToString(h.Sum(nil)) != desc.Digest {
+		return errors.WithCategory(errors.Errorf("blob %s of %s doesn't match its digest or size", desc.Digest, ref), errors.CategoryRemote)
+	}
+	return nil
+}
+
+var regexpNextLink = regexp.MustCompile(`<([^>]+)>\s*;\s*rel="?next"?`)
+
+// ListTags returns all the tags in the repository of ref, following pages of the list
+func (c *Client) ListTags(ref Reference) ([]string, errors.GitGhostError) {
+	tags := []string{}
+	next := c.endpoint(ref, "tags/list")
+	for next != "" {
+		page := next
+		resp, err := c.do(ref, "pull", func() (*http.Request, error) {
+			return http.NewRequestWithContext(c.ctx, http.MethodGet, page, nil)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			defer util.LogDeferredError(resp.Body.Close)
+			return nil, responseError(ref, "listing tags", resp)
+		}
+		var list struct {
+			Tags []string `json:"tags"`
+		}
+		jerr := json.NewDecoder(resp.Body).Decode(&list)
+		util.LogDeferredError(resp.Body.Close)
+		if jerr != nil {
+			return nil, errors.WithCategory(errors.Errorf("listing tags of %s failed: %s", ref, jerr), errors.CategoryRemote)
+		}
+		tags = append(tags, list.Tags...)
+		next = ""
+		if m := regexpNextLink.FindStringSubmatch(resp.Header.Get("Link")); m != nil {
+			u, perr := resp.Request.URL.Parse(m[1])
+			if perr != nil {
+				return nil, errors.WithCategory(errors.Errorf("registry %s sent an invalid link: %s", ref.Registry, m[1]), errors.CategoryRemote)
+			}
+			next = u.String()
+		}
+	}
+	return tags, nil
+}
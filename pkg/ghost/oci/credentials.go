@@ -0,0 +1,134 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// Credentials are a user name and a password (or a token) to log in to a registry
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// dockerConfig is a part of config.json of docker, which 'docker login' writes credentials to
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth     string `json:"auth"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"auths"`
+	CredHelpers map[string]string `json:"credHelpers"`
+	CredsStore  string            `json:"credsStore"`
+}
+
+// dockerConfigPath returns the path of config.json of docker in DOCKER_CONFIG env or in ~/.docker
+func dockerConfigPath() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".docker", "config.json")
+}
+
+// LoadCredentials returns credentials of registry which 'docker login' (or a compatible tool) stored, or nil if there are none
+//
+// Credentials are looked up in the credential helper for registry, in "auths" and in the credential store in this order.
+func LoadCredentials(registry string) (*Credentials, errors.GitGhostError) {
+	path := dockerConfigPath()
+	if path == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var config dockerConfig
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return nil, errors.Errorf("%s is invalid: %s", path, err)
+	}
+	if helper, ok := config.CredHelpers[registry]; ok {
+		return credentialsFromHelper(helper, registry)
+	}
+	for _, key := range []string{registry, "https://" + registry, "http://" + registry} {
+		auth, ok := config.Auths[key]
+		if !ok {
+			continue
+		}
+		if auth.Auth == "" {
+			if auth.Username != "" {
+				return &Credentials{Username: auth.Username, Password: auth.Password}, nil
+			}
+			break
+		}
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return nil, errors.Errorf("auth of %s in %s is invalid: %s", registry, path, err)
+		}
+		userPassword := strings.SplitN(string(decoded), ":", 2)
+		if len(userPassword) != 2 {
+			return nil, errors.Errorf("auth of %s in %s is not in the form of user:password", registry, path)
+		}
+		return &Credentials{Username: userPassword[0], Password: userPassword[1]}, nil
+	}
+	if config.CredsStore != "" {
+		return credentialsFromHelper(config.CredsStore, registry)
+	}
+	return nil, nil
+}
+
+// credentialsFromHelper gets credentials of registry from docker-credential-<helper>, or nil if the helper has none
+func credentialsFromHelper(helper, registry string) (*Credentials, errors.GitGhostError) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(registry)
+	// helpers tell missing credentials on stdout, which is lost by util.JustOutputCmd
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	err := util.JustRunCmd(cmd)
+	output := stdout.Bytes()
+	if err != nil {
+		if strings.Contains(stdout.String(), "credentials not found") || strings.Contains(err.Error(), "credentials not found") {
+			return nil, nil
+		}
+		return nil, errors.Errorf("credential helper docker-credential-%s failed for %s: %s", helper, registry, err)
+	}
+	var creds struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	jerr := json.Unmarshal(output, &creds)
+	if jerr != nil {
+		return nil, errors.Errorf("output of credential helper docker-credential-%s is invalid: %s", helper, jerr)
+	}
+	return &Credentials{Username: creds.Username, Password: creds.Secret}, nil
+}
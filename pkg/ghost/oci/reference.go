@@ -0,0 +1,119 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// Reference is a reference of an artifact in an OCI registry like "registry.example.com/team/ghosts:tag"
+type Reference struct {
+	// Registry is a host of the registry with an optional port
+	Registry string
+	// Repository is a path of the repository in the registry
+	Repository string
+	// Tag is a tag in the repository, which may be empty if Digest is specified or only the repository is
+	Tag string
+	// Digest is a digest of a manifest (e.g. "sha256:...") in the repository if not empty
+	Digest string
+}
+
+var (
+	regexpRepository = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+	regexpTag        = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]{0,127}$`)
+	regexpDigest     = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+)
+
+// ParseReference parses a reference of an artifact, or of a repository without a tag or a digest
+//
+// The registry host is required, which must contain "." or ":" or be "localhost" as docker's rules, since there is no default registry.
+func ParseReference(s string) (Reference, errors.GitGhostError) {
+	slash := strings.Index(s, "/")
+	if slash < 0 {
+		return Reference{}, errors.Errorf("%s has no registry host. it must be in the form of HOST[:PORT]/REPOSITORY[:TAG][@DIGEST]", s)
+	}
+	ref := Reference{Registry: s[:slash]}
+	if !strings.ContainsAny(ref.Registry, ".:") && ref.Registry != "localhost" {
+		return Reference{}, errors.Errorf("%s has no registry host (%s is not a host). it must be in the form of HOST[:PORT]/REPOSITORY[:TAG][@DIGEST]", s, ref.Registry)
+	}
+	rest := s[slash+1:]
+	if at := strings.Index(rest, "@"); at >= 0 {
+		ref.Digest = rest[at+1:]
+		rest = rest[:at]
+		if !regexpDigest.MatchString(ref.Digest) {
+			return Reference{}, errors.Errorf("digest of %s is invalid: %s", s, ref.Digest)
+		}
+	}
+	if colon := strings.LastIndex(rest, ":"); colon >= 0 && !strings.Contains(rest[colon:], "/") {
+		ref.Tag = rest[colon+1:]
+		rest = rest[:colon]
+		if !regexpTag.MatchString(ref.Tag) {
+			return Reference{}, errors.Errorf("tag of %s is invalid: %s", s, ref.Tag)
+		}
+	}
+	ref.Repository = rest
+	if !regexpRepository.MatchString(ref.Repository) {
+		return Reference{}, errors.Errorf("repository of %s is invalid: %s", s, ref.Repository)
+	}
+	return ref, nil
+}
+
+// WithTag returns the reference of tag in the same repository
+func (r Reference) WithTag(tag string) Reference {
+	return Reference{Registry: r.Registry, Repository: r.Repository, Tag: tag}
+}
+
+// WithDigest returns the reference of digest in the same repository
+func (r Reference) WithDigest(digest string) Reference {
+	return Reference{Registry: r.Registry, Repository: r.Repository, Digest: digest}
+}
+
+// name returns the tag or the digest to address a manifest by
+func (r Reference) name() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}
+
+func (r Reference) String() string {
+	s := r.Registry + "/" + r.Repository
+	if r.Digest != "" {
+		return s + "@" + r.Digest
+	}
+	if r.Tag != "" {
+		return s + ":" + r.Tag
+	}
+	return s
+}
+
+// scheme returns "http" for a registry on the loopback interface like docker's default insecure registries, and "https" otherwise
+func (r Reference) scheme() string {
+	host := r.Registry
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return "http"
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return "http"
+	}
+	return "https"
+}
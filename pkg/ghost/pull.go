@@ -15,6 +15,13 @@
 package ghost
 
 import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/oci"
 	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
 	"github.com/pfnet-research/git-ghost/pkg/util"
 	"github.com/pfnet-research/git-ghost/pkg/util/errors"
@@ -27,37 +34,276 @@ type PullOptions struct {
 	types.WorkingEnvSpec
 	*types.CommitsBranchSpec
 	*types.PullableDiffBranchSpec
+	types.ApplyOptions
+	// AutoStash stashes local changes before applying ghost branches and restores them after that
+	AutoStash bool
+	// Atomic restores HEAD, the index and the working tree of the source directory as they were before applying if any of it fails
+	Atomic bool
+	// PostApplyHook is a shell command run in the source directory after ghost branches are applied successfully if not empty
+	PostApplyHook string
+	// FailOnHookError makes Pull fail if PostApplyHook fails, which is only logged otherwise
+	FailOnHookError bool
+	// OnPulled is called with metrics of each ghost branch applied successfully if not nil
+	OnPulled func(types.TransferMetrics)
+	// OCIReference is a reference of an OCI artifact which ghost branches are pulled from instead of ghost repo if not empty
+	OCIReference string
+	// OCIClient is options to talk to the registry of OCIReference
+	OCIClient oci.ClientOptions
 }
 
-func pullAndApply(spec types.PullableGhostBranchSpec, we types.WorkingEnv) errors.GitGhostError {
+func pullAndApply(spec types.PullableGhostBranchSpec, we types.WorkingEnv, opts types.ApplyOptions, onPulled func(types.TransferMetrics)) (types.GhostBranch, errors.GitGhostError) {
+	start := time.Now()
 	pulledBranch, err := spec.PullBranch(we)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	metrics := types.TransferMetrics{Branch: pulledBranch.BranchName(), Transfer: time.Since(start)}
+	metrics.Bytes, err = git.GetTreeSize(we.GhostDir, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	start = time.Now()
+	types.ReportProgress(types.ProgressPhaseApply, types.ProgressStart, metrics.Branch, 0, metrics.Bytes)
+	err = pulledBranch.Apply(we, opts)
+	if err != nil {
+		return pulledBranch, err
 	}
-	return pulledBranch.Apply(we)
+	metrics.Local = time.Since(start)
+	types.ReportProgress(types.ProgressPhaseApply, types.ProgressDone, metrics.Branch, metrics.Bytes, metrics.Bytes)
+	if opts.Report != nil {
+		// the report of the ghost branch is the last one appended by applying it
+		if last := len(opts.Report.Ghosts) - 1; last >= 0 && opts.Report.Ghosts[last].Branch == metrics.Branch {
+			opts.Report.Ghosts[last].Metrics = &metrics
+		}
+	}
+	if onPulled != nil {
+		onPulled(metrics)
+	}
+	return pulledBranch, nil
 }
 
 // Pull pulls ghost branches and apply to workind directory
 func Pull(options PullOptions) errors.GitGhostError {
 	log.WithFields(util.ToFields(options)).Debug("pull command with")
+	var applied []types.GhostBranch
+	err := withPullSource(options, func(options PullOptions) errors.GitGhostError {
+		var err errors.GitGhostError
+		applied, err = pull(options)
+		return err
+	})
+	writeAuditRecord("pull", options.WorkingEnvSpec, applied, err)
+	return err
+}
+
+// pull pulls and applies ghost branches in options, runs the post-apply hook and returns the applied ones
+func pull(options PullOptions) ([]types.GhostBranch, errors.GitGhostError) {
 	we, err := options.WorkingEnvSpec.Initialize()
 	if err != nil {
-		return errors.WithStack(err)
+		return nil, errors.WithStack(err)
 	}
 	defer util.LogDeferredGitGhostError(we.Clean)
 
+	if !options.Atomic {
+		return applyAll(options, *we)
+	}
+	var applied []types.GhostBranch
+	err = withRollback(we.SrcDir, options.Report, func() errors.GitGhostError {
+		var err errors.GitGhostError
+		applied, err = applyAll(options, *we)
+		return err
+	})
+	if err != nil {
+		// nothing is left applied after rolling back
+		return nil, err
+	}
+	return applied, nil
+}
+
+// applyAll applies ghost branches in options to the source directory of we, runs the post-apply hook and returns the applied ones
+func applyAll(options PullOptions, we types.WorkingEnv) ([]types.GhostBranch, errors.GitGhostError) {
+	var applied []types.GhostBranch
+	var err errors.GitGhostError
+	if options.AutoStash {
+		err = withAutoStash(we.SrcDir, func() errors.GitGhostError {
+			var err errors.GitGhostError
+			applied, err = pullAll(options, we)
+			return err
+		})
+	} else {
+		applied, err = pullAll(options, we)
+	}
+	if err != nil || options.PostApplyHook == "" || len(applied) == 0 {
+		return applied, err
+	}
+	err = runPostApplyHook(options.PostApplyHook, we.SrcDir, applied)
+	if err != nil {
+		if options.FailOnHookError {
+			return applied, err
+		}
+		log.WithFields(log.Fields{
+			"srcDir": we.SrcDir,
+		}).Errorf("%s. ghosts are applied anyway", err)
+	}
+	return applied, nil
+}
+
+// pullAll pulls and applies ghost branches in options and returns the applied ones
+func pullAll(options PullOptions, we types.WorkingEnv) ([]types.GhostBranch, errors.GitGhostError) {
+	applied := []types.GhostBranch{}
 	if options.CommitsBranchSpec != nil {
-		err := pullAndApply(*options.CommitsBranchSpec, *we)
+		branch, err := pullAndApply(*options.CommitsBranchSpec, we, options.ApplyOptions, options.OnPulled)
 		if err != nil {
-			return errors.WithStack(err)
+			return applied, errors.WithStack(err)
 		}
+		applied = append(applied, branch)
 	}
 
 	if options.PullableDiffBranchSpec != nil {
-		err := pullAndApply(*options.PullableDiffBranchSpec, *we)
-		return errors.WithStack(err)
+		branch, err := pullAndApply(*options.PullableDiffBranchSpec, we, options.ApplyOptions, options.OnPulled)
+		if err != nil {
+			return applied, errors.WithStack(err)
+		}
+		return append(applied, branch), nil
 	}
 
 	log.WithFields(util.ToFields(options)).Warn("pull command has nothing to do with")
+	return applied, nil
+}
+
+// CheckPull pulls ghost branches in options and checks whether they apply to the working tree of the source directory as it is
+//
+// Nothing is applied, so neither the working tree nor the index of the source directory is touched, and the post-apply hook is not run.
+// Ghost branches which don't apply are not an error, but a result.
+func CheckPull(options PullOptions) (*types.ApplyCheck, errors.GitGhostError) {
+	log.WithFields(util.ToFields(options)).Debug("pull check command with")
+	var check *types.ApplyCheck
+	err := withPullSource(options, func(options PullOptions) errors.GitGhostError {
+		var err errors.GitGhostError
+		check, err = checkPull(options)
+		return err
+	})
+	return check, err
+}
+
+// withPullSource calls f with options, whose ghost repo is replaced by the bundle of OCIReference if it is not empty
+func withPullSource(options PullOptions, f func(PullOptions) errors.GitGhostError) errors.GitGhostError {
+	if options.OCIReference == "" {
+		return f(options)
+	}
+	return withOCIBundle(options.WorkingEnvSpec, options.OCIClient, options.OCIReference, func(spec types.WorkingEnvSpec) errors.GitGhostError {
+		options.WorkingEnvSpec = spec
+		return f(options)
+	})
+}
+
+func checkPull(options PullOptions) (*types.ApplyCheck, errors.GitGhostError) {
+	we, err := options.WorkingEnvSpec.Initialize()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer util.LogDeferredGitGhostError(we.Clean)
+
+	specs := []types.PullableGhostBranchSpec{}
+	if options.CommitsBranchSpec != nil {
+		specs = append(specs, *options.CommitsBranchSpec)
+	}
+	if options.PullableDiffBranchSpec != nil {
+		specs = append(specs, *options.PullableDiffBranchSpec)
+	}
+	checker := types.NewApplyChecker(*we, options.ApplyOptions)
+	defer checker.Clean()
+	for _, spec := range specs {
+		branch, err := spec.PullBranch(*we)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		err = checker.Add(branch)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	return checker.Check()
+}
+
+// withAutoStash stashes local changes on srcDir, calls f and restores the changes
+func withAutoStash(srcDir string, f func() errors.GitGhostError) errors.GitGhostError {
+	dirty, err := git.HasLocalChanges(srcDir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !dirty {
+		return f()
+	}
+	err = git.StashPush(srcDir, "git-ghost autostash")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	log.WithFields(log.Fields{
+		"srcDir": srcDir,
+	}).Info("stashed local changes")
+
+	applyErr := f()
+	err = git.StashPop(srcDir)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"srcDir": srcDir,
+		}).Errorf("failed to restore local changes, which are kept in the stash: %s", err)
+		if applyErr != nil {
+			return errors.WithStack(applyErr)
+		}
+		return errors.WithCategory(errors.Errorf("ghost was applied but restoring local changes conflicted. your changes are kept in the stash. please resolve the conflicts and run 'git stash drop'"), errors.CategoryConflict)
+	}
+	log.WithFields(log.Fields{
+		"srcDir": srcDir,
+	}).Info("restored local changes")
+	return errors.WithStack(applyErr)
+}
+
+// withRollback takes a snapshot of srcDir, calls f and restores srcDir to the snapshot if f fails or panics
+//
+// SIGINT and SIGTERM are held until then, so that an interrupted git command fails f instead of leaving srcDir half applied.
+// Whether srcDir is rolled back is recorded in report if it is not nil.
+func withRollback(srcDir string, report *types.ApplyReport, f func() errors.GitGhostError) errors.GitGhostError {
+	snapshot, err := git.TakeSnapshot(srcDir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	rollback := func(cause errors.GitGhostError) errors.GitGhostError {
+		log.WithFields(log.Fields{
+			"srcDir": srcDir,
+			"head":   snapshot.Head,
+		}).Infof("rolling back: %s", cause)
+		err := git.RestoreSnapshot(srcDir, snapshot)
+		if err != nil {
+			return errors.Annotatef(cause, "%s, and rolling back %s failed: %s. files before pulling are kept in tree %s on commit %s, which 'git read-tree --reset -u %s' restores", cause, srcDir, err, snapshot.WorkTree, snapshot.Head, snapshot.WorkTree)
+		}
+		if report != nil {
+			report.RolledBack = true
+		}
+		return errors.Annotatef(cause, "%s. %s is rolled back to the state before pulling", cause, srcDir)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			util.LogDeferredGitGhostError(func() errors.GitGhostError { return rollback(errors.Errorf("panic: %v", r)) })
+			panic(r)
+		}
+	}()
+
+	err = f()
+	select {
+	case s := <-signals:
+		// git commands run by f may have succeeded if only git-ghost got the signal
+		if err == nil {
+			err = errors.Errorf("interrupted by %s", s)
+		}
+	default:
+	}
+	if err != nil {
+		return rollback(err)
+	}
 	return nil
 }
@@ -15,6 +15,9 @@
 package ghost
 
 import (
+	"os"
+	"time"
+
 	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
 	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
 	"github.com/pfnet-research/git-ghost/pkg/util"
@@ -28,12 +31,22 @@ type PushOptions struct {
 	types.WorkingEnvSpec
 	*types.CommitsBranchSpec
 	*types.DiffBranchSpec
+	// Force creates and pushes a local mod branch even if it is the same as the one pushed last time
+	Force bool
+	// LastTag is a tag of the local mod branch pushed last time if not empty, which the local mod branch is pushed incrementally from
+	// if it is on the same base, and which is moved to the pushed one
+	LastTag string
+	// CreateOnly fails pushing a ghost branch which already exists in ghost repo with errors.CategoryExists instead of skipping it,
+	// creating the branch atomically so that only one of concurrent pushes of it succeeds
+	CreateOnly bool
 }
 
 // PushResult contains resultant ghost branches of Push func
 type PushResult struct {
 	*types.CommitsBranch
 	*types.DiffBranch
+	// Metrics are of ghost branches actually pushed, excluding ones skipped since they exist or are unchanged
+	Metrics []types.TransferMetrics
 }
 
 // Push pushes create ghost branches and push them to remote ghost repository
@@ -41,63 +54,238 @@ func Push(options PushOptions) (*PushResult, errors.GitGhostError) {
 	log.WithFields(util.ToFields(options)).Debug("push command with")
 
 	var result PushResult
+	err := push(options, &result)
+	writeAuditRecord("push", options.WorkingEnvSpec, result.branches(), err)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// branches returns pushed ghost branches
+func (result PushResult) branches() []types.GhostBranch {
+	branches := []types.GhostBranch{}
+	if result.CommitsBranch != nil {
+		branches = append(branches, result.CommitsBranch)
+	}
+	if result.DiffBranch != nil {
+		branches = append(branches, result.DiffBranch)
+	}
+	return branches
+}
+
+// push pushes ghost branches in options, filling result with them as they are pushed
+func push(options PushOptions, result *PushResult) errors.GitGhostError {
 	if options.CommitsBranchSpec != nil {
-		branch, err := pushGhostBranch(options.CommitsBranchSpec, options.WorkingEnvSpec)
+		branch, metrics, err := pushGhostBranch(options.CommitsBranchSpec, options.WorkingEnvSpec, options.CreateOnly)
 		if err != nil {
-			return nil, errors.WithStack(err)
+			return errors.WithStack(err)
 		}
+		result.addMetrics(metrics)
 		commitsBranch, _ := branch.(*types.CommitsBranch)
 		result.CommitsBranch = commitsBranch
 	}
 
 	if options.DiffBranchSpec != nil {
-		branch, err := pushGhostBranch(options.DiffBranchSpec, options.WorkingEnvSpec)
+		if options.LastTag != "" {
+			spec, err := incrementalFromLastTag(options)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			options.DiffBranchSpec = spec
+		}
+		unchanged, patchFile, err := unchangedDiffBranch(options)
+		if patchFile != "" {
+			defer util.LogDeferredError(func() error { return os.Remove(patchFile) })
+			spec := *options.DiffBranchSpec
+			spec.GeneratedPatchFile = patchFile
+			options.DiffBranchSpec = &spec
+		}
 		if err != nil {
-			return nil, errors.WithStack(err)
+			return errors.WithStack(err)
+		}
+		if unchanged != nil {
+			log.WithFields(log.Fields{
+				"branch":    unchanged.BranchName(),
+				"ghostRepo": options.GhostRepo,
+			}).Info("skipped pushing branch unchanged since the last push")
+			result.DiffBranch = unchanged
+			return moveLastTag(options, unchanged)
 		}
+		branch, metrics, err := pushGhostBranch(options.DiffBranchSpec, options.WorkingEnvSpec, options.CreateOnly)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		result.addMetrics(metrics)
 		diffBranch, _ := branch.(*types.DiffBranch)
 		result.DiffBranch = diffBranch
+		if diffBranch != nil {
+			err = saveLastPushedBranchName(options.SrcDir, options.GhostRepo, diffBranch.BranchName())
+			if err != nil {
+				log.WithFields(log.Fields{
+					"srcDir": options.SrcDir,
+				}).Warnf("failed to save the last pushed branch: %s", err)
+			}
+			return moveLastTag(options, diffBranch)
+		}
 	}
 
-	return &result, nil
+	return nil
 }
 
-func pushGhostBranch(branchSpec types.GhostBranchSpec, workingEnvSpec types.WorkingEnvSpec) (types.GhostBranch, errors.GitGhostError) {
+// incrementalFromLastTag returns the spec of the local mod branch in options incremental from the one of options.LastTag
+//
+// The spec is returned as it is if the tag doesn't exist or isn't of a local mod branch on the same base, so a full diff is pushed.
+func incrementalFromLastTag(options PushOptions) (*types.DiffBranchSpec, errors.GitGhostError) {
+	spec := *options.DiffBranchSpec
+	tags, err := ListTags(TagOptions{WorkingEnvSpec: options.WorkingEnvSpec, Prefix: spec.Prefix}, "")
+	if err != nil {
+		return nil, err
+	}
+	var last types.GhostBranch
+	for _, tag := range tags {
+		if tag.Name == options.LastTag {
+			last = tag.Branch
+		}
+	}
+	fields := log.Fields{"tag": options.LastTag}
+	diffBranch, ok := last.(*types.DiffBranch)
+	if !ok {
+		if last != nil {
+			fields["branch"] = last.BranchName()
+		}
+		log.WithFields(fields).Info("no local mod branch is tagged to be pushed incrementally from. pushing a full diff")
+		return &spec, nil
+	}
+	fields["branch"] = diffBranch.BranchName()
+	base, err := git.ResolveCommittish(options.SrcDir, spec.CommittishFrom)
+	if err != nil {
+		return nil, err
+	}
+	if diffBranch.CommitHashFrom != base {
+		fields["base"] = base
+		log.WithFields(fields).Info("the last local mod branch is on another base. pushing a full diff")
+		return &spec, nil
+	}
+	log.WithFields(fields).Info("pushing incrementally from the last local mod branch")
+	spec.ParentDiffHash = diffBranch.DiffHash
+	return &spec, nil
+}
+
+// moveLastTag moves options.LastTag to branch if it is not empty
+func moveLastTag(options PushOptions, branch *types.DiffBranch) errors.GitGhostError {
+	if options.LastTag == "" {
+		return nil
+	}
+	_, err := SetTag(TagOptions{WorkingEnvSpec: options.WorkingEnvSpec, Prefix: options.DiffBranchSpec.Prefix}, branch.BranchName(), options.LastTag)
+	return err
+}
+
+// unchangedDiffBranch returns a local mod branch to be pushed if it is the same as the one pushed last time
+// and still exists in ghost repo, with a temporary file of the patch generated for it, which must be removed by the caller
+//
+// Only the ref of the branch is listed from ghost repo so that repeated pushes without changes are cheap.
+func unchangedDiffBranch(options PushOptions) (*types.DiffBranch, string, errors.GitGhostError) {
+	if options.Force || options.CreateOnly {
+		return nil, "", nil
+	}
+	predicted, patchFile, err := options.DiffBranchSpec.PredictBranchKeepingPatch(options.WorkingEnvSpec)
+	if err != nil || predicted == nil {
+		return nil, patchFile, err
+	}
+	last, err := lastPushedBranchName(options.SrcDir, options.GhostRepo)
+	if err != nil {
+		return nil, patchFile, err
+	}
+	if last != predicted.BranchName() {
+		return nil, patchFile, nil
+	}
+	exists, err := git.ValidateRemoteBranchExistence(options.GhostRepo, predicted.BranchName())
+	if err != nil {
+		return nil, patchFile, err
+	}
+	if !exists {
+		log.WithFields(log.Fields{
+			"branch":    predicted.BranchName(),
+			"ghostRepo": options.GhostRepo,
+		}).Info("the branch pushed last time is missing in ghost repo. pushing it again")
+		return nil, patchFile, nil
+	}
+	return predicted, patchFile, nil
+}
+
+func (result *PushResult) addMetrics(metrics *types.TransferMetrics) {
+	if metrics != nil {
+		result.Metrics = append(result.Metrics, *metrics)
+	}
+}
+
+// pushGhostBranch creates and pushes a ghost branch, returning metrics of pushing it or nil if it already exists
+//
+// If createOnly is true, it fails if the branch already exists instead.
+func pushGhostBranch(branchSpec types.GhostBranchSpec, workingEnvSpec types.WorkingEnvSpec, createOnly bool) (types.GhostBranch, *types.TransferMetrics, errors.GitGhostError) {
 	workingEnv, err := workingEnvSpec.Initialize()
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, nil, errors.WithStack(err)
 	}
 	defer util.LogDeferredGitGhostError(workingEnv.Clean)
 	dstDir := workingEnv.GhostDir
+	start := time.Now()
+	types.ReportProgress(types.ProgressPhaseCreate, types.ProgressStart, "", 0, 0)
 	branch, err := branchSpec.CreateBranch(*workingEnv)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, nil, errors.WithStack(err)
 	}
 	if branch == nil {
-		return nil, nil
+		types.ReportProgress(types.ProgressPhaseCreate, types.ProgressDone, "", 0, 0)
+		return nil, nil, nil
 	}
+	metrics := types.TransferMetrics{Branch: branch.BranchName(), Local: time.Since(start)}
 	existence, err := git.ValidateRemoteBranchExistence(
 		workingEnv.GhostRepo,
 		branch.BranchName(),
 	)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, nil, errors.WithStack(err)
+	}
+	if existence && createOnly {
+		return nil, nil, errors.WithCategory(errors.Errorf("ghost branch %s already exists", branch.BranchName()), errors.CategoryExists)
 	}
 	if existence {
 		log.WithFields(log.Fields{
 			"branch":    branch.BranchName(),
 			"ghostRepo": workingEnv.GhostRepo,
 		}).Info("skipped pushing existing branch")
-		return branch, nil
+		// the branch may be an existing one reused without being created (e.g. by a patch id)
+		types.ReportProgress(types.ProgressPhaseCreate, types.ProgressDone, branch.BranchName(), 0, 0)
+		return branch, nil, nil
+	}
+	metrics.Bytes, err = git.GetTreeSize(dstDir, branch.BranchName())
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
 	}
+	types.ReportProgress(types.ProgressPhaseCreate, types.ProgressDone, branch.BranchName(), metrics.Bytes, metrics.Bytes)
 
 	log.WithFields(log.Fields{
 		"branch":    branch.BranchName(),
 		"ghostRepo": workingEnv.GhostRepo,
 	}).Info("pushing branch")
-	err = git.Push(dstDir, branch.BranchName())
+	refs := []string{branch.BranchName()}
+	if commitsBranch, ok := branch.(*types.CommitsBranch); ok && commitsBranch.PatchID != "" {
+		refs = append(refs, "+refs/tags/"+commitsBranch.PatchIDTagName())
+	}
+	start = time.Now()
+	types.ReportProgress(types.ProgressPhasePush, types.ProgressStart, branch.BranchName(), 0, metrics.Bytes)
+	if createOnly {
+		// checked again by ghost repo since another push may create the branch after checking its existence above
+		err = git.PushIfAbsent(dstDir, refs...)
+	} else {
+		err = git.PushWithProgress(dstDir, types.TransferProgress(types.ProgressPhasePush, branch.BranchName(), metrics.Bytes), refs...)
+	}
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, nil, errors.WithStack(err)
 	}
-	return branch, nil
+	metrics.Transfer = time.Since(start)
+	types.ReportProgress(types.ProgressPhasePush, types.ProgressDone, branch.BranchName(), metrics.Bytes, metrics.Bytes)
+	return branch, &metrics, nil
 }
@@ -0,0 +1,86 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// pushCacheFile is a file in the git directory of a source directory
+// which records a local mod branch pushed last time for each ghost repo
+const pushCacheFile = "git-ghost-push-cache"
+
+func readPushCache(srcDir string) (map[string]string, errors.GitGhostError) {
+	path, ggerr := git.ResolveGitPath(srcDir, pushCacheFile)
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	cache := map[string]string{}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		tokens := strings.Split(line, "\t")
+		if len(tokens) != 2 {
+			continue
+		}
+		cache[tokens[0]] = tokens[1]
+	}
+	return cache, nil
+}
+
+// lastPushedBranchName returns a branch name pushed to ghostRepo from srcDir last time
+func lastPushedBranchName(srcDir, ghostRepo string) (string, errors.GitGhostError) {
+	cache, ggerr := readPushCache(srcDir)
+	if ggerr != nil {
+		return "", ggerr
+	}
+	return cache[ghostRepo], nil
+}
+
+// saveLastPushedBranchName records a branch name pushed to ghostRepo from srcDir
+func saveLastPushedBranchName(srcDir, ghostRepo, branchName string) errors.GitGhostError {
+	cache, ggerr := readPushCache(srcDir)
+	if ggerr != nil {
+		return ggerr
+	}
+	cache[ghostRepo] = branchName
+	repos := make([]string, 0, len(cache))
+	for repo := range cache {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+	var buffer bytes.Buffer
+	for _, repo := range repos {
+		buffer.WriteString(fmt.Sprintf("%s\t%s\n", repo, cache[repo]))
+	}
+	path, ggerr := git.ResolveGitPath(srcDir, pushCacheFile)
+	if ggerr != nil {
+		return ggerr
+	}
+	return errors.WithStack(ioutil.WriteFile(path, buffer.Bytes(), 0600))
+}
@@ -0,0 +1,150 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RebaseOptions represents arg for Rebase func
+type RebaseOptions struct {
+	types.WorkingEnvSpec
+	*types.PullableDiffBranchSpec
+	// Onto is a committish which the diff is re-created on
+	Onto string
+	// Force deletes the original ghost branch after the rebased one is pushed
+	Force bool
+}
+
+// RebaseResult contains the original and the rebased ghost branches of Rebase func
+type RebaseResult struct {
+	Original *types.DiffBranch
+	Rebased  *types.DiffBranch
+	// Deleted is true if the original ghost branch was deleted
+	Deleted bool
+}
+
+// branches returns ghost branches pushed or deleted by Rebase
+func (result RebaseResult) branches() []types.GhostBranch {
+	branches := []types.GhostBranch{}
+	if result.Rebased != nil {
+		branches = append(branches, result.Rebased)
+	}
+	if result.Deleted {
+		branches = append(branches, result.Original)
+	}
+	return branches
+}
+
+// Rebase re-creates a local mod branch on another base and pushes it as a new local mod branch
+//
+// The diff is applied onto Onto in a temporary worktree, so neither the working tree nor the index of the source directory is touched.
+// Nothing is pushed if the diff doesn't apply cleanly.
+func Rebase(options RebaseOptions) (*RebaseResult, errors.GitGhostError) {
+	log.WithFields(util.ToFields(options)).Debug("rebase command with")
+
+	var result RebaseResult
+	err := rebase(options, &result)
+	writeAuditRecord("rebase", options.WorkingEnvSpec, result.branches(), err)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func rebase(options RebaseOptions, result *RebaseResult) errors.GitGhostError {
+	if options.PullableDiffBranchSpec == nil {
+		return errors.New("diff to rebase is not specified")
+	}
+	err := git.ValidateCommittish(options.SrcDir, options.Onto)
+	if err != nil {
+		return err
+	}
+	onto, err := git.ResolveCommittish(options.SrcDir, options.Onto)
+	if err != nil {
+		return err
+	}
+
+	we, err := options.WorkingEnvSpec.Initialize()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer util.LogDeferredGitGhostError(we.Clean)
+	branch, err := options.PullableDiffBranchSpec.PullBranch(*we)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	original, _ := branch.(*types.DiffBranch)
+	result.Original = original
+
+	patch, ioerr := ioutil.TempFile(util.TempDir(), "git-ghost-rebase")
+	if ioerr != nil {
+		return errors.WithStack(ioerr)
+	}
+	util.LogDeferredError(patch.Close)
+	defer util.LogDeferredError(func() error { return os.Remove(patch.Name()) })
+	err = git.WithTemporaryWorktree(we.SrcDir, onto, func(worktree string) errors.GitGhostError {
+		worktreeEnv := *we
+		worktreeEnv.SrcDir = worktree
+		err := original.Apply(worktreeEnv, types.ApplyOptions{})
+		if err != nil {
+			return errors.WithCategory(
+				errors.Errorf("%s does not apply cleanly onto %s, so nothing is pushed: %s", original.BranchName(), onto, err),
+				errors.CategoryConflict,
+			)
+		}
+		tree, err := git.WriteWorktreeState(worktree)
+		if err != nil {
+			return err
+		}
+		return git.CreateTreeDiffPatchFile(worktree, patch.Name(), onto, tree, git.DiffOptions{})
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	pushed, _, err := pushGhostBranch(&types.DiffBranchSpec{
+		Prefix:         original.Prefix,
+		CommittishFrom: onto,
+		PatchFile:      patch.Name(),
+	}, options.WorkingEnvSpec, false)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	rebased, _ := pushed.(*types.DiffBranch)
+	result.Rebased = rebased
+	if !options.Force || rebased == nil || rebased.BranchName() == original.BranchName() {
+		return nil
+	}
+
+	log.WithFields(log.Fields{
+		"branch":    original.BranchName(),
+		"ghostRepo": we.GhostRepo,
+	}).Info("deleting the original branch")
+	err = git.DeleteRemoteBranches(we.GhostDir, original.BranchName())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	result.Deleted = true
+	return nil
+}
@@ -0,0 +1,362 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"html/template"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ServeOptions represents arg for Serve func
+type ServeOptions struct {
+	types.WorkingEnvSpec
+	// Prefixes are prefixes of ghost branches which can be browsed, the first of which is listed by default
+	Prefixes []string
+	// Addr is an address to listen on like "127.0.0.1:8080"
+	Addr string
+	// Username and Password are required by Basic authentication if Username is not empty
+	Username string
+	Password string
+	// Token is required as a Bearer token if not empty
+	Token string
+	// OnListen is called with the address listened on if not nil
+	OnListen func(addr string)
+	// Stop stops serving when it is closed
+	Stop <-chan struct{}
+}
+
+// Serve serves a read-only HTTP gateway to browse ghost branches until options.Stop is closed
+//
+// It lists ghost branches at "/" and shows their contents at "/ghosts/<branch>" (as plain text at "/raw/<branch>") by List and Show,
+// and has no endpoints changing anything.
+func Serve(options ServeOptions) errors.GitGhostError {
+	log.WithFields(util.ToFields(options)).Debug("serve command with")
+	listener, err := net.Listen("tcp", options.Addr)
+	if err != nil {
+		return errors.WithCategory(errors.Errorf("failed to listen on %s: %s", options.Addr, err), errors.CategoryConfig)
+	}
+	server := &http.Server{Handler: NewGateway(options)}
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Serve(listener)
+	}()
+	log.WithFields(log.Fields{
+		"addr": listener.Addr().String(),
+	}).Info("serving ghost branches")
+	if options.OnListen != nil {
+		options.OnListen(listener.Addr().String())
+	}
+	select {
+	case err = <-done:
+		return errors.WithStack(err)
+	case <-options.Stop:
+	}
+	// requests in flight finish so that their working envs are cleaned
+	err = server.Shutdown(context.Background())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// NewGateway returns a handler of the read-only HTTP gateway served by Serve
+func NewGateway(options ServeOptions) http.Handler {
+	mux := http.NewServeMux()
+	g := gateway{options}
+	mux.HandleFunc("/", g.handleList)
+	mux.HandleFunc("/ghosts/", g.handleShow(false))
+	mux.HandleFunc("/raw/", g.handleShow(true))
+	return g.authenticate(mux)
+}
+
+type gateway struct {
+	ServeOptions
+}
+
+// authenticate requires requests to h to be authenticated by Basic or Bearer if either is configured, and to be read-only
+func (g gateway) authenticate(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "the gateway is read-only", http.StatusMethodNotAllowed)
+			return
+		}
+		if g.Username == "" && g.Token == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if user, password, ok := r.BasicAuth(); ok && g.Username != "" && secureEqual(user, g.Username) && secureEqual(password, g.Password) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if auth := r.Header.Get("Authorization"); g.Token != "" && strings.HasPrefix(auth, "Bearer ") && secureEqual(strings.TrimPrefix(auth, "Bearer "), g.Token) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if g.Username != "" {
+			w.Header().Add("WWW-Authenticate", `Basic realm="git-ghost"`)
+		}
+		if g.Token != "" {
+			w.Header().Add("WWW-Authenticate", `Bearer realm="git-ghost"`)
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	})
+}
+
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// serveError writes err with a status by its category
+func serveError(w http.ResponseWriter, err errors.GitGhostError) {
+	status := http.StatusInternalServerError
+	switch errors.CategoryOf(err) {
+	case errors.CategoryNotFound:
+		status = http.StatusNotFound
+	case errors.CategoryConfig:
+		status = http.StatusBadRequest
+	case errors.CategoryRemote:
+		status = http.StatusBadGateway
+	}
+	log.WithFields(log.Fields{
+		"status": status,
+	}).Warnf("request failed: %s", err)
+	http.Error(w, err.Error(), status)
+}
+
+// prefix returns the prefix of ghost branches requested by the "prefix" query, which must be one of g.Prefixes
+func (g gateway) prefix(r *http.Request) (string, errors.GitGhostError) {
+	prefix := r.URL.Query().Get("prefix")
+	if prefix == "" {
+		return g.Prefixes[0], nil
+	}
+	for _, p := range g.Prefixes {
+		if p == prefix {
+			return p, nil
+		}
+	}
+	return "", errors.WithCategory(errors.Errorf("prefix %s is not served", prefix), errors.CategoryNotFound)
+}
+
+// listedGhost is a ghost branch listed by the gateway
+type listedGhost struct {
+	Type   string
+	Branch string
+	From   string
+	To     string
+	Tags   []string
+}
+
+func (g gateway) handleList(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	prefix, err := g.prefix(r)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	query := r.URL.Query()
+	res, err := List(ListOptions{
+		WorkingEnvSpec:        g.WorkingEnvSpec,
+		ListCommitsBranchSpec: &types.ListCommitsBranchSpec{Prefix: prefix, HashFrom: query.Get("from"), HashTo: query.Get("to")},
+		ListDiffBranchSpec:    &types.ListDiffBranchSpec{Prefix: prefix, HashFrom: query.Get("from"), HashTo: query.Get("to")},
+	})
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	tags, err := ListTags(TagOptions{WorkingEnvSpec: g.WorkingEnvSpec, Prefix: prefix}, "")
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	if pattern := query.Get("tag"); pattern != "" {
+		tags, err = tags.Match(pattern)
+		if err != nil {
+			serveError(w, errors.WithCategory(err, errors.CategoryConfig))
+			return
+		}
+	}
+	tagged := map[string][]string{}
+	for _, tag := range tags {
+		if tag.Branch != nil {
+			tagged[tag.Branch.BranchName()] = append(tagged[tag.Branch.BranchName()], tag.Name)
+		}
+	}
+
+	ghosts := []listedGhost{}
+	for _, b := range *res.CommitsBranches {
+		ghosts = append(ghosts, listedGhost{Type: "commits", Branch: b.BranchName(), From: b.CommitHashFrom, To: b.CommitHashTo, Tags: tagged[b.BranchName()]})
+	}
+	for _, b := range *res.DiffBranches {
+		ghosts = append(ghosts, listedGhost{Type: "diff", Branch: b.BranchName(), From: b.CommitHashFrom, To: b.DiffHash, Tags: tagged[b.BranchName()]})
+	}
+	if query.Get("tag") != "" {
+		filtered := []listedGhost{}
+		for _, ghost := range ghosts {
+			if len(ghost.Tags) > 0 {
+				filtered = append(filtered, ghost)
+			}
+		}
+		ghosts = filtered
+	}
+	g.render(w, listTemplate, map[string]interface{}{
+		"Prefix":   prefix,
+		"Prefixes": g.Prefixes,
+		"Query":    query,
+		"Ghosts":   ghosts,
+	})
+}
+
+func (g gateway) handleShow(raw bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/ghosts/"), "/raw/")
+		branch := types.CreateGhostBranchByName(name)
+		if branch == nil || !g.serves(name) {
+			http.NotFound(w, r)
+			return
+		}
+		options := ShowOptions{WorkingEnvSpec: g.WorkingEnvSpec}
+		switch b := branch.(type) {
+		case *types.CommitsBranch:
+			options.CommitsBranchSpec = &types.CommitsBranchSpec{Prefix: b.Prefix, CommittishFrom: b.CommitHashFrom, CommittishTo: b.CommitHashTo}
+		case *types.DiffBranch:
+			options.PullableDiffBranchSpec = &types.PullableDiffBranchSpec{Prefix: b.Prefix, CommittishFrom: b.CommitHashFrom, DiffHash: b.DiffHash}
+		}
+		var buf bytes.Buffer
+		options.Writer = &buf
+		// the raw one is a patch as it is
+		options.Provenance = !raw
+		err := Show(options)
+		if err != nil {
+			serveError(w, err)
+			return
+		}
+		if raw {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			_, _ = w.Write(buf.Bytes())
+			return
+		}
+		g.render(w, showTemplate, map[string]interface{}{
+			"Branch": name,
+			"Lines":  highlightLines(buf.String()),
+		})
+	}
+}
+
+// serves returns whether a ghost branch of name is under one of g.Prefixes
+func (g gateway) serves(name string) bool {
+	for _, p := range g.Prefixes {
+		if strings.HasPrefix(name, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (g gateway) render(w http.ResponseWriter, t *template.Template, data interface{}) {
+	var buf bytes.Buffer
+	err := t.Execute(&buf, data)
+	if err != nil {
+		serveError(w, errors.WithStack(err))
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(buf.Bytes())
+}
+
+// highlightedLine is a line of a diff with a CSS class by its kind
+type highlightedLine struct {
+	Class string
+	Text  string
+}
+
+// highlightLines splits a diff into lines classified by their kinds to be highlighted
+func highlightLines(diff string) []highlightedLine {
+	lines := []highlightedLine{}
+	inHunk := false
+	for _, line := range strings.Split(strings.TrimRight(diff, "\n"), "\n") {
+		class := ""
+		switch {
+		case strings.HasPrefix(line, "diff --git "), strings.HasPrefix(line, "From "):
+			inHunk = false
+			class = "file"
+		case strings.HasPrefix(line, "@@"):
+			inHunk = true
+			class = "hunk"
+		case inHunk && strings.HasPrefix(line, "+"):
+			class = "add"
+		case inHunk && strings.HasPrefix(line, "-"):
+			class = "del"
+		case !inHunk || strings.HasPrefix(line, "\\"):
+			class = "meta"
+		}
+		lines = append(lines, highlightedLine{Class: class, Text: line})
+	}
+	return lines
+}
+
+const gatewayStyle = `<style>
+body { font-family: sans-serif; margin: 1em 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.2em 0.8em; text-align: left; border-bottom: 1px solid #ddd; }
+code, pre { font-family: monospace; }
+pre { line-height: 1.3; }
+.add { background: #e6ffed; color: #22863a; }
+.del { background: #ffeef0; color: #b31d28; }
+.hunk { background: #f1f8ff; color: #6f42c1; }
+.file { font-weight: bold; background: #fafbfc; }
+.meta { color: #6a737d; }
+.tag { background: #eee; border-radius: 3px; padding: 0 0.3em; margin-right: 0.3em; }
+</style>`
+
+var listTemplate = template.Must(template.New("list").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>git-ghost: {{.Prefix}}</title>` + gatewayStyle + `</head><body>
+<h1>Ghost branches of {{.Prefix}}</h1>
+<form method="get" action="/">
+<select name="prefix">{{range .Prefixes}}<option{{if eq . $.Prefix}} selected{{end}}>{{.}}</option>{{end}}</select>
+<input name="tag" placeholder="tag pattern, e.g. ci/*" value="{{.Query.Get "tag"}}">
+<input name="from" placeholder="from hash" value="{{.Query.Get "from"}}">
+<input name="to" placeholder="to hash" value="{{.Query.Get "to"}}">
+<button type="submit">Filter</button>
+</form>
+<table>
+<tr><th>Type</th><th>From</th><th>To</th><th>Tags</th></tr>
+{{range .Ghosts}}<tr><td>{{.Type}}</td><td><code>{{.From}}</code></td><td><a href="/ghosts/{{.Branch}}"><code>{{.To}}</code></a></td><td>{{range .Tags}}<span class="tag">{{.}}</span>{{end}}</td></tr>
+{{else}}<tr><td colspan="4">no ghost branches</td></tr>
+{{end}}</table>
+</body></html>
+`))
+
+var showTemplate = template.Must(template.New("show").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>git-ghost: {{.Branch}}</title>` + gatewayStyle + `</head><body>
+<p><a href="/">ghost branches</a> / <code>{{.Branch}}</code> (<a href="/raw/{{.Branch}}">raw</a>)</p>
+<pre>{{range .Lines}}<span class="{{.Class}}">{{.Text}}</span>
+{{end}}</pre>
+</body></html>
+`))
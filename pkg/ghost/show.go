@@ -15,7 +15,10 @@
 package ghost
 
 import (
+	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
 
 	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
 	"github.com/pfnet-research/git-ghost/pkg/util"
@@ -37,27 +40,86 @@ type ShowOptions struct {
 	// ````
 	// Then, you can read the output from `r` and transform them as you like.
 	Writer io.Writer
+	// Provenance writes where ghost branches come from before their contents
+	Provenance bool
+	// OutputDir is a directory to write files touched by ghost branches into as they are after applying if not empty.
+	// Paths of written files are written to Writer instead of the contents. It must be empty or not exist.
+	OutputDir string
+	// Combined writes ghost branches as a single combined patch with a manifest of their sections in the order to apply,
+	// which is applied by ApplyCombined
+	Combined bool
 }
 
-func pullAndshow(branchSpec types.PullableGhostBranchSpec, we types.WorkingEnv, writer io.Writer) errors.GitGhostError {
+func pullAndshow(branchSpec types.PullableGhostBranchSpec, we types.WorkingEnv, writer io.Writer, provenance bool, outputDir string) errors.GitGhostError {
 	branch, err := branchSpec.PullBranch(we)
 	if err != nil {
 		return err
 	}
+	if outputDir != "" {
+		paths, err := types.ExtractFiles(we, branch, outputDir)
+		if err != nil {
+			return err
+		}
+		for _, p := range paths {
+			_, ioerr := fmt.Fprintln(writer, p)
+			if ioerr != nil {
+				return errors.WithStack(ioerr)
+			}
+		}
+		return nil
+	}
+	if provenance {
+		err := writeProvenance(branch, we, writer)
+		if err != nil {
+			return err
+		}
+	}
 	return branch.Show(we, writer)
 }
 
+func writeProvenance(branch types.GhostBranch, we types.WorkingEnv, writer io.Writer) errors.GitGhostError {
+	provenance, err := types.GetProvenance(we.GhostDir, "HEAD", branch)
+	if err != nil {
+		return err
+	}
+	extra := ""
+	if provenance.SourceRepo != "" {
+		extra = fmt.Sprintf("Source-Repo: %s\n", provenance.SourceRepo)
+	}
+	if provenance.Tree != "" {
+		extra += fmt.Sprintf("Tree: %s\n", provenance.Tree)
+	}
+	if provenance.CI != nil {
+		extra += provenance.CI.PrettyString()
+	}
+	extra += provenance.Metadata.PrettyString()
+	_, ioerr := fmt.Fprintf(writer, "Ghost-Branch: %s\nFormat-Version: %d\nPushed-By: %s\nPushed-At: %s\n%s\n",
+		provenance.Branch, provenance.FormatVersion, provenance.PushedBy, provenance.PushedAt, extra)
+	return errors.WithStack(ioerr)
+}
+
 // Show writes ghost branches contents to option.Writer
 func Show(options ShowOptions) errors.GitGhostError {
 	log.WithFields(util.ToFields(options)).Debug("pull command with")
 
+	if options.OutputDir != "" {
+		err := prepareOutputDir(options.OutputDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	if options.Combined {
+		return showCombined(options)
+	}
+
 	if options.CommitsBranchSpec != nil {
 		we, err := options.WorkingEnvSpec.Initialize()
 		if err != nil {
 			return err
 		}
 		defer util.LogDeferredGitGhostError(we.Clean)
-		err = pullAndshow(options.CommitsBranchSpec, *we, options.Writer)
+		err = pullAndshow(options.CommitsBranchSpec, *we, options.Writer, options.Provenance, options.OutputDir)
 		if err != nil {
 			return err
 		}
@@ -69,9 +131,76 @@ func Show(options ShowOptions) errors.GitGhostError {
 			return err
 		}
 		defer util.LogDeferredGitGhostError(we.Clean)
-		return pullAndshow(options.PullableDiffBranchSpec, *we, options.Writer)
+		return pullAndshow(options.PullableDiffBranchSpec, *we, options.Writer, options.Provenance, options.OutputDir)
 	}
 
 	log.WithFields(util.ToFields(options)).Warn("show command has nothing to do with")
 	return nil
 }
+
+// showCombined writes sections of the commits and then the diff in options as a combined patch
+func showCombined(options ShowOptions) errors.GitGhostError {
+	specs := []types.PullableGhostBranchSpec{}
+	if options.CommitsBranchSpec != nil {
+		specs = append(specs, *options.CommitsBranchSpec)
+	}
+	if options.PullableDiffBranchSpec != nil {
+		specs = append(specs, *options.PullableDiffBranchSpec)
+	}
+	sections := []types.CombinedSection{}
+	defer func() { types.RemoveCombinedSections(sections) }()
+	for _, spec := range specs {
+		we, err := options.WorkingEnvSpec.Initialize()
+		if err != nil {
+			return err
+		}
+		defer util.LogDeferredGitGhostError(we.Clean)
+		branch, err := spec.PullBranch(*we)
+		if err != nil {
+			return err
+		}
+		s, err := types.CombinedSections(*we, branch)
+		if err != nil {
+			return err
+		}
+		sections = append(sections, s...)
+	}
+	return types.WriteCombined(options.Writer, sections)
+}
+
+// ApplyCombinedOptions represents arg for ApplyCombined func
+type ApplyCombinedOptions struct {
+	SrcDir string
+	// Reader is a combined patch written by Show with Combined
+	Reader io.Reader
+}
+
+// ApplyCombined applies sections of a combined patch on the source directory in the order of its manifest
+//
+// The whole patch is read into temporary files and checked before applying anything, so a truncated or broken one changes nothing.
+func ApplyCombined(options ApplyCombinedOptions) errors.GitGhostError {
+	log.WithFields(log.Fields{
+		"srcDir": options.SrcDir,
+	}).Debug("apply-combined command with")
+	sections, err := types.ReadCombined(options.Reader)
+	if err != nil {
+		return err
+	}
+	defer types.RemoveCombinedSections(sections)
+	return types.ApplyCombined(options.SrcDir, sections)
+}
+
+// prepareOutputDir creates dir if it doesn't exist, and checks it is empty otherwise so that no existing file is overwritten
+func prepareOutputDir(dir string) errors.GitGhostError {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return errors.WithStack(os.MkdirAll(dir, 0755))
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if len(entries) > 0 {
+		return errors.WithCategory(errors.Errorf("output directory %s is not empty", dir), errors.CategoryConfig)
+	}
+	return nil
+}
@@ -0,0 +1,389 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TagOptions represents arg for tag funcs
+type TagOptions struct {
+	types.WorkingEnvSpec
+	Prefix string
+}
+
+// Tag represents a human-friendly name of a ghost branch
+type Tag struct {
+	Name string
+	// Branch is a ghost branch which the tag points to, which is nil if the branch was deleted
+	Branch types.GhostBranch
+	commit string
+}
+
+// Tags is an alias for []Tag
+type Tags []Tag
+
+// tagNamePattern allows tags to be grouped by slashes like "ci/nightly"
+var tagNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*(/[A-Za-z0-9][A-Za-z0-9._-]*)*$`)
+
+func tagRef(prefix, name string) string {
+	return fmt.Sprintf("refs/tags/%s/tag/%s", prefix, name)
+}
+
+func validateTagName(name string) errors.GitGhostError {
+	if !tagNamePattern.MatchString(name) {
+		return errors.Errorf("invalid tag name: %s (allowed pattern: %s)", name, tagNamePattern.String())
+	}
+	return nil
+}
+
+// AddTag adds a tag to a ghost branch specified by its diff hash, its local base commit hash or its branch name
+//
+// It fails with errors.CategoryExists if the tag already exists, even when it is added concurrently,
+// so that a tag can be used as a lock.
+func AddTag(options TagOptions, hash, name string) (*Tag, errors.GitGhostError) {
+	log.WithFields(util.ToFields(options)).Debug("tag add command with")
+	return addTag(options, hash, name, false)
+}
+
+// SetTag adds a tag to a ghost branch like AddTag, moving the tag if it already exists
+func SetTag(options TagOptions, hash, name string) (*Tag, errors.GitGhostError) {
+	return addTag(options, hash, name, true)
+}
+
+func addTag(options TagOptions, hash, name string, move bool) (*Tag, errors.GitGhostError) {
+	err := validateTagName(name)
+	if err != nil {
+		return nil, err
+	}
+	if !move {
+		tags, err := ListTags(options, "")
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range tags {
+			if tag.Name == name {
+				return nil, errors.WithCategory(errors.Errorf("tag %s already exists", name), errors.CategoryExists)
+			}
+		}
+	}
+	found, commit, err := findGhostBranch(options.GhostRepo, options.Prefix, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	we, err := options.WorkingEnvSpec.Initialize()
+	if err != nil {
+		return nil, err
+	}
+	defer util.LogDeferredGitGhostError(we.Clean)
+	err = git.FetchBranches(we.GhostDir, found.BranchName())
+	if err != nil {
+		return nil, err
+	}
+	refspec := fmt.Sprintf("%s:%s", commit, tagRef(options.Prefix, name))
+	if move {
+		err = git.Push(we.GhostDir, "+"+refspec)
+	} else {
+		// the tag may be added by another push after listing tags above, and only one of them wins
+		err = git.PushIfAbsent(we.GhostDir, refspec)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Tag{Name: name, Branch: found, commit: commit}, nil
+}
+
+// ListTags returns tags in ghost repo sorted by their names
+//
+// If hash is not empty, only tags of the ghost branch matching it are returned.
+func ListTags(options TagOptions, hash string) (Tags, errors.GitGhostError) {
+	log.WithFields(util.ToFields(options)).Debug("tag list command with")
+
+	refs, err := git.ListRemoteRefHashes(options.GhostRepo, tagRef(options.Prefix, "*"))
+	if err != nil {
+		return nil, err
+	}
+	if len(refs) == 0 {
+		return Tags{}, nil
+	}
+	heads, err := git.ListRemoteRefHashes(options.GhostRepo, fmt.Sprintf("refs/heads/%s/*", options.Prefix))
+	if err != nil {
+		return nil, err
+	}
+	branches := map[string]types.GhostBranch{}
+	for ref, commit := range heads {
+		branch := types.CreateGhostBranchByName(strings.TrimPrefix(ref, "refs/heads/"))
+		if branch != nil {
+			branches[commit] = branch
+		}
+	}
+
+	tags := Tags{}
+	refPrefix := tagRef(options.Prefix, "")
+	for ref, commit := range refs {
+		tag := Tag{
+			Name:   strings.TrimPrefix(ref, refPrefix),
+			Branch: branches[commit],
+			commit: commit,
+		}
+		if hash != "" && (tag.Branch == nil || !matchesHash(tag.Branch, hash)) {
+			continue
+		}
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+	return tags, nil
+}
+
+// RemoveTags removes tags from ghost repo and returns removed ones
+//
+// Ghost branches which the tags point to are kept unless deleteGhosts is true.
+func RemoveTags(options TagOptions, names []string, deleteGhosts bool) (Tags, errors.GitGhostError) {
+	log.WithFields(util.ToFields(options)).Debug("tag rm command with")
+
+	tags, err := ListTags(options, "")
+	if err != nil {
+		return nil, err
+	}
+	existing := map[string]Tag{}
+	for _, tag := range tags {
+		existing[tag.Name] = tag
+	}
+	removed := Tags{}
+	refspecs := []string{}
+	for _, name := range names {
+		tag, ok := existing[name]
+		if !ok {
+			return nil, errors.WithCategory(errors.Errorf("tag %s is not found", name), errors.CategoryNotFound)
+		}
+		removed = append(removed, tag)
+		refspecs = append(refspecs, ":"+tagRef(options.Prefix, name))
+		if deleteGhosts && tag.Branch != nil {
+			refspecs = append(refspecs, ":refs/heads/"+tag.Branch.BranchName())
+		}
+	}
+	if len(refspecs) == 0 {
+		return removed, nil
+	}
+
+	we, err := options.WorkingEnvSpec.Initialize()
+	if err != nil {
+		return nil, err
+	}
+	defer util.LogDeferredGitGhostError(we.Clean)
+	err = git.Push(we.GhostDir, util.UniqueStringSlice(refspecs)...)
+	if deleteGhosts {
+		// deleting ghosts is audited as delete does
+		branches := []types.GhostBranch{}
+		if err == nil {
+			for _, tag := range removed {
+				if tag.Branch != nil {
+					branches = append(branches, tag.Branch)
+				}
+			}
+		}
+		writeAuditRecord("delete", options.WorkingEnvSpec, branches, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// RenameTag renames a tag in ghost repo
+func RenameTag(options TagOptions, oldName, newName string) (*Tag, errors.GitGhostError) {
+	log.WithFields(util.ToFields(options)).Debug("tag rename command with")
+
+	err := validateTagName(newName)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := ListTags(options, "")
+	if err != nil {
+		return nil, err
+	}
+	var renamed *Tag
+	for _, tag := range tags {
+		if tag.Name == newName {
+			return nil, errors.Errorf("tag %s already exists", newName)
+		}
+		if tag.Name == oldName {
+			renamed = &Tag{Name: newName, Branch: tag.Branch, commit: tag.commit}
+		}
+	}
+	if renamed == nil {
+		return nil, errors.WithCategory(errors.Errorf("tag %s is not found", oldName), errors.CategoryNotFound)
+	}
+
+	we, err := options.WorkingEnvSpec.Initialize()
+	if err != nil {
+		return nil, err
+	}
+	defer util.LogDeferredGitGhostError(we.Clean)
+	oldRef := tagRef(options.Prefix, oldName)
+	err = git.FetchRefs(we.GhostDir, fmt.Sprintf("+%s:%s", oldRef, oldRef))
+	if err != nil {
+		return nil, err
+	}
+	err = git.Push(we.GhostDir,
+		fmt.Sprintf("%s:%s", renamed.commit, tagRef(options.Prefix, newName)),
+		":"+tagRef(options.Prefix, oldName),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return renamed, nil
+}
+
+// LatestTag returns the tag matching a glob pattern whose ghost branch was created most recently
+//
+// Ghost branches are compared by the committer dates of their ghost commits, and tags of deleted ones are ignored.
+func LatestTag(options TagOptions, pattern string) (*Tag, errors.GitGhostError) {
+	log.WithFields(util.ToFields(options)).Debug("latest tag with")
+
+	tags, err := ListTags(options, "")
+	if err != nil {
+		return nil, err
+	}
+	tags, err = tags.Match(pattern)
+	if err != nil {
+		return nil, err
+	}
+	matched := Tags{}
+	refspecs := []string{}
+	for _, tag := range tags {
+		if tag.Branch == nil {
+			log.Debugf("tag %s is ignored because its ghost branch was deleted", tag.Name)
+			continue
+		}
+		matched = append(matched, tag)
+		ref := tagRef(options.Prefix, tag.Name)
+		refspecs = append(refspecs, fmt.Sprintf("+%s:%s", ref, ref))
+	}
+	if len(matched) == 0 {
+		return nil, errors.WithCategory(errors.Errorf("no tag of an existing ghost branch matches %s", pattern), errors.CategoryNotFound)
+	}
+
+	we, err := options.WorkingEnvSpec.Initialize()
+	if err != nil {
+		return nil, err
+	}
+	defer util.LogDeferredGitGhostError(we.Clean)
+	err = git.FetchRefs(we.GhostDir, refspecs...)
+	if err != nil {
+		return nil, err
+	}
+	commits := []string{}
+	for _, tag := range matched {
+		commits = append(commits, tag.commit)
+	}
+	timestamps, err := git.GetCommitTimestamps(we.GhostDir, commits...)
+	if err != nil {
+		return nil, err
+	}
+	// tags are sorted by their names, so the first one wins among ghost branches created at the same second
+	latest := matched[0]
+	for _, tag := range matched[1:] {
+		if timestamps[tag.commit] > timestamps[latest.commit] {
+			latest = tag
+		}
+	}
+	if len(matched) > 1 {
+		log.Infof("%d tags match %s and %s is chosen as the latest", len(matched), pattern, latest.Name)
+	}
+	return &latest, nil
+}
+
+// Match returns tags whose names match a glob pattern like path.Match, so "*" doesn't match "/"
+func (tags Tags) Match(pattern string) (Tags, errors.GitGhostError) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, errors.Errorf("invalid tag pattern: %s", pattern)
+	}
+	matched := Tags{}
+	for _, tag := range tags {
+		if ok, _ := path.Match(pattern, tag.Name); ok {
+			matched = append(matched, tag)
+		}
+	}
+	return matched, nil
+}
+
+// findGhostBranch finds a ghost branch under prefix in repo by its diff hash, its local base commit hash or its branch name,
+// and returns it together with the commit it points to
+func findGhostBranch(repo, prefix, hash string) (types.GhostBranch, string, errors.GitGhostError) {
+	heads, err := git.ListRemoteRefHashes(repo, fmt.Sprintf("refs/heads/%s/*", prefix))
+	if err != nil {
+		return nil, "", err
+	}
+	var found types.GhostBranch
+	var commit string
+	for ref, c := range heads {
+		branch := types.CreateGhostBranchByName(strings.TrimPrefix(ref, "refs/heads/"))
+		if branch == nil || !matchesHash(branch, hash) {
+			continue
+		}
+		if found != nil {
+			return nil, "", errors.Errorf("%s is ambiguous: %s, %s", hash, found.BranchName(), branch.BranchName())
+		}
+		found = branch
+		commit = c
+	}
+	if found == nil {
+		return nil, "", errors.WithCategory(errors.Errorf("no ghost branch is found for %s", hash), errors.CategoryNotFound)
+	}
+	return found, commit, nil
+}
+
+func matchesHash(branch types.GhostBranch, hash string) bool {
+	if branch.BranchName() == hash {
+		return true
+	}
+	switch b := branch.(type) {
+	case *types.CommitsBranch:
+		return b.CommitHashTo == hash
+	case *types.DiffBranch:
+		return b.DiffHash == hash
+	}
+	return false
+}
+
+// PrettyString pretty prints Tags
+func (tags Tags) PrettyString(headers bool) string {
+	var buffer bytes.Buffer
+	if headers {
+		buffer.WriteString(fmt.Sprintf("%-20s %s\n", "Tag", "Ghost Branch"))
+	}
+	for _, tag := range tags {
+		branchName := "(deleted)"
+		if tag.Branch != nil {
+			branchName = tag.Branch.BranchName()
+		}
+		buffer.WriteString(fmt.Sprintf("%-20s %s\n", tag.Name, branchName))
+	}
+	return buffer.String()
+}
@@ -0,0 +1,118 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// Binary files of a diff with attachments are stored as blobs in "<file name>.attachments/" next to the diff,
+// where "index.json" lists sections of the diff for them to be restored on extracting the diff.
+const attachmentsSuffix = ".attachments"
+
+const attachmentsIndexName = "index.json"
+
+// splitAttachments moves sections for binary files out of a diff patch file created on srcDir if required by the spec
+func (bs DiffBranchSpec) splitAttachments(srcDir, patchFile string) ([]git.BinaryPatchSection, errors.GitGhostError) {
+	if !bs.BinaryAttachments {
+		return []git.BinaryPatchSection{}, nil
+	}
+	return git.SplitBinaryPatchFile(srcDir, patchFile)
+}
+
+// storeAttachments writes blobs of binary files on srcDir and their sections next to a ghost file stored by storeGhostFile if any
+func storeAttachments(srcDir, dstDir, fileName string, attachments []git.BinaryPatchSection) errors.GitGhostError {
+	if len(attachments) == 0 {
+		return nil
+	}
+	dir := filepath.Join(dstDir, fileName+attachmentsSuffix)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, a := range attachments {
+		if a.Deleted() {
+			continue
+		}
+		content, ggerr := git.ReadBlob(srcDir, a.Blob)
+		if ggerr != nil {
+			return ggerr
+		}
+		err := ioutil.WriteFile(filepath.Join(dir, a.Blob), content, 0600)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	index, err := json.MarshalIndent(attachments, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(ioutil.WriteFile(filepath.Join(dir, attachmentsIndexName), append(index, '\n'), 0600))
+}
+
+func attachmentsIndexPath(fileName string) string {
+	return fileName + attachmentsSuffix + "/" + attachmentsIndexName
+}
+
+// hasAttachments checks a ghost file at committish on ghostDir has attachments or not
+func hasAttachments(ghostDir, committish, fileName string) (bool, errors.GitGhostError) {
+	return git.FileExistsAt(ghostDir, committish, attachmentsIndexPath(fileName))
+}
+
+// appendAttachments appends sections for binary files attached to a ghost file at committish on ghostDir to dstPath
+//
+// It restores the whole diff which the sections are moved out of by splitAttachments.
+func appendAttachments(ghostDir, committish, fileName, dstPath string) errors.GitGhostError {
+	attached, ggerr := hasAttachments(ghostDir, committish, fileName)
+	if ggerr != nil || !attached {
+		return ggerr
+	}
+	index, ggerr := git.ReadBlob(ghostDir, committish+":"+attachmentsIndexPath(fileName))
+	if ggerr != nil {
+		return ggerr
+	}
+	attachments := []git.BinaryPatchSection{}
+	err := json.Unmarshal(index, &attachments)
+	if err != nil {
+		return errors.Errorf("invalid attachments of %s: %s", fileName, err)
+	}
+	f, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer util.LogDeferredError(f.Close)
+	for _, a := range attachments {
+		blob := []byte{}
+		if !a.Deleted() {
+			// attached blobs have the same hashes on ghostDir
+			blob, ggerr = git.ReadBlob(ghostDir, a.Blob)
+			if ggerr != nil {
+				return ggerr
+			}
+		}
+		ggerr = git.WriteBinaryPatchSection(f, a, blob)
+		if ggerr != nil {
+			return ggerr
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// BackupDir is a directory in the git dir of a source directory where files are backed up before applying ghosts
+const BackupDir = "git-ghost-backup"
+
+// withBackup backs up files in srcDir which patches touch, calls f and discards the backup if f succeeds and keep is false
+func withBackup(srcDir string, patches []string, pathOpts git.PatchPathOptions, keep bool, f func() errors.GitGhostError) errors.GitGhostError {
+	paths := []string{}
+	for _, p := range patches {
+		touched, err := git.ListPatchPaths(srcDir, p, pathOpts)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, touched...)
+	}
+	paths = util.UniqueStringSlice(paths)
+	sort.Strings(paths)
+
+	backupDir, err := backupFiles(srcDir, paths)
+	if err != nil {
+		return err
+	}
+	if backupDir == "" {
+		return f()
+	}
+
+	applyErr := f()
+	if applyErr == nil && !keep {
+		log.WithFields(log.Fields{
+			"backupDir": backupDir,
+		}).Info("discarding backup")
+		return errors.WithStack(os.RemoveAll(backupDir))
+	}
+	if applyErr != nil {
+		log.WithFields(log.Fields{
+			"backupDir": backupDir,
+		}).Error("failed to apply the ghost. files touched by it are backed up")
+		return applyErr
+	}
+	log.WithFields(log.Fields{
+		"backupDir": backupDir,
+	}).Warn("files touched by the ghost are backed up")
+	return nil
+}
+
+// backupFiles copies files of paths in srcDir into a new timestamped directory under BackupDir and returns it
+//
+// Paths which don't exist in srcDir are skipped. It returns an empty string if no file is backed up.
+func backupFiles(srcDir string, paths []string) (string, errors.GitGhostError) {
+	root, ggerr := git.ResolveGitPath(srcDir, BackupDir)
+	if ggerr != nil {
+		return "", ggerr
+	}
+	backupDir := filepath.Join(root, time.Now().Format("20060102-150405.000000000"))
+
+	backedUp := 0
+	for _, p := range paths {
+		src := filepath.Join(srcDir, p)
+		fi, err := os.Lstat(src)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		dst := filepath.Join(backupDir, p)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return "", errors.WithStack(err)
+		}
+		switch {
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(src)
+			if err != nil {
+				return "", errors.WithStack(err)
+			}
+			if err := os.Symlink(target, dst); err != nil {
+				return "", errors.WithStack(err)
+			}
+		case fi.Mode().IsRegular():
+			if ggerr := copyFile(src, dst, fi.Mode().Perm()); ggerr != nil {
+				return "", ggerr
+			}
+		default:
+			continue
+		}
+		backedUp++
+	}
+	if backedUp == 0 {
+		return "", nil
+	}
+	log.WithFields(log.Fields{
+		"backupDir": backupDir,
+		"files":     backedUp,
+	}).Info("backed up files touched by the ghost")
+	return backupDir, nil
+}
+
+func copyFile(src, dst string, perm os.FileMode) errors.GitGhostError {
+	in, err := os.Open(src)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer util.LogDeferredError(in.Close)
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = io.Copy(out, in)
+	if err != nil {
+		util.LogDeferredError(out.Close)
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(out.Close())
+}
@@ -17,11 +17,13 @@ package types
 import (
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
-	"path"
+	"path/filepath"
 	"reflect"
 	"regexp"
 	"sort"
+	"strings"
 
 	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
 	"github.com/pfnet-research/git-ghost/pkg/util"
@@ -41,7 +43,90 @@ type GhostBranch interface {
 	// Show writes contents of this ghost branch on passed working env to writer
 	Show(we WorkingEnv, writer io.Writer) errors.GitGhostError
 	// Apply applies contents(diff or patch) of this ghost branch on passed working env
-	Apply(we WorkingEnv) errors.GitGhostError
+	Apply(we WorkingEnv, opts ApplyOptions) errors.GitGhostError
+}
+
+// ApplyOptions represents options to apply a ghost branch
+type ApplyOptions struct {
+	// Force aborts 'git am' or 'git rebase' which is left in progress on the source directory before applying,
+	// and applies a ghost refused by StrictSourceRepo
+	Force bool
+	// Commit creates a commit of an applied diff if not nil. It has no effect on commits branches.
+	Commit *CommitOptions
+	// Backup copies files which are touched by applying into BackupDir beforehand
+	Backup bool
+	// KeepBackup keeps the backup even if applying succeeds
+	KeepBackup bool
+	// Directory is a subdirectory of the source directory to apply into if not empty. It is not supported for bundles.
+	Directory string
+	// Strip is the number of leading components removed from paths in patches (default to 1). It is not supported for bundles.
+	Strip int
+	// Paths limits applying a diff to files at or under the paths (relative to the top of the repo as in the ghost) if not empty.
+	// It is not supported for commits branches, which are applied entirely.
+	Paths []string
+	// Reject applies hunks of a diff which can be applied and leaves the others in *.rej files. It has no effect on commits branches.
+	Reject bool
+	// UntrackedCollision resolves untracked files in the way of files which a diff creates by one of CollisionPolicies before applying if not empty,
+	// which make applying fail otherwise. Resolved files are logged and recorded in Report. It has no effect on commits branches.
+	UntrackedCollision string
+	// Fuzz falls back on 'patch' ignoring up to this number of lines of context of each hunk when 'git apply' refuses a diff if positive,
+	// which may apply hunks to wrong places. Hunks applied with fuzz are logged and recorded in Report. It has no effect on commits branches.
+	Fuzz int
+	// Recover escalates applying which fails: commits are retried by 'git am --3way', and a diff of commits or a diff branch
+	// is applied by 'git apply --reject' at last to salvage hunks which can be applied. It has no effect on bundles.
+	Recover bool
+	// Rerere retries commits which 'git am' refuses by 'git am --3way' with rerere enabled, continuing it while recorded resolutions
+	// resolve all the conflicts. Paths resolved by them are logged and recorded in Report. It has no effect on bundles and diff branches.
+	Rerere bool
+	// Resume skips changes of a ghost which are already applied, e.g. by a pull interrupted partway: commits whose patch ids are
+	// already in the history of HEAD, and files of a diff which can be applied in reverse. 'git am' left in progress is quit keeping
+	// commits applied by it. It has no effect on bundles.
+	Resume bool
+	// StrategyOption is a merge strategy option ("ours" or "theirs") to cherry-pick commits with when 'git am' conflicts if not empty.
+	// Cherry-picked commits get hashes different from the ones in the ghost. It has no effect on diff branches.
+	StrategyOption string
+	// Trailers appends trailers of where a ghost comes from (its branch, base, source repo, pusher and git-ghost version) to messages of
+	// applied commits, so that they can be traced from the commit log. It has no effect on bundles and diff branches.
+	Trailers bool
+	// FFOnly refuses commits which would not fast-forward HEAD, i.e. unless HEAD is their base or an ancestor of it.
+	// HEAD behind the base is fast-forwarded to it before applying patches. It has no effect on diff branches.
+	FFOnly bool
+	// StrictSourceRepo refuses a ghost created in a repo which is neither the source directory nor one of its remotes
+	// unless Force is set. Such a ghost is only warned about otherwise.
+	StrictSourceRepo bool
+	// Report records what applying did if not nil
+	Report *ApplyReport
+}
+
+func (opts ApplyOptions) patchPathOptions() git.PatchPathOptions {
+	return git.PatchPathOptions{
+		Directory: opts.Directory,
+		Strip:     opts.Strip,
+	}
+}
+
+// validateDirectory checks Directory is an existing directory in srcDir
+func (opts ApplyOptions) validateDirectory(srcDir string) errors.GitGhostError {
+	if opts.Directory == "" {
+		return nil
+	}
+	cleaned := filepath.Clean(opts.Directory)
+	if filepath.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return errors.Errorf("directory must be a relative path in %s (value: %s)", srcDir, opts.Directory)
+	}
+	fi, err := os.Stat(filepath.Join(srcDir, cleaned))
+	if err != nil || !fi.IsDir() {
+		return errors.WithCategory(errors.Errorf("directory %s is not found in %s", opts.Directory, srcDir), errors.CategoryNotFound)
+	}
+	return nil
+}
+
+// CommitOptions represents options to commit an applied diff
+type CommitOptions struct {
+	// Message is a commit message
+	Message string
+	// Author overrides the author (in the form of "Name <email>") if not empty
+	Author string
 }
 
 // interface assetions
@@ -55,6 +140,12 @@ type CommitsBranch struct {
 	Prefix         string
 	CommitHashFrom string
 	CommitHashTo   string
+	// Stats is statistics of its patch, which is only available on a created branch
+	Stats *git.PatchStats
+	// PatchID is a stable patch id of its patch, which is only available on a branch created with DedupByPatchID
+	PatchID string
+	// Bundle is true if the commits are stored as a git bundle instead of patches
+	Bundle bool
 }
 
 // DiffBranch represents a local mod branch
@@ -69,6 +160,8 @@ type DiffBranch struct {
 	CommitHashFrom string
 	// DiffHash is a hash value of its diff
 	DiffHash string
+	// Stats is statistics of its diff, which is only available on a created branch
+	Stats *git.PatchStats
 }
 
 // CommitsBranches is an alias for []CommitsBranch
@@ -82,17 +175,27 @@ var diffBranchNamePattern = regexp.MustCompile(`^([a-z0-9]+)/([a-f0-9]+)/([a-f0-
 
 // BranchName returns its full branch name on git repository
 func (b CommitsBranch) BranchName() string {
-	return fmt.Sprintf("%s/%s-%s", b.Prefix, b.CommitHashFrom, b.CommitHashTo)
+	return commitsBranchName(b.Prefix, b.CommitHashFrom, b.CommitHashTo)
 }
 
 // FileName returns a file name containing this GhostBranch
 func (b CommitsBranch) FileName() string {
+	if b.Bundle {
+		return commitsBundleFileName
+	}
 	return "commits.patch"
 }
 
+const commitsBundleFileName = "commits.bundle"
+
+// PatchIDTagName returns a tag name which points to this branch's commit by its patch id
+func (b CommitsBranch) PatchIDTagName() string {
+	return fmt.Sprintf("%s/patch-id/%s", b.Prefix, b.PatchID)
+}
+
 // BranchName returns its full branch name on git repository
 func (b DiffBranch) BranchName() string {
-	return fmt.Sprintf("%s/%s/%s", b.Prefix, b.CommitHashFrom, b.DiffHash)
+	return diffBranchName(b.Prefix, b.CommitHashFrom, b.DiffHash)
 }
 
 // FileName returns a file name containing this GhostBranch
@@ -100,8 +203,33 @@ func (b DiffBranch) FileName() string {
 	return "local-mod.patch"
 }
 
+// WriteResultTree writes a tree object which applying this ghost branch on CommitHashFrom results in to the source directory and returns its hash
+//
+// This ghost branch must be pulled on passed working env beforehand.
+func (b DiffBranch) WriteResultTree(we WorkingEnv) (string, errors.GitGhostError) {
+	patches, ggerr := extractPatchChain(we.GhostDir, "HEAD", b.FileName())
+	defer removeFiles(patches)
+	if ggerr != nil {
+		return "", ggerr
+	}
+	return git.WritePatchedTree(we.SrcDir, b.CommitHashFrom, patches)
+}
+
 // CreateGhostBranchByName instantiates GhostBranch object from branchname
+//
+// It returns nil if branchName doesn't follow the branch name scheme.
 func CreateGhostBranchByName(branchName string) GhostBranch {
+	if branchNameScheme != nil {
+		prefix, typ, base, hash, ok := branchNameScheme.parse(branchName)
+		switch {
+		case !ok:
+			return nil
+		case typ == schemeTypeCommits:
+			return &CommitsBranch{Prefix: prefix, CommitHashFrom: base, CommitHashTo: hash}
+		default:
+			return &DiffBranch{Prefix: prefix, CommitHashFrom: base, DiffHash: hash}
+		}
+	}
 	m := commitsBranchNamePattern.FindStringSubmatch(branchName)
 	if len(m) > 0 {
 		return &CommitsBranch{
@@ -156,12 +284,42 @@ func (branches DiffBranches) AsGhostBranches() []GhostBranch {
 }
 
 func show(ghost GhostBranch, we WorkingEnv, writer io.Writer) errors.GitGhostError {
-	cmd := exec.Command("git", "-C", we.GhostDir, "--no-pager", "cat-file", "-p", fmt.Sprintf("HEAD:%s", ghost.FileName()))
-	cmd.Stdout = writer
-	return util.JustRunCmd(cmd)
+	split, ggerr := git.FileExistsAt(we.GhostDir, "HEAD", ghost.FileName()+partsManifestSuffix)
+	if ggerr != nil {
+		return ggerr
+	}
+	attached, ggerr := hasAttachments(we.GhostDir, "HEAD", ghost.FileName())
+	if ggerr != nil {
+		return ggerr
+	}
+	piped, ggerr := isPiped(we.GhostDir, "HEAD", ghost.FileName())
+	if ggerr != nil {
+		return ggerr
+	}
+	compressed, ggerr := isCompressed(we.GhostDir, "HEAD", ghost.FileName())
+	if ggerr != nil {
+		return ggerr
+	}
+	if !split && !attached && !piped && !compressed {
+		cmd := exec.Command("git", "-C", we.GhostDir, "--no-pager", "cat-file", "-p", fmt.Sprintf("HEAD:%s", ghost.FileName()))
+		cmd.Stdout = writer
+		return util.JustRunCmd(cmd)
+	}
+	patch, ggerr := extractGhostFileToTemp(we.GhostDir, "HEAD", ghost.FileName())
+	defer removeFiles([]string{patch})
+	if ggerr != nil {
+		return ggerr
+	}
+	f, err := os.Open(patch)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer util.LogDeferredError(f.Close)
+	_, err = io.Copy(writer, f)
+	return errors.WithStack(err)
 }
 
-func apply(ghost GhostBranch, we WorkingEnv, expectedSrcHead string) errors.GitGhostError {
+func apply(ghost GhostBranch, we WorkingEnv, opts ApplyOptions, expectedSrcHead string) errors.GitGhostError {
 	log.WithFields(util.MergeFields(
 		util.ToFields(ghost),
 		log.Fields{
@@ -171,6 +329,21 @@ func apply(ghost GhostBranch, we WorkingEnv, expectedSrcHead string) errors.GitG
 		},
 	)).Info("applying ghost branch")
 
+	err := ensureNoOperationInProgress(we.SrcDir, opts.Force, opts.Resume)
+	if err != nil {
+		return err
+	}
+
+	err = checkSourceRepo(ghost, we, opts)
+	if err != nil {
+		return err
+	}
+
+	err = opts.validateDirectory(we.SrcDir)
+	if err != nil {
+		return err
+	}
+
 	srcHead, err := git.ResolveCommittish(we.SrcDir, "HEAD")
 	if err != nil {
 		return err
@@ -192,21 +365,363 @@ func apply(ghost GhostBranch, we WorkingEnv, expectedSrcHead string) errors.GitG
 	// TODO make this instance methods.
 	switch ghost.(type) {
 	case CommitsBranch:
-		return git.ApplyDiffBundleFile(we.SrcDir, path.Join(we.GhostDir, ghost.FileName()))
+		if len(opts.Paths) > 0 {
+			return errors.New("paths are not supported for commits, which are applied entirely")
+		}
+		if opts.Commit != nil {
+			log.Info("ignoring commit option because commits are applied as they are")
+		}
+		if opts.Reject {
+			log.Info("ignoring reject option because commits are applied entirely or not at all")
+		}
+		if opts.Fuzz > 0 {
+			log.Info("ignoring fuzz option because commits are applied by 'git am'")
+		}
+		if opts.UntrackedCollision != "" {
+			log.Info("ignoring untracked collision option because commits are applied entirely or not at all")
+		}
+		patch, err := extractGhostFileToTemp(we.GhostDir, "HEAD", ghost.FileName())
+		defer removeFiles([]string{patch})
+		if err != nil {
+			return err
+		}
+		if ghost.(CommitsBranch).Bundle {
+			if opts.Resume {
+				log.Info("ignoring resume option because commits pushed as a bundle are fast-forwarded")
+			}
+			if opts.StrategyOption != "" {
+				log.Info("ignoring strategy option because commits pushed as a bundle are fast-forwarded")
+			}
+			if opts.Recover {
+				log.Info("ignoring recover option because commits pushed as a bundle are fast-forwarded")
+			}
+			if opts.Trailers {
+				log.Info("ignoring trailers option because commits pushed as a bundle are fast-forwarded")
+			}
+			if opts.Directory != "" || opts.Strip > 1 {
+				return errors.New("directory and strip are not supported for commits pushed as a bundle, which are applied as they are")
+			}
+			if opts.FFOnly {
+				_, err := checkFastForward(we.SrcDir, srcHead, ghost.(CommitsBranch).CommitHashFrom)
+				if err != nil {
+					return err
+				}
+			}
+			// fast-forwarding never overwrites local changes, so nothing has to be backed up
+			return opts.Report.record(we.SrcDir, ghost, []string{}, opts, func() errors.GitGhostError {
+				return git.ApplyCommitGhostBundle(we.SrcDir, patch)
+			})
+		}
+		opts.Reject = false
+		if opts.StrategyOption != "" && (opts.Directory != "" || opts.Strip > 1) {
+			return errors.New("directory and strip are not supported with a strategy option")
+		}
+		if opts.Trailers {
+			provenance, err := GetProvenance(we.GhostDir, "HEAD", ghost)
+			if err != nil {
+				return err
+			}
+			err = git.AddTrailersToDiffBundleFile(patch, provenance.commitsTrailers(ghost.(CommitsBranch)))
+			if err != nil {
+				return err
+			}
+		}
+		if opts.FFOnly {
+			if opts.Resume {
+				return errors.New("resume is not supported with ff-only, which requires HEAD not to have any commits beyond the base")
+			}
+			behind, err := checkFastForward(we.SrcDir, srcHead, ghost.(CommitsBranch).CommitHashFrom)
+			if err != nil {
+				return err
+			}
+			if behind {
+				log.WithFields(log.Fields{
+					"srcHead": srcHead,
+					"base":    ghost.(CommitsBranch).CommitHashFrom,
+				}).Info("fast-forwarding HEAD to the base of ghost commits")
+				err = git.FastForward(we.SrcDir, ghost.(CommitsBranch).CommitHashFrom)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		skipped := []string{}
+		if opts.Resume {
+			if opts.StrategyOption != "" {
+				return errors.New("resume is not supported with a strategy option, which recreates all the commits on their base")
+			}
+			skipped, err = git.SkipAppliedDiffBundlePatches(we.SrcDir, patch, ghost.(CommitsBranch).CommitHashFrom)
+			if err != nil {
+				return err
+			}
+			logSkipped(ghost, "commits", skipped)
+		}
+		defer opts.Report.recordSkipped(skipped)
+		resolved := []string{}
+		defer func() { opts.Report.recordRerere(resolved) }()
+		return applyPatches(we.SrcDir, ghost, []string{patch}, opts, func() errors.GitGhostError {
+			if len(skipped) > 0 {
+				size, err := util.FileSize(patch)
+				if err != nil || size == 0 {
+					return err
+				}
+			}
+			var err errors.GitGhostError
+			if opts.Recover {
+				resolved, err = recoverCommits(we.SrcDir, patch, opts.patchPathOptions(), opts.Rerere)
+				return err
+			}
+			err = git.ApplyDiffBundleFile(we.SrcDir, patch, opts.patchPathOptions())
+			if err != nil && opts.Rerere {
+				log.WithFields(log.Fields{
+					"error": err.Error(),
+					"patch": patch,
+				}).Warn("applying commits by 'git am' failed. retrying by 'git am --3way' with rerere")
+				resolved, err = git.ApplyDiffBundleFileWithRerere(we.SrcDir, patch, opts.patchPathOptions())
+				return err
+			}
+			if err == nil || opts.StrategyOption == "" {
+				return err
+			}
+			log.WithFields(log.Fields{
+				"error":          err.Error(),
+				"strategyOption": opts.StrategyOption,
+			}).Warn("applying commits by 'git am' failed. retrying by 'git cherry-pick'")
+			return git.CherryPickDiffBundleFile(we.SrcDir, patch, ghost.(CommitsBranch).CommitHashFrom, opts.StrategyOption)
+		})
 	case DiffBranch:
-		return git.ApplyDiffPatchFile(we.SrcDir, path.Join(we.GhostDir, ghost.FileName()))
+		if opts.Trailers {
+			log.Info("ignoring trailers option because a diff is applied without commits")
+		}
+		// an incremental diff requires diffs of its ancestors to be applied beforehand
+		patches, err := extractPatchChain(we.GhostDir, "HEAD", ghost.FileName())
+		defer removeFiles(patches)
+		if err != nil {
+			return err
+		}
+		if len(opts.Paths) > 0 {
+			err = filterPatchChain(we.SrcDir, ghost, patches, opts.Paths)
+			if err != nil {
+				return err
+			}
+		}
+		skipped := []string{}
+		if opts.Resume {
+			if opts.Commit != nil {
+				return errors.New("resume is not supported with commit, which requires a diff to be applied entirely")
+			}
+			for _, p := range patches {
+				paths, err := git.SkipAppliedDiffSections(we.SrcDir, p, opts.patchPathOptions())
+				if err != nil {
+					return err
+				}
+				skipped = append(skipped, paths...)
+			}
+			skipped = util.UniqueStringSlice(skipped)
+			logSkipped(ghost, "files", skipped)
+		}
+		defer opts.Report.recordSkipped(skipped)
+		collisions := []UntrackedCollision{}
+		if opts.UntrackedCollision != "" {
+			collisions, err = resolveUntrackedCollisions(we.SrcDir, patches, opts.patchPathOptions(), opts.UntrackedCollision)
+			if err != nil {
+				return err
+			}
+		}
+		defer func() { opts.Report.recordCollisions(collisions) }()
+		fuzzed := []git.FuzzedHunk{}
+		defer func() { opts.Report.recordFuzzed(fuzzed) }()
+		return applyPatches(we.SrcDir, ghost, patches, opts, func() errors.GitGhostError {
+			if opts.Commit != nil {
+				err := applyAndCommit(we.SrcDir, patches, opts.patchPathOptions(), *opts.Commit)
+				if err != nil {
+					return err
+				}
+			} else {
+				applyDiffPatchFile := git.ApplyDiffPatchFile
+				if opts.Recover {
+					applyDiffPatchFile = recoverDiff
+				} else if opts.Reject {
+					applyDiffPatchFile = git.ApplyDiffPatchFileWithReject
+				} else if opts.Fuzz > 0 {
+					applyDiffPatchFile = func(srcDir, patch string, pathOpts git.PatchPathOptions) errors.GitGhostError {
+						hunks, err := applyWithFuzz(srcDir, patch, pathOpts, opts.Fuzz)
+						fuzzed = append(fuzzed, hunks...)
+						return err
+					}
+				}
+				for _, p := range patches {
+					err := applyDiffPatchFile(we.SrcDir, p, opts.patchPathOptions())
+					if err != nil {
+						return err
+					}
+				}
+			}
+			// the latest list of empty directories in the chain is for the whole state
+			err := restoreEmptyDirs(we.GhostDir, "HEAD", ghost.FileName(), we.SrcDir, opts)
+			if err != nil {
+				return err
+			}
+			return verifyAppliedTree(ghost.(DiffBranch), we, patches, skipped, opts, srcHead)
+		})
 	default:
 		return errors.Errorf("not supported on type = %+v", reflect.TypeOf(ghost))
 	}
 }
 
+// filterPatchChain removes files not under any of paths from patches of ghost, warning about paths which are not in any of them
+func filterPatchChain(srcDir string, ghost GhostBranch, patches []string, paths []string) errors.GitGhostError {
+	matched := []string{}
+	for _, p := range patches {
+		m, err := git.FilterDiffSections(srcDir, p, paths)
+		if err != nil {
+			return err
+		}
+		matched = append(matched, m...)
+	}
+	if len(matched) == 0 {
+		return errors.WithCategory(errors.Errorf("none of the paths %s is in %s", strings.Join(paths, ", "), ghost.BranchName()), errors.CategoryNotFound)
+	}
+	for _, p := range util.SubtractStringSlice(paths, matched) {
+		log.WithFields(log.Fields{
+			"branch": ghost.BranchName(),
+			"path":   p,
+		}).Warn("path is not in the ghost, so nothing is applied for it")
+	}
+	return nil
+}
+
+// logSkipped logs what of ghost is skipped because it is already applied
+func logSkipped(ghost GhostBranch, kind string, skipped []string) {
+	for _, s := range skipped {
+		log.WithFields(log.Fields{
+			"branch": ghost.BranchName(),
+			kind:     s,
+		}).Info("skipping what is already applied")
+	}
+	if len(skipped) > 0 {
+		log.WithFields(log.Fields{
+			"branch": ghost.BranchName(),
+		}).Warnf("skipped %d %s already applied", len(skipped), kind)
+	}
+}
+
+// applyPatches calls f which applies patches of ghost on srcDir, backing up files touched by them and reporting what f did if required by opts
+func applyPatches(srcDir string, ghost GhostBranch, patches []string, opts ApplyOptions, f func() errors.GitGhostError) errors.GitGhostError {
+	return opts.Report.record(srcDir, ghost, patches, opts, func() errors.GitGhostError {
+		if !opts.Backup {
+			return f()
+		}
+		return withBackup(srcDir, patches, opts.patchPathOptions(), opts.KeepBackup, f)
+	})
+}
+
+// applyAndCommit applies patches to both the index and the working tree of srcDir and commits them
+//
+// Changes already staged are refused because they would be committed together.
+func applyAndCommit(srcDir string, patches []string, pathOpts git.PatchPathOptions, opts CommitOptions) errors.GitGhostError {
+	staged, err := git.HasStagedChanges(srcDir)
+	if err != nil {
+		return err
+	}
+	if staged {
+		return errors.Errorf("%s has staged changes which would be committed with the ghost. please commit or unstage them (or pull with --autostash) and retry", srcDir)
+	}
+	for _, p := range patches {
+		err := git.ApplyDiffPatchFileWithIndex(srcDir, p, pathOpts)
+		if err != nil {
+			return err
+		}
+	}
+	staged, err = git.HasStagedChanges(srcDir)
+	if err != nil {
+		return err
+	}
+	if !staged {
+		log.WithFields(log.Fields{
+			"srcDir": srcDir,
+		}).Warn("skipping commit because the applied diff is empty")
+		return nil
+	}
+	return git.CommitIndex(srcDir, opts.Message, opts.Author)
+}
+
+// ensureNoOperationInProgress checks 'git am' or 'git rebase' is not left in progress on dir.
+// If force is true, it aborts the operation instead of returning an error.
+// If resume is true, 'git am' is quit keeping commits applied by it, which are skipped on applying commits.
+func ensureNoOperationInProgress(dir string, force, resume bool) errors.GitGhostError {
+	operation, err := git.OperationInProgress(dir)
+	if err != nil {
+		return err
+	}
+	if operation == "" {
+		return nil
+	}
+	if resume && operation == "am" {
+		log.WithFields(log.Fields{
+			"srcDir":    dir,
+			"operation": operation,
+		}).Warnf("quitting 'git %s' in progress to resume applying", operation)
+		return git.QuitOperation(dir, operation)
+	}
+	if !force {
+		return errors.Errorf("'git %s' is in progress in %s. please run 'git %s --abort' (or pull with --force) and retry", operation, dir, operation)
+	}
+	log.WithFields(log.Fields{
+		"srcDir":    dir,
+		"operation": operation,
+	}).Warnf("aborting 'git %s' in progress", operation)
+	return git.AbortOperation(dir, operation)
+}
+
+// checkFastForward checks applying commits on base fast-forwards srcHead of dir, which is true if srcHead is base or an ancestor of it.
+// It returns true if srcHead is behind base, and an error describing the divergence otherwise.
+func checkFastForward(dir, srcHead, base string) (bool, errors.GitGhostError) {
+	if srcHead == base {
+		return false, nil
+	}
+	err := git.ValidateCommittish(dir, base)
+	if err != nil {
+		if errors.CategoryOf(err) == errors.CategoryNotFound {
+			return false, errors.WithCategory(errors.Errorf("base %s of the ghost is not in %s, so it is not known whether applying fast-forwards HEAD. please fetch it and retry", base, dir), errors.CategoryNotFound)
+		}
+		return false, err
+	}
+	ancestor, err := git.IsAncestor(dir, srcHead, base)
+	if err != nil || ancestor {
+		return ancestor, err
+	}
+	ahead, err := git.CountCommits(dir, base, srcHead)
+	if err != nil {
+		return false, err
+	}
+	behind, err := git.CountCommits(dir, srcHead, base)
+	if err != nil {
+		return false, err
+	}
+	return false, errors.WithCategory(errors.Errorf("applying does not fast-forward HEAD %s because it diverges from base %s of the ghost: HEAD has %d commit(s) not in the base, and the base has %d commit(s) not in HEAD. please rebase HEAD onto the base (or pull without --ff-only) and retry", srcHead, base, ahead, behind), errors.CategoryConflict)
+}
+
 // Show writes contents of this ghost branch on passed working env to writer
 func (bs CommitsBranch) Show(we WorkingEnv, writer io.Writer) errors.GitGhostError {
-	return show(bs, we, writer)
+	if !bs.Bundle {
+		return show(bs, we, writer)
+	}
+	// a bundle is shown as patches of its commits, which requires the source directory to have CommitHashFrom
+	bundle, err := extractGhostFileToTemp(we.GhostDir, "HEAD", bs.FileName())
+	defer removeFiles([]string{bundle})
+	if err != nil {
+		return err
+	}
+	hash, err := git.FetchCommitGhostBundle(we.SrcDir, bundle)
+	if err != nil {
+		return err
+	}
+	return git.WriteDiffBundle(we.SrcDir, bs.CommitHashFrom, hash, writer)
 }
 
 // Apply applies contents(diff or patch) of this ghost branch on passed working env
-func (bs CommitsBranch) Apply(we WorkingEnv) errors.GitGhostError {
+func (bs CommitsBranch) Apply(we WorkingEnv, opts ApplyOptions) errors.GitGhostError {
 	if bs.CommitHashFrom == bs.CommitHashTo {
 		log.WithFields(log.Fields{
 			"from": bs.CommitHashFrom,
@@ -214,7 +729,7 @@ func (bs CommitsBranch) Apply(we WorkingEnv) errors.GitGhostError {
 		}).Warn("skipping apply ghost commits branch because from-hash and to-hash is the same.")
 		return nil
 	}
-	err := apply(bs, we, bs.CommitHashFrom)
+	err := apply(bs, we, opts, bs.CommitHashFrom)
 	if err != nil {
 		return err
 	}
@@ -227,10 +742,30 @@ func (bs DiffBranch) Show(we WorkingEnv, writer io.Writer) errors.GitGhostError
 }
 
 // Apply applies contents(diff or patch) of this ghost branch on passed working env
-func (bs DiffBranch) Apply(we WorkingEnv) errors.GitGhostError {
-	err := apply(bs, we, bs.CommitHashFrom)
+func (bs DiffBranch) Apply(we WorkingEnv, opts ApplyOptions) errors.GitGhostError {
+	err := apply(bs, we, opts, bs.CommitHashFrom)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Revert reverts contents of this ghost branch applied on passed working env
+//
+// It fails if files of the diff have been changed since they were applied.
+// Empty directories restored by applying are left.
+func (bs DiffBranch) Revert(we WorkingEnv, opts ApplyOptions) errors.GitGhostError {
+	patches, err := extractPatchChain(we.GhostDir, "HEAD", bs.FileName())
+	defer removeFiles(patches)
 	if err != nil {
 		return err
 	}
+	// an incremental diff is reverted before diffs of its ancestors
+	for i := len(patches) - 1; i >= 0; i-- {
+		err := git.RevertDiffPatchFile(we.SrcDir, patches[i], opts.patchPathOptions())
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
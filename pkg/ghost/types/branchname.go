@@ -0,0 +1,168 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// placeholders of a branch name scheme
+const (
+	schemePrefix = "{prefix}"
+	schemeType   = "{type}"
+	schemeBase   = "{base}"
+	schemeHash   = "{hash}"
+)
+
+// types of ghost branches in a branch name scheme
+const (
+	schemeTypeCommits = "commits"
+	schemeTypeDiff    = "diff"
+)
+
+// BranchNameScheme is a layout of ghost branch names given by a template like "{prefix}/{type}/{base}/{hash}"
+//
+// {type} is replaced with "commits" for a local base branch and "diff" for a local mod branch, {base} with the commit hash
+// the ghost is based on and {hash} with the last commit hash of commits or the diff hash.
+type BranchNameScheme struct {
+	template string
+	pattern  *regexp.Regexp
+	// fields are placeholders in the order of the groups of pattern
+	fields []string
+}
+
+// branchNameScheme is nil for the default layout, "{prefix}/{base}-{hash}" for commits and "{prefix}/{base}/{hash}" for a diff
+var branchNameScheme *BranchNameScheme
+
+// SetBranchNameScheme sets a layout of names of ghost branches created, listed and parsed, or the default one if scheme is nil
+func SetBranchNameScheme(scheme *BranchNameScheme) {
+	branchNameScheme = scheme
+}
+
+var schemePlaceholderPattern = regexp.MustCompile(`\{[^{}]*\}`)
+
+// ParseBranchNameScheme validates a template of ghost branch names and returns its scheme
+//
+// The template must start with "{prefix}/" so that all the refs of git-ghost are under the prefix, and have each of the placeholders once,
+// separated by something other than lower case letters and digits (e.g. "/" or "-") so that a branch name is parsed unambiguously.
+func ParseBranchNameScheme(template string) (*BranchNameScheme, errors.GitGhostError) {
+	if !strings.HasPrefix(template, schemePrefix+"/") {
+		return nil, errors.Errorf("branch name scheme %s must start with %s/", template, schemePrefix)
+	}
+	locs := schemePlaceholderPattern.FindAllStringIndex(template, -1)
+	seen := map[string]bool{}
+	fields := []string{}
+	expr := "^"
+	last := 0
+	for i, loc := range locs {
+		field := template[loc[0]:loc[1]]
+		switch field {
+		case schemePrefix, schemeType, schemeBase, schemeHash:
+		default:
+			return nil, errors.Errorf("unknown placeholder %s in branch name scheme %s. it must be one of %s, %s, %s and %s", field, template, schemePrefix, schemeType, schemeBase, schemeHash)
+		}
+		if seen[field] {
+			return nil, errors.Errorf("placeholder %s appears more than once in branch name scheme %s", field, template)
+		}
+		seen[field] = true
+		separator := template[last:loc[0]]
+		if i > 0 && strings.Trim(separator, "abcdefghijklmnopqrstuvwxyz0123456789") == "" {
+			return nil, errors.Errorf("placeholders in branch name scheme %s must be separated by a character other than lower case letters and digits", template)
+		}
+		if strings.ContainsAny(separator, "{}*?[") {
+			return nil, errors.Errorf("branch name scheme %s has an invalid character", template)
+		}
+		expr += regexp.QuoteMeta(separator) + schemeFieldExpr(field)
+		fields = append(fields, field)
+		last = loc[1]
+	}
+	if strings.ContainsAny(template[last:], "{}*?[") {
+		return nil, errors.Errorf("branch name scheme %s has an invalid character", template)
+	}
+	for _, field := range []string{schemeType, schemeBase, schemeHash} {
+		if !seen[field] {
+			return nil, errors.Errorf("branch name scheme %s must have %s", template, field)
+		}
+	}
+	expr += regexp.QuoteMeta(template[last:]) + "$"
+	scheme := &BranchNameScheme{
+		template: template,
+		pattern:  regexp.MustCompile(expr),
+		fields:   fields,
+	}
+	hash := strings.Repeat("0", 40)
+	for _, typ := range []string{schemeTypeCommits, schemeTypeDiff} {
+		err := git.ValidateRefName("refs/heads/" + scheme.name("ghost", typ, hash, hash))
+		if err != nil {
+			return nil, errors.Errorf("branch name scheme %s doesn't make a valid branch name: %s", template, err)
+		}
+	}
+	return scheme, nil
+}
+
+func schemeFieldExpr(field string) string {
+	switch field {
+	case schemePrefix:
+		return "([a-z0-9]+)"
+	case schemeType:
+		return fmt.Sprintf("(%s|%s)", schemeTypeCommits, schemeTypeDiff)
+	default:
+		return "([a-f0-9]+)"
+	}
+}
+
+// String returns the template of the scheme
+func (s BranchNameScheme) String() string {
+	return s.template
+}
+
+// name returns a branch name of the fields, which can be patterns like "*"
+func (s BranchNameScheme) name(prefix, typ, base, hash string) string {
+	return strings.NewReplacer(schemePrefix, prefix, schemeType, typ, schemeBase, base, schemeHash, hash).Replace(s.template)
+}
+
+// parse returns the fields of a branch name, or false if it doesn't follow the scheme
+func (s BranchNameScheme) parse(branchName string) (prefix, typ, base, hash string, ok bool) {
+	m := s.pattern.FindStringSubmatch(branchName)
+	if len(m) == 0 {
+		return "", "", "", "", false
+	}
+	values := map[string]string{}
+	for i, field := range s.fields {
+		values[field] = m[i+1]
+	}
+	return values[schemePrefix], values[schemeType], values[schemeBase], values[schemeHash], true
+}
+
+// commitsBranchName returns a name of a local base branch, whose base and hash can be patterns
+func commitsBranchName(prefix, base, hash string) string {
+	if branchNameScheme != nil {
+		return branchNameScheme.name(prefix, schemeTypeCommits, base, hash)
+	}
+	return fmt.Sprintf("%s/%s-%s", prefix, base, hash)
+}
+
+// diffBranchName returns a name of a local mod branch, whose base and hash can be patterns
+func diffBranchName(prefix, base, hash string) string {
+	if branchNameScheme != nil {
+		return branchNameScheme.name(prefix, schemeTypeDiff, base, hash)
+	}
+	return fmt.Sprintf("%s/%s/%s", prefix, base, hash)
+}
@@ -16,9 +16,12 @@ package types
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
@@ -59,6 +62,23 @@ type CommitsBranchSpec struct {
 	Prefix         string
 	CommittishFrom string
 	CommittishTo   string
+	// Anonymize replaces author names and emails in the patches with a placeholder
+	Anonymize bool
+	// AnonymizeDates replaces author dates in the patches with the epoch (effective with Anonymize)
+	AnonymizeDates bool
+	// DedupByPatchID reuses an existing local base branch whose patches have the same patch id
+	DedupByPatchID bool
+	// SplitSize is a size in bytes over which patches are split into parts (0 means no limit)
+	SplitSize int64
+	// Pathspecs limits commits to the ones touching them like 'git log -- <pathspec>'
+	Pathspecs []string
+	// Bundle stores the commits as a git bundle instead of patches
+	Bundle bool
+	// PatchFormat is a format of the patches
+	PatchFormat git.PatchFormatOptions
+	// MaxCommits is the maximum number of commits from CommittishFrom to CommittishTo (0 means no limit),
+	// which guards against a wrong base commit making an enormous ghost
+	MaxCommits int
 }
 
 // DiffBranchSpec is a spec for creating local mod branch
@@ -67,6 +87,54 @@ type DiffBranchSpec struct {
 	CommittishFrom    string
 	IncludedFilepaths []string
 	FollowSymlinks    bool
+	// ParentDiffHash is a diff hash of a ghost branch on the same base from which the diff is created incrementally
+	ParentDiffHash string
+	// SplitSize is a size in bytes over which the diff is split into parts (0 means no limit)
+	SplitSize int64
+	// SkipGenerated excludes files marked by linguist-generated attribute in .gitattributes from the diff
+	SkipGenerated bool
+	// IgnoreModeChanges excludes files whose modes are changed without their contents from the diff
+	IgnoreModeChanges bool
+	// Unified is the number of context lines of the diff, or nil for git's default (3)
+	Unified *int
+	// IgnoreLines drops hunks of the diff whose changed lines all match either of these regular expressions (POSIX extended),
+	// e.g. build timestamps, which makes the diff not reproduce them
+	IgnoreLines []string
+	// KeepEmptyDirs records empty directories, which git doesn't track, to recreate them on applying the diff
+	KeepEmptyDirs bool
+	// NoUntracked drops IncludedFilepaths and KeepEmptyDirs so that the diff has changes of tracked files only
+	NoUntracked bool
+	// SkipNonIndexedBinaries excludes binary files (by git's heuristic) from IncludedFilepaths
+	SkipNonIndexedBinaries bool
+	// SkipNonIndexedText excludes text files (by git's heuristic) from IncludedFilepaths
+	SkipNonIndexedText bool
+	// PatchFile is a diff file (e.g. created by 'git diff') pushed instead of local modifications
+	//
+	// It must apply cleanly to CommittishFrom.
+	PatchFile string
+	// Stash is a stash entry (e.g. stash@{2}) pushed instead of local modifications
+	//
+	// CommittishFrom is replaced with the base of the stash, and its untracked files (by 'git stash -u') are included.
+	Stash string
+	// GeneratedPatchFile is a patch of the local modifications already generated by PredictBranchKeepingPatch,
+	// which is pushed as it is instead of generating it again
+	GeneratedPatchFile string
+	// BinaryAttachments stores binary files changed by the diff as blobs attached to it instead of binary hunks inside it,
+	// so that the diff stays human readable
+	BinaryAttachments bool
+	// VerifyRoundtrip checks that re-diffing the base with the diff applied reproduces the diff before pushing it
+	VerifyRoundtrip bool
+	// AllowConflictMarkers pushes the diff even if lines added by it are conflict markers, which refuse pushing otherwise
+	AllowConflictMarkers bool
+}
+
+func (bs DiffBranchSpec) diffOptions() git.DiffOptions {
+	return git.DiffOptions{
+		SkipGenerated:     bs.SkipGenerated,
+		IgnoreModeChanges: bs.IgnoreModeChanges,
+		Unified:           bs.Unified,
+		IgnoreLines:       bs.IgnoreLines,
+	}
 }
 
 // PullableDiffBranchSpec is a spec for pulling local base branch
@@ -98,20 +166,32 @@ func (bs CommitsBranchSpec) Resolve(srcDir string) (*CommitsBranchSpec, errors.G
 
 // PullBranch pulls a ghost branch on from ghost repo in WorkingEnv and returns a GhostBranch object
 func (bs CommitsBranchSpec) PullBranch(we WorkingEnv) (GhostBranch, errors.GitGhostError) {
-	resolved, err := bs.Resolve(we.SrcDir)
+	err := validatePullBase(we.SrcDir, bs.CommittishFrom)
 	if err != nil {
 		return nil, err
 	}
 
+	// CommittishTo doesn't need to exist locally since the commits are created by applying the ghost
+	// (e.g. commits replayed by Pathspecs exist only in the ghost)
+	// a hash abbreviated but not found locally is resolved by ghost branches
+	commitHashFrom := resolveCommittishOr(we.SrcDir, bs.CommittishFrom)
+	commitHashTo, err := ResolveGhostHashPrefix(we.GhostRepo, bs.Prefix, commitHashFrom, resolveCommittishOr(we.SrcDir, bs.CommittishTo), true)
+	if err != nil {
+		return nil, err
+	}
 	branch := &CommitsBranch{
-		Prefix:         resolved.Prefix,
-		CommitHashFrom: resolved.CommittishFrom,
-		CommitHashTo:   resolved.CommittishTo,
+		Prefix:         bs.Prefix,
+		CommitHashFrom: commitHashFrom,
+		CommitHashTo:   commitHashTo,
 	}
 	err = pull(branch, we)
 	if err != nil {
 		return nil, err
 	}
+	branch.Bundle, err = ghostFileExists(we.GhostDir, "HEAD", commitsBundleFileName)
+	if err != nil {
+		return nil, err
+	}
 	return branch, nil
 }
 
@@ -126,46 +206,212 @@ func (bs CommitsBranchSpec) CreateBranch(we WorkingEnv) (GhostBranch, errors.Git
 
 	commitHashFrom := resolved.CommittishFrom
 	commitHashTo := resolved.CommittishTo
+	if bs.MaxCommits > 0 {
+		count, ggerr := git.CountCommits(srcDir, commitHashFrom, commitHashTo)
+		if ggerr != nil {
+			return nil, ggerr
+		}
+		if count > bs.MaxCommits {
+			return nil, errors.WithCategory(errors.Errorf("%s..%s has %d commits, which exceeds max-commits %d. please specify a nearer base commit (e.g. the merge base with the upstream), or push with --force or a larger --max-commits if it is intended", bs.CommittishFrom, bs.CommittishTo, count, bs.MaxCommits), errors.CategoryConfig)
+		}
+	}
+	if len(bs.Pathspecs) > 0 {
+		commitHashTo, ggerr = selectCommitsByPathspecs(srcDir, commitHashFrom, commitHashTo, bs.Pathspecs)
+		if ggerr != nil {
+			return nil, ggerr
+		}
+	}
 	branch := CommitsBranch{
 		Prefix:         resolved.Prefix,
 		CommitHashFrom: commitHashFrom,
 		CommitHashTo:   commitHashTo,
+		Bundle:         bs.Bundle,
 	}
-	tmpFile, err := ioutil.TempFile("", "git-ghost-local-base")
+	tmpFile, err := ioutil.TempFile(util.TempDir(), "git-ghost-local-base")
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 	util.LogDeferredError(tmpFile.Close)
 	defer util.LogDeferredError(func() error { return os.Remove(tmpFile.Name()) })
-	ggerr = git.CreateDiffBundleFile(srcDir, tmpFile.Name(), commitHashFrom, commitHashTo)
+	ggerr = git.CreateDiffBundleFile(srcDir, tmpFile.Name(), commitHashFrom, commitHashTo, bs.PatchFormat)
 	if ggerr != nil {
 		return nil, ggerr
 	}
-	err = os.Rename(tmpFile.Name(), filepath.Join(dstDir, branch.FileName()))
-	if err != nil {
-		return nil, errors.WithStack(err)
+	if bs.Anonymize {
+		ggerr = git.AnonymizeDiffBundleFile(tmpFile.Name(), bs.AnonymizeDates)
+		if ggerr != nil {
+			return nil, ggerr
+		}
+	}
+	ggerr = checkSecrets(tmpFile.Name())
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	ggerr = checkEOLs(tmpFile.Name())
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	branch.Stats, ggerr = git.GetPatchStats(tmpFile.Name())
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	if bs.DedupByPatchID {
+		branch.PatchID, ggerr = git.GetPatchID(srcDir, tmpFile.Name())
+		if ggerr != nil {
+			return nil, ggerr
+		}
+		existing, ggerr := findCommitsBranchByPatchID(we, branch)
+		if ggerr != nil {
+			return nil, ggerr
+		}
+		if existing != nil {
+			log.WithFields(log.Fields{
+				"branch":  existing.BranchName(),
+				"patchId": branch.PatchID,
+			}).Info("found an existing branch with the same patch id")
+			return existing, nil
+		}
+	}
+	stored := tmpFile.Name()
+	if bs.Bundle {
+		// patches are still created above for statistics and the patch id
+		bundleFile, err := ioutil.TempFile(util.TempDir(), "git-ghost-local-base-bundle")
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		util.LogDeferredError(bundleFile.Close)
+		defer util.LogDeferredError(func() error { return os.Remove(bundleFile.Name()) })
+		ggerr = git.CreateCommitGhostBundle(srcDir, bundleFile.Name(), commitHashFrom, commitHashTo)
+		if ggerr != nil {
+			return nil, ggerr
+		}
+		branch.Stats.Bytes, ggerr = util.FileSize(bundleFile.Name())
+		if ggerr != nil {
+			return nil, ggerr
+		}
+		stored = bundleFile.Name()
+	}
+	ggerr = storeGhostFile(dstDir, stored, branch.FileName(), bs.SplitSize)
+	if ggerr != nil {
+		return nil, ggerr
 	}
 
 	ggerr = git.CreateOrphanBranch(dstDir, branch.BranchName())
 	if ggerr != nil {
 		return nil, ggerr
 	}
-	ggerr = git.CommitFile(dstDir, branch.FileName(), fmt.Sprintf("Create ghost commit"))
+	ggerr = commitGhostFile(dstDir, branch.FileName())
 	if ggerr != nil {
 		return nil, ggerr
 	}
+	if branch.PatchID != "" {
+		ggerr = git.CreateTag(dstDir, branch.PatchIDTagName())
+		if ggerr != nil {
+			return nil, ggerr
+		}
+	}
 
 	return &branch, nil
 }
 
+// selectCommitsByPathspecs returns a commit hash to which commits in commitHashFrom..commitHashTo touching pathspecs are applied
+//
+// If some commits in between are left out, the selected ones are replayed on commitHashFrom so that they form a series
+// which can be applied by itself, and the hash of the last replayed commit is returned.
+func selectCommitsByPathspecs(srcDir, commitHashFrom, commitHashTo string, pathspecs []string) (string, errors.GitGhostError) {
+	all, ggerr := git.ListCommits(srcDir, commitHashFrom, commitHashTo)
+	if ggerr != nil {
+		return "", ggerr
+	}
+	selected, ggerr := git.ListCommits(srcDir, commitHashFrom, commitHashTo, pathspecs...)
+	if ggerr != nil {
+		return "", ggerr
+	}
+	if len(selected) == 0 {
+		return "", errors.Errorf("no commits in %s..%s touch %s", commitHashFrom, commitHashTo, strings.Join(pathspecs, " "))
+	}
+	if len(selected) == len(all) {
+		return commitHashTo, nil
+	}
+
+	log.WithFields(log.Fields{
+		"selected": len(selected),
+		"all":      len(all),
+	}).Warn("selected commits are not contiguous. they are replayed without commits in between, so the ghost may not apply where those commits are required.")
+	replayed, ggerr := git.ReplayCommits(srcDir, commitHashFrom, selected)
+	if ggerr != nil {
+		return "", ggerr
+	}
+	log.WithFields(log.Fields{
+		"to":       commitHashTo,
+		"replayed": replayed,
+	}).Info("replayed selected commits")
+	return replayed, nil
+}
+
+// findCommitsBranchByPatchID returns a local base branch on the ghost repo tagged with the patch id of branch
+//
+// It returns nil if the tag doesn't exist or the branch it points to was deleted.
+func findCommitsBranchByPatchID(we WorkingEnv, branch CommitsBranch) (*CommitsBranch, errors.GitGhostError) {
+	if branch.PatchID == "" {
+		return nil, nil
+	}
+	tags, ggerr := git.ListRemoteRefHashes(we.GhostRepo, "refs/tags/"+branch.PatchIDTagName())
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	tagged, ok := tags["refs/tags/"+branch.PatchIDTagName()]
+	if !ok {
+		return nil, nil
+	}
+	heads, ggerr := git.ListRemoteRefHashes(we.GhostRepo, fmt.Sprintf("refs/heads/%s/*", branch.Prefix))
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	for ref, hash := range heads {
+		if hash != tagged {
+			continue
+		}
+		existing, ok := CreateGhostBranchByName(strings.TrimPrefix(ref, "refs/heads/")).(*CommitsBranch)
+		if ok {
+			existing.PatchID = branch.PatchID
+			return existing, nil
+		}
+	}
+	return nil, nil
+}
+
 // Resolve resolves committish in DiffBranchSpec as full commit hash values
 func (bs DiffBranchSpec) Resolve(srcDir string) (*DiffBranchSpec, errors.GitGhostError) {
+	if bs.Stash != "" {
+		stash, err := git.ResolveStash(srcDir, bs.Stash)
+		if err != nil {
+			return nil, err
+		}
+		log.WithFields(log.Fields{
+			"stash": bs.Stash,
+			"base":  stash.Base,
+		}).Info("using the base of the stash as the base of the diff")
+		bs.Stash = stash.Commit
+		bs.CommittishFrom = stash.Base
+	}
 	err := git.ValidateCommittish(srcDir, bs.CommittishFrom)
 	if err != nil {
 		return nil, err
 	}
 	commitHashFrom := resolveCommittishOr(srcDir, bs.CommittishFrom)
 
+	if bs.NoUntracked {
+		if len(bs.IncludedFilepaths) > 0 || bs.KeepEmptyDirs {
+			log.WithFields(log.Fields{
+				"included":      bs.IncludedFilepaths,
+				"keepEmptyDirs": bs.KeepEmptyDirs,
+			}).Warn("untracked files and empty directories are ignored because only tracked files are ghosted")
+		}
+		bs.IncludedFilepaths = nil
+		bs.KeepEmptyDirs = false
+	}
+
 	var errs error
 	includedFilepaths := make([]string, 0, len(bs.IncludedFilepaths))
 	for _, p := range bs.IncludedFilepaths {
@@ -177,7 +423,7 @@ func (bs DiffBranchSpec) Resolve(srcDir string) (*DiffBranchSpec, errors.GitGhos
 		includedFilepaths = append(includedFilepaths, resolved)
 
 		if bs.FollowSymlinks {
-			islink, err := util.IsSymlink(p)
+			islink, err := util.IsSymlink(filepath.Join(srcDir, resolved))
 			if err != nil {
 				errs = multierror.Append(errs, err)
 				continue
@@ -209,15 +455,274 @@ func (bs DiffBranchSpec) Resolve(srcDir string) (*DiffBranchSpec, errors.GitGhos
 	}
 	if len(includedFilepaths) > 0 {
 		includedFilepaths = util.UniqueStringSlice(includedFilepaths)
+		if gitDirFiles := git.GitDirFiles(includedFilepaths); len(gitDirFiles) > 0 {
+			log.WithFields(log.Fields{
+				"excluded": gitDirFiles,
+			}).Info("excluded files inside .git directories")
+			includedFilepaths = util.SubtractStringSlice(includedFilepaths, gitDirFiles)
+		}
+	}
+	if len(includedFilepaths) > 0 {
+		excluded, err := git.ExcludedNonIndexedFiles(srcDir, includedFilepaths)
+		if err != nil {
+			return nil, err
+		}
+		if len(excluded) > 0 {
+			log.WithFields(log.Fields{
+				"excluded": excluded,
+			}).Infof("excluded files matching %s", git.ExcludeFile)
+			includedFilepaths = util.SubtractStringSlice(includedFilepaths, excluded)
+		}
+	}
+	if len(includedFilepaths) > 0 && (bs.SkipNonIndexedBinaries || bs.SkipNonIndexedText) {
+		includedFilepaths, err = bs.filterByBinariness(srcDir, includedFilepaths)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(includedFilepaths) > 0 && bs.SkipGenerated {
+		generated, err := git.GeneratedFiles(srcDir, includedFilepaths)
+		if err != nil {
+			return nil, err
+		}
+		if len(generated) > 0 {
+			log.WithFields(log.Fields{
+				"excluded": generated,
+			}).Info("excluded generated files")
+			includedFilepaths = util.SubtractStringSlice(includedFilepaths, generated)
+		}
 	}
 
 	return &DiffBranchSpec{
-		Prefix:            bs.Prefix,
-		CommittishFrom:    commitHashFrom,
-		IncludedFilepaths: includedFilepaths,
+		Prefix:               bs.Prefix,
+		CommittishFrom:       commitHashFrom,
+		IncludedFilepaths:    includedFilepaths,
+		ParentDiffHash:       bs.ParentDiffHash,
+		SkipGenerated:        bs.SkipGenerated,
+		IgnoreModeChanges:    bs.IgnoreModeChanges,
+		Unified:              bs.Unified,
+		IgnoreLines:          bs.IgnoreLines,
+		KeepEmptyDirs:        bs.KeepEmptyDirs,
+		NoUntracked:          bs.NoUntracked,
+		PatchFile:            bs.PatchFile,
+		Stash:                bs.Stash,
+		GeneratedPatchFile:   bs.GeneratedPatchFile,
+		BinaryAttachments:    bs.BinaryAttachments,
+		VerifyRoundtrip:      bs.VerifyRoundtrip,
+		AllowConflictMarkers: bs.AllowConflictMarkers,
 	}, nil
 }
 
+// filterByBinariness drops binary or text files in filepaths (relative to srcDir) as required by the spec
+func (bs DiffBranchSpec) filterByBinariness(srcDir string, filepaths []string) ([]string, errors.GitGhostError) {
+	binaries, err := git.BinaryFiles(srcDir, filepaths)
+	if err != nil {
+		return nil, err
+	}
+	binary := map[string]bool{}
+	for _, p := range binaries {
+		binary[p] = true
+	}
+	kept := make([]string, 0, len(filepaths))
+	skipped := []string{}
+	for _, p := range filepaths {
+		if (binary[p] && bs.SkipNonIndexedBinaries) || (!binary[p] && bs.SkipNonIndexedText) {
+			skipped = append(skipped, p)
+			continue
+		}
+		kept = append(kept, p)
+	}
+	if len(skipped) > 0 {
+		log.WithFields(log.Fields{
+			"excluded":     skipped,
+			"skipBinaries": bs.SkipNonIndexedBinaries,
+			"skipText":     bs.SkipNonIndexedText,
+		}).Info("excluded non-indexed files by binariness")
+	}
+	return kept, nil
+}
+
+// PredictBranch returns a local mod branch which CreateBranch would create without accessing ghost repo
+//
+// It returns nil for an incremental diff, which depends on its parent in ghost repo.
+func (bs DiffBranchSpec) PredictBranch(weSpec WorkingEnvSpec) (*DiffBranch, errors.GitGhostError) {
+	branch, patchFile, ggerr := bs.PredictBranchKeepingPatch(weSpec)
+	if patchFile != "" {
+		util.LogDeferredError(func() error { return os.Remove(patchFile) })
+	}
+	return branch, ggerr
+}
+
+// PredictBranchKeepingPatch is PredictBranch which also returns a temporary file of the patch generated for predicting,
+// which CreateBranch takes by GeneratedPatchFile instead of generating it again
+//
+// The file must be removed by the caller if it is not empty, even if an error is returned.
+func (bs DiffBranchSpec) PredictBranchKeepingPatch(weSpec WorkingEnvSpec) (*DiffBranch, string, errors.GitGhostError) {
+	if bs.ParentDiffHash != "" {
+		return nil, "", nil
+	}
+	srcDir := weSpec.SrcDir
+	resolved, ggerr := bs.Resolve(srcDir)
+	if ggerr != nil {
+		return nil, "", ggerr
+	}
+	patchFile, err := ioutil.TempFile(util.TempDir(), "git-ghost-local-mod")
+	if err != nil {
+		return nil, "", errors.WithStack(err)
+	}
+	util.LogDeferredError(patchFile.Close)
+	ggerr = createDiffPatchFile(srcDir, patchFile.Name(), *resolved)
+	if ggerr != nil {
+		return nil, patchFile.Name(), ggerr
+	}
+	// binary files are split from a copy so that the patch is kept as CreateBranch generates it
+	tmpFile, err := ioutil.TempFile(util.TempDir(), "git-ghost-local-mod")
+	if err != nil {
+		return nil, patchFile.Name(), errors.WithStack(err)
+	}
+	util.LogDeferredError(tmpFile.Close)
+	defer util.LogDeferredError(func() error { return os.Remove(tmpFile.Name()) })
+	ggerr = copyPatchContent(patchFile.Name(), tmpFile.Name())
+	if ggerr != nil {
+		return nil, patchFile.Name(), ggerr
+	}
+	emptyDirs, ggerr := resolved.emptyDirs(srcDir)
+	if ggerr != nil {
+		return nil, patchFile.Name(), ggerr
+	}
+	stats, ggerr := git.GetPatchStats(tmpFile.Name())
+	if ggerr != nil {
+		return nil, patchFile.Name(), ggerr
+	}
+	attachments, ggerr := resolved.splitAttachments(srcDir, tmpFile.Name())
+	if ggerr != nil {
+		return nil, patchFile.Name(), ggerr
+	}
+	hash, ggerr := diffHash(weSpec.DiffHasher, DiffHashInput{
+		Prefix:         resolved.Prefix,
+		CommitHashFrom: resolved.CommittishFrom,
+		PatchFile:      tmpFile.Name(),
+		EmptyDirs:      emptyDirs,
+		Attachments:    attachments,
+	})
+	if ggerr != nil {
+		return nil, patchFile.Name(), ggerr
+	}
+	return &DiffBranch{
+		Prefix:         resolved.Prefix,
+		CommitHashFrom: resolved.CommittishFrom,
+		DiffHash:       hash,
+		Stats:          stats,
+	}, patchFile.Name(), nil
+}
+
+// createDiffPatchFile creates a patch of local modifications including non-indexed files for a resolved spec
+func createDiffPatchFile(srcDir, filepath string, resolved DiffBranchSpec) errors.GitGhostError {
+	if resolved.GeneratedPatchFile != "" {
+		return copyPatchContent(resolved.GeneratedPatchFile, filepath)
+	}
+	if resolved.PatchFile != "" {
+		return copyPatchFile(srcDir, filepath, resolved)
+	}
+	if resolved.Stash != "" {
+		stash, ggerr := git.ResolveStash(srcDir, resolved.Stash)
+		if ggerr != nil {
+			return ggerr
+		}
+		return git.CreateStashPatchFile(srcDir, filepath, *stash)
+	}
+	ggerr := git.CreateDiffPatchFile(srcDir, filepath, resolved.CommittishFrom, resolved.diffOptions())
+	if ggerr != nil {
+		return ggerr
+	}
+	if len(resolved.IncludedFilepaths) > 0 {
+		return git.AppendNonIndexedDiffFiles(srcDir, filepath, resolved.IncludedFilepaths)
+	}
+	return nil
+}
+
+// copyPatchFile copies PatchFile of a resolved spec to filepath after checking it applies cleanly to CommittishFrom
+//
+// The patch is applied to a temporary index and object directory, so the working tree, the index and objects of srcDir are not touched.
+func copyPatchFile(srcDir, filepath string, resolved DiffBranchSpec) errors.GitGhostError {
+	_, ggerr := git.HashPatchedTree(srcDir, resolved.CommittishFrom, []string{resolved.PatchFile})
+	if ggerr != nil {
+		return errors.WithCategory(
+			errors.Errorf("patch %s does not apply cleanly to %s: %s", resolved.PatchFile, resolved.CommittishFrom, ggerr),
+			errors.CategoryConflict,
+		)
+	}
+	return copyPatchContent(resolved.PatchFile, filepath)
+}
+
+// copyFile copies a content of srcPath to dstPath
+func copyPatchContent(srcPath, dstPath string) errors.GitGhostError {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer util.LogDeferredError(src.Close)
+	dst, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = io.Copy(dst, src)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	return errors.WithStack(err)
+}
+
+// parentBranch returns a parent ghost branch of an incremental diff if it exists in ghost repo
+func (bs DiffBranchSpec) parentBranch(we WorkingEnv) (*DiffBranch, errors.GitGhostError) {
+	if bs.ParentDiffHash == "" {
+		return nil, nil
+	}
+	parent := DiffBranch{
+		Prefix:         bs.Prefix,
+		CommitHashFrom: bs.CommittishFrom,
+		DiffHash:       bs.ParentDiffHash,
+	}
+	existence, err := git.ValidateRemoteBranchExistence(we.GhostRepo, parent.BranchName())
+	if err != nil {
+		return nil, err
+	}
+	if !existence {
+		log.WithFields(log.Fields{
+			"branch":    parent.BranchName(),
+			"ghostRepo": we.GhostRepo,
+		}).Warn("parent ghost branch is not found. creating a full diff instead of an incremental one.")
+		return nil, nil
+	}
+	err = git.FetchBranches(we.GhostDir, parent.BranchName())
+	if err != nil {
+		return nil, err
+	}
+	err = checkFormatVersion(we.GhostDir, git.ORIGIN+"/"+parent.BranchName(), parent)
+	if err != nil {
+		return nil, err
+	}
+	return &parent, nil
+}
+
+// createIncrementalDiffPatchFile creates a diff from the state which parent ghost branch reproduces to current working state
+func createIncrementalDiffPatchFile(we WorkingEnv, parent DiffBranch, filepath string, resolved DiffBranchSpec) errors.GitGhostError {
+	patches, err := extractPatchChain(we.GhostDir, git.ORIGIN+"/"+parent.BranchName(), parent.FileName())
+	defer removeFiles(patches)
+	if err != nil {
+		return err
+	}
+	parentTree, err := git.WritePatchedTree(we.SrcDir, parent.CommitHashFrom, patches)
+	if err != nil {
+		return err
+	}
+	currentTree, err := git.WriteWorkingTree(we.SrcDir, resolved.IncludedFilepaths)
+	if err != nil {
+		return err
+	}
+	return git.CreateTreeDiffPatchFile(we.SrcDir, filepath, parentTree, currentTree, resolved.diffOptions())
+}
+
 // CreateBranch create a ghost branch on WorkingEnv and returns a GhostBranch object
 func (bs DiffBranchSpec) CreateBranch(we WorkingEnv) (GhostBranch, errors.GitGhostError) {
 	dstDir := we.GhostDir
@@ -227,25 +732,76 @@ func (bs DiffBranchSpec) CreateBranch(we WorkingEnv) (GhostBranch, errors.GitGho
 		return nil, ggerr
 	}
 	commitHashFrom := resolved.CommittishFrom
-	tmpFile, err := ioutil.TempFile("", "git-ghost-local-mod")
+	parent, ggerr := resolved.parentBranch(we)
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	tmpFile, err := ioutil.TempFile(util.TempDir(), "git-ghost-local-mod")
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 	util.LogDeferredError(tmpFile.Close)
 	defer util.LogDeferredError(func() error { return os.Remove(tmpFile.Name()) })
-	err = git.CreateDiffPatchFile(srcDir, tmpFile.Name(), commitHashFrom)
+	if parent != nil {
+		err = createIncrementalDiffPatchFile(we, *parent, tmpFile.Name(), *resolved)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	} else {
+		err = createDiffPatchFile(srcDir, tmpFile.Name(), *resolved)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	if resolved.VerifyRoundtrip {
+		err = verifyRoundtrip(we, parent, tmpFile.Name(), commitHashFrom, resolved.diffOptions())
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	err = checkSecrets(tmpFile.Name())
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-
-	if len(bs.IncludedFilepaths) > 0 {
-		err = git.AppendNonIndexedDiffFiles(srcDir, tmpFile.Name(), resolved.IncludedFilepaths)
+	err = checkEOLs(tmpFile.Name())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if !resolved.AllowConflictMarkers {
+		err = checkConflictMarkers(tmpFile.Name())
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
 	}
+	// the tree is written before binary files are split into attachments, which are restored on extracting the diff
+	tree, err := expectedTree(we, parent, tmpFile.Name(), commitHashFrom)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
 
-	hash, err := util.GenerateFileContentHash(tmpFile.Name())
+	emptyDirs, err := resolved.emptyDirs(srcDir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	stats, err := git.GetPatchStats(tmpFile.Name())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	attachments, err := resolved.splitAttachments(srcDir, tmpFile.Name())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	input := DiffHashInput{
+		Prefix:         resolved.Prefix,
+		CommitHashFrom: commitHashFrom,
+		PatchFile:      tmpFile.Name(),
+		EmptyDirs:      emptyDirs,
+		Attachments:    attachments,
+	}
+	if parent != nil {
+		input.ParentDiffHash = parent.DiffHash
+	}
+	hash, err := diffHash(we.DiffHasher, input)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -253,17 +809,35 @@ func (bs DiffBranchSpec) CreateBranch(we WorkingEnv) (GhostBranch, errors.GitGho
 		Prefix:         resolved.Prefix,
 		CommitHashFrom: commitHashFrom,
 		DiffHash:       hash,
+		Stats:          stats,
+	}
+	if parent != nil {
+		// the ghost commit of an incremental diff is a child of its parent's one
+		err = git.CreateBranchFrom(dstDir, branch.BranchName(), git.ORIGIN+"/"+parent.BranchName())
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
 	}
-	err = os.Rename(tmpFile.Name(), filepath.Join(dstDir, branch.FileName()))
+	err = storeGhostFile(dstDir, tmpFile.Name(), branch.FileName(), bs.SplitSize)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-
-	err = git.CreateOrphanBranch(dstDir, branch.BranchName())
+	err = storeEmptyDirs(dstDir, branch.FileName(), emptyDirs)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	err = storeAttachments(srcDir, dstDir, branch.FileName(), attachments)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	err = git.CommitFile(dstDir, branch.FileName(), fmt.Sprintf("Create ghost commit"))
+
+	if parent == nil {
+		err = git.CreateOrphanBranch(dstDir, branch.BranchName())
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	err = commitGhostFile(dstDir, branch.FileName(), treeTrailerLine(tree))
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -271,6 +845,25 @@ func (bs DiffBranchSpec) CreateBranch(we WorkingEnv) (GhostBranch, errors.GitGho
 	return &branch, nil
 }
 
+// verifyRoundtrip checks a diff in filepath is reproduced by re-diffing its base with it applied
+//
+// The base of an incremental diff is the state which its parent ghost branch reproduces.
+func verifyRoundtrip(we WorkingEnv, parent *DiffBranch, filepath, commitHashFrom string, opts git.DiffOptions) errors.GitGhostError {
+	base := commitHashFrom
+	if parent != nil {
+		patches, err := extractPatchChain(we.GhostDir, git.ORIGIN+"/"+parent.BranchName(), parent.FileName())
+		defer removeFiles(patches)
+		if err != nil {
+			return err
+		}
+		base, err = git.WritePatchedTree(we.SrcDir, parent.CommitHashFrom, patches)
+		if err != nil {
+			return err
+		}
+	}
+	return git.VerifyPatchRoundtrip(we.SrcDir, base, filepath, opts)
+}
+
 // Resolve resolves committish in PullableDiffBranchSpec as full commit hash values
 func (bs PullableDiffBranchSpec) Resolve(srcDir string) (*PullableDiffBranchSpec, errors.GitGhostError) {
 	err := git.ValidateCommittish(srcDir, bs.CommittishFrom)
@@ -288,14 +881,19 @@ func (bs PullableDiffBranchSpec) Resolve(srcDir string) (*PullableDiffBranchSpec
 
 // PullBranch pulls a ghost branch on from ghost repo in WorkingEnv and returns a GhostBranch object
 func (bs PullableDiffBranchSpec) PullBranch(we WorkingEnv) (GhostBranch, errors.GitGhostError) {
-	resolved, err := bs.Resolve(we.SrcDir)
+	err := validatePullBase(we.SrcDir, bs.CommittishFrom)
+	if err != nil {
+		return nil, err
+	}
+	commitHashFrom := resolveCommittishOr(we.SrcDir, bs.CommittishFrom)
+	diffHash, err := ResolveGhostHashPrefix(we.GhostRepo, bs.Prefix, commitHashFrom, bs.DiffHash, false)
 	if err != nil {
 		return nil, err
 	}
 	branch := &DiffBranch{
-		Prefix:         resolved.Prefix,
-		CommitHashFrom: resolved.CommittishFrom,
-		DiffHash:       bs.DiffHash,
+		Prefix:         bs.Prefix,
+		CommitHashFrom: commitHashFrom,
+		DiffHash:       diffHash,
 	}
 	err = pull(branch, we)
 	if err != nil {
@@ -304,8 +902,161 @@ func (bs PullableDiffBranchSpec) PullBranch(we WorkingEnv) (GhostBranch, errors.
 	return branch, nil
 }
 
+var fullCommitHashPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+var fetchMissingBase = true
+
+// SetFetchMissingBase sets whether a base commit of a ghost to pull missing in a partial clone is fetched from its promisor remote,
+// which fails pulling otherwise
+func SetFetchMissingBase(fetch bool) {
+	fetchMissingBase = fetch
+}
+
+// validatePullBase checks committish which a ghost to pull is based on exists on srcDir
+//
+// A full commit hash is accepted even if it is missing on srcDir since a ghost can be applied to
+// another repo (e.g. into a subdirectory by ApplyOptions.Directory), unless srcDir is a partial clone.
+func validatePullBase(srcDir, committish string) errors.GitGhostError {
+	if fullCommitHashPattern.MatchString(committish) {
+		err := ensurePartialCloneBase(srcDir, committish)
+		if err != nil {
+			return err
+		}
+	}
+	err := git.ValidateCommittish(srcDir, committish)
+	if err != nil && fullCommitHashPattern.MatchString(committish) {
+		log.WithFields(log.Fields{
+			"srcDir":     srcDir,
+			"committish": committish,
+		}).Warn("base commit of the ghost does not exist on the source directory. applying it might be failed.")
+		return nil
+	}
+	return err
+}
+
+// ensurePartialCloneBase fetches commit missing in srcDir from its promisor remote if srcDir is a partial clone,
+// so that the base of a ghost is never left to be fetched lazily and obscurely while applying
+func ensurePartialCloneBase(srcDir, commit string) errors.GitGhostError {
+	remote, err := git.PromisorRemote(srcDir)
+	if err != nil || remote == "" {
+		return err
+	}
+	exists, err := git.CommitExistsWithoutFetching(srcDir, commit)
+	if err != nil || exists {
+		return err
+	}
+	if !fetchMissingBase {
+		return errors.WithCategory(errors.Errorf("base commit %s of the ghost is not present in the source repo, which is a partial clone. please run 'git fetch %s %s' first", commit, remote, commit), errors.CategoryNotFound)
+	}
+	log.WithFields(log.Fields{
+		"srcDir": srcDir,
+		"remote": remote,
+		"commit": commit,
+	}).Info("fetching base commit of the ghost missing in the partial clone")
+	err = git.FetchCommit(srcDir, remote, commit)
+	if err != nil {
+		return errors.WithCategory(errors.Errorf("base commit %s of the ghost is not present in the source repo, which is a partial clone, and fetching it from %s failed: %s", commit, remote, err), errors.CategoryRemote)
+	}
+	return nil
+}
+
+var abbreviatedHashPattern = regexp.MustCompile(`^[0-9a-f]{4,39}$`)
+
+// ResolveGhostHashPrefix resolves hash abbreviated like a commit hash of git into the full last hash
+// (LOCAL_BASE_COMMIT of a local base branch if commits is true, or DIFF_HASH of a local mod branch) of a ghost branch on repo based on from
+//
+// from can be "*" to match any base. A hash which is not abbreviated, or which is the whole hash of a ghost branch, is returned as it is.
+func ResolveGhostHashPrefix(repo, prefix, from, hash string, commits bool) (string, errors.GitGhostError) {
+	if !abbreviatedHashPattern.MatchString(hash) {
+		return hash, nil
+	}
+	pattern := diffBranchName(prefix, from, hash+"*")
+	if commits {
+		pattern = commitsBranchName(prefix, from, hash+"*")
+	}
+	names, ggerr := git.ListRemoteBranchNames(repo, []string{pattern})
+	if ggerr != nil {
+		return "", ggerr
+	}
+	candidates := []string{}
+	for _, name := range names {
+		switch b := CreateGhostBranchByName(name).(type) {
+		case *CommitsBranch:
+			if commits && strings.HasPrefix(b.CommitHashTo, hash) {
+				candidates = append(candidates, b.CommitHashTo)
+			}
+		case *DiffBranch:
+			if !commits && strings.HasPrefix(b.DiffHash, hash) {
+				candidates = append(candidates, b.DiffHash)
+			}
+		}
+	}
+	// a short hash of a custom DiffHasher is not an abbreviation of another one of which it is a prefix
+	for _, candidate := range candidates {
+		if candidate == hash {
+			return hash, nil
+		}
+	}
+	candidates = util.UniqueStringSlice(candidates)
+	switch len(candidates) {
+	case 0:
+		return "", errors.WithCategory(errors.Errorf("no ghost branch is found for %s", hash), errors.CategoryNotFound)
+	case 1:
+		log.WithFields(log.Fields{
+			"prefix": hash,
+			"hash":   candidates[0],
+		}).Debug("resolved abbreviated hash")
+		return candidates[0], nil
+	}
+	sort.Strings(candidates)
+	return "", errors.Errorf("ambiguous prefix %s: candidates are %s", hash, strings.Join(candidates, ", "))
+}
+
 func pull(ghost GhostBranch, we WorkingEnv) errors.GitGhostError {
-	return git.ResetHardToBranch(we.GhostDir, git.ORIGIN+"/"+ghost.BranchName())
+	ReportProgress(ProgressPhaseFetch, ProgressStart, ghost.BranchName(), 0, 0)
+	err := git.FetchBranchesWithProgress(we.GhostDir, TransferProgress(ProgressPhaseFetch, ghost.BranchName(), 0), ghost.BranchName())
+	if err != nil {
+		return err
+	}
+	err = git.ResetHardToBranch(we.GhostDir, git.ORIGIN+"/"+ghost.BranchName())
+	if err != nil {
+		return err
+	}
+	err = checkFormatVersion(we.GhostDir, "HEAD", ghost)
+	if err != nil || progressReporter == nil {
+		return err
+	}
+	size, err := git.GetTreeSize(we.GhostDir, "HEAD")
+	if err != nil {
+		return err
+	}
+	ReportProgress(ProgressPhaseFetch, ProgressDone, ghost.BranchName(), size, size)
+	return nil
+}
+
+// extractPatchChain extracts patch files of a ghost branch and its ancestors in the order to be applied
+func extractPatchChain(ghostDir, committish, filename string) ([]string, errors.GitGhostError) {
+	commits, ggerr := git.ListFirstParentCommits(ghostDir, committish)
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	patches := make([]string, 0, len(commits))
+	for _, commit := range commits {
+		patch, ggerr := extractGhostFileToTemp(ghostDir, commit, filename)
+		if patch != "" {
+			patches = append(patches, patch)
+		}
+		if ggerr != nil {
+			return patches, ggerr
+		}
+	}
+	return patches, nil
+}
+
+func removeFiles(filepaths []string) {
+	for _, p := range filepaths {
+		util.LogDeferredError(func() error { return os.Remove(p) })
+	}
 }
 
 func resolveCommittishOr(srcDir string, committishToResolve string) string {
@@ -338,7 +1089,7 @@ func resolveFilepath(dir, p string) (string, errors.GitGhostError) {
 	if strings.HasPrefix(relp, "../") {
 		return "", errors.Errorf("%s is not located in the source directory", p)
 	}
-	isdir, err := util.IsDir(relp)
+	isdir, err := util.IsDir(absp)
 	if err != nil {
 		return "", errors.WithStack(err)
 	}
@@ -0,0 +1,178 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ApplyCheck is a result of checking whether ghost branches apply to the source directory as it is
+type ApplyCheck struct {
+	// Branches are the ghost branches checked in the order they are applied
+	Branches []string `json:"branches"`
+	// Clean is true if all of them apply cleanly
+	Clean bool `json:"clean"`
+	// Conflicts are files which some hunks don't apply to
+	Conflicts []string `json:"conflicts"`
+	// Error is why applying would fail
+	Error string `json:"error,omitempty"`
+}
+
+// ApplyChecker collects patches of ghost branches pulled one by one, and checks them against the source directory at once
+//
+// Nothing is applied. Only Directory, Strip, Paths, StrictSourceRepo and Force of ApplyOptions are taken into account.
+type ApplyChecker struct {
+	we       WorkingEnv
+	opts     ApplyOptions
+	branches []string
+	patches  []string
+	// failure is why applying would fail found before checking the patches, e.g. commits of a bundle which don't fast-forward HEAD
+	failure errors.GitGhostError
+}
+
+// NewApplyChecker returns an ApplyChecker on passed working env
+func NewApplyChecker(we WorkingEnv, opts ApplyOptions) *ApplyChecker {
+	return &ApplyChecker{
+		we:       we,
+		opts:     opts,
+		branches: []string{},
+		patches:  []string{},
+	}
+}
+
+// Add collects patches of a ghost branch, which must be pulled into HEAD of the ghost directory
+func (c *ApplyChecker) Add(ghost GhostBranch) errors.GitGhostError {
+	c.branches = append(c.branches, ghost.BranchName())
+	err := checkSourceRepo(ghost, c.we, c.opts)
+	if err != nil {
+		return err
+	}
+	err = c.opts.validateDirectory(c.we.SrcDir)
+	if err != nil {
+		return err
+	}
+
+	switch g := ghost.(type) {
+	case *CommitsBranch:
+		if len(c.opts.Paths) > 0 {
+			return errors.New("paths are not supported for commits, which are applied entirely")
+		}
+		c.warnUnexpectedHead(ghost, g.CommitHashFrom)
+		patch, err := extractGhostFileToTemp(c.we.GhostDir, "HEAD", ghost.FileName())
+		if !g.Bundle {
+			c.patches = append(c.patches, patch)
+			return err
+		}
+		defer removeFiles([]string{patch})
+		if err != nil {
+			return err
+		}
+		if c.opts.Directory != "" || c.opts.Strip > 1 {
+			return errors.New("directory and strip are not supported for commits pushed as a bundle, which are applied as they are")
+		}
+		err = git.VerifyCommitGhostBundle(c.we.SrcDir, patch)
+		if err != nil {
+			return err
+		}
+		// a bundle is applied by fast-forwarding, which fails only if HEAD diverges from its base
+		srcHead, err := git.ResolveCommittish(c.we.SrcDir, "HEAD")
+		if err != nil {
+			return err
+		}
+		_, err = checkFastForward(c.we.SrcDir, srcHead, g.CommitHashFrom)
+		if err != nil && errors.CategoryOf(err) == errors.CategoryConflict && c.failure == nil {
+			c.failure = err
+			return nil
+		}
+		return err
+	case *DiffBranch:
+		c.warnUnexpectedHead(ghost, g.CommitHashFrom)
+		patches, err := extractPatchChain(c.we.GhostDir, "HEAD", ghost.FileName())
+		c.patches = append(c.patches, patches...)
+		if err != nil {
+			return err
+		}
+		if len(c.opts.Paths) > 0 {
+			return filterPatchChain(c.we.SrcDir, ghost, patches, c.opts.Paths)
+		}
+		return nil
+	default:
+		return errors.Errorf("not supported on type = %+v", reflect.TypeOf(ghost))
+	}
+}
+
+// warnUnexpectedHead warns HEAD of the source directory is not the base of ghost like applying it
+func (c *ApplyChecker) warnUnexpectedHead(ghost GhostBranch, base string) {
+	srcHead, err := git.ResolveCommittish(c.we.SrcDir, "HEAD")
+	if err != nil || srcHead == base {
+		return
+	}
+	log.WithFields(log.Fields{
+		"branch":          ghost.BranchName(),
+		"actualSrcHead":   srcHead,
+		"expectedSrcHead": base,
+		"srcDir":          c.we.SrcDir,
+	}).Warn("HEAD is not equal to expected. Applying ghost branch might be failed.")
+}
+
+// Check checks the collected patches apply to the working tree of the source directory in order
+//
+// Patches which don't apply are not an error, but a result.
+func (c *ApplyChecker) Check() (*ApplyCheck, errors.GitGhostError) {
+	result := &ApplyCheck{
+		Branches:  c.branches,
+		Conflicts: []string{},
+	}
+	if c.failure != nil {
+		result.Error = firstLine(c.failure)
+		return result, nil
+	}
+	operation, err := git.OperationInProgress(c.we.SrcDir)
+	if err != nil {
+		return nil, err
+	}
+	if operation != "" && !c.opts.Force {
+		result.Error = fmt.Sprintf("'git %s' is in progress in %s", operation, c.we.SrcDir)
+		return result, nil
+	}
+	conflicts, err := git.CheckPatchFiles(c.we.SrcDir, c.patches, c.opts.patchPathOptions())
+	if err != nil {
+		if errors.CategoryOf(err) != errors.CategoryConflict {
+			return nil, err
+		}
+		result.Conflicts = conflicts
+		result.Error = firstLine(err)
+		return result, nil
+	}
+	result.Clean = true
+	return result, nil
+}
+
+// Clean removes the collected patches
+func (c *ApplyChecker) Clean() {
+	removeFiles(c.patches)
+	c.patches = []string{}
+}
+
+func firstLine(err errors.GitGhostError) string {
+	return strings.SplitN(strings.TrimSpace(err.Error()), "\n", 2)[0]
+}
@@ -0,0 +1,138 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// CIEnvironment represents a CI job which ghost branches are pushed from
+type CIEnvironment struct {
+	// System is a name of the CI system (github-actions, gitlab-ci or jenkins)
+	System string
+	// Commit is a commit hash which the job runs on
+	Commit string
+	// Branch is a branch name which the job runs on
+	Branch string
+	// JobURL is a url of the job
+	JobURL string
+}
+
+// ciTrailers are trailers in messages of ghost commits recording CI jobs, in the order written
+var ciTrailers = []struct {
+	key   string
+	value func(ci *CIEnvironment) *string
+}{
+	{"Git-Ghost-CI-System", func(ci *CIEnvironment) *string { return &ci.System }},
+	{"Git-Ghost-CI-Commit", func(ci *CIEnvironment) *string { return &ci.Commit }},
+	{"Git-Ghost-CI-Branch", func(ci *CIEnvironment) *string { return &ci.Branch }},
+	{"Git-Ghost-CI-Job-URL", func(ci *CIEnvironment) *string { return &ci.JobURL }},
+}
+
+var ciTrailerPattern = regexp.MustCompile(`(?m)^(Git-Ghost-CI-[A-Za-z-]+): *(.*?) *$`)
+
+var ciEnvironment *CIEnvironment
+
+// SetCIEnvironment sets a CI job recorded in ghost commits created afterwards (nil records nothing)
+func SetCIEnvironment(ci *CIEnvironment) {
+	ciEnvironment = ci
+}
+
+// DetectCIEnvironment detects a CI job from environment variables which CI systems set, and returns nil outside of CI
+func DetectCIEnvironment(getenv func(string) string) *CIEnvironment {
+	firstOf := func(keys ...string) string {
+		for _, key := range keys {
+			if v := getenv(key); v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	switch {
+	case getenv("GITHUB_ACTIONS") == "true":
+		ci := &CIEnvironment{
+			System: "github-actions",
+			Commit: getenv("GITHUB_SHA"),
+			// GITHUB_HEAD_REF is the source branch of a pull request
+			Branch: firstOf("GITHUB_HEAD_REF", "GITHUB_REF_NAME"),
+		}
+		if getenv("GITHUB_REPOSITORY") != "" && getenv("GITHUB_RUN_ID") != "" {
+			server := getenv("GITHUB_SERVER_URL")
+			if server == "" {
+				server = "https://github.com"
+			}
+			ci.JobURL = fmt.Sprintf("%s/%s/actions/runs/%s", server, getenv("GITHUB_REPOSITORY"), getenv("GITHUB_RUN_ID"))
+		}
+		return ci
+	case getenv("GITLAB_CI") == "true":
+		return &CIEnvironment{
+			System: "gitlab-ci",
+			Commit: getenv("CI_COMMIT_SHA"),
+			Branch: firstOf("CI_MERGE_REQUEST_SOURCE_BRANCH_NAME", "CI_COMMIT_REF_NAME"),
+			JobURL: getenv("CI_JOB_URL"),
+		}
+	case getenv("JENKINS_URL") != "":
+		return &CIEnvironment{
+			System: "jenkins",
+			Commit: getenv("GIT_COMMIT"),
+			Branch: firstOf("CHANGE_BRANCH", "BRANCH_NAME", "GIT_BRANCH"),
+			JobURL: getenv("BUILD_URL"),
+		}
+	}
+	return nil
+}
+
+// trailers returns trailers recording ci, skipping empty values
+func (ci *CIEnvironment) trailers() string {
+	lines := []string{}
+	for _, t := range ciTrailers {
+		// a newline in a value would break the trailer
+		value := strings.Join(strings.Fields(*t.value(ci)), " ")
+		if value != "" {
+			lines = append(lines, fmt.Sprintf("%s: %s", t.key, value))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// PrettyString returns lines like "CI-System: github-actions" of non-empty values of ci
+func (ci *CIEnvironment) PrettyString() string {
+	var buffer bytes.Buffer
+	for _, t := range ciTrailers {
+		if value := *t.value(ci); value != "" {
+			buffer.WriteString(fmt.Sprintf("%s: %s\n", strings.TrimPrefix(t.key, "Git-Ghost-"), value))
+		}
+	}
+	return buffer.String()
+}
+
+// parseCIEnvironment parses a CI job recorded in a message of a ghost commit, and returns nil if it is not recorded
+func parseCIEnvironment(message string) *CIEnvironment {
+	var ci *CIEnvironment
+	for _, m := range ciTrailerPattern.FindAllStringSubmatch(message, -1) {
+		for _, t := range ciTrailers {
+			if t.key == m[1] {
+				if ci == nil {
+					ci = &CIEnvironment{}
+				}
+				*t.value(ci) = m[2]
+			}
+		}
+	}
+	return ci
+}
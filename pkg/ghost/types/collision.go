@@ -0,0 +1,120 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// policies of ApplyOptions.UntrackedCollision for an untracked file in the way of a file created by a diff
+const (
+	// CollisionSkip leaves the untracked file and doesn't apply changes of the diff to it
+	CollisionSkip = "skip"
+	// CollisionOverwrite removes the untracked file so that the diff creates it
+	CollisionOverwrite = "overwrite"
+	// CollisionBackup copies the untracked file into BackupDir and overwrites it
+	CollisionBackup = "backup"
+)
+
+// CollisionPolicies are all the policies of ApplyOptions.UntrackedCollision
+var CollisionPolicies = []string{CollisionSkip, CollisionOverwrite, CollisionBackup}
+
+// UntrackedCollision is an untracked file which was in the way of a file created by a diff
+type UntrackedCollision struct {
+	Path string `json:"path"`
+	// Policy is how the collision is resolved, which is one of CollisionPolicies
+	Policy string `json:"policy"`
+	// BackupDir is a directory which the untracked file is copied into by CollisionBackup
+	BackupDir string `json:"backupDir,omitempty"`
+}
+
+// resolveUntrackedCollisions finds untracked files in srcDir which patches of a diff would create and resolves them by policy before applying
+//
+// Files which are skipped are removed from patches, including their changes by later patches of an incremental chain.
+func resolveUntrackedCollisions(srcDir string, patches []string, pathOpts git.PatchPathOptions, policy string) ([]UntrackedCollision, errors.GitGhostError) {
+	created := []string{}
+	for _, p := range patches {
+		paths, err := git.ListCreatedPaths(srcDir, p, pathOpts)
+		if err != nil {
+			return nil, err
+		}
+		created = append(created, paths...)
+	}
+	existing := []string{}
+	for _, p := range util.UniqueStringSlice(created) {
+		fi, err := os.Lstat(filepath.Join(srcDir, p))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		// a directory in the way is not a file to be resolved, and git fails as usual
+		if !fi.IsDir() {
+			existing = append(existing, p)
+		}
+	}
+	tracked, err := git.ListTrackedPaths(srcDir, existing)
+	if err != nil {
+		return nil, err
+	}
+	untracked := util.SubtractStringSlice(existing, tracked)
+	if len(untracked) == 0 {
+		return []UntrackedCollision{}, nil
+	}
+
+	backupDir := ""
+	switch policy {
+	case CollisionSkip:
+		for _, p := range patches {
+			err := git.ExcludeDiffSections(srcDir, p, untracked, pathOpts)
+			if err != nil {
+				return nil, err
+			}
+		}
+	case CollisionBackup:
+		backupDir, err = backupFiles(srcDir, untracked)
+		if err != nil {
+			return nil, err
+		}
+		fallthrough
+	case CollisionOverwrite:
+		for _, p := range untracked {
+			err := os.Remove(filepath.Join(srcDir, p))
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
+	default:
+		return nil, errors.Errorf("unknown untracked collision policy %s", policy)
+	}
+	collisions := make([]UntrackedCollision, 0, len(untracked))
+	for _, p := range untracked {
+		log.WithFields(log.Fields{
+			"path":      p,
+			"policy":    policy,
+			"backupDir": backupDir,
+		}).Warn("untracked file is in the way of a file created by the ghost")
+		collisions = append(collisions, UntrackedCollision{Path: p, Policy: policy, BackupDir: backupDir})
+	}
+	return collisions, nil
+}
@@ -0,0 +1,336 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// CombinedVersion is a version of the format of combined patches, which is bumped on an incompatible change
+	CombinedVersion = 1
+
+	combinedHeader        = "# git-ghost combined patch v"
+	combinedManifestEnd   = "# end of manifest"
+	combinedSectionMarker = "### git-ghost section"
+	combinedEndMarker     = "### git-ghost end of section"
+
+	// CombinedSectionCommits is a type of a section holding patches of commits in the mbox format as 'git format-patch' writes
+	CombinedSectionCommits = "commits"
+	// CombinedSectionDiff is a type of a section holding a diff as 'git diff' writes
+	CombinedSectionDiff = "diff"
+)
+
+// CombinedSection is a section of a combined patch, which is applied in the order of sections
+type CombinedSection struct {
+	// Type is CombinedSectionCommits or CombinedSectionDiff
+	Type string
+	// Branch is a ghost branch which the section comes from
+	Branch string
+	// File is a path of a temporary file holding the patch of the section, which is removed by RemoveCombinedSections
+	File string
+	// Size is the size of the patch in bytes
+	Size int64
+}
+
+// RemoveCombinedSections removes temporary files of sections
+func RemoveCombinedSections(sections []CombinedSection) {
+	for _, s := range sections {
+		removeFiles([]string{s.File})
+	}
+}
+
+// CombinedSections returns sections of ghost pulled into we in the order to apply
+//
+// Commits pushed as a bundle are written as patches of the commits, which requires the source directory to have their base.
+// An incremental diff has a section for each diff in its chain from the oldest one.
+// Patches are kept in temporary files, which the caller removes by RemoveCombinedSections.
+func CombinedSections(we WorkingEnv, ghost GhostBranch) ([]CombinedSection, errors.GitGhostError) {
+	switch b := ghost.(type) {
+	case *CommitsBranch:
+		f, err := ioutil.TempFile(util.TempDir(), "git-ghost-patch")
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		ggerr := b.Show(we, f)
+		util.LogDeferredError(f.Close)
+		if ggerr != nil {
+			removeFiles([]string{f.Name()})
+			return nil, ggerr
+		}
+		sections, ggerr := combinedSectionsOf(CombinedSectionCommits, b.BranchName(), []string{f.Name()})
+		if ggerr != nil {
+			removeFiles([]string{f.Name()})
+		}
+		return sections, ggerr
+	case *DiffBranch:
+		patches, err := extractPatchChain(we.GhostDir, "HEAD", b.FileName())
+		if err == nil {
+			var sections []CombinedSection
+			sections, err = combinedSectionsOf(CombinedSectionDiff, b.BranchName(), patches)
+			if err == nil {
+				return sections, nil
+			}
+		}
+		removeFiles(patches)
+		return nil, err
+	}
+	return nil, errors.Errorf("unsupported ghost branch: %s", ghost.BranchName())
+}
+
+func combinedSectionsOf(sectionType, branch string, patches []string) ([]CombinedSection, errors.GitGhostError) {
+	sections := make([]CombinedSection, 0, len(patches))
+	for _, p := range patches {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		sections = append(sections, CombinedSection{Type: sectionType, Branch: branch, File: p, Size: info.Size()})
+	}
+	return sections, nil
+}
+
+// WriteCombined writes sections as a combined patch, which starts with a manifest of the sections in the order to apply
+//
+// Patches are copied from their files one by one, so a patch is never held in memory as a whole.
+func WriteCombined(writer io.Writer, sections []CombinedSection) errors.GitGhostError {
+	w := bufio.NewWriter(writer)
+	fmt.Fprintf(w, "%s%d\n", combinedHeader, CombinedVersion)
+	fmt.Fprintf(w, "# apply the sections in this order by 'git-ghost apply-combined'\n")
+	for i, s := range sections {
+		fmt.Fprintf(w, "# %d %s %s %d\n", i+1, s.Type, s.Branch, s.Size)
+	}
+	fmt.Fprintf(w, "%s\n", combinedManifestEnd)
+	for i, s := range sections {
+		fmt.Fprintf(w, "\n%s %d %s %s\n", combinedSectionMarker, i+1, s.Type, s.Branch)
+		last, err := copyCombinedSection(w, s)
+		if err != nil {
+			return err
+		}
+		if s.Size > 0 && last != '\n' {
+			_ = w.WriteByte('\n')
+		}
+		fmt.Fprintf(w, "%s %d\n", combinedEndMarker, i+1)
+	}
+	return errors.WithStack(w.Flush())
+}
+
+// copyCombinedSection copies the patch of section into w and returns its last byte
+func copyCombinedSection(w io.Writer, section CombinedSection) (byte, errors.GitGhostError) {
+	f, err := os.Open(section.File)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	defer util.LogDeferredError(f.Close)
+	lw := &lastByteWriter{w: w}
+	n, err := io.Copy(lw, f)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	if n != section.Size {
+		return 0, errors.Errorf("size of section of %s changed from %d to %d bytes", section.Branch, section.Size, n)
+	}
+	return lw.last, nil
+}
+
+// lastByteWriter is a writer remembering the last byte written through it
+type lastByteWriter struct {
+	w    io.Writer
+	last byte
+}
+
+func (lw *lastByteWriter) Write(p []byte) (int, error) {
+	n, err := lw.w.Write(p)
+	if n > 0 {
+		lw.last = p[n-1]
+	}
+	return n, err
+}
+
+// ReadCombined reads sections of a combined patch written by WriteCombined
+//
+// Sections are read by their sizes in the manifest and checked against their markers, so a patch is never cut at a line looking like a marker.
+// Each patch is streamed into a temporary file, which the caller removes by RemoveCombinedSections. No file is left on an error.
+func ReadCombined(reader io.Reader) ([]CombinedSection, errors.GitGhostError) {
+	sections, err := readCombined(reader)
+	if err != nil {
+		RemoveCombinedSections(sections)
+		return nil, err
+	}
+	return sections, nil
+}
+
+func readCombined(reader io.Reader) ([]CombinedSection, errors.GitGhostError) {
+	r := bufio.NewReader(reader)
+	line, err := readCombinedLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, combinedHeader) {
+		return nil, errors.New("not a combined patch of git-ghost")
+	}
+	version, converr := strconv.Atoi(strings.TrimPrefix(line, combinedHeader))
+	if converr != nil || version != CombinedVersion {
+		return nil, errors.Errorf("unsupported version of combined patch: %s", strings.TrimPrefix(line, combinedHeader))
+	}
+	sizes := []int64{}
+	sections := []CombinedSection{}
+	for {
+		line, err := readCombinedLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if line == combinedManifestEnd {
+			break
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, "#"))
+		if len(fields) != 4 || fields[0] != strconv.Itoa(len(sections)+1) {
+			// a comment
+			continue
+		}
+		size, converr := strconv.ParseInt(fields[3], 10, 64)
+		if converr != nil || size < 0 || (fields[1] != CombinedSectionCommits && fields[1] != CombinedSectionDiff) {
+			return nil, errors.Errorf("invalid section in the manifest of combined patch: %s", line)
+		}
+		sections = append(sections, CombinedSection{Type: fields[1], Branch: fields[2]})
+		sizes = append(sizes, size)
+	}
+	read := []CombinedSection{}
+	for i := range sections {
+		marker := fmt.Sprintf("%s %d %s %s", combinedSectionMarker, i+1, sections[i].Type, sections[i].Branch)
+		for {
+			line, err := readCombinedLine(r)
+			if err != nil {
+				return read, errors.Errorf("section %d of combined patch is missing: %s", i+1, err)
+			}
+			if line == marker {
+				break
+			}
+			if line != "" {
+				return read, errors.Errorf("expected the marker of section %d of combined patch but got: %s", i+1, line)
+			}
+		}
+		f, ioerr := ioutil.TempFile(util.TempDir(), "git-ghost-patch")
+		if ioerr != nil {
+			return read, errors.WithStack(ioerr)
+		}
+		sections[i].File = f.Name()
+		sections[i].Size = sizes[i]
+		read = append(read, sections[i])
+		lw := &lastByteWriter{w: f}
+		_, ioerr = io.CopyN(lw, r, sizes[i])
+		util.LogDeferredError(f.Close)
+		if ioerr == io.EOF {
+			return read, errors.Errorf("section %d of combined patch is truncated", i+1)
+		}
+		if ioerr != nil {
+			return read, errors.WithStack(ioerr)
+		}
+		if sizes[i] > 0 && lw.last != '\n' {
+			_, _ = r.ReadString('\n')
+		}
+		line, err := readCombinedLine(r)
+		if err != nil || line != fmt.Sprintf("%s %d", combinedEndMarker, i+1) {
+			return read, errors.Errorf("section %d of combined patch does not end with its marker, whose size might be wrong", i+1)
+		}
+	}
+	return sections, nil
+}
+
+func readCombinedLine(r *bufio.Reader) (string, errors.GitGhostError) {
+	line, err := r.ReadString('\n')
+	if err == io.EOF && line != "" {
+		return line, nil
+	}
+	if err == io.EOF {
+		return "", errors.New("unexpected end of combined patch")
+	}
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return strings.TrimSuffix(line, "\n"), nil
+}
+
+// ApplyCombined applies sections of a combined patch on srcDir in their order
+//
+// Applying stops at the first section failing, whose number is in the error. Sections applied before it are left applied.
+func ApplyCombined(srcDir string, sections []CombinedSection) errors.GitGhostError {
+	if len(sections) > 0 {
+		err := checkCombinedBase(srcDir, sections[0])
+		if err != nil {
+			return err
+		}
+	}
+	for i, s := range sections {
+		log.WithFields(log.Fields{
+			"section": i + 1,
+			"type":    s.Type,
+			"branch":  s.Branch,
+			"srcDir":  srcDir,
+		}).Info("applying section of combined patch")
+		err := applyCombinedSection(srcDir, s)
+		if err != nil {
+			return errors.WithCategory(errors.Errorf("failed to apply section %d (%s of %s) of combined patch after applying %d section(s): %s", i+1, s.Type, s.Branch, i, err), errors.CategoryOf(err))
+		}
+	}
+	return nil
+}
+
+// checkCombinedBase warns about HEAD of srcDir other than the base of the first section, as pulling does
+func checkCombinedBase(srcDir string, first CombinedSection) errors.GitGhostError {
+	var base string
+	switch b := CreateGhostBranchByName(first.Branch).(type) {
+	case *CommitsBranch:
+		base = b.CommitHashFrom
+	case *DiffBranch:
+		base = b.CommitHashFrom
+	default:
+		return nil
+	}
+	srcHead, err := git.ResolveCommittish(srcDir, "HEAD")
+	if err != nil {
+		return err
+	}
+	if srcHead != base {
+		log.WithFields(log.Fields{
+			"actualSrcHead":   srcHead,
+			"expectedSrcHead": base,
+			"srcDir":          srcDir,
+		}).Warn("HEAD is not equal to the base of the combined patch. Applying it might be failed.")
+	}
+	return nil
+}
+
+func applyCombinedSection(srcDir string, section CombinedSection) errors.GitGhostError {
+	if section.Size == 0 {
+		return nil
+	}
+	if section.Type == CombinedSectionCommits {
+		return git.ApplyDiffBundleFile(srcDir, section.File, git.PatchPathOptions{})
+	}
+	return git.ApplyDiffPatchFile(srcDir, section.File, git.PatchPathOptions{})
+}
@@ -0,0 +1,201 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// A ghost file is stored with a file "<file name>.compression" containing the codec it is compressed by,
+// which is "none" if it is stored as it is because it is smaller than the threshold or compression is disabled.
+// Ghost files without it are created before compression, and are stored as they are.
+const compressionSuffix = ".compression"
+
+// Codecs of compression of ghost files
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// Compressions is the list of codecs which ghost files can be compressed by
+var Compressions = []string{CompressionGzip, CompressionZstd, CompressionNone}
+
+// DefaultCompressMinSize is the size in bytes from which ghost files are compressed unless SetCompressMinSize is called
+const DefaultCompressMinSize = 1024 * 1024
+
+var compressMinSize int64 = DefaultCompressMinSize
+
+var compression = CompressionGzip
+
+// SetCompressMinSize sets the size in bytes from which ghost files are compressed on push, and smaller ones are stored as they are
+func SetCompressMinSize(size int64) {
+	compressMinSize = size
+}
+
+// SetCompression sets the codec which ghost files are compressed by on push, one of Compressions
+func SetCompression(codec string) {
+	compression = codec
+}
+
+// compressionOf returns the codec which a ghost file at committish on ghostDir is compressed by, or CompressionNone if it isn't
+func compressionOf(ghostDir, committish, fileName string) (string, errors.GitGhostError) {
+	recorded, ggerr := git.FileExistsAt(ghostDir, committish, fileName+compressionSuffix)
+	if ggerr != nil || !recorded {
+		return CompressionNone, ggerr
+	}
+	f, err := ioutil.TempFile(util.TempDir(), "git-ghost-compression")
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	util.LogDeferredError(f.Close)
+	defer util.LogDeferredError(func() error { return os.Remove(f.Name()) })
+	ggerr = git.ExtractFile(ghostDir, committish, fileName+compressionSuffix, f.Name())
+	if ggerr != nil {
+		return "", ggerr
+	}
+	content, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// storedCompression returns the codec which a ghost file stored in dstDir is compressed by, or CompressionNone if it isn't
+func storedCompression(dstDir, fileName string) string {
+	content, err := ioutil.ReadFile(filepath.Join(dstDir, fileName+compressionSuffix))
+	if err != nil {
+		return CompressionNone
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// compressOnPush compresses srcPath if it is not smaller than the threshold, and records the codec of fileName in dstDir
+//
+// It returns a path of the compressed content, which must be removed by the caller if it differs from srcPath.
+func compressOnPush(dstDir, srcPath, fileName string) (string, errors.GitGhostError) {
+	size, ggerr := util.FileSize(srcPath)
+	if ggerr != nil {
+		return srcPath, ggerr
+	}
+	codec := compression
+	compressed := srcPath
+	if size < compressMinSize {
+		codec = CompressionNone
+	}
+	var compress func(dst io.Writer, src io.Reader) error
+	switch codec {
+	case CompressionGzip:
+		compress = func(dst io.Writer, src io.Reader) error {
+			zw := gzip.NewWriter(dst)
+			_, err := io.Copy(zw, src)
+			if err != nil {
+				return err
+			}
+			return zw.Close()
+		}
+	case CompressionZstd:
+		compress = func(dst io.Writer, src io.Reader) error {
+			zw, err := zstd.NewWriter(dst)
+			if err != nil {
+				return err
+			}
+			_, err = zw.ReadFrom(src)
+			if err != nil {
+				zw.Close()
+				return err
+			}
+			return zw.Close()
+		}
+	}
+	if compress != nil {
+		compressed, ggerr = transformToTemp(srcPath, "git-ghost-compressed", compress)
+		if ggerr != nil {
+			return compressed, ggerr
+		}
+	}
+	return compressed, errors.WithStack(ioutil.WriteFile(filepath.Join(dstDir, fileName+compressionSuffix), []byte(codec+"\n"), 0600))
+}
+
+// decompressOnPull decompresses a ghost file at committish on ghostDir extracted to path in place, if it is compressed
+func decompressOnPull(ghostDir, committish, fileName, path string) errors.GitGhostError {
+	codec, ggerr := compressionOf(ghostDir, committish, fileName)
+	if ggerr != nil || codec == CompressionNone {
+		return ggerr
+	}
+	var decompress func(dst io.Writer, src io.Reader) error
+	switch codec {
+	case CompressionGzip:
+		decompress = func(dst io.Writer, src io.Reader) error {
+			zr, err := gzip.NewReader(src)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(dst, zr)
+			return err
+		}
+	case CompressionZstd:
+		decompress = func(dst io.Writer, src io.Reader) error {
+			zr, err := zstd.NewReader(src)
+			if err != nil {
+				return err
+			}
+			defer zr.Close()
+			_, err = zr.WriteTo(dst)
+			return err
+		}
+	default:
+		return errors.Errorf("%s is compressed by an unknown codec %q; please upgrade git-ghost", fileName, codec)
+	}
+	decompressed, ggerr := transformToTemp(path, "git-ghost-decompressed", decompress)
+	if ggerr != nil {
+		removeFiles([]string{decompressed})
+		return errors.Errorf("failed to decompress %s by %s: %s", fileName, codec, ggerr)
+	}
+	return errors.WithStack(os.Rename(decompressed, path))
+}
+
+// transformToTemp streams a content of srcPath through transform into a temporary file and returns its path
+//
+// The returned path must be removed by the caller even if an error is returned.
+func transformToTemp(srcPath, pattern string, transform func(dst io.Writer, src io.Reader) error) (string, errors.GitGhostError) {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer util.LogDeferredError(src.Close)
+	dst, err := ioutil.TempFile(util.TempDir(), pattern)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer util.LogDeferredError(dst.Close)
+	return dst.Name(), errors.WithStack(transform(dst, src))
+}
+
+// isCompressed checks a ghost file at committish on ghostDir is stored after being compressed or not
+func isCompressed(ghostDir, committish, fileName string) (bool, errors.GitGhostError) {
+	codec, ggerr := compressionOf(ghostDir, committish, fileName)
+	return codec != CompressionNone, ggerr
+}
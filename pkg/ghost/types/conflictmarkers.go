@@ -0,0 +1,53 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// conflictMarkerRule matches lines which git writes around a conflict, where "=======" alone is left out since it is common in text files (e.g. headings)
+var conflictMarkerRule = git.SecretRule{Name: "conflict marker", Pattern: regexp.MustCompile(`^(<{7}|>{7}|\|{7})( |$)`)}
+
+// checkConflictMarkers fails if lines added by a patch file are conflict markers, listing files which have them
+//
+// Added lines are scanned in the same way as secrets, so only files changed by the patch are.
+func checkConflictMarkers(patch string) errors.GitGhostError {
+	findings, err := git.ScanPatchSecrets(patch, []git.SecretRule{conflictMarkerRule})
+	if err != nil {
+		return err
+	}
+	if len(findings) == 0 {
+		return nil
+	}
+	paths := []string{}
+	lines := map[string][]string{}
+	for _, f := range findings {
+		if _, ok := lines[f.Path]; !ok {
+			paths = append(paths, f.Path)
+		}
+		lines[f.Path] = append(lines[f.Path], fmt.Sprint(f.Line))
+	}
+	files := make([]string, 0, len(paths))
+	for _, p := range paths {
+		files = append(files, fmt.Sprintf("  %s (line %s)", p, strings.Join(lines[p], ", ")))
+	}
+	return errors.Errorf("conflict markers are found in the ghost, which is not pushed:\n%s\nplease resolve the conflicts, or push with --allow-conflict-markers if they are intended", strings.Join(files, "\n"))
+}
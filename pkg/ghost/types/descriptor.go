@@ -0,0 +1,121 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"fmt"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// Descriptor fully describes a ghost branch stored in a ghost repo
+type Descriptor struct {
+	Provenance
+	// Type is "commits" or "diff"
+	Type string
+	// Ref is a full ref name of the ghost branch in the ghost repo
+	Ref string
+	// From is a remote base commit of a commits branch or a local base commit of a diff branch
+	From string
+	// To is a local base commit of a commits branch
+	To string
+	// Hash is a diff hash of a diff branch
+	Hash string
+	// File is a name of the ghost file
+	File string
+	// Bundle is true if the commits are stored as a git bundle
+	Bundle bool
+	// Split is true if the ghost file is stored in parts
+	Split bool
+	// Piped is true if the ghost file is stored after being piped through a command
+	Piped bool
+	// Compression is a codec which the ghost file is compressed by, or CompressionNone if it is stored as it is
+	Compression string
+	// Attachments is true if binary files of the diff are stored as blobs next to it
+	Attachments bool
+	// Incremental is true if the diff is stored on top of its ancestors
+	Incremental bool
+	// Size is the total size in bytes of files in the ghost branch
+	Size int64
+	// Commit is a hash of the ghost commit at the tip of the ghost branch
+	Commit string
+	// Checksum is a hash of the tree of the ghost commit, which changes whenever stored contents change
+	Checksum string
+}
+
+// Describe returns a descriptor of a ghost branch at committish on ghostDir
+func Describe(ghostDir, committish string, ghost GhostBranch) (*Descriptor, errors.GitGhostError) {
+	d := Descriptor{Ref: fmt.Sprintf("refs/heads/%s", ghost.BranchName())}
+	var err errors.GitGhostError
+	switch b := ghost.(type) {
+	case *CommitsBranch:
+		d.Type = "commits"
+		d.From = b.CommitHashFrom
+		d.To = b.CommitHashTo
+		b.Bundle, err = ghostFileExists(ghostDir, committish, commitsBundleFileName)
+		if err != nil {
+			return nil, err
+		}
+		d.Bundle = b.Bundle
+	case *DiffBranch:
+		d.Type = "diff"
+		d.From = b.CommitHashFrom
+		d.Hash = b.DiffHash
+		d.Attachments, err = hasAttachments(ghostDir, committish, b.FileName())
+		if err != nil {
+			return nil, err
+		}
+		commits, err := git.ListFirstParentCommits(ghostDir, committish)
+		if err != nil {
+			return nil, err
+		}
+		d.Incremental = len(commits) > 1
+	default:
+		return nil, errors.Errorf("unknown ghost branch %s", ghost.BranchName())
+	}
+	d.File = ghost.FileName()
+	d.Split, err = git.FileExistsAt(ghostDir, committish, d.File+partsManifestSuffix)
+	if err != nil {
+		return nil, err
+	}
+	d.Piped, err = isPiped(ghostDir, committish, d.File)
+	if err != nil {
+		return nil, err
+	}
+	d.Compression, err = compressionOf(ghostDir, committish, d.File)
+	if err != nil {
+		return nil, err
+	}
+
+	provenance, err := GetProvenance(ghostDir, committish, ghost)
+	if err != nil {
+		return nil, err
+	}
+	d.Provenance = *provenance
+	d.Size, err = git.GetTreeSize(ghostDir, committish)
+	if err != nil {
+		return nil, err
+	}
+	d.Commit, err = git.ResolveCommittish(ghostDir, committish)
+	if err != nil {
+		return nil, err
+	}
+	d.Checksum, err = git.ResolveTree(ghostDir, committish)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
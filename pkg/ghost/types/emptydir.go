@@ -0,0 +1,110 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Empty directories kept with a diff are listed line by line in "<file name>.empty-dirs" next to the diff
+const emptyDirsSuffix = ".empty-dirs"
+
+// emptyDirs returns empty directories in srcDir to be kept with the diff if required by the spec
+func (bs DiffBranchSpec) emptyDirs(srcDir string) ([]string, errors.GitGhostError) {
+	if !bs.KeepEmptyDirs {
+		return []string{}, nil
+	}
+	return git.ListEmptyDirs(srcDir)
+}
+
+// diffContentHash returns a content hash of a diff patch file together with empty directories and attachments kept with it
+func diffContentHash(filepath string, emptyDirs []string, attachments []git.BinaryPatchSection) (string, errors.GitGhostError) {
+	hash, ggerr := util.GenerateFileContentHash(filepath)
+	if ggerr != nil {
+		return hash, ggerr
+	}
+	if len(emptyDirs) > 0 {
+		hash = util.GenerateStringsHash(append([]string{hash, emptyDirsSuffix}, emptyDirs...)...)
+	}
+	if len(attachments) > 0 {
+		strs := []string{hash, attachmentsSuffix}
+		for _, a := range attachments {
+			strs = append(strs, a.Header, a.Blob)
+		}
+		hash = util.GenerateStringsHash(strs...)
+	}
+	return hash, nil
+}
+
+// storeEmptyDirs writes a list of empty directories next to a ghost file stored by storeGhostFile if any
+func storeEmptyDirs(dstDir, fileName string, emptyDirs []string) errors.GitGhostError {
+	if len(emptyDirs) == 0 {
+		return nil
+	}
+	content := strings.Join(emptyDirs, "\n") + "\n"
+	return errors.WithStack(ioutil.WriteFile(filepath.Join(dstDir, fileName+emptyDirsSuffix), []byte(content), 0600))
+}
+
+// restoreEmptyDirs creates empty directories kept with a ghost file at committish on ghostDir in srcDir
+//
+// Their paths are rebased as the diff by Directory and Strip of opts, and ones not under Paths of opts are skipped.
+func restoreEmptyDirs(ghostDir, committish, fileName, srcDir string, opts ApplyOptions) errors.GitGhostError {
+	name := fileName + emptyDirsSuffix
+	exists, ggerr := git.FileExistsAt(ghostDir, committish, name)
+	if ggerr != nil || !exists {
+		return ggerr
+	}
+	path, ggerr := extractGhostFileToTemp(ghostDir, committish, name)
+	defer removeFiles([]string{path})
+	if ggerr != nil {
+		return ggerr
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, d := range strings.Split(string(content), "\n") {
+		if d == "" || (len(opts.Paths) > 0 && git.MatchPaths(d, opts.Paths) == "") {
+			continue
+		}
+		// "a/" and "b/" of paths in a diff correspond to the first component to strip
+		components := strings.Split(d, "/")
+		if opts.Strip > 1 {
+			if len(components) < opts.Strip {
+				continue
+			}
+			components = components[opts.Strip-1:]
+		}
+		d = filepath.Join(opts.Directory, filepath.Join(components...))
+		log.WithFields(log.Fields{
+			"srcDir": srcDir,
+			"dir":    d,
+		}).Debug("creating empty directory")
+		err := os.MkdirAll(filepath.Join(srcDir, d), 0755)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
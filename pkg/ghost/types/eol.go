@@ -0,0 +1,107 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// EOLsNone is a value of check-eol which checks nothing
+const EOLsNone = "none"
+
+var acceptedEOLs []string
+
+// SetAcceptedEOLs sets line endings (git.EOLLF or git.EOLCRLF) which each file changed by ghost branches created afterwards
+// must have consistently before they are stored (empty checks nothing)
+func SetAcceptedEOLs(eols []string) {
+	acceptedEOLs = eols
+}
+
+// ParseEOLs parses comma-separated line endings of check-eol, or EOLsNone for nothing
+func ParseEOLs(s string) ([]string, errors.GitGhostError) {
+	if s == "" || s == EOLsNone {
+		return []string{}, nil
+	}
+	eols := []string{}
+	for _, eol := range strings.Split(s, ",") {
+		eol = strings.TrimSpace(eol)
+		if eol != git.EOLLF && eol != git.EOLCRLF {
+			return nil, errors.Errorf("unknown line ending: %s (one of: %s|%s, or %s)", eol, git.EOLLF, git.EOLCRLF, EOLsNone)
+		}
+		eols = append(eols, eol)
+	}
+	return util.UniqueStringSlice(eols), nil
+}
+
+// checkEOLs fails if a file changed by a patch file has mixed line endings or ones which are not accepted, listing such files
+//
+// Lines are scanned by git.ScanPatchEOLs, so only lines around changes are.
+func checkEOLs(patch string) errors.GitGhostError {
+	if len(acceptedEOLs) == 0 {
+		return nil
+	}
+	files, err := git.ScanPatchEOLs(patch)
+	if err != nil {
+		return err
+	}
+	accepted := map[string]bool{}
+	for _, eol := range acceptedEOLs {
+		accepted[eol] = true
+	}
+	problems := []string{}
+	for _, f := range files {
+		found := []string{}
+		for _, eol := range []string{git.EOLLF, git.EOLCRLF} {
+			if lines, ok := f.Lines[eol]; ok {
+				found = append(found, fmt.Sprintf("%s at %s", eol, lineNumbers(lines)))
+			}
+		}
+		if len(found) > 1 {
+			problems = append(problems, fmt.Sprintf("  %s: mixed line endings (%s)", f.Path, strings.Join(found, ", ")))
+			continue
+		}
+		for eol := range f.Lines {
+			if !accepted[eol] {
+				problems = append(problems, fmt.Sprintf("  %s: %s line endings, which are not accepted (%s)", f.Path, eol, found[0]))
+			}
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.Errorf("files with unexpected line endings are found in the ghost, which is not pushed:\n%s\nplease fix them in the source repo (e.g. by eol attributes in .gitattributes and 'git add --renormalize .'), or push with --check-eol %s if they are intended", strings.Join(problems, "\n"), EOLsNone)
+}
+
+// lineNumbers returns a human-readable list of line numbers, which is truncated after a few of them
+func lineNumbers(lines []int) string {
+	const max = 3
+	s := []string{}
+	for i, line := range lines {
+		if i == max {
+			s = append(s, "...")
+			break
+		}
+		s = append(s, fmt.Sprint(line))
+	}
+	if len(lines) == 1 {
+		return "line " + s[0]
+	}
+	return "lines " + strings.Join(s, ", ")
+}
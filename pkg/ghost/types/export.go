@@ -0,0 +1,101 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"io"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ExportPatches writes commits of a local base branch into outputDir as a numbered series of patches by 'git format-patch' and returns their paths
+//
+// The commits are recreated by withGhostCommits, so hashes in the series may differ from the original ones.
+func ExportPatches(we WorkingEnv, branch *CommitsBranch, outputDir string) ([]string, errors.GitGhostError) {
+	if branch.CommitHashFrom == branch.CommitHashTo {
+		return []string{}, nil
+	}
+	log.WithFields(log.Fields{
+		"branch":    branch.BranchName(),
+		"outputDir": outputDir,
+	}).Info("exporting patches")
+	var paths []string
+	err := withGhostCommits(we, branch, func(dir, hash string) errors.GitGhostError {
+		var err errors.GitGhostError
+		paths, err = git.FormatPatches(dir, branch.CommitHashFrom, hash, outputDir)
+		return err
+	})
+	return paths, err
+}
+
+// LogFormat represents how commits are shown by WriteLog
+type LogFormat struct {
+	// Oneline shows each commit in a line like 'git log --oneline'
+	Oneline bool
+	// Stat shows files changed by each commit like 'git log --stat'
+	Stat bool
+}
+
+func (format LogFormat) args() []string {
+	args := []string{}
+	if format.Oneline {
+		args = append(args, "--oneline")
+	}
+	if format.Stat {
+		args = append(args, "--stat")
+	}
+	return args
+}
+
+// WriteLog writes commits of a local base branch to writer like 'git log' without applying them to the source directory
+//
+// The commits are recreated by withGhostCommits, so hashes in the log may differ from the original ones.
+func WriteLog(we WorkingEnv, branch *CommitsBranch, format LogFormat, writer io.Writer) errors.GitGhostError {
+	if branch.CommitHashFrom == branch.CommitHashTo {
+		return nil
+	}
+	return withGhostCommits(we, branch, func(dir, hash string) errors.GitGhostError {
+		return git.WriteLog(dir, branch.CommitHashFrom, hash, format.args(), writer)
+	})
+}
+
+// withGhostCommits calls f with a directory having commits of a local base branch on top of CommitHashFrom and the hash of the last one
+//
+// Commits pushed as a bundle are fetched into the source directory. Patches are applied on CommitHashFrom in a temporary worktree
+// to recreate the commits, so their hashes may differ from the original ones. CommitHashFrom has to exist in the source directory.
+func withGhostCommits(we WorkingEnv, branch *CommitsBranch, f func(dir, hash string) errors.GitGhostError) errors.GitGhostError {
+	file, err := extractGhostFileToTemp(we.GhostDir, "HEAD", branch.FileName())
+	defer removeFiles([]string{file})
+	if err != nil {
+		return err
+	}
+	if branch.Bundle {
+		hash, err := git.FetchCommitGhostBundle(we.SrcDir, file)
+		if err != nil {
+			return err
+		}
+		return f(we.SrcDir, hash)
+	}
+	return git.WithTemporaryWorktree(we.SrcDir, branch.CommitHashFrom, func(worktree string) errors.GitGhostError {
+		err := git.ApplyDiffBundleFile(worktree, file, git.PatchPathOptions{})
+		if err != nil {
+			return err
+		}
+		return f(worktree, "HEAD")
+	})
+}
@@ -0,0 +1,168 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ExtractFiles writes files touched by ghost as they are after applying it into outputDir and returns their paths
+//
+// The ghost is applied on its base commit in the source directory with a temporary index, so neither the index nor the working tree is modified.
+// Files deleted by the ghost are removed from outputDir if they are there, e.g. written by a ghost extracted before.
+func ExtractFiles(we WorkingEnv, ghost GhostBranch, outputDir string) ([]string, errors.GitGhostError) {
+	base, tree, err := patchedTree(we, ghost)
+	if err != nil {
+		return nil, err
+	}
+
+	changed, deleted, err := git.ListChangedTreePaths(we.SrcDir, base, tree)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range deleted {
+		rerr := os.Remove(filepath.Join(outputDir, p))
+		if rerr != nil && !os.IsNotExist(rerr) {
+			return nil, errors.WithStack(rerr)
+		}
+	}
+	log.WithFields(log.Fields{
+		"branch":    ghost.BranchName(),
+		"outputDir": outputDir,
+		"files":     len(changed),
+		"deleted":   len(deleted),
+	}).Info("extracting files")
+	err = git.CheckoutTreeFiles(we.SrcDir, tree, outputDir, changed)
+	if err != nil {
+		return nil, err
+	}
+	return changed, nil
+}
+
+// patchedTree writes a tree of ghost applied on its base commit into the source directory and returns the base and the tree
+func patchedTree(we WorkingEnv, ghost GhostBranch) (string, string, errors.GitGhostError) {
+	switch b := ghost.(type) {
+	case *CommitsBranch:
+		patch, err := extractGhostFileToTemp(we.GhostDir, "HEAD", ghost.FileName())
+		defer removeFiles([]string{patch})
+		if err != nil {
+			return "", "", err
+		}
+		var tree string
+		if b.Bundle {
+			tree, err = git.FetchCommitGhostBundle(we.SrcDir, patch)
+		} else {
+			tree, err = git.WritePatchedTree(we.SrcDir, b.CommitHashFrom, []string{patch})
+		}
+		return b.CommitHashFrom, tree, err
+	case *DiffBranch:
+		patches, err := extractPatchChain(we.GhostDir, "HEAD", ghost.FileName())
+		defer removeFiles(patches)
+		if err != nil {
+			return "", "", err
+		}
+		tree, err := git.WritePatchedTree(we.SrcDir, b.CommitHashFrom, patches)
+		return b.CommitHashFrom, tree, err
+	default:
+		return "", "", errors.Errorf("not supported on type = %+v", reflect.TypeOf(ghost))
+	}
+}
+
+// GhostFile represents a file touched by a ghost as it is after applying it
+type GhostFile struct {
+	Path string
+	// Mode is a git file mode of the file, e.g. "100644", or empty if the file is deleted by the ghost
+	Mode string
+	// Content is a reader of the content of the file as it is checked out (e.g. with line endings by .gitattributes),
+	// which is valid only until the walk function returns.
+	// It is empty for a deleted file or a submodule, and the target path for a symlink.
+	Content io.Reader
+}
+
+// Deleted returns whether the file is deleted by the ghost
+func (f GhostFile) Deleted() bool {
+	return f.Mode == ""
+}
+
+// WalkFiles calls f for each file touched by ghost in path order with its content after applying the ghost
+//
+// As ExtractFiles, the ghost is applied with a temporary index and nothing is written into the working tree,
+// while objects of the applied ghost are written into the object database of the source directory.
+// Contents are streamed from git as f reads them. If f returns an error, walking stops and the error is returned as it is.
+func WalkFiles(we WorkingEnv, ghost GhostBranch, f func(GhostFile) errors.GitGhostError) errors.GitGhostError {
+	base, tree, err := patchedTree(we, ghost)
+	if err != nil {
+		return err
+	}
+	changed, deleted, err := git.ListChangedTreePaths(we.SrcDir, base, tree)
+	if err != nil {
+		return err
+	}
+	entries, err := git.ListTreeEntries(we.SrcDir, tree, changed)
+	if err != nil {
+		return err
+	}
+	files := make([]GhostFile, 0, len(entries)+len(deleted))
+	blobs := map[string]string{}
+	for _, e := range entries {
+		files = append(files, GhostFile{Path: e.Path, Mode: e.Mode})
+		if e.Type == "blob" {
+			blobs[e.Path] = e.Hash
+		}
+	}
+	for _, p := range deleted {
+		files = append(files, GhostFile{Path: p})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	log.WithFields(log.Fields{
+		"branch":  ghost.BranchName(),
+		"files":   len(entries),
+		"deleted": len(deleted),
+	}).Info("walking files")
+
+	for _, file := range files {
+		hash, ok := blobs[file.Path]
+		if !ok {
+			file.Content = strings.NewReader("")
+			if err := f(file); err != nil {
+				return err
+			}
+			continue
+		}
+		// the target path of a symlink is never converted
+		path := file.Path
+		if file.Mode == "120000" {
+			path = ""
+		}
+		err := git.StreamBlob(we.SrcDir, hash, path, func(r io.Reader) errors.GitGhostError {
+			file.Content = r
+			return f(file)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// FormatVersion is a version of the format of ghost branches which this git-ghost creates and supports at most
+//
+// It must be increased when ghost branches get a change which older git-ghost would mishandle (e.g. compression or encryption of ghost files).
+//
+//	1: the initial version
+//	2: binary files of a diff can be attached as blobs
+//	3: ghost files can be piped through an external command
+//	4: ghost files can be compressed
+const FormatVersion = 4
+
+// formatTrailer is a trailer in messages of ghost commits recording their format versions.
+// Ghost commits without it are created before the version was recorded, and are of version 1.
+const formatTrailer = "Git-Ghost-Format"
+
+var formatTrailerPattern = regexp.MustCompile(`(?m)^` + formatTrailer + `: *([0-9]+) *$`)
+
+// ghostCommitMessage returns a message of a ghost commit of version with trailers of the ghost branch, which are followed by ones set globally
+func ghostCommitMessage(version int, trailers ...string) string {
+	message := fmt.Sprintf("Create ghost commit\n\n%s: %d", formatTrailer, version)
+	for _, trailer := range trailers {
+		message += "\n" + trailer
+	}
+	if sourceRepo != "" {
+		message += fmt.Sprintf("\n%s: %s", sourceRepoTrailer, sourceRepo)
+	}
+	if ciEnvironment != nil {
+		if trailers := ciEnvironment.trailers(); trailers != "" {
+			message += "\n" + trailers
+		}
+	}
+	if len(metadata) > 0 {
+		message += "\n" + metadata.trailers()
+	}
+	return message
+}
+
+// requiredFormatVersion returns the lowest format version which can read a ghost file stored in dstDir
+//
+// Ghost branches not using newer features keep older versions so that older git-ghost can still read them.
+func requiredFormatVersion(dstDir, fileName string) int {
+	// a ghost file stored as it is can be read without knowing compression
+	if storedCompression(dstDir, fileName) != CompressionNone {
+		return 4
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, fileName+pipedSuffix)); err == nil {
+		return 3
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, fileName+attachmentsSuffix)); err == nil {
+		return 2
+	}
+	return 1
+}
+
+// UnsupportedFormatError is an error for a ghost branch created by a newer git-ghost
+type UnsupportedFormatError struct {
+	Branch  string
+	Version int
+}
+
+func (e *UnsupportedFormatError) Error() string {
+	return fmt.Sprintf("%s is created by a newer git-ghost (format version %d, supported up to %d); please upgrade git-ghost", e.Branch, e.Version, FormatVersion)
+}
+
+// Provenance represents where a ghost branch comes from
+type Provenance struct {
+	Branch        string
+	FormatVersion int
+	// PushedBy is an author of the ghost commit in the form of "Name <email>"
+	PushedBy string
+	// PushedAt is a date of the ghost commit in the strict ISO 8601 format
+	PushedAt string
+	// SourceRepo is a URL (or a local path) of the repo which the ghost branch was created in, or empty if it is not recorded
+	SourceRepo string
+	// CI is a CI job which the ghost branch was pushed from, or nil if it was not pushed from CI
+	CI *CIEnvironment
+	// Metadata is what the ghost branch was annotated with on pushing it, or nil if it has nothing
+	Metadata Metadata
+	// Tree is a tree object which applying the local mod branch on its base results in, or empty if it is not recorded
+	Tree string
+}
+
+var gitGhostVersion string
+
+// SetGitGhostVersion sets a version of git-ghost recorded in trailers added to applied commits (empty records nothing)
+func SetGitGhostVersion(version string) {
+	gitGhostVersion = version
+}
+
+// commitsTrailers returns trailers added to messages of commits of a local base branch applied with ApplyOptions.Trailers
+func (p Provenance) commitsTrailers(ghost CommitsBranch) []string {
+	trailers := []string{
+		fmt.Sprintf("Git-Ghost-Branch: %s", p.Branch),
+		fmt.Sprintf("Git-Ghost-Base: %s", ghost.CommitHashFrom),
+	}
+	if p.SourceRepo != "" {
+		trailers = append(trailers, fmt.Sprintf("%s: %s", sourceRepoTrailer, p.SourceRepo))
+	}
+	trailers = append(trailers, fmt.Sprintf("Git-Ghost-Pushed-By: %s", p.PushedBy), fmt.Sprintf("Git-Ghost-Pushed-At: %s", p.PushedAt))
+	if gitGhostVersion != "" {
+		trailers = append(trailers, fmt.Sprintf("Git-Ghost-Version: %s", gitGhostVersion))
+	}
+	return trailers
+}
+
+// GetProvenance returns a provenance of a ghost branch at committish on ghostDir
+func GetProvenance(ghostDir, committish string, ghost GhostBranch) (*Provenance, errors.GitGhostError) {
+	metadata, err := git.GetCommitMetadata(ghostDir, committish)
+	if err != nil {
+		return nil, err
+	}
+	version := 1
+	if m := formatTrailerPattern.FindStringSubmatch(metadata.Message); m != nil {
+		version, _ = strconv.Atoi(m[1])
+	}
+	return &Provenance{
+		Branch:        ghost.BranchName(),
+		FormatVersion: version,
+		PushedBy:      metadata.Author,
+		PushedAt:      metadata.Date,
+		SourceRepo:    parseSourceRepo(metadata.Message),
+		CI:            parseCIEnvironment(metadata.Message),
+		Metadata:      parseMetadata(metadata.Message),
+		Tree:          parseTree(metadata.Message),
+	}, nil
+}
+
+// checkFormatVersion checks a ghost branch at committish on ghostDir is of a format version this git-ghost supports
+//
+// It returns an error whose cause is *UnsupportedFormatError if not.
+func checkFormatVersion(ghostDir, committish string, ghost GhostBranch) errors.GitGhostError {
+	provenance, err := GetProvenance(ghostDir, committish, ghost)
+	if err != nil {
+		return err
+	}
+	if provenance.FormatVersion > FormatVersion {
+		return errors.WithStack(&UnsupportedFormatError{Branch: ghost.BranchName(), Version: provenance.FormatVersion})
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// groupManifestFileName is a file in a group branch listing ghost branches of the repos in the group
+const groupManifestFileName = "group.json"
+
+var groupBranchNamePattern = regexp.MustCompile(`^([a-z0-9]+)/group/([a-f0-9]+)$`)
+
+// GroupComponent is a repo in a group with its ghost branches
+type GroupComponent struct {
+	// Path is a directory of the repo relative to the root directory of the group
+	Path string `json:"path"`
+	// Commits is a name of the local base branch of the repo, or empty if the repo has no commits in the group
+	Commits string `json:"commits,omitempty"`
+	// Diff is a name of the local mod branch of the repo, or empty if the repo has no diff in the group
+	Diff string `json:"diff,omitempty"`
+}
+
+// GroupManifest lists ghost branches of several repos captured together, which are pulled as a unit
+type GroupManifest struct {
+	Components []GroupComponent `json:"components"`
+}
+
+// GroupBranch is a branch storing a GroupManifest, which is named by a hash of its content
+type GroupBranch struct {
+	Prefix    string
+	GroupHash string
+}
+
+// BranchName returns its full branch name on git repository
+func (b GroupBranch) BranchName() string {
+	return fmt.Sprintf("%s/group/%s", b.Prefix, b.GroupHash)
+}
+
+// CreateGroupBranchByName instantiates GroupBranch object from branchname, or returns nil if it is not a name of group branch
+func CreateGroupBranchByName(branchName string) *GroupBranch {
+	m := groupBranchNamePattern.FindStringSubmatch(branchName)
+	if len(m) == 0 {
+		return nil
+	}
+	return &GroupBranch{Prefix: m[1], GroupHash: m[2]}
+}
+
+// CreateBranch commits the manifest into a new group branch on the ghost dir of we
+//
+// The manifest is stored as plain JSON without being split or piped, so that it can be read by 'git show'.
+func (manifest GroupManifest) CreateBranch(we WorkingEnv, prefix string) (*GroupBranch, errors.GitGhostError) {
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	path := filepath.Join(we.GhostDir, groupManifestFileName)
+	err = ioutil.WriteFile(path, append(content, '\n'), 0644)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	hash, ggerr := util.GenerateFileContentHash(path)
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	branch := GroupBranch{Prefix: prefix, GroupHash: hash}
+	ggerr = git.CreateOrphanBranch(we.GhostDir, branch.BranchName())
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	ggerr = git.CommitFiles(we.GhostDir, ghostCommitMessage(1), groupManifestFileName)
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	return &branch, nil
+}
+
+// ReadManifest fetches this group branch into the ghost dir of we and reads its manifest
+//
+// The manifest is verified by its hash in the branch name, so that one rewritten in ghost repo is never read.
+func (b GroupBranch) ReadManifest(we WorkingEnv) (*GroupManifest, errors.GitGhostError) {
+	ggerr := git.FetchBranches(we.GhostDir, b.BranchName())
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	f, err := ioutil.TempFile(util.TempDir(), "git-ghost-group")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	util.LogDeferredError(f.Close)
+	defer util.LogDeferredError(func() error { return os.Remove(f.Name()) })
+	ggerr = git.ExtractFile(we.GhostDir, fmt.Sprintf("%s/%s", git.ORIGIN, b.BranchName()), groupManifestFileName, f.Name())
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	hash, ggerr := util.GenerateFileContentHash(f.Name())
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	if hash != b.GroupHash {
+		return nil, errors.Errorf("manifest of %s doesn't match its hash (got: %s), which may be tampered with", b.BranchName(), hash)
+	}
+	content, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var manifest GroupManifest
+	err = json.Unmarshal(content, &manifest)
+	if err != nil {
+		return nil, errors.Errorf("manifest of %s is invalid: %s", b.BranchName(), err)
+	}
+	return &manifest, nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"regexp"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// DiffHashInput is what a diff hash of a local mod branch is derived from
+type DiffHashInput struct {
+	// Prefix is a prefix of the branch name
+	Prefix string
+	// CommitHashFrom is the local base commit of the diff
+	CommitHashFrom string
+	// PatchFile is a path of the diff file to be stored, which must not be modified
+	PatchFile string
+	// EmptyDirs are empty directories kept with the diff
+	EmptyDirs []string
+	// Attachments are binary files stored as blobs next to the diff
+	Attachments []git.BinaryPatchSection
+	// ParentDiffHash is a diff hash of the parent of an incremental diff, or empty for a full diff
+	ParentDiffHash string
+}
+
+// DiffHasher derives a diff hash, the identifier of a local mod branch on its local base commit, from a diff and its metadata
+//
+// A hash must be deterministic, i.e. the same for the same input, since pushing the same diff again reuses the ghost branch
+// of the same hash instead of creating another one. It must be also collision-resistant, since different diffs of the same
+// hash on the same base overwrite each other. It must consist of lowercase hex digits, which ghost branch names are made of.
+type DiffHasher interface {
+	DiffHash(input DiffHashInput) (string, errors.GitGhostError)
+}
+
+// DefaultDiffHasher is the DiffHasher used unless WorkingEnvSpec has another one
+//
+// A hash is the SHA-1 of the diff file, which is combined with empty directories, attachments and the parent by SHA-1 of them if any.
+type DefaultDiffHasher struct{}
+
+// DiffHash returns a diff hash of input
+func (DefaultDiffHasher) DiffHash(input DiffHashInput) (string, errors.GitGhostError) {
+	hash, ggerr := diffContentHash(input.PatchFile, input.EmptyDirs, input.Attachments)
+	if ggerr != nil {
+		return "", ggerr
+	}
+	if input.ParentDiffHash != "" {
+		// distinguish an incremental diff from a full diff with the same content
+		hash = util.GenerateStringsHash(input.ParentDiffHash, hash)
+	}
+	return hash, nil
+}
+
+var diffHashPattern = regexp.MustCompile(`^[a-f0-9]+$`)
+
+// diffHash derives a diff hash of input by hasher, or DefaultDiffHasher if it is nil, and checks it can be a part of a ghost branch name
+//
+// Ghost branches pushed with another hasher are still pulled as they are, since hashes are only derived on push.
+func diffHash(hasher DiffHasher, input DiffHashInput) (string, errors.GitGhostError) {
+	if hasher == nil {
+		hasher = DefaultDiffHasher{}
+	}
+	hash, ggerr := hasher.DiffHash(input)
+	if ggerr != nil {
+		return "", ggerr
+	}
+	if !diffHashPattern.MatchString(hash) {
+		return "", errors.Errorf("diff hasher returned an invalid hash %q, which must consist of lowercase hex digits", hash)
+	}
+	return hash, nil
+}
@@ -0,0 +1,158 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+	"github.com/pfnet-research/git-ghost/test/util"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fixedDiffHasher is a DiffHasher returning itself as the hash of any diff
+type fixedDiffHasher string
+
+func (h fixedDiffHasher) DiffHash(input types.DiffHashInput) (string, errors.GitGhostError) {
+	return string(h), nil
+}
+
+func TestCustomDiffHasher(t *testing.T) {
+	srcDir, ghostRepo, base := setupDiffHasherEnv(t)
+	defer srcDir.Remove()
+	defer ghostRepo.Remove()
+
+	result, err := pushDiff(srcDir, ghostRepo, fixedDiffHasher("0123abcd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "0123abcd", result.DiffBranch.DiffHash)
+	assert.Equal(t, "ghost/"+base+"/0123abcd", result.DiffBranch.BranchName())
+	assert.Equal(t, []string{"refs/heads/ghost/" + base + "/0123abcd"}, listHeads(t, ghostRepo))
+
+	// predicting a branch derives its hash by the hasher of the env as well
+	predicted, err := ghost.Hash(ghost.HashOptions{
+		WorkingEnvSpec: types.WorkingEnvSpec{SrcDir: srcDir.Dir, DiffHasher: fixedDiffHasher("4567abcd")},
+		DiffBranchSpec: &types.DiffBranchSpec{Prefix: "ghost", CommittishFrom: "HEAD"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "4567abcd", predicted.DiffHash)
+
+	// the default hasher is used without one
+	result, err = pushDiff(srcDir, ghostRepo, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Regexp(t, "^[0-9a-f]{40}$", result.DiffBranch.DiffHash)
+}
+
+func TestResolveCustomDiffHash(t *testing.T) {
+	srcDir, ghostRepo, base := setupDiffHasherEnv(t)
+	defer srcDir.Remove()
+	defer ghostRepo.Remove()
+	for _, hash := range []string{"0123abcd", "0123abcdef"} {
+		_, err := pushDiff(srcDir, ghostRepo, fixedDiffHasher(hash))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a whole hash is not ambiguous even if it is a prefix of another one
+	resolved, err := types.ResolveGhostHashPrefix(ghostRepo.Dir, "ghost", base, "0123abcd", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "0123abcd", resolved)
+	resolved, err = types.ResolveGhostHashPrefix(ghostRepo.Dir, "ghost", base, "0123abcde", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "0123abcdef", resolved)
+	_, err = types.ResolveGhostHashPrefix(ghostRepo.Dir, "ghost", base, "0123", false)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "ambiguous prefix 0123")
+	}
+}
+
+func TestInvalidDiffHasher(t *testing.T) {
+	for _, hash := range []string{"ABC", ""} {
+		t.Run(hash, func(t *testing.T) {
+			srcDir, ghostRepo, _ := setupDiffHasherEnv(t)
+			defer srcDir.Remove()
+			defer ghostRepo.Remove()
+
+			_, err := pushDiff(srcDir, ghostRepo, fixedDiffHasher(hash))
+			if assert.NotNil(t, err) {
+				assert.Contains(t, err.Error(), "invalid hash")
+			}
+			assert.Equal(t, []string{}, listHeads(t, ghostRepo))
+		})
+	}
+}
+
+// setupDiffHasherEnv creates a source repo with a local modification and an empty ghost repo, and returns them with the base commit
+func setupDiffHasherEnv(t *testing.T) (*util.WorkDir, *util.WorkDir, string) {
+	srcDir, err := util.CreateGitWorkDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, _, err := srcDir.RunCommmand("bash", "-c", "echo a > sample.txt && git add sample.txt && git commit -q -m 'initial commit' && echo b > sample.txt && git rev-parse HEAD")
+	if err != nil {
+		srcDir.Remove()
+		t.Fatal(err)
+	}
+	ghostRepo, err := util.CreateWorkDir()
+	if err != nil {
+		srcDir.Remove()
+		t.Fatal(err)
+	}
+	_, _, err = ghostRepo.RunCommmand("git", "init", "-q", "--bare")
+	if err != nil {
+		srcDir.Remove()
+		ghostRepo.Remove()
+		t.Fatal(err)
+	}
+	return srcDir, ghostRepo, strings.TrimSpace(base)
+}
+
+// pushDiff pushes the local modification of srcDir to ghostRepo as push diff does with hasher, or the default one if it is nil
+func pushDiff(srcDir, ghostRepo *util.WorkDir, hasher types.DiffHasher) (*ghost.PushResult, errors.GitGhostError) {
+	return ghost.Push(ghost.PushOptions{
+		WorkingEnvSpec: types.WorkingEnvSpec{
+			SrcDir:     srcDir.Dir,
+			GhostRepo:  ghostRepo.Dir,
+			DiffHasher: hasher,
+		},
+		DiffBranchSpec: &types.DiffBranchSpec{
+			Prefix:         "ghost",
+			CommittishFrom: "HEAD",
+		},
+	})
+}
+
+// listHeads returns the branches of ghostRepo
+func listHeads(t *testing.T, ghostRepo *util.WorkDir) []string {
+	stdout, _, err := ghostRepo.RunCommmand("git", "for-each-ref", "--format=%(refname)", "refs/heads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Fields(stdout)
+}
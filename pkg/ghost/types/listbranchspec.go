@@ -15,8 +15,6 @@
 package types
 
 import (
-	"fmt"
-
 	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
 	"github.com/pfnet-research/git-ghost/pkg/util/errors"
 )
@@ -68,6 +66,16 @@ func (ls *ListCommitsBranchSpec) GetBranches(repo string) (CommitsBranches, erro
 	return branches, nil
 }
 
+// StreamBranches calls f with each CommitsBranch from spec as soon as the ghost repo lists it
+func (ls *ListCommitsBranchSpec) StreamBranches(repo string, f func(CommitsBranch) errors.GitGhostError) errors.GitGhostError {
+	return streamGhostBranches(repo, ls.Prefix, ls.HashFrom, ls.HashTo, func(branch GhostBranch) errors.GitGhostError {
+		if br, ok := branch.(*CommitsBranch); ok {
+			return f(*br)
+		}
+		return nil
+	})
+}
+
 // Resolve resolves committish values in ListDiffBranchSpec as full commit hash
 func (ls *ListDiffBranchSpec) Resolve(srcDir string) *ListDiffBranchSpec {
 	newSpec := *ls
@@ -93,7 +101,28 @@ func (ls *ListDiffBranchSpec) GetBranches(repo string) (DiffBranches, errors.Git
 	return branches, nil
 }
 
-func listGhostBranchNames(repo, prefix, fromCommittish, toCommittish string) ([]string, error) {
+// StreamBranches calls f with each DiffBranch from spec as soon as the ghost repo lists it
+func (ls *ListDiffBranchSpec) StreamBranches(repo string, f func(DiffBranch) errors.GitGhostError) errors.GitGhostError {
+	return streamGhostBranches(repo, ls.Prefix, ls.HashFrom, ls.HashTo, func(branch GhostBranch) errors.GitGhostError {
+		if br, ok := branch.(*DiffBranch); ok {
+			return f(*br)
+		}
+		return nil
+	})
+}
+
+func streamGhostBranches(repo, prefix, fromCommittish, toCommittish string, f func(GhostBranch) errors.GitGhostError) errors.GitGhostError {
+	patterns := ghostBranchNamePatterns(prefix, fromCommittish, toCommittish)
+	return git.StreamRemoteBranchNames(repo, patterns, func(name string) errors.GitGhostError {
+		branch := CreateGhostBranchByName(name)
+		if branch == nil {
+			return nil
+		}
+		return f(branch)
+	})
+}
+
+func ghostBranchNamePatterns(prefix, fromCommittish, toCommittish string) []string {
 	fromPattern := "*"
 	toPattern := "*"
 	if fromCommittish != "" {
@@ -102,11 +131,14 @@ func listGhostBranchNames(repo, prefix, fromCommittish, toCommittish string) ([]
 	if toCommittish != "" {
 		toPattern = toCommittish
 	}
+	return []string{
+		commitsBranchName(prefix, fromPattern, toPattern),
+		diffBranchName(prefix, fromPattern, toPattern),
+	}
+}
 
-	branchNames, err := git.ListRemoteBranchNames(repo, []string{
-		fmt.Sprintf("%s/%s-%s", prefix, fromPattern, toPattern),
-		fmt.Sprintf("%s/%s/%s", prefix, fromPattern, toPattern),
-	})
+func listGhostBranchNames(repo, prefix, fromCommittish, toCommittish string) ([]string, error) {
+	branchNames, err := git.ListRemoteBranchNames(repo, ghostBranchNamePatterns(prefix, fromCommittish, toCommittish))
 	if err != nil {
 		return []string{}, errors.WithStack(err)
 	}
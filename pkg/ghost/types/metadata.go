@@ -0,0 +1,152 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// metadataTrailer is a trailer in messages of ghost commits recording each pair of metadata as "key=value"
+const metadataTrailer = "Git-Ghost-Meta"
+
+// MaxMetadataSize is the maximum total size in bytes of metadata of a ghost branch as "key=value" pairs
+const MaxMetadataSize = 4096
+
+var (
+	metadataTrailerPattern = regexp.MustCompile(`(?m)^` + metadataTrailer + `: *([^=\n]+)=(.*?) *$`)
+	metadataKeyPattern     = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+)
+
+// Metadata is arbitrary key/value pairs annotating a ghost branch, e.g. a ticket ID or a CI job URL
+type Metadata map[string]string
+
+var metadata Metadata
+
+// SetMetadata sets metadata recorded in ghost commits created afterwards (empty records nothing)
+func SetMetadata(m Metadata) {
+	metadata = m
+}
+
+// ParseMetadata parses pairs in the form of "key=value", checking their keys and their total size
+func ParseMetadata(pairs []string) (Metadata, errors.GitGhostError) {
+	m := Metadata{}
+	size := 0
+	for _, pair := range pairs {
+		i := strings.Index(pair, "=")
+		if i < 0 {
+			return nil, errors.Errorf("metadata must be in the form of key=value: %s", pair)
+		}
+		key, value := pair[:i], strings.TrimSpace(pair[i+1:])
+		if !metadataKeyPattern.MatchString(key) {
+			return nil, errors.Errorf("invalid metadata key: %s (allowed pattern: %s)", key, metadataKeyPattern.String())
+		}
+		if _, ok := m[key]; ok {
+			return nil, errors.Errorf("metadata key %s is specified more than once", key)
+		}
+		if strings.IndexFunc(value, unicode.IsControl) >= 0 {
+			return nil, errors.Errorf("metadata value of %s must not have control characters (e.g. newlines)", key)
+		}
+		m[key] = value
+		size += len(key) + 1 + len(value)
+	}
+	if size > MaxMetadataSize {
+		return nil, errors.Errorf("metadata is too large: %d bytes in total exceeds %d bytes", size, MaxMetadataSize)
+	}
+	return m, nil
+}
+
+// MetadataFilter is conditions on metadata of ghost branches, all of which have to be met
+type MetadataFilter []MetadataCondition
+
+// MetadataCondition requires metadata to have Key, with Value unless AnyValue is set
+type MetadataCondition struct {
+	Key      string
+	Value    string
+	AnyValue bool
+}
+
+// ParseMetadataFilter parses conditions in the form of "key=value", or "key" to require only its presence
+func ParseMetadataFilter(conditions []string) (MetadataFilter, errors.GitGhostError) {
+	filter := MetadataFilter{}
+	for _, c := range conditions {
+		condition := MetadataCondition{Key: c, AnyValue: true}
+		if i := strings.Index(c, "="); i >= 0 {
+			condition = MetadataCondition{Key: c[:i], Value: strings.TrimSpace(c[i+1:])}
+		}
+		if !metadataKeyPattern.MatchString(condition.Key) {
+			return nil, errors.Errorf("invalid metadata key: %s (allowed pattern: %s)", condition.Key, metadataKeyPattern.String())
+		}
+		filter = append(filter, condition)
+	}
+	return filter, nil
+}
+
+// parseMetadata parses metadata recorded in a message of a ghost commit, and returns nil if nothing is recorded
+func parseMetadata(message string) Metadata {
+	var m Metadata
+	for _, match := range metadataTrailerPattern.FindAllStringSubmatch(message, -1) {
+		if m == nil {
+			m = Metadata{}
+		}
+		m[match[1]] = match[2]
+	}
+	return m
+}
+
+// Keys returns keys of m in sorted order
+func (m Metadata) Keys() []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Matches checks m meets all the conditions in filter
+func (m Metadata) Matches(filter MetadataFilter) bool {
+	for _, condition := range filter {
+		v, ok := m[condition.Key]
+		if !ok || (!condition.AnyValue && v != condition.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// trailers returns trailers recording m in a message of a ghost commit
+func (m Metadata) trailers() string {
+	lines := []string{}
+	for _, key := range m.Keys() {
+		lines = append(lines, fmt.Sprintf("%s: %s=%s", metadataTrailer, key, m[key]))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// PrettyString returns m as "Meta: key=value" lines
+func (m Metadata) PrettyString() string {
+	var buffer bytes.Buffer
+	for _, key := range m.Keys() {
+		buffer.WriteString(fmt.Sprintf("Meta: %s=%s\n", key, m[key]))
+	}
+	return buffer.String()
+}
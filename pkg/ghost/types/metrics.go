@@ -0,0 +1,50 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// TransferMetrics records the size of a ghost branch and timings of pushing or pulling it
+type TransferMetrics struct {
+	Branch string
+	// Bytes is the total size of files in the ghost commit, i.e. the patch or the bundle as stored in ghost repo
+	Bytes int64
+	// Local is time spent on the local side, which is creating the ghost branch on push and applying it on pull
+	Local time.Duration
+	// Transfer is time spent on talking to ghost repo, which is pushing the ghost branch on push and fetching it on pull
+	Transfer time.Duration
+}
+
+// Throughput returns effective bytes per second of the transfer, or 0 if it took no time
+func (m TransferMetrics) Throughput() float64 {
+	if m.Transfer <= 0 {
+		return 0
+	}
+	return float64(m.Bytes) / m.Transfer.Seconds()
+}
+
+// MarshalJSON writes timings in seconds
+func (m TransferMetrics) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Branch          string  `json:"branch"`
+		Bytes           int64   `json:"bytes"`
+		LocalSeconds    float64 `json:"localSeconds"`
+		TransferSeconds float64 `json:"transferSeconds"`
+		BytesPerSecond  float64 `json:"bytesPerSecond"`
+	}{m.Branch, m.Bytes, m.Local.Seconds(), m.Transfer.Seconds(), m.Throughput()})
+}
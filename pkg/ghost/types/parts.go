@@ -0,0 +1,264 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// A ghost file larger than a split size is stored as ordered parts named "<file name>.part-NNN"
+// with a manifest "<file name>.parts" whose lines are "<sha1> <part name>" (the format of sha1sum)
+const partsManifestSuffix = ".parts"
+
+func partName(fileName string, i int) string {
+	return fmt.Sprintf("%s.part-%03d", fileName, i)
+}
+
+// storeGhostFile moves srcPath to fileName in dstDir, splitting it into parts if it is larger than splitSize (0 means no limit)
+func storeGhostFile(dstDir, srcPath, fileName string, splitSize int64) errors.GitGhostError {
+	// files of a parent ghost may be left in an incremental diff
+	stale, err := filepath.Glob(filepath.Join(dstDir, fileName+"*"))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, p := range stale {
+		err := os.RemoveAll(p)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	// a content is compressed before being piped, e.g. encrypted, which makes it incompressible
+	compressed, ggerr := compressOnPush(dstDir, srcPath, fileName)
+	if compressed != srcPath {
+		defer removeFiles([]string{compressed})
+	}
+	if ggerr != nil {
+		return ggerr
+	}
+	srcPath = compressed
+
+	// a piped content is split so that parts are reassembled before being piped back
+	piped, ggerr := pipeOnPush(dstDir, srcPath, fileName)
+	if piped != srcPath {
+		defer removeFiles([]string{piped})
+	}
+	if ggerr != nil {
+		return ggerr
+	}
+	srcPath = piped
+
+	size, ggerr := util.FileSize(srcPath)
+	if ggerr != nil {
+		return ggerr
+	}
+	if splitSize <= 0 || size <= splitSize {
+		return errors.WithStack(os.Rename(srcPath, filepath.Join(dstDir, fileName)))
+	}
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer util.LogDeferredError(src.Close)
+	var manifest bytes.Buffer
+	for i := 0; int64(i)*splitSize < size; i++ {
+		name := partName(fileName, i)
+		dst, err := os.OpenFile(filepath.Join(dstDir, name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		_, err = io.CopyN(dst, src, splitSize)
+		util.LogDeferredError(dst.Close)
+		if err != nil && err != io.EOF {
+			return errors.WithStack(err)
+		}
+		hash, ggerr := util.GenerateFileContentHash(filepath.Join(dstDir, name))
+		if ggerr != nil {
+			return ggerr
+		}
+		manifest.WriteString(fmt.Sprintf("%s  %s\n", hash, name))
+	}
+	return errors.WithStack(ioutil.WriteFile(filepath.Join(dstDir, fileName+partsManifestSuffix), manifest.Bytes(), 0600))
+}
+
+// commitGhostFile commits a ghost file stored by storeGhostFile with trailers of its ghost branch
+func commitGhostFile(dstDir, fileName string, trailers ...string) errors.GitGhostError {
+	return git.CommitFiles(dstDir, ghostCommitMessage(requiredFormatVersion(dstDir, fileName), trailers...), fileName+"*")
+}
+
+// ghostFileExists checks a ghost file stored by storeGhostFile exists at committish on ghostDir or not
+func ghostFileExists(ghostDir, committish, fileName string) (bool, errors.GitGhostError) {
+	exists, ggerr := git.FileExistsAt(ghostDir, committish, fileName)
+	if ggerr != nil || exists {
+		return exists, ggerr
+	}
+	return git.FileExistsAt(ghostDir, committish, fileName+partsManifestSuffix)
+}
+
+// extractGhostFile writes a content of a ghost file at committish on ghostDir to dstPath,
+// reassembling its parts if it is split, piping it back if it is piped, decompressing it if it is compressed
+// and restoring its attachments if any
+func extractGhostFile(ghostDir, committish, fileName, dstPath string) errors.GitGhostError {
+	ggerr := extractGhostFileParts(ghostDir, committish, fileName, dstPath)
+	if ggerr != nil {
+		return ggerr
+	}
+	ggerr = pipeOnPull(ghostDir, committish, fileName, dstPath)
+	if ggerr != nil {
+		return ggerr
+	}
+	ggerr = decompressOnPull(ghostDir, committish, fileName, dstPath)
+	if ggerr != nil {
+		return ggerr
+	}
+	return appendAttachments(ghostDir, committish, fileName, dstPath)
+}
+
+func extractGhostFileParts(ghostDir, committish, fileName, dstPath string) errors.GitGhostError {
+	manifestName := fileName + partsManifestSuffix
+	split, ggerr := git.FileExistsAt(ghostDir, committish, manifestName)
+	if ggerr != nil {
+		return ggerr
+	}
+	if !split {
+		return git.ExtractFile(ghostDir, committish, fileName, dstPath)
+	}
+
+	manifest, err := ioutil.TempFile(util.TempDir(), "git-ghost-parts")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	util.LogDeferredError(manifest.Close)
+	defer util.LogDeferredError(func() error { return os.Remove(manifest.Name()) })
+	ggerr = git.ExtractFile(ghostDir, committish, manifestName, manifest.Name())
+	if ggerr != nil {
+		return ggerr
+	}
+	content, err := ioutil.ReadFile(manifest.Name())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	dst, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer util.LogDeferredError(dst.Close)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		tokens := strings.Fields(scanner.Text())
+		if len(tokens) != 2 {
+			return errors.Errorf("unexpected line in %s: %s", manifestName, scanner.Text())
+		}
+		ggerr := appendGhostFilePart(ghostDir, committish, tokens[1], tokens[0], dst)
+		if ggerr != nil {
+			return ggerr
+		}
+	}
+	return errors.WithStack(scanner.Err())
+}
+
+// extractGhostFileToTemp extracts a ghost file at committish on ghostDir to a temporary file and returns its path
+//
+// The returned path must be removed by the caller even if an error is returned.
+func extractGhostFileToTemp(ghostDir, committish, fileName string) (string, errors.GitGhostError) {
+	f, err := ioutil.TempFile(util.TempDir(), "git-ghost-patch")
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	util.LogDeferredError(f.Close)
+	return f.Name(), extractGhostFile(ghostDir, committish, fileName, f.Name())
+}
+
+func appendGhostFilePart(ghostDir, committish, name, expectedHash string, dst io.Writer) errors.GitGhostError {
+	part, err := ioutil.TempFile(util.TempDir(), "git-ghost-part")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	util.LogDeferredError(part.Close)
+	defer util.LogDeferredError(func() error { return os.Remove(part.Name()) })
+	ggerr := git.ExtractFile(ghostDir, committish, name, part.Name())
+	if ggerr != nil {
+		return ggerr
+	}
+	hash, ggerr := util.GenerateFileContentHash(part.Name())
+	if ggerr != nil {
+		return ggerr
+	}
+	if hash != expectedHash {
+		return errors.Errorf("checksum mismatch of %s: expected %s but got %s", name, expectedHash, hash)
+	}
+	src, err := os.Open(part.Name())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer util.LogDeferredError(src.Close)
+	_, err = io.Copy(dst, src)
+	return errors.WithStack(err)
+}
+
+// VerifyGhostFile checks a ghost file of ghost exists at committish on ghostDir and can be reassembled from its parts
+//
+// A diff branch is checked on all the commits it is stored on top of. A missing ghost file is classified as errors.CategoryNotFound.
+func VerifyGhostFile(ghostDir, committish string, ghost GhostBranch) errors.GitGhostError {
+	commits := []string{committish}
+	switch b := ghost.(type) {
+	case *CommitsBranch:
+		bundle, ggerr := ghostFileExists(ghostDir, committish, commitsBundleFileName)
+		if ggerr != nil {
+			return ggerr
+		}
+		b.Bundle = bundle
+	case *DiffBranch:
+		var ggerr errors.GitGhostError
+		commits, ggerr = git.ListFirstParentCommits(ghostDir, committish)
+		if ggerr != nil {
+			return ggerr
+		}
+	}
+	fileName := ghost.FileName()
+	for _, commit := range commits {
+		exists, ggerr := ghostFileExists(ghostDir, commit, fileName)
+		if ggerr != nil {
+			return ggerr
+		}
+		if !exists {
+			return errors.WithCategory(errors.Errorf("%s is missing at %s", fileName, commit), errors.CategoryNotFound)
+		}
+		f, err := ioutil.TempFile(util.TempDir(), "git-ghost-verify")
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		util.LogDeferredError(f.Close)
+		ggerr = extractGhostFileParts(ghostDir, commit, fileName, f.Name())
+		util.LogDeferredError(func() error { return os.Remove(f.Name()) })
+		if ggerr != nil {
+			return ggerr
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// A ghost file piped through a command on push is marked by an empty file "<file name>.piped"
+const pipedSuffix = ".piped"
+
+var (
+	pipeThroughOnPush string
+	pipeThroughOnPull string
+)
+
+// SetPipeThrough sets shell commands through which ghost files are piped before being stored and after being extracted
+//
+// An empty command disables piping in the direction.
+func SetPipeThrough(onPush, onPull string) {
+	pipeThroughOnPush = onPush
+	pipeThroughOnPull = onPull
+}
+
+// isPiped checks a ghost file at committish on ghostDir is stored after being piped through a command or not
+func isPiped(ghostDir, committish, fileName string) (bool, errors.GitGhostError) {
+	return git.FileExistsAt(ghostDir, committish, fileName+pipedSuffix)
+}
+
+// pipeToTemp pipes a content of srcPath through command into a temporary file and returns its path
+//
+// The returned path must be removed by the caller even if an error is returned.
+func pipeToTemp(command, srcPath string) (string, errors.GitGhostError) {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer util.LogDeferredError(src.Close)
+	dst, err := ioutil.TempFile(util.TempDir(), "git-ghost-piped")
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer util.LogDeferredError(dst.Close)
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin = src
+	cmd.Stdout = dst
+	ggerr := util.JustRunCmd(cmd)
+	if ggerr != nil {
+		return dst.Name(), errors.Errorf("pipe-through command failed: %s", ggerr)
+	}
+	return dst.Name(), nil
+}
+
+// pipeOnPush pipes srcPath through the command set for push if any, and marks fileName in dstDir as piped
+//
+// It returns a path of the piped content, which must be removed by the caller if it differs from srcPath.
+func pipeOnPush(dstDir, srcPath, fileName string) (string, errors.GitGhostError) {
+	if pipeThroughOnPush == "" {
+		return srcPath, nil
+	}
+	piped, ggerr := pipeToTemp(pipeThroughOnPush, srcPath)
+	if ggerr != nil {
+		return piped, ggerr
+	}
+	return piped, errors.WithStack(ioutil.WriteFile(filepath.Join(dstDir, fileName+pipedSuffix), []byte{}, 0600))
+}
+
+// pipeOnPull reverses piping of a ghost file at committish on ghostDir extracted to path in place, if it is piped
+func pipeOnPull(ghostDir, committish, fileName, path string) errors.GitGhostError {
+	piped, ggerr := isPiped(ghostDir, committish, fileName)
+	if ggerr != nil || !piped {
+		return ggerr
+	}
+	if pipeThroughOnPull == "" {
+		return errors.Errorf("%s is piped through a command on push. please specify a command reversing it by --pipe-through-on-pull", fileName)
+	}
+	restored, ggerr := pipeToTemp(pipeThroughOnPull, path)
+	if ggerr != nil {
+		removeFiles([]string{restored})
+		return ggerr
+	}
+	return errors.WithStack(os.Rename(restored, path))
+}
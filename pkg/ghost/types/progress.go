@@ -0,0 +1,108 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+// ProgressEventVersion is the version of the schema of ProgressEvent, which is increased only by an incompatible change
+//
+// Adding a field or a phase is not an incompatible change.
+const ProgressEventVersion = 1
+
+// Phases of ProgressEvent
+const (
+	// ProgressPhaseCreate is creating a ghost branch (e.g. a bundle or a patch) on push
+	ProgressPhaseCreate = "create"
+	// ProgressPhasePush is pushing a ghost branch to ghost repo
+	ProgressPhasePush = "push"
+	// ProgressPhaseFetch is fetching a ghost branch from ghost repo on pull
+	ProgressPhaseFetch = "fetch"
+	// ProgressPhaseApply is applying a fetched ghost branch on pull
+	ProgressPhaseApply = "apply"
+)
+
+// States of ProgressEvent
+const (
+	ProgressStart    = "start"
+	ProgressProgress = "progress"
+	ProgressDone     = "done"
+)
+
+// ProgressEvent is an event of progress of a phase of pushing or pulling a ghost branch
+type ProgressEvent struct {
+	Version int    `json:"version"`
+	Phase   string `json:"phase"`
+	State   string `json:"state"`
+	// Branch is the ghost branch, which is empty while creating it since its name is not known yet
+	Branch string `json:"branch,omitempty"`
+	// BytesDone is the size done so far, estimated by the percentage of objects transferred during a transfer
+	BytesDone int64 `json:"bytesDone"`
+	// BytesTotal is the size of the ghost branch as by TransferMetrics, which is 0 while it is not known yet
+	BytesTotal int64 `json:"bytesTotal"`
+	Percent    int   `json:"percent"`
+}
+
+var progressReporter func(ProgressEvent)
+
+// SetProgressReporter sets a function which receives events of progress of pushing and pulling ghost branches afterwards (nil reports nothing)
+func SetProgressReporter(f func(ProgressEvent)) {
+	progressReporter = f
+}
+
+// ReportProgress sends an event of phase for branch to the reporter set by SetProgressReporter if any
+func ReportProgress(phase, state, branch string, bytesDone, bytesTotal int64) {
+	if progressReporter == nil {
+		return
+	}
+	percent := 0
+	switch {
+	case state == ProgressDone:
+		percent = 100
+	case bytesTotal > 0:
+		percent = int(bytesDone * 100 / bytesTotal)
+	}
+	progressReporter(ProgressEvent{
+		Version:    ProgressEventVersion,
+		Phase:      phase,
+		State:      state,
+		Branch:     branch,
+		BytesDone:  bytesDone,
+		BytesTotal: bytesTotal,
+		Percent:    percent,
+	})
+}
+
+// TransferProgress returns a function reporting progress of a transfer of branch by the percentage of objects transferred,
+// or nil if no reporter is set so that git reports nothing
+func TransferProgress(phase, branch string, bytesTotal int64) func(percent int) {
+	if progressReporter == nil {
+		return nil
+	}
+	last := -1
+	return func(percent int) {
+		// git repeats the same percentage while counting objects
+		if percent == last {
+			return
+		}
+		last = percent
+		progressReporter(ProgressEvent{
+			Version:    ProgressEventVersion,
+			Phase:      phase,
+			State:      ProgressProgress,
+			Branch:     branch,
+			BytesDone:  bytesTotal * int64(percent) / 100,
+			BytesTotal: bytesTotal,
+			Percent:    percent,
+		})
+	}
+}
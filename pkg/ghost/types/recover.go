@@ -0,0 +1,108 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// recoverCommits applies a patch file of commits escalating from 'git am' to 'git am --3way', and to 'git apply --reject' at last
+//
+// Commits are not created by the last step, which leaves changes which can be applied in the working tree and the others in *.rej files.
+// 'git am --3way' is run with rerere enabled if rerere is true, and paths of conflicts resolved by rerere are returned.
+func recoverCommits(srcDir, patch string, pathOpts git.PatchPathOptions, rerere bool) ([]string, errors.GitGhostError) {
+	err := git.ApplyDiffBundleFile(srcDir, patch, pathOpts)
+	if err == nil {
+		return []string{}, nil
+	}
+	log.WithFields(log.Fields{
+		"error":  err.Error(),
+		"patch":  patch,
+		"rerere": rerere,
+	}).Warn("applying commits by 'git am' failed. retrying by 'git am --3way'")
+	if rerere {
+		var resolved []string
+		resolved, err = git.ApplyDiffBundleFileWithRerere(srcDir, patch, pathOpts)
+		if err == nil {
+			return resolved, nil
+		}
+	} else {
+		err = git.ApplyDiffBundleFileWith3way(srcDir, patch, pathOpts)
+		if err == nil {
+			return []string{}, nil
+		}
+	}
+	log.WithFields(log.Fields{
+		"error": err.Error(),
+		"patch": patch,
+	}).Warn("applying commits by 'git am --3way' failed. salvaging their diff by 'git apply --reject' without committing")
+	return []string{}, salvage(srcDir, patch, pathOpts)
+}
+
+// recoverDiff applies a diff file escalating from 'git apply' to 'git apply --reject'
+//
+// 'git apply --3way' is not tried because conflict markers it leaves can't be rolled back before the next step.
+func recoverDiff(srcDir, patch string, pathOpts git.PatchPathOptions) errors.GitGhostError {
+	err := git.ApplyDiffPatchFile(srcDir, patch, pathOpts)
+	if err == nil {
+		return nil
+	}
+	log.WithFields(log.Fields{
+		"error": err.Error(),
+		"patch": patch,
+	}).Warn("applying diff by 'git apply' failed. salvaging it by 'git apply --reject'")
+	return salvage(srcDir, patch, pathOpts)
+}
+
+// applyWithFuzz applies a diff file by 'git apply' falling back on 'patch' with fuzz, and returns hunks applied with fuzz
+func applyWithFuzz(srcDir, patch string, pathOpts git.PatchPathOptions, fuzz int) ([]git.FuzzedHunk, errors.GitGhostError) {
+	err := git.ApplyDiffPatchFile(srcDir, patch, pathOpts)
+	if err == nil {
+		return []git.FuzzedHunk{}, nil
+	}
+	log.WithFields(log.Fields{
+		"error": err.Error(),
+		"patch": patch,
+		"fuzz":  fuzz,
+	}).Warn("applying diff by 'git apply' failed. retrying by 'patch' with fuzz")
+	hunks, err := git.ApplyDiffPatchFileWithFuzz(srcDir, patch, pathOpts, fuzz)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range hunks {
+		log.WithFields(log.Fields{
+			"path": h.Path,
+			"hunk": h.Hunk,
+			"line": h.Line,
+			"fuzz": h.Fuzz,
+		}).Warn("hunk was applied with fuzz, which may be a wrong place. please review it")
+	}
+	return hunks, nil
+}
+
+// salvage applies hunks of a patch file which can be applied and leaves the others in *.rej files
+func salvage(srcDir, patch string, pathOpts git.PatchPathOptions) errors.GitGhostError {
+	err := git.ApplyDiffPatchFileWithReject(srcDir, patch, pathOpts)
+	if err == nil {
+		log.WithFields(log.Fields{
+			"patch": patch,
+		}).Warn("salvaged all hunks by 'git apply --reject'")
+		return nil
+	}
+	return errors.WithCategory(errors.Errorf("ghost was applied partially. hunks which didn't apply are left in *.rej files: %s", err), errors.CategoryConflict)
+}
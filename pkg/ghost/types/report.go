@@ -0,0 +1,164 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// ApplyReport records what applying ghost branches did, e.g. to be kept as an artifact of CI
+type ApplyReport struct {
+	// Ghosts are reports of ghost branches in the order they are applied
+	Ghosts []GhostApplyReport `json:"ghosts"`
+	// Error is an error which stopped pulling if any
+	Error string `json:"error,omitempty"`
+	// RolledBack is true if the source directory is restored to the state before pulling after Error by 'pull --atomic'
+	RolledBack bool `json:"rolledBack,omitempty"`
+}
+
+// GhostApplyReport records what applying a ghost branch did
+type GhostApplyReport struct {
+	Branch string `json:"branch"`
+	// Applied is true if the ghost branch is applied without any error
+	Applied bool `json:"applied"`
+	// Files are files which the ghost branch touches (empty for a bundle)
+	Files []FileApplyReport `json:"files"`
+	// Skipped are commits (hashes in the ghost) or files of a diff skipped because they are already applied on resuming
+	Skipped []string `json:"skipped,omitempty"`
+	// Fuzzed are hunks of a diff applied by 'patch' with fuzz, which should be reviewed
+	Fuzzed []git.FuzzedHunk `json:"fuzzed,omitempty"`
+	// Rerere are paths of conflicts of commits resolved by resolutions recorded by rerere
+	Rerere []string `json:"rerere,omitempty"`
+	// Collisions are untracked files in the way of files created by a diff, which are resolved by ApplyOptions.UntrackedCollision
+	Collisions []UntrackedCollision `json:"collisions,omitempty"`
+	// Metrics are the size and timings of pulling the ghost branch, which are set after applying it
+	Metrics *TransferMetrics `json:"metrics,omitempty"`
+	Error   string           `json:"error,omitempty"`
+}
+
+// FileApplyReport records changes of a file by applying a ghost branch
+type FileApplyReport struct {
+	git.PatchFileStat
+	// Rejected is true if some hunks for the file are left in a *.rej file
+	Rejected bool `json:"rejected,omitempty"`
+}
+
+// record calls f which applies patches of ghost on srcDir and appends what it did to the report
+//
+// It just calls f if the report is nil.
+func (report *ApplyReport) record(srcDir string, ghost GhostBranch, patches []string, opts ApplyOptions, f func() errors.GitGhostError) errors.GitGhostError {
+	if report == nil {
+		return f()
+	}
+	ghostReport := GhostApplyReport{
+		Branch: ghost.BranchName(),
+		Files:  []FileApplyReport{},
+	}
+	indices := map[string]int{}
+	for _, p := range patches {
+		fileStats, err := git.ListPatchFileStats(srcDir, p, opts.patchPathOptions())
+		if err != nil {
+			ghostReport.Error = err.Error()
+			report.Ghosts = append(report.Ghosts, ghostReport)
+			return err
+		}
+		for _, fs := range fileStats {
+			if i, ok := indices[fs.Path]; ok {
+				ghostReport.Files[i].Added += fs.Added
+				ghostReport.Files[i].Deleted += fs.Deleted
+				ghostReport.Files[i].Binary = ghostReport.Files[i].Binary || fs.Binary
+				continue
+			}
+			indices[fs.Path] = len(ghostReport.Files)
+			ghostReport.Files = append(ghostReport.Files, FileApplyReport{PatchFileStat: fs})
+		}
+	}
+
+	var rejectModTimes map[string]time.Time
+	if opts.Reject || opts.Recover {
+		rejectModTimes = ghostReport.rejectModTimes(srcDir)
+	}
+	err := f()
+	if opts.Reject || opts.Recover {
+		// files are rejected if their *.rej files are created or updated by applying
+		for path, modTime := range ghostReport.rejectModTimes(srcDir) {
+			before, ok := rejectModTimes[path]
+			ghostReport.Files[indices[path]].Rejected = !ok || !modTime.Equal(before)
+		}
+	}
+	ghostReport.Applied = err == nil
+	if err != nil {
+		ghostReport.Error = err.Error()
+	}
+	report.Ghosts = append(report.Ghosts, ghostReport)
+	return err
+}
+
+// rejectModTimes returns modification times of existing *.rej files of Files keyed by their paths
+func (r GhostApplyReport) rejectModTimes(srcDir string) map[string]time.Time {
+	modTimes := map[string]time.Time{}
+	for _, f := range r.Files {
+		fi, err := os.Stat(filepath.Join(srcDir, f.Path+".rej"))
+		if err == nil {
+			modTimes[f.Path] = fi.ModTime()
+		}
+	}
+	return modTimes
+}
+
+// recordSkipped records what is skipped on resuming applying to the report of the ghost branch applied last
+//
+// It does nothing if the report is nil or nothing is skipped.
+func (report *ApplyReport) recordSkipped(skipped []string) {
+	if report == nil || len(skipped) == 0 || len(report.Ghosts) == 0 {
+		return
+	}
+	report.Ghosts[len(report.Ghosts)-1].Skipped = skipped
+}
+
+// recordFuzzed records hunks applied with fuzz to the report of the ghost branch applied last
+//
+// It does nothing if the report is nil or no hunk is fuzzed.
+func (report *ApplyReport) recordFuzzed(fuzzed []git.FuzzedHunk) {
+	if report == nil || len(fuzzed) == 0 || len(report.Ghosts) == 0 {
+		return
+	}
+	report.Ghosts[len(report.Ghosts)-1].Fuzzed = fuzzed
+}
+
+// recordCollisions records untracked files resolved before applying to the report of the ghost branch applied last
+//
+// It does nothing if the report is nil or there is no collision.
+func (report *ApplyReport) recordCollisions(collisions []UntrackedCollision) {
+	if report == nil || len(collisions) == 0 || len(report.Ghosts) == 0 {
+		return
+	}
+	report.Ghosts[len(report.Ghosts)-1].Collisions = collisions
+}
+
+// recordRerere records paths of conflicts resolved by rerere to the report of the ghost branch applied last
+//
+// It does nothing if the report is nil or nothing is resolved.
+func (report *ApplyReport) recordRerere(resolved []string) {
+	if report == nil || len(resolved) == 0 || len(report.Ghosts) == 0 {
+		return
+	}
+	report.Ghosts[len(report.Ghosts)-1].Rerere = resolved
+}
@@ -0,0 +1,48 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+var secretRules []git.SecretRule
+
+// SetSecretRules sets rules which patches of ghost branches created afterwards are scanned with before they are stored (empty scans nothing)
+func SetSecretRules(rules []git.SecretRule) {
+	secretRules = rules
+}
+
+// checkSecrets fails if lines added by a patch file match any of the secret rules
+func checkSecrets(patch string) errors.GitGhostError {
+	if len(secretRules) == 0 {
+		return nil
+	}
+	findings, err := git.ScanPatchSecrets(patch, secretRules)
+	if err != nil {
+		return err
+	}
+	if len(findings) == 0 {
+		return nil
+	}
+	lines := make([]string, 0, len(findings))
+	for _, f := range findings {
+		lines = append(lines, "  "+f.String())
+	}
+	return errors.Errorf("possible secrets are found in the ghost, which is not pushed:\n%s\nplease remove them, or push with --no-secret-scan if they are false positives", strings.Join(lines, "\n"))
+}
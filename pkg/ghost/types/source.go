@@ -0,0 +1,75 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"regexp"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// sourceRepoTrailer is a trailer in messages of ghost commits recording the repo which ghosts are created in
+const sourceRepoTrailer = "Git-Ghost-Source-Repo"
+
+var sourceRepoTrailerPattern = regexp.MustCompile(`(?m)^` + sourceRepoTrailer + `: *(.*?) *$`)
+
+var sourceRepo string
+
+// SetSourceRepo sets a repo recorded in ghost commits created afterwards as the one which they are created in (empty records nothing)
+func SetSourceRepo(repo string) {
+	sourceRepo = repo
+}
+
+// parseSourceRepo parses a source repo recorded in a message of a ghost commit, and returns empty if it is not recorded
+func parseSourceRepo(message string) string {
+	if m := sourceRepoTrailerPattern.FindStringSubmatch(message); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// checkSourceRepo checks a ghost pulled into the ghost dir of we was created in the repo of the source dir or one of its remotes
+//
+// A ghost from another repo is only warned about, or refused with opts.StrictSourceRepo unless opts.Force is set.
+// A ghost without its source repo recorded (e.g. created by older git-ghost) is always accepted.
+func checkSourceRepo(ghost GhostBranch, we WorkingEnv, opts ApplyOptions) errors.GitGhostError {
+	provenance, err := GetProvenance(we.GhostDir, "HEAD", ghost)
+	if err != nil {
+		return err
+	}
+	if provenance.SourceRepo == "" {
+		return nil
+	}
+	same, err := git.IsSameRepo(we.SrcDir, provenance.SourceRepo)
+	if err != nil {
+		return err
+	}
+	if same {
+		return nil
+	}
+	fields := log.Fields{
+		"branch":     ghost.BranchName(),
+		"sourceRepo": provenance.SourceRepo,
+		"srcDir":     we.SrcDir,
+	}
+	if opts.StrictSourceRepo && !opts.Force {
+		return errors.Errorf("%s was created in %s, which is neither this repo nor one of its remotes. please pass --force if it is intended", ghost.BranchName(), provenance.SourceRepo)
+	}
+	log.WithFields(fields).Warn("applying ghost which was created in another repo")
+	return nil
+}
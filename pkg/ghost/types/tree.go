@@ -0,0 +1,107 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// treeTrailer is a trailer in messages of ghost commits of local mod branches recording the tree object
+// which applying them on their bases results in, so that pulling them can verify the applied state
+const treeTrailer = "Git-Ghost-Tree"
+
+var treeTrailerPattern = regexp.MustCompile(`(?m)^` + treeTrailer + `: *([0-9a-f]+) *$`)
+
+// parseTree parses a tree recorded in a message of a ghost commit, and returns empty if it is not recorded
+func parseTree(message string) string {
+	if m := treeTrailerPattern.FindStringSubmatch(message); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+func treeTrailerLine(tree string) string {
+	return fmt.Sprintf("%s: %s", treeTrailer, tree)
+}
+
+// expectedTree returns a tree object which applying a diff in filepath on commitHashFrom results in
+//
+// The diff of an incremental one is applied after the diffs of its parent chain, and no object is written into the source dir.
+func expectedTree(we WorkingEnv, parent *DiffBranch, filepath, commitHashFrom string) (string, errors.GitGhostError) {
+	patches := []string{}
+	if parent != nil {
+		chain, err := extractPatchChain(we.GhostDir, git.ORIGIN+"/"+parent.BranchName(), parent.FileName())
+		defer removeFiles(chain)
+		if err != nil {
+			return "", err
+		}
+		patches = chain
+	}
+	return git.HashPatchedTree(we.SrcDir, commitHashFrom, append(patches, filepath))
+}
+
+// verifyAppliedTree checks files changed by patches of a local mod branch applied on the source dir of we
+// result in the tree recorded on pushing it
+//
+// Only files of the patches and skipped (files already applied by resuming) are compared, so other local modifications don't matter. Nothing is checked for ghosts without the tree,
+// when srcHead (HEAD before applying) is not their base, and for options applying the patches partially or onto other paths.
+func verifyAppliedTree(ghost DiffBranch, we WorkingEnv, patches, skipped []string, opts ApplyOptions, srcHead string) errors.GitGhostError {
+	if srcHead != ghost.CommitHashFrom || len(opts.Paths) > 0 || opts.Directory != "" || opts.Strip > 1 || opts.Reject || opts.Recover || opts.Fuzz > 0 || opts.UntrackedCollision != "" {
+		return nil
+	}
+	provenance, err := GetProvenance(we.GhostDir, "HEAD", ghost)
+	if err != nil {
+		return err
+	}
+	if provenance.Tree == "" {
+		return nil
+	}
+	paths := append([]string{}, skipped...)
+	for _, p := range patches {
+		patchPaths, err := git.ListPatchPaths(we.SrcDir, p, git.PatchPathOptions{})
+		if err != nil {
+			return err
+		}
+		paths = append(paths, patchPaths...)
+	}
+	applied, err := git.WriteAppliedTree(we.SrcDir, ghost.CommitHashFrom, util.UniqueStringSlice(paths))
+	if err != nil {
+		return err
+	}
+	if applied == provenance.Tree {
+		log.WithFields(log.Fields{
+			"branch": ghost.BranchName(),
+			"tree":   applied,
+		}).Debug("applied tree is verified")
+		return nil
+	}
+	// the recorded tree is written on the pushing side, so it can only be compared when both of them are here
+	if !git.TreeExists(we.SrcDir, provenance.Tree) {
+		return errors.Errorf("applying %s resulted in tree %s, which differs from tree %s recorded on pushing it", ghost.BranchName(), applied, provenance.Tree)
+	}
+	differing, err := git.ListTreeDiffPaths(we.SrcDir, provenance.Tree, applied)
+	if err != nil {
+		return err
+	}
+	return errors.Errorf("applying %s resulted in tree %s, which differs from tree %s recorded on pushing it in %s", ghost.BranchName(), applied, provenance.Tree, strings.Join(differing, ", "))
+}
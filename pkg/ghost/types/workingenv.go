@@ -17,6 +17,7 @@ package types
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
 
 	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
 	"github.com/pfnet-research/git-ghost/pkg/util/errors"
@@ -41,6 +42,11 @@ type WorkingEnvSpec struct {
 	GhostUserName string
 	// GhostUserEmail is a user email which is used in ghost working directories.
 	GhostUserEmail string
+	// FullFetch fetches all the branches of ghost repo on initialization.
+	// By default, only branches required by each operation are fetched on demand.
+	FullFetch bool
+	// DiffHasher derives diff hashes of local mod branches pushed in this env, or DefaultDiffHasher does if it is nil
+	DiffHasher DiffHasher
 }
 
 // WorkingEnv is initialized environment containing temporary local ghost repository
@@ -49,12 +55,28 @@ type WorkingEnv struct {
 	GhostDir string
 }
 
+// Initialize creates a temporary local ghost repository
+//
+// SrcDir is validated and normalized to an absolute path in the returned WorkingEnv.
 func (weSpec WorkingEnvSpec) Initialize() (*WorkingEnv, errors.GitGhostError) {
+	srcDir, err := filepath.Abs(weSpec.SrcDir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	ggerr := git.ValidateWorkTree(srcDir)
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	weSpec.SrcDir = srcDir
 	ghostDir, err := ioutil.TempDir(weSpec.GhostWorkingDir, "git-ghost-")
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	ggerr := git.InitializeGitDir(ghostDir, weSpec.GhostRepo, "")
+	if weSpec.FullFetch {
+		ggerr = git.InitializeGitDir(ghostDir, weSpec.GhostRepo, "")
+	} else {
+		ggerr = git.InitializeEmptyGitDir(ghostDir, weSpec.GhostRepo)
+	}
 	if ggerr != nil {
 		return nil, ggerr
 	}
@@ -73,7 +95,7 @@ func (weSpec WorkingEnvSpec) Initialize() (*WorkingEnv, errors.GitGhostError) {
 
 	log.WithFields(log.Fields{
 		"dir": ghostDir,
-	}).Debug("ghost repo was cloned")
+	}).Debug("ghost repo was initialized")
 
 	return &WorkingEnv{
 		WorkingEnvSpec: weSpec,
@@ -0,0 +1,182 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// VerifyOptions represents arg for Verify func
+type VerifyOptions struct {
+	types.WorkingEnvSpec
+	*types.PullableDiffBranchSpec
+	// Bases are committishes which the diff is tried to be applied onto
+	Bases []string
+	// Jobs is the maximum number of bases tried in parallel (default to 1)
+	Jobs int
+}
+
+// BaseVerification is a result of applying a diff onto a base
+type BaseVerification struct {
+	// Base is a committish as it is given
+	Base   string
+	Commit string
+	// Clean is true if the diff applies cleanly onto the base
+	Clean bool
+	// Conflicts are files which some hunks of the diff don't apply to
+	Conflicts []string
+	// Error is why applying failed
+	Error string
+}
+
+// VerifyResult contains results of Verify func in the order of the bases
+type VerifyResult struct {
+	Branch *types.DiffBranch
+	Bases  []BaseVerification
+}
+
+// Verify tries applying a local mod branch onto each of bases and reports which ones it applies cleanly to
+//
+// The diff is applied in a temporary worktree for each base, so neither the working tree nor the index of the source directory is touched.
+// A base which the diff conflicts with is not an error, but a result.
+func Verify(options VerifyOptions) (*VerifyResult, errors.GitGhostError) {
+	log.WithFields(util.ToFields(options)).Debug("verify command with")
+	if options.PullableDiffBranchSpec == nil {
+		return nil, errors.New("diff to verify is not specified")
+	}
+	if len(options.Bases) == 0 {
+		return nil, errors.New("no base to verify against is specified")
+	}
+	result := VerifyResult{Bases: make([]BaseVerification, len(options.Bases))}
+	for i, base := range options.Bases {
+		commit, err := git.ResolveCommittish(options.SrcDir, base)
+		if err != nil {
+			return nil, errors.WithCategory(errors.Errorf("base %s is not found in %s: %s", base, options.SrcDir, err), errors.CategoryNotFound)
+		}
+		result.Bases[i] = BaseVerification{Base: base, Commit: commit}
+	}
+
+	we, err := options.WorkingEnvSpec.Initialize()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer util.LogDeferredGitGhostError(we.Clean)
+	branch, err := options.PullableDiffBranchSpec.PullBranch(*we)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	result.Branch, _ = branch.(*types.DiffBranch)
+
+	jobs := options.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	// every worker writes only its own result, so they don't have to be locked
+	indices := make(chan int)
+	errs := make(chan errors.GitGhostError, len(result.Bases))
+	for w := 0; w < jobs && w < len(result.Bases); w++ {
+		go func() {
+			for i := range indices {
+				errs <- verifyBase(*we, result.Branch, &result.Bases[i])
+			}
+		}()
+	}
+	for i := range result.Bases {
+		indices <- i
+	}
+	close(indices)
+	for range result.Bases {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// verifyBase applies the diff onto the base of v in a temporary worktree and records the result in v
+//
+// It returns an error only if the worktree can't be made.
+func verifyBase(we types.WorkingEnv, branch *types.DiffBranch, v *BaseVerification) errors.GitGhostError {
+	return git.WithTemporaryWorktree(we.SrcDir, v.Commit, func(worktree string) errors.GitGhostError {
+		worktreeEnv := we
+		worktreeEnv.SrcDir = worktree
+		report := types.ApplyReport{}
+		// rejecting hunks tells which files conflict instead of only the first one
+		err := branch.Apply(worktreeEnv, types.ApplyOptions{Reject: true, Report: &report})
+		if err == nil {
+			v.Clean = true
+			return nil
+		}
+		v.Error = strings.SplitN(strings.TrimSpace(err.Error()), "\n", 2)[0]
+		for _, g := range report.Ghosts {
+			for _, f := range g.Files {
+				if f.Rejected {
+					v.Conflicts = append(v.Conflicts, f.Path)
+				}
+			}
+		}
+		v.Conflicts = util.UniqueStringSlice(v.Conflicts)
+		sort.Strings(v.Conflicts)
+		return nil
+	})
+}
+
+// CleanBases returns the number of bases which the diff applies cleanly onto
+func (result *VerifyResult) CleanBases() int {
+	n := 0
+	for _, v := range result.Bases {
+		if v.Clean {
+			n++
+		}
+	}
+	return n
+}
+
+// PrettyString pretty prints VerifyResult
+func (result *VerifyResult) PrettyString(headers bool) string {
+	var buffer bytes.Buffer
+	if headers {
+		buffer.WriteString(fmt.Sprintf("%-20s %-40s %-8s %s\n", "Base", "Commit", "Result", "Conflicts"))
+	}
+	for _, v := range result.Bases {
+		status := "clean"
+		detail := "-"
+		if !v.Clean {
+			status = "conflict"
+			detail = v.Error
+			if len(v.Conflicts) > 0 {
+				detail = fmt.Sprintf("%d files: %s", len(v.Conflicts), strings.Join(v.Conflicts, ", "))
+			}
+		}
+		buffer.WriteString(fmt.Sprintf("%-20s %-40s %-8s %s\n", v.Base, v.Commit, status, detail))
+	}
+	if headers {
+		buffer.WriteString(fmt.Sprintf("%d of %d bases apply cleanly\n", result.CleanBases(), len(result.Bases)))
+	}
+	return buffer.String()
+}
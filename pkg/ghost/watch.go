@@ -0,0 +1,124 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"time"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// WatchOptions represents arg for Watch func
+type WatchOptions struct {
+	types.WorkingEnvSpec
+	*types.DiffBranchSpec
+	// Tag is moved to every pushed local mod branch
+	Tag string
+	// Interval is how often the working dir is checked for changes
+	Interval time.Duration
+	// Debounce is how long the working dir has to stay unchanged before a change is pushed
+	Debounce time.Duration
+	// OnPush is called with every pushed local mod branch if not nil
+	OnPush func(*types.DiffBranch)
+	// Stop stops watching when it is closed
+	Stop <-chan struct{}
+}
+
+// Watch pushes a local mod branch of the working dir and moves a tag to it every time the working dir changes until options.Stop is closed
+//
+// The working dir is polled for its diff hash, so only changes which would change a pushed ghost (e.g. not of ignored files) are pushed.
+// Failing to push the first state is returned, while later failures are only logged to keep watching.
+func Watch(options WatchOptions) errors.GitGhostError {
+	log.WithFields(util.ToFields(options)).Debug("watch command with")
+
+	if options.DiffBranchSpec == nil {
+		return errors.New("diff to watch is not specified")
+	}
+	err := validateTagName(options.Tag)
+	if err != nil {
+		return err
+	}
+	pushed, err := watchPush(options)
+	if err != nil {
+		return err
+	}
+	seen := pushed
+	changedAt := time.Now()
+	ticker := time.NewTicker(options.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-options.Stop:
+			return nil
+		case now := <-ticker.C:
+			predicted, err := options.DiffBranchSpec.PredictBranch(options.WorkingEnvSpec)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"srcDir": options.SrcDir,
+				}).Errorf("failed to check changes of the working dir: %s", err)
+				continue
+			}
+			name := ""
+			if predicted != nil {
+				name = predicted.BranchName()
+			}
+			if name != seen {
+				seen = name
+				changedAt = now
+				continue
+			}
+			if name == pushed || now.Sub(changedAt) < options.Debounce {
+				continue
+			}
+			p, err := watchPush(options)
+			if err != nil {
+				errors.LogErrorWithStack(err)
+				// retried on the next change instead of every interval
+				pushed = name
+				continue
+			}
+			pushed = p
+		}
+	}
+}
+
+// watchPush pushes a local mod branch of the working dir and moves options.Tag to it, returning its branch name
+func watchPush(options WatchOptions) (string, errors.GitGhostError) {
+	result, err := Push(PushOptions{
+		WorkingEnvSpec: options.WorkingEnvSpec,
+		DiffBranchSpec: options.DiffBranchSpec,
+	})
+	if err != nil {
+		return "", err
+	}
+	if result.DiffBranch == nil {
+		log.WithFields(log.Fields{
+			"srcDir": options.SrcDir,
+		}).Info("skipped pushing because the working dir has no local modifications")
+		return "", nil
+	}
+	_, err = SetTag(TagOptions{WorkingEnvSpec: options.WorkingEnvSpec, Prefix: options.DiffBranchSpec.Prefix}, result.DiffBranch.BranchName(), options.Tag)
+	if err != nil {
+		return "", err
+	}
+	if options.OnPush != nil {
+		options.OnPush(result.DiffBranch)
+	}
+	return result.DiffBranch.BranchName(), nil
+}
@@ -0,0 +1,187 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"time"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// WatchPullOptions represents arg for WatchPull func
+type WatchPullOptions struct {
+	types.WorkingEnvSpec
+	types.ApplyOptions
+	Prefix string
+	// Tag is a tag of local mod branches to follow, e.g. one moved by Watch
+	Tag string
+	// AutoStash stashes local changes while watching and restores them after that if true, which are refused otherwise
+	AutoStash bool
+	// Interval is how often the tag is checked for updates
+	Interval time.Duration
+	// OnApply is called with every applied local mod branch if not nil
+	OnApply func(*types.DiffBranch)
+	// Stop stops watching when it is closed
+	Stop <-chan struct{}
+}
+
+// WatchPull applies the local mod branch of a tag to the working dir, and replaces it every time the tag is moved until options.Stop is closed
+//
+// A new version is applied after reverting the previous one, so that every version is applied on the clean base.
+// If reverting fails because the applied files have been changed, applying is paused until the working dir has no local changes again.
+// If applying fails by a conflict, the version is skipped until the tag is moved again. Both are logged as errors.
+func WatchPull(options WatchPullOptions) errors.GitGhostError {
+	log.WithFields(util.ToFields(options)).Debug("watch-pull command with")
+
+	err := validateTagName(options.Tag)
+	if err != nil {
+		return err
+	}
+	if options.AutoStash {
+		return withAutoStash(options.SrcDir, func() errors.GitGhostError {
+			return watchPull(options)
+		})
+	}
+	dirty, err := git.HasLocalChanges(options.SrcDir)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return errors.Errorf("%s has local changes, which the ghost would be applied on. please commit or stash them (or watch-pull with --autostash) and retry", options.SrcDir)
+	}
+	return watchPull(options)
+}
+
+func watchPull(options WatchPullOptions) errors.GitGhostError {
+	var applied *types.DiffBranch
+	checked := ""
+	paused := false
+	ticker := time.NewTicker(options.Interval)
+	defer ticker.Stop()
+	for {
+		branch, err := taggedDiffBranch(options)
+		if err != nil && errors.CategoryOf(err) != errors.CategoryNotFound {
+			return err
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"tag": options.Tag,
+			}).Infof("waiting for the tag: %s", err)
+		} else if branch.BranchName() != checked || paused {
+			if paused {
+				dirty, err := git.HasLocalChanges(options.SrcDir)
+				if err != nil {
+					return err
+				}
+				if !dirty {
+					log.WithFields(log.Fields{
+						"srcDir": options.SrcDir,
+					}).Warn("resuming watch-pull because the working dir has no local changes")
+					applied = nil
+					paused = false
+				}
+			}
+			if !paused {
+				checked = branch.BranchName()
+				applied, paused = replaceApplied(options, applied, branch)
+			}
+		}
+		select {
+		case <-options.Stop:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// taggedDiffBranch returns the local mod branch which options.Tag points to
+func taggedDiffBranch(options WatchPullOptions) (*types.DiffBranch, errors.GitGhostError) {
+	tags, err := ListTags(TagOptions{WorkingEnvSpec: options.WorkingEnvSpec, Prefix: options.Prefix}, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range tags {
+		if tag.Name != options.Tag {
+			continue
+		}
+		if tag.Branch == nil {
+			return nil, errors.WithCategory(errors.Errorf("ghost branch of tag %s was deleted", tag.Name), errors.CategoryNotFound)
+		}
+		branch, ok := tag.Branch.(*types.DiffBranch)
+		if !ok {
+			return nil, errors.Errorf("tag %s points to %s, which is not a diff", tag.Name, tag.Branch.BranchName())
+		}
+		return branch, nil
+	}
+	return nil, errors.WithCategory(errors.Errorf("tag %s is not found", options.Tag), errors.CategoryNotFound)
+}
+
+// replaceApplied reverts applied (if not nil) and applies branch, returning the applied branch and whether watching is paused
+//
+// Errors are only logged to keep watching.
+func replaceApplied(options WatchPullOptions, applied, branch *types.DiffBranch) (*types.DiffBranch, bool) {
+	fields := log.Fields{
+		"tag":    options.Tag,
+		"branch": branch.BranchName(),
+	}
+	if applied != nil {
+		err := revertDiffBranch(options, applied)
+		if err != nil {
+			log.WithFields(fields).Errorf("paused watch-pull because %s applied last can't be reverted, e.g. by local changes of its files: %s. new versions are applied once the working dir has no local changes (e.g. by 'git stash')", applied.BranchName(), err)
+			return applied, true
+		}
+	}
+	err := Pull(PullOptions{
+		WorkingEnvSpec: options.WorkingEnvSpec,
+		PullableDiffBranchSpec: &types.PullableDiffBranchSpec{
+			Prefix:         options.Prefix,
+			CommittishFrom: branch.CommitHashFrom,
+			DiffHash:       branch.DiffHash,
+		},
+		ApplyOptions: options.ApplyOptions,
+	})
+	if err != nil {
+		log.WithFields(fields).Errorf("failed to apply %s, which is skipped until the tag is moved again: %s", branch.BranchName(), err)
+		return nil, false
+	}
+	if options.OnApply != nil {
+		options.OnApply(branch)
+	}
+	return branch, false
+}
+
+// revertDiffBranch reverts a local mod branch applied to the working dir
+func revertDiffBranch(options WatchPullOptions, branch *types.DiffBranch) errors.GitGhostError {
+	we, err := options.WorkingEnvSpec.Initialize()
+	if err != nil {
+		return err
+	}
+	defer util.LogDeferredGitGhostError(we.Clean)
+	spec := types.PullableDiffBranchSpec{
+		Prefix:         options.Prefix,
+		CommittishFrom: branch.CommitHashFrom,
+		DiffHash:       branch.DiffHash,
+	}
+	pulled, err := spec.PullBranch(*we)
+	if err != nil {
+		return err
+	}
+	return pulled.(*types.DiffBranch).Revert(*we, options.ApplyOptions)
+}
@@ -0,0 +1,161 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// WhichOptions represents arg for Which func
+type WhichOptions struct {
+	types.WorkingEnvSpec
+	Prefix string
+}
+
+// WhichResult contains results of Which func
+type WhichResult struct {
+	// Repo is the ghost repo url with passwords redacted
+	Repo string
+	// Descriptors describe ghost branches matching the hash, sorted by their branch names
+	Descriptors []types.Descriptor
+}
+
+// Which describes ghost branches specified by a diff hash, a local base commit hash or a branch name
+//
+// It fetches the matching branches to compute their sizes and checksums, but never extracts their contents.
+func Which(options WhichOptions, hash string) (*WhichResult, errors.GitGhostError) {
+	log.WithFields(util.ToFields(options)).Debug("which command with")
+
+	heads, err := git.ListRemoteRefHashes(options.GhostRepo, fmt.Sprintf("refs/heads/%s/*", options.Prefix))
+	if err != nil {
+		return nil, err
+	}
+	branches := []types.GhostBranch{}
+	names := []string{}
+	for ref := range heads {
+		branch := types.CreateGhostBranchByName(strings.TrimPrefix(ref, "refs/heads/"))
+		if branch == nil || !matchesHash(branch, hash) {
+			continue
+		}
+		branches = append(branches, branch)
+		names = append(names, branch.BranchName())
+	}
+	if len(branches) == 0 {
+		return nil, errors.WithCategory(errors.Errorf("no ghost branch is found for %s", hash), errors.CategoryNotFound)
+	}
+
+	we, err := options.WorkingEnvSpec.Initialize()
+	if err != nil {
+		return nil, err
+	}
+	defer util.LogDeferredGitGhostError(we.Clean)
+	err = git.FetchBranches(we.GhostDir, names...)
+	if err != nil {
+		return nil, err
+	}
+	res := WhichResult{Repo: util.RedactURLPassword(options.GhostRepo)}
+	for _, branch := range branches {
+		d, err := types.Describe(we.GhostDir, fmt.Sprintf("%s/%s", git.ORIGIN, branch.BranchName()), branch)
+		if err != nil {
+			return nil, err
+		}
+		res.Descriptors = append(res.Descriptors, *d)
+	}
+	res.sort()
+	return &res, nil
+}
+
+func (res *WhichResult) sort() {
+	sort.Slice(res.Descriptors, func(i, j int) bool {
+		return res.Descriptors[i].Branch < res.Descriptors[j].Branch
+	})
+}
+
+// PrettyString pretty prints WhichResult with a paragraph per ghost branch
+func (res *WhichResult) PrettyString() string {
+	var buffer bytes.Buffer
+	for i, d := range res.Descriptors {
+		if i > 0 {
+			buffer.WriteString("\n")
+		}
+		fields := [][2]string{
+			{"Ghost-Branch", d.Branch},
+			{"Ghost-Repo", res.Repo},
+			{"Ref", d.Ref},
+			{"Type", d.Type},
+			{"From", d.From},
+		}
+		if d.Type == "commits" {
+			fields = append(fields, [2]string{"To", d.To})
+		} else {
+			fields = append(fields, [2]string{"Hash", d.Hash})
+		}
+		fields = append(fields,
+			[2]string{"File", d.File},
+			[2]string{"Stored-As", storedAs(d)},
+			[2]string{"Size", fmt.Sprintf("%d", d.Size)},
+			[2]string{"Checksum", d.Checksum},
+			[2]string{"Commit", d.Commit},
+			[2]string{"Format-Version", fmt.Sprintf("%d", d.FormatVersion)},
+			[2]string{"Pushed-By", d.PushedBy},
+			[2]string{"Pushed-At", d.PushedAt},
+		)
+		if d.SourceRepo != "" {
+			fields = append(fields, [2]string{"Source-Repo", d.SourceRepo})
+		}
+		for _, f := range fields {
+			buffer.WriteString(fmt.Sprintf("%s: %s\n", f[0], f[1]))
+		}
+		if d.CI != nil {
+			buffer.WriteString(d.CI.PrettyString())
+		}
+		buffer.WriteString(d.Metadata.PrettyString())
+	}
+	return buffer.String()
+}
+
+// storedAs returns how the ghost file of d is stored, e.g. "patch, split, incremental"
+func storedAs(d types.Descriptor) string {
+	kinds := []string{"patch"}
+	if d.Bundle {
+		kinds[0] = "bundle"
+	}
+	if d.Split {
+		kinds = append(kinds, "split")
+	}
+	if d.Compression != types.CompressionNone {
+		kinds = append(kinds, d.Compression)
+	}
+	if d.Piped {
+		kinds = append(kinds, "piped")
+	}
+	if d.Attachments {
+		kinds = append(kinds, "attachments")
+	}
+	if d.Incremental {
+		kinds = append(kinds, "incremental")
+	}
+	return strings.Join(kinds, ", ")
+}
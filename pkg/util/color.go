@@ -0,0 +1,202 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+const colorReset = "\033[m"
+
+var colorCommitPattern = regexp.MustCompile(`^From [0-9a-f]{40} `)
+var colorHunkPattern = regexp.MustCompile(`^@@ -\d+(?:,(\d+))? \+\d+(?:,(\d+))? @@`)
+
+// states of DiffColorWriter in a patch
+const (
+	// in a commit message of format-patch or its signature, or before any diff
+	colorStateMessage = iota
+	// in headers of a file diff or between its hunks
+	colorStateHeader
+	// in a body of a hunk
+	colorStateHunk
+)
+
+// DiffColors are escape sequences of colors of lines of patches by their slots of color.diff.<slot> in git config
+//
+// A slot of an empty color is not colored.
+type DiffColors struct {
+	Meta   string
+	Frag   string
+	Old    string
+	New    string
+	Commit string
+}
+
+// DiffColorWriter is a writer which colors lines of patches like 'git diff --color'
+//
+// Flush must be called after all the writes to write the last line without a newline.
+// As git does, lines are classified by where they are, so a removed line '-- x' is colored as an old line in a hunk
+// while the signature '-- ' after the last hunk and lines of a commit message are not colored.
+type DiffColorWriter struct {
+	writer io.Writer
+	colors DiffColors
+	buffer bytes.Buffer
+	state  int
+	// the numbers of old and new lines left in the current hunk
+	oldLines int
+	newLines int
+}
+
+// NewDiffColorWriter returns a DiffColorWriter writing to writer in colors
+func NewDiffColorWriter(writer io.Writer, colors DiffColors) *DiffColorWriter {
+	return &DiffColorWriter{writer: writer, colors: colors}
+}
+
+// Write writes colored complete lines in p and buffers the rest
+func (w *DiffColorWriter) Write(p []byte) (int, error) {
+	w.buffer.Write(p)
+	for {
+		i := bytes.IndexByte(w.buffer.Bytes(), '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := string(w.buffer.Next(i + 1))
+		err := w.writeLine(line)
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Flush writes a buffered line
+func (w *DiffColorWriter) Flush() errors.GitGhostError {
+	if w.buffer.Len() == 0 {
+		return nil
+	}
+	return errors.WithStack(w.writeLine(string(w.buffer.Next(w.buffer.Len()))))
+}
+
+func (w *DiffColorWriter) writeLine(line string) error {
+	color := w.lineColor(line)
+	if color == "" {
+		_, err := io.WriteString(w.writer, line)
+		return err
+	}
+	body := strings.TrimSuffix(line, "\n")
+	_, err := io.WriteString(w.writer, color+body+colorReset+line[len(body):])
+	return err
+}
+
+func (w *DiffColorWriter) lineColor(line string) string {
+	if w.state == colorStateHunk {
+		color, ok := w.hunkLineColor(line)
+		if ok {
+			return color
+		}
+		// a hunk shorter than its header ends here
+		w.state = colorStateHeader
+	}
+	switch {
+	case colorCommitPattern.MatchString(line):
+		w.state = colorStateMessage
+		return w.colors.Commit
+	case strings.HasPrefix(line, "diff --git "):
+		w.state = colorStateHeader
+		return w.colors.Meta
+	case w.state == colorStateMessage:
+		return ""
+	case strings.HasPrefix(line, "@@"):
+		m := colorHunkPattern.FindStringSubmatch(line)
+		if m != nil {
+			w.oldLines, w.newLines = hunkLines(m[1]), hunkLines(m[2])
+			w.state = colorStateHunk
+			w.endHunk()
+		}
+		return w.colors.Frag
+	case strings.HasPrefix(line, "index "),
+		strings.HasPrefix(line, "--- "),
+		strings.HasPrefix(line, "+++ "):
+		return w.colors.Meta
+	}
+	return ""
+}
+
+// hunkLineColor returns a color of line in a hunk, or false if line is not of a hunk
+func (w *DiffColorWriter) hunkLineColor(line string) (string, bool) {
+	switch {
+	case strings.HasPrefix(line, "-") && w.oldLines > 0:
+		w.oldLines--
+		w.endHunk()
+		return w.colors.Old, true
+	case strings.HasPrefix(line, "+") && w.newLines > 0:
+		w.newLines--
+		w.endHunk()
+		return w.colors.New, true
+	case (strings.HasPrefix(line, " ") || line == "\n") && w.oldLines > 0 && w.newLines > 0:
+		w.oldLines--
+		w.newLines--
+		w.endHunk()
+		return "", true
+	case strings.HasPrefix(line, "\\"):
+		// no newline at end of file
+		return "", true
+	}
+	return "", false
+}
+
+// endHunk leaves a hunk whose lines are all written
+func (w *DiffColorWriter) endHunk() {
+	if w.oldLines == 0 && w.newLines == 0 {
+		w.state = colorStateHeader
+	}
+}
+
+// hunkLines returns the number of lines of a hunk header, which is 1 if it is omitted
+func hunkLines(s string) int {
+	if s == "" {
+		return 1
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// IsTerminal returns whether a given file is a terminal or not
+func IsTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// HasTerminal returns whether the process has a controlling terminal, which git prompts for credentials on
+func HasTerminal() bool {
+	f, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return false
+	}
+	LogDeferredError(f.Close)
+	return true
+}
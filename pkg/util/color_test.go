@@ -0,0 +1,118 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pfnet-research/git-ghost/pkg/util"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testDiffColors = util.DiffColors{Meta: "<meta>", Frag: "<frag>", Old: "<old>", New: "<new>", Commit: "<commit>"}
+
+func colorLines(t *testing.T, lines ...string) []string {
+	var b strings.Builder
+	w := util.NewDiffColorWriter(&b, testDiffColors)
+	// split writes in the middle of lines
+	for _, line := range lines {
+		_, err := w.Write([]byte(line[:len(line)/2]))
+		assert.Nil(t, err)
+		_, err = w.Write([]byte(line[len(line)/2:]))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, w.Flush())
+	return strings.SplitAfter(strings.TrimSuffix(b.String(), "\n"), "\n")
+}
+
+func TestDiffColorWriterDiff(t *testing.T) {
+	colored := colorLines(t,
+		"diff --git a/a.txt b/a.txt\n",
+		"index 0123456..789abcd 100644\n",
+		"--- a/a.txt\n",
+		"+++ b/a.txt\n",
+		"@@ -1,3 +1,3 @@ func\n",
+		" context\n",
+		"--- a removed line starting with --\n",
+		"++ an added line starting with +\n",
+		"\n",
+		"@@ -10 +10 @@\n",
+		"-old\n",
+		"\\ No newline at end of file\n",
+		"+new",
+	)
+	assert.Equal(t, []string{
+		"<meta>diff --git a/a.txt b/a.txt\033[m\n",
+		"<meta>index 0123456..789abcd 100644\033[m\n",
+		"<meta>--- a/a.txt\033[m\n",
+		"<meta>+++ b/a.txt\033[m\n",
+		"<frag>@@ -1,3 +1,3 @@ func\033[m\n",
+		" context\n",
+		"<old>--- a removed line starting with --\033[m\n",
+		"<new>++ an added line starting with +\033[m\n",
+		"\n",
+		"<frag>@@ -10 +10 @@\033[m\n",
+		"<old>-old\033[m\n",
+		"\\ No newline at end of file\n",
+		"<new>+new\033[m",
+	}, colored)
+}
+
+func TestDiffColorWriterFormatPatch(t *testing.T) {
+	colored := colorLines(t,
+		"From 0123456789abcdef0123456789abcdef01234567 Mon Sep 17 00:00:00 2001\n",
+		"Subject: [PATCH] a message\n",
+		"\n",
+		"- a list item of the message\n",
+		"+++ not a header\n",
+		"---\n",
+		" a.txt | 2 +-\n",
+		"\n",
+		"diff --git a/a.txt b/a.txt\n",
+		"--- a/a.txt\n",
+		"+++ b/a.txt\n",
+		"@@ -1 +1 @@\n",
+		"-- \n",
+		"+\n",
+		"-- \n",
+		"2.20.1\n",
+		"\n",
+		"From 89abcdef0123456789abcdef0123456789abcdef Mon Sep 17 00:00:00 2001\n",
+		"- another message",
+	)
+	assert.Equal(t, []string{
+		"<commit>From 0123456789abcdef0123456789abcdef01234567 Mon Sep 17 00:00:00 2001\033[m\n",
+		"Subject: [PATCH] a message\n",
+		"\n",
+		"- a list item of the message\n",
+		"+++ not a header\n",
+		"---\n",
+		" a.txt | 2 +-\n",
+		"\n",
+		"<meta>diff --git a/a.txt b/a.txt\033[m\n",
+		"<meta>--- a/a.txt\033[m\n",
+		"<meta>+++ b/a.txt\033[m\n",
+		"<frag>@@ -1 +1 @@\033[m\n",
+		"<old>-- \033[m\n",
+		"<new>+\033[m\n",
+		"-- \n",
+		"2.20.1\n",
+		"\n",
+		"<commit>From 89abcdef0123456789abcdef0123456789abcdef Mon Sep 17 00:00:00 2001\033[m\n",
+		"- another message",
+	}, colored)
+}
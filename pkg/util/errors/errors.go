@@ -42,7 +42,7 @@ func Errorf(s string, args ...interface{}) GitGhostError {
 }
 
 func New(s string) GitGhostError {
-	return errors.WithStack(fmt.Errorf(s)).(GitGhostError)
+	return errors.WithStack(fmt.Errorf("%s", s)).(GitGhostError)
 }
 
 func WithStack(err error) GitGhostError {
@@ -54,3 +54,83 @@ func WithStack(err error) GitGhostError {
 	}
 	return errors.WithStack(err).(GitGhostError)
 }
+
+// Annotatef returns an error of a formatted message which keeps err as its cause unlike Errorf,
+// so that the category and the type of err are still found by CategoryOf and Cause
+func Annotatef(err error, s string, args ...interface{}) GitGhostError {
+	if err == nil {
+		return nil
+	}
+	return &annotatedError{Errorf(s, args...), err}
+}
+
+type annotatedError struct {
+	GitGhostError
+	cause error
+}
+
+func (e *annotatedError) Cause() error {
+	return e.cause
+}
+
+func (e *annotatedError) Format(s fmt.State, verb rune) {
+	if f, ok := e.GitGhostError.(fmt.Formatter); ok {
+		f.Format(s, verb)
+		return
+	}
+	fmt.Fprint(s, e.Error())
+}
+
+// Category classifies errors by what failed so that callers can react to them without parsing messages
+type Category int
+
+const (
+	// CategoryGeneric is a category of errors which are not classified
+	CategoryGeneric Category = iota
+	// CategoryConflict is a category of errors on applying ghosts which conflict with files of the destination
+	CategoryConflict
+	// CategoryNotFound is a category of errors on looking up ghosts, tags, commits or files which do not exist
+	CategoryNotFound
+	// CategoryRemote is a category of errors on talking to a remote repo, e.g. network or authentication failures
+	CategoryRemote
+	// CategoryConfig is a category of errors on invalid settings, flags or arguments
+	CategoryConfig
+	// CategoryExists is a category of errors on creating ghost branches or tags which already exist, e.g. locks held by others
+	CategoryExists
+)
+
+type categorizedError struct {
+	GitGhostError
+	category Category
+}
+
+func (e *categorizedError) Format(s fmt.State, verb rune) {
+	if f, ok := e.GitGhostError.(fmt.Formatter); ok {
+		f.Format(s, verb)
+		return
+	}
+	fmt.Fprint(s, e.Error())
+}
+
+// WithCategory classifies err into category, overriding its category if it is already classified
+func WithCategory(err error, category Category) GitGhostError {
+	if err == nil {
+		return nil
+	}
+	return &categorizedError{WithStack(err), category}
+}
+
+// CategoryOf returns a category of err given by WithCategory to it or its causes, or CategoryGeneric if it is not classified
+func CategoryOf(err error) Category {
+	for err != nil {
+		if c, ok := err.(*categorizedError); ok {
+			return c.category
+		}
+		causer, ok := err.(interface{ Cause() error })
+		if !ok {
+			break
+		}
+		err = causer.Cause()
+	}
+	return CategoryGeneric
+}
@@ -20,6 +20,8 @@ import (
 
 	"github.com/pfnet-research/git-ghost/pkg/util/errors"
 
+	gherrors "github.com/pkg/errors"
+
 	"github.com/stretchr/testify/assert"
 )
 
@@ -45,3 +47,38 @@ func TestWithStack(t *testing.T) {
 	err = errors.WithStack(errors.New("foo"))
 	assert.Equal(t, "foo", err.Error())
 }
+
+type causeError struct{}
+
+func (e *causeError) Error() string {
+	return "foo"
+}
+
+func TestAnnotatef(t *testing.T) {
+	assert.Nil(t, errors.Annotatef(nil, "bar"))
+
+	cause := &causeError{}
+	err := errors.Annotatef(errors.WithCategory(cause, errors.CategoryConflict), "%s, and bar", cause)
+	assert.Equal(t, "foo, and bar", err.Error())
+	// the cause is kept
+	assert.Equal(t, cause, gherrors.Cause(err))
+	assert.Equal(t, errors.CategoryConflict, errors.CategoryOf(err))
+	// stack trace is kept
+	assert.Contains(t, fmt.Sprintf("%+v", err), "TestAnnotatef")
+}
+
+func TestCategory(t *testing.T) {
+	assert.Equal(t, errors.CategoryGeneric, errors.CategoryOf(errors.New("foo")))
+	assert.Nil(t, errors.WithCategory(nil, errors.CategoryRemote))
+
+	err := errors.WithCategory(errors.New("foo"), errors.CategoryNotFound)
+	assert.Equal(t, "foo", err.Error())
+	assert.Equal(t, errors.CategoryNotFound, errors.CategoryOf(err))
+	// kept through wrapping
+	assert.Equal(t, errors.CategoryNotFound, errors.CategoryOf(errors.WithStack(err)))
+	assert.Equal(t, errors.CategoryNotFound, errors.CategoryOf(gherrors.WithMessage(err, "bar")))
+	// overridden by outer one
+	assert.Equal(t, errors.CategoryConfig, errors.CategoryOf(errors.WithCategory(err, errors.CategoryConfig)))
+	// stack trace is kept
+	assert.Contains(t, fmt.Sprintf("%+v", err), "TestCategory")
+}
@@ -15,9 +15,14 @@
 package util
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"regexp"
 	"strings"
 	"syscall"
 
@@ -26,35 +31,229 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-func JustOutputCmd(cmd *exec.Cmd) ([]byte, errors.GitGhostError) {
+var cmdContext = context.Background()
+
+// SetCommandContext sets a context which bounds all the commands run by JustOutputCmd and JustRunCmd
+//
+// When the context is done, running commands are killed and return an error.
+func SetCommandContext(ctx context.Context) {
+	cmdContext = ctx
+}
+
+// CommandContext returns the context set by SetCommandContext, which can bound operations other than commands as well
+func CommandContext() context.Context {
+	return cmdContext
+}
+
+// CommandContextDone checks the context set by SetCommandContext is done or not
+func CommandContextDone() bool {
+	return cmdContext.Err() != nil
+}
+
+var shellSafePattern = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// ShellQuote quotes s so that a shell treats it as a single word
+func ShellQuote(s string) string {
+	if shellSafePattern.MatchString(s) {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+var urlPasswordPattern = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://[^/:@\s]*):[^/@\s]*@`)
+
+// RedactURLPassword hides passwords in URLs in s
+func RedactURLPassword(s string) string {
+	return urlPasswordPattern.ReplaceAllString(s, "$1:<redacted>@")
+}
+
+// CommandLine returns a command line of args which can be pasted into a shell, with passwords in URLs redacted
+func CommandLine(args []string) string {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		quoted = append(quoted, ShellQuote(RedactURLPassword(arg)))
+	}
+	return strings.Join(quoted, " ")
+}
+
+func logCmd(cmd *exec.Cmd) {
 	wd, _ := os.Getwd()
 	log.WithFields(log.Fields{
 		"pwd":     wd,
-		"command": strings.Join(cmd.Args, " "),
+		"command": CommandLine(cmd.Args),
 	}).Debug("exec")
+}
+
+func JustOutputCmd(cmd *exec.Cmd) ([]byte, errors.GitGhostError) {
+	logCmd(cmd)
+	stdout := bytes.NewBufferString("")
 	stderr := bytes.NewBufferString("")
+	cmd.Stdout = stdout
 	cmd.Stderr = stderr
-	bytes, err := cmd.Output()
+	err := runWithContext(cmd)
 	if err != nil {
+		if ggerr := contextError(cmd); ggerr != nil {
+			return []byte{}, ggerr
+		}
 		s := stderr.String()
 		if s != "" {
 			return []byte{}, errors.New(s)
 		}
 		return []byte{}, errors.WithStack(err)
 	}
-	return bytes, nil
+	return stdout.Bytes(), nil
 }
 
 func JustRunCmd(cmd *exec.Cmd) errors.GitGhostError {
-	wd, _ := os.Getwd()
-	log.WithFields(log.Fields{
-		"pwd":     wd,
-		"command": strings.Join(cmd.Args, " "),
-	}).Debug("exec")
+	logCmd(cmd)
+	stderr := bytes.NewBufferString("")
+	cmd.Stderr = stderr
+	err := runWithContext(cmd)
+	if err != nil {
+		if ggerr := contextError(cmd); ggerr != nil {
+			return ggerr
+		}
+		s := stderr.String()
+		if s != "" {
+			return errors.New(s)
+		}
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// ScanCmdStderr is the same as JustRunCmd except that it calls f with each line of stderr (ended by either of CR and LF) as it comes
+//
+// Lines for which f returns true are consumed, e.g. progress of git, and left out of the error.
+func ScanCmdStderr(cmd *exec.Cmd, f func(line string) bool) errors.GitGhostError {
+	logCmd(cmd)
+	stderr := &stderrScanner{f: f}
+	cmd.Stderr = stderr
+	err := runWithContext(cmd)
+	stderr.flush()
+	if err != nil {
+		if ggerr := contextError(cmd); ggerr != nil {
+			return ggerr
+		}
+		s := stderr.kept.String()
+		if s != "" {
+			return errors.New(s)
+		}
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// stderrScanner is a writer which calls f with each line written to it
+type stderrScanner struct {
+	f       func(line string) bool
+	partial bytes.Buffer
+	kept    bytes.Buffer
+}
+
+func (s *stderrScanner) Write(p []byte) (int, error) {
+	for _, b := range p {
+		if b != '\r' && b != '\n' {
+			s.partial.WriteByte(b)
+			continue
+		}
+		s.line()
+	}
+	return len(p), nil
+}
+
+func (s *stderrScanner) line() {
+	line := s.partial.String()
+	s.partial.Reset()
+	if line == "" || s.f(line) {
+		return
+	}
+	s.kept.WriteString(line + "\n")
+}
+
+func (s *stderrScanner) flush() {
+	if s.partial.Len() > 0 {
+		s.line()
+	}
+}
+
+// StreamCmd runs cmd writing both of its stdout and stderr to writer as they come
+//
+// Unlike JustRunCmd, the output is not kept in an error, which only tells how cmd exited.
+func StreamCmd(cmd *exec.Cmd, writer io.Writer) errors.GitGhostError {
+	logCmd(cmd)
+	cmd.Stdout = writer
+	cmd.Stderr = writer
+	err := runWithContext(cmd)
+	if err != nil {
+		if ggerr := contextError(cmd); ggerr != nil {
+			return ggerr
+		}
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// ScanCmdLines runs cmd calling f with each line of its stdout as soon as the line comes
+//
+// If f returns an error, cmd is killed and the error is returned as it is.
+func ScanCmdLines(cmd *exec.Cmd, f func(line string) errors.GitGhostError) errors.GitGhostError {
+	return ReadCmdOutput(cmd, func(stdout io.Reader) errors.GitGhostError {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			if ggerr := f(scanner.Text()); ggerr != nil {
+				return ggerr
+			}
+		}
+		return errors.WithStack(scanner.Err())
+	})
+}
+
+// ReadCmdOutput runs cmd calling f with a reader of its stdout, which can be read as the output comes
+//
+// The rest of the output which f doesn't read is discarded. If f returns an error, cmd is killed and the error is returned as it is.
+func ReadCmdOutput(cmd *exec.Cmd, f func(stdout io.Reader) errors.GitGhostError) errors.GitGhostError {
+	logCmd(cmd)
 	stderr := bytes.NewBufferString("")
 	cmd.Stderr = stderr
-	err := cmd.Run()
+	stdout, err := cmd.StdoutPipe()
 	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := cmdContext.Err(); err != nil {
+		return contextError(cmd)
+	}
+	err = cmd.Start()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-cmdContext.Done():
+			LogDeferredError(cmd.Process.Kill)
+		case <-done:
+		}
+	}()
+
+	if ggerr := f(stdout); ggerr != nil {
+		LogDeferredError(cmd.Process.Kill)
+		LogDeferredError(cmd.Wait)
+		return ggerr
+	}
+	// cmd can't exit while its output is left in the pipe
+	_, err = io.Copy(ioutil.Discard, stdout)
+	if err != nil {
+		LogDeferredError(cmd.Process.Kill)
+		LogDeferredError(cmd.Wait)
+		return errors.WithStack(err)
+	}
+	err = cmd.Wait()
+	if err != nil {
+		if ggerr := contextError(cmd); ggerr != nil {
+			return ggerr
+		}
 		s := stderr.String()
 		if s != "" {
 			return errors.New(s)
@@ -64,6 +263,39 @@ func JustRunCmd(cmd *exec.Cmd) errors.GitGhostError {
 	return nil
 }
 
+func runWithContext(cmd *exec.Cmd) error {
+	if cmdContext.Done() == nil {
+		return cmd.Run()
+	}
+	if err := cmdContext.Err(); err != nil {
+		return err
+	}
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		return err
+	case <-cmdContext.Done():
+		LogDeferredError(cmd.Process.Kill)
+		<-done
+		return cmdContext.Err()
+	}
+}
+
+func contextError(cmd *exec.Cmd) errors.GitGhostError {
+	switch cmdContext.Err() {
+	case context.DeadlineExceeded:
+		return errors.Errorf("timed out while running %s", CommandLine(cmd.Args))
+	case context.Canceled:
+		return errors.Errorf("canceled while running %s", CommandLine(cmd.Args))
+	}
+	return nil
+}
+
 func GetExitCode(err error) int {
 	if exiterr, ok := err.(*exec.ExitError); ok {
 		if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
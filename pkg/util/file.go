@@ -15,6 +15,9 @@
 package util
 
 import (
+	"bytes"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 
@@ -31,6 +34,18 @@ func FileSize(filepath string) (int64, errors.GitGhostError) {
 	return fi.Size(), nil
 }
 
+// FileExists returns whether a given file exists or not
+func FileExists(filepath string) (bool, errors.GitGhostError) {
+	_, err := os.Stat(filepath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, errors.WithStack(err)
+	}
+	return true, nil
+}
+
 // WalkSymlink reads a symlink and call a given callback until the resolved path is not a symlink.WalkSymlink
 func WalkSymlink(dir, path string, cb func([]string, string) errors.GitGhostError) errors.GitGhostError {
 	abspath := path
@@ -90,3 +105,98 @@ func IsSymlink(path string) (bool, errors.GitGhostError) {
 	}
 	return fi.Mode()&os.ModeSymlink != 0, nil
 }
+
+// binaryCheckSize is the number of leading bytes which git checks for binariness
+const binaryCheckSize = 8000
+
+// IsBinaryFile returns whether a given file is binary by git's heuristic, i.e. it has a NUL byte in its first 8000 bytes
+//
+// A symlink is never binary since its target path is stored instead of its content.
+func IsBinaryFile(path string) (bool, errors.GitGhostError) {
+	islink, ggerr := IsSymlink(path)
+	if ggerr != nil || islink {
+		return false, ggerr
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	defer LogDeferredError(f.Close)
+	buf := make([]byte, binaryCheckSize)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, errors.WithStack(err)
+	}
+	return bytes.IndexByte(buf[:n], 0) >= 0, nil
+}
+
+var tempDir = ""
+
+// SetTempDir sets a directory where temporary files and clones are created
+//
+// An empty string means the default temporary directory of the system.
+func SetTempDir(dir string) {
+	tempDir = dir
+}
+
+// TempDir returns a directory where temporary files and clones are created
+func TempDir() string {
+	if tempDir == "" {
+		return os.TempDir()
+	}
+	return tempDir
+}
+
+// WriteFileAtomically writes data to path by renaming a temporary file in the same directory,
+// so that readers of path never see it partially written
+func WriteFileAtomically(path string, data []byte) errors.GitGhostError {
+	f, err := ioutil.TempFile(filepath.Dir(path), ".git-ghost-")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer LogDeferredError(func() error {
+		err := os.Remove(f.Name())
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	})
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(f.Name(), path))
+}
+
+// ValidateWritableDir checks that a file can be created in a given directory
+func ValidateWritableDir(dir string) errors.GitGhostError {
+	f, err := ioutil.TempFile(dir, "git-ghost-check")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	LogDeferredError(f.Close)
+	return errors.WithStack(os.Remove(f.Name()))
+}
+
+// ValidateReadableFile checks filepath is a regular file which can be read
+func ValidateReadableFile(filepath string) errors.GitGhostError {
+	fi, err := os.Stat(filepath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !fi.Mode().IsRegular() {
+		return errors.Errorf("not a regular file: %s", filepath)
+	}
+	f, err := os.Open(filepath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	LogDeferredError(f.Close)
+	return nil
+}
@@ -15,6 +15,9 @@
 package util
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
 	"os/exec"
 	"strings"
 
@@ -31,3 +34,13 @@ func GenerateFileContentHash(filepath string) (string, errors.GitGhostError) {
 	hash := strings.Split(string(output), " ")[0]
 	return hash, nil
 }
+
+// GenerateStringsHash returns a hash value of given strings
+func GenerateStringsHash(values ...string) string {
+	h := sha1.New()
+	for _, v := range values {
+		_, _ = io.WriteString(h, v)
+		_, _ = h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -30,3 +30,20 @@ func UniqueStringSlice(slice []string) []string {
 
 	return uniq
 }
+
+// SubtractStringSlice returns elements of slice which are not in others, keeping their order
+func SubtractStringSlice(slice []string, others []string) []string {
+	m := make(map[string]empty)
+	for _, ele := range others {
+		m[ele] = empty{}
+	}
+
+	subtracted := []string{}
+	for _, ele := range slice {
+		if _, ok := m[ele]; !ok {
+			subtracted = append(subtracted, ele)
+		}
+	}
+
+	return subtracted
+}